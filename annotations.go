@@ -0,0 +1,108 @@
+package grepast
+
+import (
+	"slices"
+	"strings"
+
+	sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+// annotationKinds lists, per language, the kinds of nodes other than comments that belong to the
+// header of the definition directly below them when HeaderAnnotations is set. Languages not listed
+// keep their headers as parsed, and have no doc comments for ShowDocComments.
+var annotationKinds = map[string]map[string]bool{
+	"c_sharp":    {"attribute_list": true},
+	"go":         {},
+	"java":       {"annotation": true, "marker_annotation": true},
+	"javascript": {"decorator": true},
+	"python":     {"decorator": true},
+	"rust":       {"attribute_item": true},
+	"typescript": {"decorator": true},
+}
+
+// recordAnnotation records node if it is an annotation or comment standing on lines of its own.
+func (tc *TreeContext) recordAnnotation(node *sitter.Node) {
+	kinds, ok := annotationKinds[tc.language]
+	if !ok || !kinds[node.Kind()] && !IsCommentKind(tc.language, node.Kind()) {
+		return
+	}
+
+	start := int(node.StartPosition().Row)
+	if col := int(node.StartPosition().Column); !isBlank(tc.lines[start][:col]) {
+		return // Trails code on the same line.
+	}
+	end := int(node.EndPosition().Row)
+	// Line comments may include their newline, ending at the start of the next line.
+	if end > start && node.EndPosition().Column == 0 {
+		end--
+	}
+	if s, ok := tc.annotationStarts[end]; !ok || start < s {
+		tc.annotationStarts[end] = start
+	}
+}
+
+// annotatedStart returns the first line of the contiguous run of annotations and comments ending
+// directly above line, or line itself if there are none.
+func (tc *TreeContext) annotatedStart(line int) int {
+	for {
+		start, ok := tc.annotationStarts[line-1]
+		if !ok || start >= line {
+			return line
+		}
+		line = start
+	}
+}
+
+// addDocComments shows the doc comment of the declaration starting on line, if any: the run of
+// comments and annotations directly above it, and the docstring at the start of its body.
+func (tc *TreeContext) addDocComments(line int) {
+	if line < 0 || line >= len(tc.nodes) {
+		return
+	}
+	declaration := false
+	for _, node := range tc.nodes[line] {
+		if !tc.isDeclarationKind(node.Kind()) {
+			continue
+		}
+		declaration = true
+		if start, end, ok := tc.docstringLines(node); ok {
+			for i := start; i <= end; i++ {
+				tc.showLines[i] = struct{}{}
+			}
+		}
+	}
+	if !declaration {
+		return
+	}
+	for i := tc.annotatedStart(line); i < line; i++ {
+		tc.showLines[i] = struct{}{}
+	}
+}
+
+// isDeclarationKind reports whether kind is the node kind of a declaration or definition in the
+// context's language, e.g. "function_declaration", "class_definition", or Rust's "struct_item".
+func (tc *TreeContext) isDeclarationKind(kind string) bool {
+	if annotationKinds[tc.language][kind] {
+		return false
+	}
+	return strings.HasSuffix(kind, "_declaration") ||
+		strings.HasSuffix(kind, "_definition") ||
+		strings.HasSuffix(kind, "_item")
+}
+
+// docstringLines returns the first and last lines of the docstring of a definition, if it has one.
+func (tc *TreeContext) docstringLines(node *sitter.Node) (start, end int, ok bool) {
+	body := node.ChildByFieldName("body")
+	if body == nil {
+		return 0, 0, false
+	}
+	stmt := body.NamedChild(0)
+	if stmt == nil || stmt.NamedChildCount() != 1 {
+		return 0, 0, false
+	}
+	doc := stmt.NamedChild(0)
+	if !slices.Contains(commentSyntaxes[tc.language].DocstringKinds, doc.Kind()) || !isDocstring(doc) {
+		return 0, 0, false
+	}
+	return int(doc.StartPosition().Row), int(doc.EndPosition().Row), true
+}
@@ -0,0 +1,130 @@
+package grepast
+
+import (
+	"sort"
+)
+
+// Priorities of shown lines, from most to least important. When Format trims its output to a
+// budget, it drops the least important lines first.
+const (
+	priorityLineOfInterest = iota // Lines of interest.
+	priorityHeader                // Headers of the scopes enclosing lines of interest.
+	priorityParent                // Other lines of the scopes enclosing lines of interest.
+	priorityChild                 // Child scopes of lines of interest.
+	priorityOther                 // Padding, margins, last lines, and filled gaps.
+)
+
+// EstimateTokens returns a rough estimate of the number of language model tokens in s,
+// assuming about four characters per token.
+func EstimateTokens(s string) int {
+	return (len(s) + 3) / 4
+}
+
+// setPriority assigns priority to every shown line that does not have one yet.
+func (tc *TreeContext) setPriority(priority int) {
+	for line := range tc.showLines {
+		if _, ok := tc.linePriority[line]; !ok {
+			tc.linePriority[line] = priority
+		}
+	}
+}
+
+// budgetLines returns the most important shown lines whose rendering fits within the output
+// budget. Lines are ranked by priority, then by distance from the nearest line of interest, then
+// by position, so the result is deterministic.
+func (tc *TreeContext) budgetLines() map[int]struct{} {
+	if tc.fitsBudget(tc.render(tc.showLines)) {
+		return tc.showLines
+	}
+
+	headers := tc.enclosingHeaderLines()
+	priority := func(line int) int {
+		p := tc.linePriority[line]
+		if _, ok := headers[line]; ok && p > priorityHeader {
+			return priorityHeader
+		}
+		return p
+	}
+
+	ranked := mapKeysSorted(tc.showLines)
+	distance := make(map[int]int, len(ranked))
+	lois := mapKeysSorted(tc.linesOfInterest)
+	for _, line := range ranked {
+		distance[line] = nearestDistance(lois, line)
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		a, b := ranked[i], ranked[j]
+		if priority(a) != priority(b) {
+			return priority(a) < priority(b)
+		}
+		return distance[a] < distance[b]
+	})
+
+	// Binary search for the largest number of top-ranked lines whose rendering fits.
+	best := map[int]struct{}{}
+	lo, hi := 1, len(ranked)-1
+	for lo <= hi {
+		n := (lo + hi) / 2
+		show := make(map[int]struct{}, n)
+		for _, line := range ranked[:n] {
+			show[line] = struct{}{}
+		}
+		if tc.fitsBudget(tc.render(show)) {
+			best = show
+			lo = n + 1
+		} else {
+			hi = n - 1
+		}
+	}
+	return best
+}
+
+// enclosingHeaderLines returns the header lines of the scopes, other than the file itself,
+// that enclose a line of interest.
+func (tc *TreeContext) enclosingHeaderLines() map[int]struct{} {
+	headers := make(map[int]struct{})
+	for loi := range tc.linesOfInterest {
+		if loi < 0 || loi >= len(tc.scopes) {
+			continue
+		}
+		for start := range tc.scopes[loi] {
+			node := tc.scopeNode(start)
+			if node == nil || start == loi {
+				continue
+			}
+			r := tc.headerRange(node)
+			for line := r[0]; line <= r[1]; line++ {
+				headers[line] = struct{}{}
+			}
+		}
+	}
+	return headers
+}
+
+// fitsBudget reports whether out is within the configured output limits.
+func (tc *TreeContext) fitsBudget(out string) bool {
+	if tc.maxOutputBytes > 0 && len(out) > tc.maxOutputBytes {
+		return false
+	}
+	if tc.maxOutputTokens > 0 && EstimateTokens(out) > tc.maxOutputTokens {
+		return false
+	}
+	return true
+}
+
+// nearestDistance returns the distance from line to the nearest of the sorted lines, or line
+// itself if there are none.
+func nearestDistance(sorted []int, line int) int {
+	if len(sorted) == 0 {
+		return line
+	}
+	i := sort.SearchInts(sorted, line)
+	d := -1
+	if i < len(sorted) {
+		d = sorted[i] - line
+	}
+	if i > 0 && (d < 0 || line-sorted[i-1] < d) {
+		d = line - sorted[i-1]
+	}
+	return d
+}
@@ -0,0 +1,101 @@
+package grepast
+
+import (
+	"context"
+	"os"
+)
+
+// Builder assembles a search of a single file step by step and runs it with SearchFile, e.g.
+//
+//	res, err := grepast.NewBuilder("main.go").Pattern("foo").ParentContext().ChildContext().Budget(2000).Run()
+//
+// Each method records a setting and returns the Builder, so that calls can be chained. A
+// Builder can be run several times, and changed between runs.
+type Builder struct {
+	path    string
+	source  []byte
+	options SearchOptions
+}
+
+// NewBuilder returns a Builder searching the file at path. Its source is read when the search
+// runs, unless it is given with Source.
+func NewBuilder(path string) *Builder {
+	return &Builder{path: path}
+}
+
+// Source searches source instead of the contents of the file; the path still selects the language.
+func (b *Builder) Source(source []byte) *Builder {
+	b.source = source
+	return b
+}
+
+// Options replaces all the settings of the search, for those without a method of their own.
+func (b *Builder) Options(options SearchOptions) *Builder {
+	b.options = options
+	return b
+}
+
+// Pattern adds a pattern to search for; lines matching any of the patterns are lines of interest.
+func (b *Builder) Pattern(pat string) *Builder {
+	b.options.Patterns = append(b.options.Patterns, pat)
+	return b
+}
+
+// Query also makes the start lines of the captures of a tree-sitter query lines of interest.
+func (b *Builder) Query(query string) *Builder {
+	b.options.Query = query
+	return b
+}
+
+// IgnoreCase matches the patterns case-insensitively.
+func (b *Builder) IgnoreCase() *Builder {
+	b.options.Grep.IgnoreCase = true
+	return b
+}
+
+// ParentContext shows the scopes enclosing the lines of interest.
+func (b *Builder) ParentContext() *Builder {
+	b.options.Context.ShowParentContext = true
+	return b
+}
+
+// ChildContext shows the child scopes of the lines of interest.
+func (b *Builder) ChildContext() *Builder {
+	b.options.Context.ShowChildContext = true
+	return b
+}
+
+// LineNumbers includes line numbers in the output.
+func (b *Builder) LineNumbers() *Builder {
+	b.options.Context.ShowLineNumber = true
+	return b
+}
+
+// Budget trims the least important context until the output is at most tokens estimated tokens.
+func (b *Builder) Budget(tokens int) *Builder {
+	b.options.Context.MaxOutputTokens = tokens
+	return b
+}
+
+// Formatter renders the result with f instead of TextFormatter.
+func (b *Builder) Formatter(f Formatter) *Builder {
+	b.options.Formatter = f
+	return b
+}
+
+// Run reads the file unless its source was given, and searches it.
+func (b *Builder) Run() (*FileResult, error) {
+	return b.RunCtx(context.Background())
+}
+
+// RunCtx is like Run, but gives up once ctx is done, returning ctx.Err().
+func (b *Builder) RunCtx(ctx context.Context) (*FileResult, error) {
+	source := b.source
+	if source == nil {
+		var err error
+		if source, err = os.ReadFile(b.path); err != nil {
+			return nil, err
+		}
+	}
+	return SearchFileCtx(ctx, b.path, source, b.options)
+}
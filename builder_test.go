@@ -0,0 +1,47 @@
+package grepast
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestBuilder tests that a Builder runs the same search as SearchFile.
+func TestBuilder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "example.go")
+	if err := os.WriteFile(path, getExampleSourceCode(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	want, err := SearchFile(path, getExampleSourceCode(), SearchOptions{
+		Patterns: []string{"SMALLSCOPE"},
+		Grep:     GrepOptions{IgnoreCase: true},
+		Context:  TreeContextOptions{ShowParentContext: true, ShowChildContext: true, MaxOutputTokens: 20},
+	})
+	if err != nil {
+		t.Fatalf("SearchFile() error = %v", err)
+	}
+
+	b := NewBuilder(path).Pattern("SMALLSCOPE").IgnoreCase().ParentContext().ChildContext().Budget(20)
+	got, err := b.Run()
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if got.Output != want.Output || fmt.Sprint(got.LinesOfInterest) != fmt.Sprint(want.LinesOfInterest) {
+		t.Errorf("Run() = %q %v, want %q %v", got.Output, got.LinesOfInterest, want.Output, want.LinesOfInterest)
+	}
+
+	// Source is searched instead of the file.
+	got, err = b.Source([]byte("package p\n\nfunc smallScope() {}\n")).Run()
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if len(got.LinesOfInterest) != 1 || got.LinesOfInterest[0] != 2 {
+		t.Errorf("Run() LinesOfInterest = %v, want [2]", got.LinesOfInterest)
+	}
+
+	if _, err := NewBuilder(filepath.Join(t.TempDir(), "missing.go")).Run(); !os.IsNotExist(err) {
+		t.Errorf("Run() error = %v, want a missing file error", err)
+	}
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	grepast "github.com/cyber-nic/grep-ast"
+)
+
+// configFileName is the name of the project config file read from the search root.
+const configFileName = ".grep-ast.json"
+
+// optionsEnv is the environment variable holding default search flags, which are parsed before
+// those of the command line, so that the command line overrides them.
+const optionsEnv = "GREP_AST_OPTS"
+
+// defaultConfig is the built-in config. It is compiled into the binary so that grep-ast behaves
+// the same wherever it is installed, and is what "grep-ast init" writes out.
+//
+//go:embed defaults/config.json
+var defaultConfig []byte
+
+// config selects how context is rendered. Profiles and themes from a project config are added to,
+// or replace, the built-in ones of the same name.
+type config struct {
+	Profile  string                                `json:"profile"`  // Name of the profile to use.
+	Theme    string                                `json:"theme"`    // Name of the theme to use.
+	Profiles map[string]grepast.TreeContextOptions `json:"profiles"` // Context rendering options by name.
+	Themes   map[string][]string                   `json:"themes"`   // SGR parameters of the match colors, e.g. "1;31", by name.
+}
+
+// flagConflicts lists the flags that cannot be used together with each flag. The list of either
+// flag of a conflicting pair may name the other.
+var flagConflicts = map[string][]string{
+	"rev": {"tui", "replace", "diff", "staged", "blame"},
+}
+
+// conflicting reports whether the flags a and b cannot be used together.
+func conflicting(a, b string) bool {
+	for _, name := range flagConflicts[a] {
+		if name == b {
+			return true
+		}
+	}
+	for _, name := range flagConflicts[b] {
+		if name == a {
+			return true
+		}
+	}
+	return false
+}
+
+// envDefaults returns the flags in value, the value of optionsEnv, to be parsed by fs before
+// args, the command line. It rejects words that are not flags, which would end flag parsing and
+// turn the flags of the command line into arguments. Flags conflicting with one given in args
+// are left out, as the command line overrides the defaults.
+func envDefaults(fs *flag.FlagSet, value string, args []string) ([]string, error) {
+	words, err := splitOptions(value)
+	if err != nil {
+		return nil, err
+	}
+	defaults, rest := groupFlags(fs, words)
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("%q is not a flag", rest[0])
+	}
+	given, _ := groupFlags(fs, args)
+
+	var kept []string
+	for _, d := range defaults {
+		overridden := false
+		for _, g := range given {
+			if conflicting(flagName(d[0]), flagName(g[0])) {
+				overridden = true
+				break
+			}
+		}
+		if !overridden {
+			kept = append(kept, d...)
+		}
+	}
+	return kept, nil
+}
+
+// groupFlags splits the leading flags of args, as fs would parse them, into the words of each
+// flag: the flag itself and the value following it, if any. It returns the words left after the
+// flags, starting with the first word that is not a flag, or the one after "--".
+func groupFlags(fs *flag.FlagSet, args []string) (flags [][]string, rest []string) {
+	for i := 0; i < len(args); i++ {
+		word := args[i]
+		if word == "--" {
+			return flags, args[i+1:]
+		}
+		if len(word) < 2 || word[0] != '-' {
+			return flags, args[i:]
+		}
+		group := []string{word}
+		if f := fs.Lookup(flagName(word)); f != nil && !strings.Contains(word, "=") && !isBoolFlag(f) && i+1 < len(args) {
+			i++
+			group = append(group, args[i])
+		}
+		flags = append(flags, group)
+	}
+	return flags, nil
+}
+
+// flagName returns the name of the flag given as word, e.g. "color" for "--color=always".
+func flagName(word string) string {
+	name, _, _ := strings.Cut(strings.TrimPrefix(strings.TrimPrefix(word, "-"), "-"), "=")
+	return name
+}
+
+// isBoolFlag reports whether f may be given without a value, as a switch.
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// splitOptions splits the value of optionsEnv into arguments at unquoted whitespace, as a shell
+// would: single quotes keep their contents as they are, and double quotes and backslashes keep
+// the next character or quoted text from being split, e.g. "-include '*.go' -e \"a b\"".
+func splitOptions(s string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	inArg := false
+	var quote rune
+	escaped := false
+	for _, r := range s {
+		switch {
+		case escaped:
+			arg.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped, inArg = true, true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				arg.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote, inArg = r, true
+		case unicode.IsSpace(r):
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("unterminated quote or escape in %q", s)
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
+
+// loadConfig returns the built-in config overlaid with the project config in root, if there is one.
+// When root is a file, the project config is looked up in its directory.
+func loadConfig(root string) (*config, error) {
+	var cfg config
+	if err := json.Unmarshal(defaultConfig, &cfg); err != nil {
+		return nil, fmt.Errorf("built-in config: %v", err)
+	}
+
+	if info, err := os.Stat(root); err == nil && !info.IsDir() {
+		root = filepath.Dir(root)
+	}
+
+	data, err := os.ReadFile(filepath.Join(root, configFileName))
+	if os.IsNotExist(err) {
+		return &cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var project config
+	if err := json.Unmarshal(data, &project); err != nil {
+		return nil, fmt.Errorf("%s: %v", configFileName, err)
+	}
+	if project.Profile != "" {
+		cfg.Profile = project.Profile
+	}
+	if project.Theme != "" {
+		cfg.Theme = project.Theme
+	}
+	for name, p := range project.Profiles {
+		cfg.Profiles[name] = p
+	}
+	for name, t := range project.Themes {
+		cfg.Themes[name] = t
+	}
+	return &cfg, nil
+}
+
+// contextOptions returns the options of the named profile, or of the configured one if profile
+// is empty, with the match colors of the named or configured theme.
+func (c *config) contextOptions(profile, theme string) (grepast.TreeContextOptions, error) {
+	if profile == "" {
+		profile = c.Profile
+	}
+	if theme == "" {
+		theme = c.Theme
+	}
+
+	options, ok := c.Profiles[profile]
+	if !ok {
+		return options, fmt.Errorf("unknown profile %q", profile)
+	}
+	colors, ok := c.Themes[theme]
+	if !ok {
+		return options, fmt.Errorf("unknown theme %q", theme)
+	}
+	options.MatchColors = make([]string, len(colors))
+	for i, sgr := range colors {
+		options.MatchColors[i] = "\033[" + sgr + "m"
+	}
+	return options, nil
+}
+
+// resolveContextOptions loads the config for root and returns the options of the given profile and
+// theme, colored according to the -color mode, exiting with an error message if they cannot be resolved.
+func resolveContextOptions(root, profile, theme, color string) grepast.TreeContextOptions {
+	cfg, err := loadConfig(root)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error loading config: %v\n", err)
+		os.Exit(1)
+	}
+	options, err := cfg.contextOptions(profile, theme)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error loading config: %v\n", err)
+		os.Exit(1)
+	}
+	switch color {
+	case "always":
+		options.Color = true
+	case "never":
+		options.Color = false
+	case "auto":
+		// Keep the profile's colors only for people reading a terminal, who have not opted out.
+		options.Color = options.Color && os.Getenv("NO_COLOR") == "" && isTerminal(os.Stdout)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown color mode %q (want auto, always, or never)\n", color)
+		os.Exit(1)
+	}
+	return options
+}
+
+// optionFlags are flags overriding single context options of the profile. Unlike the switches
+// that can only turn an option on, they override it whatever their value, so that e.g.
+// -mark-loi=false turns off an option the profile turns on.
+type optionFlags struct {
+	fs   *flag.FlagSet
+	sets map[string]func(*grepast.TreeContextOptions) // Sets the option of each flag to its value.
+}
+
+// newOptionFlags returns optionFlags defining their flags in fs.
+func newOptionFlags(fs *flag.FlagSet) *optionFlags {
+	return &optionFlags{fs: fs, sets: make(map[string]func(*grepast.TreeContextOptions))}
+}
+
+// boolFlag defines a flag setting the option returned by field, or clearing it if invert is set.
+func (o *optionFlags) boolFlag(name string, invert bool, usage string, field func(*grepast.TreeContextOptions) *bool) {
+	v := o.fs.Bool(name, false, usage)
+	o.sets[name] = func(opts *grepast.TreeContextOptions) { *field(opts) = *v != invert }
+}
+
+// intFlag defines a flag setting the option returned by field.
+func (o *optionFlags) intFlag(name, usage string, field func(*grepast.TreeContextOptions) *int) {
+	v := o.fs.Int(name, 0, usage)
+	o.sets[name] = func(opts *grepast.TreeContextOptions) { *field(opts) = *v }
+}
+
+// apply overrides the options of opts whose flags were given.
+func (o *optionFlags) apply(opts *grepast.TreeContextOptions) {
+	o.fs.Visit(func(f *flag.Flag) {
+		if set, ok := o.sets[f.Name]; ok {
+			set(opts)
+		}
+	})
+}
+
+// isTerminal reports whether f is a terminal rather than a file or pipe.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// runInit implements the "init" subcommand, which writes the built-in config to a project
+// directory as a starting point for customization.
+func runInit(arguments []string) {
+	fs := flag.NewFlagSet("init", flag.ExitOnError)
+	force := fs.Bool("force", false, "overwrite an existing config file")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: grep-ast init [flags] [directory]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(arguments)
+	args := fs.Args()
+
+	if len(args) > 1 {
+		fs.Usage()
+		return
+	}
+	path := filepath.Join(resolveRoot(args), configFileName)
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if *force {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	f, err := os.OpenFile(path, flags, 0o644)
+	if err != nil {
+		if os.IsExist(err) {
+			fmt.Fprintf(os.Stderr, "%s already exists; use -force to overwrite it\n", path)
+		} else {
+			fmt.Fprintf(os.Stderr, "error creating config: %v\n", err)
+		}
+		os.Exit(1)
+	}
+	if _, err := f.Write(defaultConfig); err != nil {
+		f.Close()
+		fmt.Fprintf(os.Stderr, "error writing config: %v\n", err)
+		os.Exit(1)
+	}
+	if err := f.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "error writing config: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("wrote %s\n", path)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"testing"
+)
+
+// TestSplitOptions tests splitting the value of the options variable into arguments as a shell would.
+func TestSplitOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		expected []string
+		err      bool
+	}{
+		{"Empty", "", nil, false},
+		{"Spaces", "  -i \t -l\n", []string{"-i", "-l"}, false},
+		{"SingleQuotes", `-include '*.go' -e 'a \b'`, []string{"-include", "*.go", "-e", `a \b`}, false},
+		{"DoubleQuotes", `-e "a \"b\" c"`, []string{"-e", `a "b" c`}, false},
+		{"Escapes", `-e a\ b\\`, []string{"-e", `a b\`}, false},
+		{"EmptyQuoted", `-e ''`, []string{"-e", ""}, false},
+		{"Joined", `-e=a'b c'd`, []string{"-e=ab cd"}, false},
+		{"UnterminatedQuote", `-e 'a`, nil, true},
+		{"UnterminatedEscape", `-e a\`, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := splitOptions(tt.value)
+			if (err != nil) != tt.err {
+				t.Fatalf("splitOptions(%q) error = %v, want error %v", tt.value, err, tt.err)
+			}
+			if fmt.Sprintf("%q", got) != fmt.Sprintf("%q", tt.expected) {
+				t.Errorf("splitOptions(%q) = %q, want %q", tt.value, got, tt.expected)
+			}
+		})
+	}
+}
+
+// TestEnvDefaults tests reading default flags from the options variable, rejecting other words
+// and leaving out the defaults conflicting with the command line.
+func TestEnvDefaults(t *testing.T) {
+	fs := flag.NewFlagSet("grep-ast", flag.ContinueOnError)
+	fs.Bool("i", false, "")
+	fs.Bool("blame", false, "")
+	fs.String("color", "auto", "")
+	fs.String("rev", "", "")
+	var diff diffFlag
+	fs.Var(&diff, "diff", "")
+
+	tests := []struct {
+		name     string
+		value    string
+		args     []string
+		expected []string
+		err      bool
+	}{
+		{"Flags", "-i --color always -diff", []string{"needle"}, []string{"-i", "--color", "always", "-diff"}, false},
+		{"FlagValue", "-color=never -i", nil, []string{"-color=never", "-i"}, false},
+		{"Positional", "-i needle", []string{"-l"}, nil, true},
+		{"Separator", "-i -- needle", nil, nil, true},
+		{"Conflict", "-blame -i", []string{"-rev", "HEAD", "needle"}, []string{"-i"}, false},
+		{"ConflictReversed", "-rev HEAD -i", []string{"-diff=main"}, []string{"-i"}, false},
+		{"ConflictAfterArguments", "-blame", []string{"needle", "-rev", "HEAD"}, []string{"-blame"}, false},
+		{"BadQuote", "-e 'a", nil, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := envDefaults(fs, tt.value, tt.args)
+			if (err != nil) != tt.err {
+				t.Fatalf("envDefaults(%q) error = %v, want error %v", tt.value, err, tt.err)
+			}
+			if fmt.Sprintf("%q", got) != fmt.Sprintf("%q", tt.expected) {
+				t.Errorf("envDefaults(%q, %q) = %q, want %q", tt.value, tt.args, got, tt.expected)
+			}
+		})
+	}
+}
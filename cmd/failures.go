@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+
+	grepast "github.com/cyber-nic/grep-ast"
+)
+
+// failureLog reports the files and directories that fail unexpectedly on standard error, and
+// keeps them for -strict and the report. It is safe for concurrent use, as walks report the
+// directories they cannot read from their own goroutines.
+type failureLog struct {
+	mu       sync.Mutex
+	failures []reportFailure
+}
+
+// add reports the failure of the file or directory at displayPath, unless err only means it is
+// not a file grep-ast can search.
+func (l *failureLog) add(displayPath string, err error) {
+	if unsearchable(err) {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// Erase any progress line first.
+	prefix := ""
+	if isTerminal(os.Stderr) {
+		prefix = "\r\033[K"
+	}
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		// The error already names the path.
+		fmt.Fprintf(os.Stderr, "%sgrep-ast: %v\n", prefix, err)
+	} else {
+		fmt.Fprintf(os.Stderr, "%sgrep-ast: %s: %v\n", prefix, displayPath, err)
+	}
+	l.failures = append(l.failures, reportFailure{Path: displayPath, Error: err.Error()})
+}
+
+// count returns the number of failures reported.
+func (l *failureLog) count() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.failures)
+}
+
+// record adds the failures to report, if it is not nil.
+func (l *failureLog) record(report *runReport) {
+	if report == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	report.Files.Failed += len(l.failures)
+	report.Failures = append(report.Failures, l.failures...)
+}
+
+// unsearchable reports whether err means a file is skipped because it is binary or of a type
+// grep-ast cannot parse, which is expected while walking a tree.
+func unsearchable(err error) bool {
+	return errors.Is(err, grepast.ErrorUnrecognizedFiletype) ||
+		errors.Is(err, grepast.ErrorUnsupportedLanguage) ||
+		errors.Is(err, grepast.ErrorBinaryFile)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	grepast "github.com/cyber-nic/grep-ast"
+)
+
+// goFileMethods are the methods declared in one Go file.
+type goFileMethods struct {
+	path    string
+	rel     string
+	methods []grepast.GoMethod
+}
+
+// runImpls implements the "impls" subcommand, which finds Go types whose methods textually
+// satisfy a named interface and renders their method definitions with context.
+func runImpls(arguments []string) {
+	fs := flag.NewFlagSet("impls", flag.ExitOnError)
+	profile := fs.String("profile", "", "render context with this config profile")
+	theme := fs.String("theme", "", "highlight matches with this config theme")
+	syntax := fs.Bool("syntax", false, "color shown lines by syntax: keywords, strings, comments, and so on")
+	color := fs.String("color", "auto", "color the output: auto (when printing to a terminal and NO_COLOR is unset), always, or never")
+	strict := fs.Bool("strict", false, "exit with status 1 if any file cannot be read or parsed; such failures are reported on standard error either way")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: grep-ast impls [flags] interface_name <file/directory path>\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(arguments)
+	args := fs.Args()
+
+	if len(args) < 1 || len(args) > 2 {
+		fs.Usage()
+		return
+	}
+	ifaceName := args[0]
+	rootPath := resolveRoot(args[1:])
+	ctxOpts := resolveContextOptions(rootPath, *profile, *theme, *color)
+	ctxOpts.SyntaxHighlight = ctxOpts.SyntaxHighlight || *syntax
+
+	var ifaces []grepast.GoInterface
+	var files []goFileMethods
+	isGo := func(path string) bool { return filepath.Ext(path) == ".go" }
+	failures := &failureLog{}
+	err := parseFiles(rootPath, grepast.TreeContextOptions{}, isGo, func(path, rel string, tc *grepast.TreeContext) {
+		ifaces = append(ifaces, tc.GoInterfaces()...)
+		files = append(files, goFileMethods{path: path, rel: rel, methods: tc.GoMethods()})
+	}, failures.add)
+	if err != nil {
+		panic(fmt.Errorf("Error walking the path: %v", err))
+	}
+
+	methodSet := grepast.GoInterfaceMethodSet(ifaceName, ifaces)
+	if methodSet == nil {
+		fmt.Fprintf(os.Stderr, "interface %s not found\n", ifaceName)
+		os.Exit(1)
+	}
+
+	// Types are matched per package, i.e. per directory.
+	byPackage := make(map[string][]goFileMethods)
+	for _, f := range files {
+		dir := filepath.Dir(f.rel)
+		byPackage[dir] = append(byPackage[dir], f)
+	}
+	dirs := make([]string, 0, len(byPackage))
+	for dir := range byPackage {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	for _, dir := range dirs {
+		var methods []grepast.GoMethod
+		for _, f := range byPackage[dir] {
+			methods = append(methods, f.methods...)
+		}
+		impls := grepast.GoImplementations(methodSet, methods)
+
+		receivers := make([]string, 0, len(impls))
+		for receiver := range impls {
+			receivers = append(receivers, receiver)
+		}
+		sort.Strings(receivers)
+
+		for _, receiver := range receivers {
+			label := receiver
+			if dir != "." {
+				label = dir + "." + receiver
+			}
+			fmt.Printf("\n%s implements %s\n", label, ifaceName)
+			for _, f := range byPackage[dir] {
+				lois := make(map[int]struct{})
+				for _, m := range f.methods {
+					if m.Receiver != receiver {
+						continue
+					}
+					for _, name := range methodSet {
+						if m.Name == name {
+							lois[m.StartLine] = struct{}{}
+						}
+					}
+				}
+				if len(lois) == 0 {
+					continue
+				}
+				renderLines(f.path, f.rel, lois, ctxOpts)
+			}
+		}
+	}
+
+	if *strict && failures.count() > 0 {
+		os.Exit(1)
+	}
+}
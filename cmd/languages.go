@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	grepast "github.com/cyber-nic/grep-ast"
+)
+
+// runLanguages implements the "languages" subcommand, which lists the file extensions and names
+// grep-ast recognizes, the language each maps to, and whether its grammar is compiled in.
+func runLanguages(arguments []string) {
+	fs := flag.NewFlagSet("languages", flag.ExitOnError)
+	supported := fs.Bool("supported", false, "list only the files whose language can be parsed")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: grep-ast languages [flags]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(arguments)
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FILE\tLANGUAGE\tSUPPORTED")
+	for _, ft := range grepast.FileTypes() {
+		if *supported && !ft.Supported {
+			continue
+		}
+		status := "no"
+		if ft.Supported {
+			status = "yes"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", ft.Pattern, ft.Language, status)
+	}
+	w.Flush()
+}
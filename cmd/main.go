@@ -1,120 +1,792 @@
 package main
 
 import (
+	"bytes"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"os"
+	"path"
 	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
 
-	goignore "github.com/cyber-nic/go-gitignore"
 	grepast "github.com/cyber-nic/grep-ast"
 )
 
+// stringList collects the values of a repeatable flag.
+type stringList []string
+
+func (p *stringList) String() string {
+	return strings.Join(*p, ", ")
+}
+
+func (p *stringList) Set(value string) error {
+	*p = append(*p, value)
+	return nil
+}
+
+// diffFlag is the value of -diff, which may be given alone to diff against the default base.
+type diffFlag struct {
+	set  bool
+	base string // Revision given as -diff=BASE, or "" for the default base.
+}
+
+func (d *diffFlag) String() string {
+	return d.base
+}
+
+func (d *diffFlag) Set(value string) error {
+	switch value {
+	case "true":
+		d.set, d.base = true, ""
+	case "false":
+		d.set, d.base = false, ""
+	default:
+		d.set, d.base = true, value
+	}
+	return nil
+}
+
+// IsBoolFlag lets -diff be given without a value.
+func (d *diffFlag) IsBoolFlag() bool {
+	return true
+}
+
+// commands describes the subcommands for the usage message, in the order they are listed.
+var commands = [][2]string{
+	{"search", "search files for patterns and show the matches with context (the default)"},
+	{"symbols", "fuzzy-find definitions by name and show them with context (alias: sym)"},
+	{"impls", "find the Go types whose methods satisfy an interface and show those methods"},
+	{"outline", "show the header of every top-level declaration with the bodies elided"},
+	{"languages", "list the file extensions recognized, their languages, and which can be parsed"},
+	{"init", "write a " + configFileName + " with the default profiles"},
+	{"help", "show the flags of a command, e.g. grep-ast help outline"},
+}
+
+// runCommand runs the subcommand name other than search with arguments, and reports whether
+// there is such a subcommand.
+func runCommand(name string, arguments []string) bool {
+	switch name {
+	case "symbols", "sym":
+		runSym(arguments)
+	case "impls":
+		runImpls(arguments)
+	case "outline":
+		runOutline(arguments)
+	case "languages", "list-languages":
+		runLanguages(arguments)
+	case "init":
+		runInit(arguments)
+	default:
+		return false
+	}
+	return true
+}
+
 func main() {
-	// Check for the correct number of arguments
-	if len(os.Args) < 2 || len(os.Args) > 3 {
-		fmt.Fprintf(os.Stderr, "Usage: grep-ast search_pattern <file/directory path>\n")
+	if len(os.Args) > 1 {
+		arguments := os.Args[2:]
+		switch name := os.Args[1]; name {
+		case "help":
+			// Show the flags of the named command, or of search.
+			if len(arguments) > 0 && runCommand(arguments[0], []string{"-h"}) {
+				return
+			}
+			os.Args = []string{os.Args[0], "-h"}
+		case "search":
+			os.Args = append([]string{os.Args[0]}, arguments...)
+		default:
+			if runCommand(name, arguments) {
+				return
+			}
+		}
+	}
+
+	var grepOpts grepast.GrepOptions
+	var patterns stringList
+	flag.Var(&patterns, "e", "search for this pattern; may be repeated to match any of several patterns")
+	ignoreCase := flag.Bool("i", false, "match case-insensitively (default: only when the patterns have no uppercase letters)")
+	flag.BoolVar(ignoreCase, "ignore-case", false, "same as -i")
+	caseSensitive := flag.Bool("s", false, "match case-sensitively, even when the patterns have no uppercase letters")
+	flag.BoolVar(caseSensitive, "case-sensitive", false, "same as -s")
+	flag.BoolVar(&grepOpts.Literal, "F", false, "treat the pattern as a literal string rather than a regular expression")
+	flag.BoolVar(&grepOpts.Literal, "fixed-strings", false, "same as -F")
+	flag.BoolVar(&grepOpts.Multiline, "U", false, "allow matches to span multiple lines")
+	flag.BoolVar(&grepOpts.Multiline, "multiline", false, "same as -U")
+	flag.BoolVar(&grepOpts.Structural, "S", false, "treat the pattern as a structural pattern in which :[name] matches any balanced code, e.g. 'foo(:[args])'")
+	flag.BoolVar(&grepOpts.Structural, "structural", false, "same as -S")
+	flag.BoolVar(&grepOpts.Fuzzy, "fuzzy", false, "match lines containing the pattern's characters in order, ignoring case, e.g. hndlr for handler")
+	flag.BoolVar(&grepOpts.Invert, "v", false, "select lines that do not match")
+	flag.BoolVar(&grepOpts.Invert, "invert-match", false, "same as -v")
+	flag.Var((*stringList)(&grepOpts.NodeKinds), "in-kind", "only match text inside parse-tree nodes of this kind (e.g. comment); may be repeated")
+	flag.BoolVar(&grepOpts.IdentifiersOnly, "identifiers", false, "only match the names of definitions such as functions, types, and variables, not references to them")
+	flag.BoolVar(&grepOpts.CommentsOnly, "comments-only", false, "only match text inside comments and docstrings")
+	flag.BoolVar(&grepOpts.MatchAllInScope, "all-in-scope", false, "only report matches in scopes where every -e pattern matches")
+	var include stringList
+	flag.Var(&include, "include", "only search files whose name or path matches this glob, e.g. '*.go'; may be repeated")
+	var exclude stringList
+	flag.Var(&exclude, "exclude", "skip files matching this gitignore-style pattern, e.g. 'vendor/**' or '*.min.js', as if it were in the ignore file; may be repeated")
+	maxDepth := flag.Int("max-depth", 0, "only search files at most this many directories deep, where 1 is the files directly in a searched directory (default: no limit)")
+	noGitIgnore := flag.Bool("no-gitignore", false, "also search the files ignored by git through .gitignore files and .git/info/exclude")
+	var languages stringList
+	flag.Var(&languages, "lang", "only search files of these comma-separated languages, by file name, e.g. go,python; may be repeated")
+	follow := flag.Bool("follow", false, "search the directories that symbolic links point to, searching each directory once")
+	var gitFilter grepast.GitFilter
+	flag.StringVar(&gitFilter.Since, "changed-since", "", "only search files changed by git commits newer than this date, e.g. 2weeks or 2024-01-31")
+	flag.StringVar(&gitFilter.Author, "author", "", "only search files changed by git commits whose author matches this pattern")
+	flag.BoolVar(&gitFilter.BlameLines, "blame", false, "with -changed-since or -author, only report matching lines that git blame attributes to those commits")
+	var diff diffFlag
+	flag.BoolVar(&gitFilter.Staged, "staged", false, "only report matching lines added or changed by the changes staged in git, reading files as staged, e.g. in a pre-commit hook")
+	rev := flag.String("rev", "", "search the files of this git commit, e.g. v1.2.0 or HEAD~3, read from the repository instead of the work tree")
+	flag.Var(&diff, "diff", "only report matching lines added or changed, committed or not, since the branch forked from main or master, or from BASE if given as -diff=BASE")
+	nodeText := flag.String("node-text", "", "search for parse-tree nodes whose source text equals this text, ignoring whitespace, e.g. 'errors.New(\"not found\")'; -e patterns are then matched as node text too")
+	query := flag.String("query", "", "tree-sitter query whose captures are lines of interest, used instead of or with patterns")
+	reportPath := flag.String("report", "", "write a local JSON report of this run (options, timings, language mix, slowest files to parse) to this file")
+	replacement := flag.String("replace", "", "rewrite the matches of the structural pattern with this template, in which :[name] is the text a placeholder matched")
+	matchWorkers := flag.Int("match-workers", 0, "number of files parsed and searched at once (default: number of CPUs)")
+	formatWorkers := flag.Int("format-workers", 0, "number of files whose context is rendered at once (default: number of CPUs)")
+	maxCount := flag.Int("max-count", 0, "show at most this many matching lines per file (default: no limit)")
+	maxMatches := flag.Int("max-matches", 0, "show at most this many matching lines in all, in walk order (default: no limit)")
+	fileTimeout := flag.Duration("file-timeout", 0, "skip files that take longer than this to parse and search, e.g. 2s (default: no limit)")
+	countHeading := flag.Bool("heading", false, "introduce each file with a heading giving its number of matching lines, e.g. == main.go (2 matches) ==")
+	hyperlinkFormat := flag.String("hyperlink-format", "", "when printing to a terminal, link file names and line numbers to this URL template, e.g. vscode://file{path}:{line} or file://{path}")
+	noHeading := flag.Bool("no-heading", false, "print the path of each file at the start of each of its lines instead of above them, as grep does")
+	showProgress := flag.Bool("progress", false, "show the number of files searched and the current file on standard error while searching, when it is a terminal")
+	unordered := flag.Bool("unordered", false, "print files as soon as they are searched rather than in walk order")
+	sortBy := flag.String("sort", "", "print the files with matches once all are searched, sorted by path, modified (most recent first), or matches (most first)")
+	dryRun := flag.Bool("dry-run", false, "with -replace, print the diff without writing files")
+	profile := flag.String("profile", "", "render context with this config profile (default from "+configFileName+" or \"default\")")
+	theme := flag.String("theme", "", "highlight matches with this config theme")
+	syntax := flag.Bool("syntax", false, "color shown lines by syntax: keywords, strings, comments, and so on")
+	color := flag.String("color", "auto", "color the output: auto (when printing to a terminal and NO_COLOR is unset), always, or never")
+	after := flag.Int("A", 0, "show this many lines after each matching line, in addition to the parent and child scopes of the profile")
+	before := flag.Int("B", 0, "show this many lines before each matching line, in addition to the parent and child scopes of the profile")
+	around := flag.Int("C", 0, "show this many lines before and after each matching line; -A and -B override it on their side")
+	forceLang := flag.String("force-lang", "", "parse every searched file as this language, e.g. go for templates with a nonstandard extension such as .gotmpl")
+	optFlags := newOptionFlags(flag.CommandLine)
+	optFlags.boolFlag("no-parent-context", true, "do not show the scopes enclosing matches", func(o *grepast.TreeContextOptions) *bool { return &o.ShowParentContext })
+	optFlags.boolFlag("no-child-context", true, "do not show the scopes nested in matching lines", func(o *grepast.TreeContextOptions) *bool { return &o.ShowChildContext })
+	optFlags.boolFlag("signature-only", false, "show only the signatures of the scopes enclosing matches, collapsing their bodies", func(o *grepast.TreeContextOptions) *bool { return &o.SignatureOnly })
+	optFlags.intFlag("header-max", "show at most this many lines of the header of each enclosing scope", func(o *grepast.TreeContextOptions) *int { return &o.HeaderMax })
+	optFlags.boolFlag("header-annotations", false, "extend headers over the decorators, annotations, and comments above them", func(o *grepast.TreeContextOptions) *bool { return &o.HeaderAnnotations })
+	optFlags.boolFlag("show-top-scope", false, "always show the top-most scope enclosing matches from the start of the file", func(o *grepast.TreeContextOptions) *bool { return &o.ShowTopOfFileParentScope })
+	optFlags.boolFlag("last-line", false, "always show the last line of the file", func(o *grepast.TreeContextOptions) *bool { return &o.ShowLastLine })
+	optFlags.intFlag("margin", "always show this many lines at the top of the file", func(o *grepast.TreeContextOptions) *int { return &o.MarginPadding })
+	optFlags.boolFlag("mark-loi", false, "mark matching lines in the gutter", func(o *grepast.TreeContextOptions) *bool { return &o.MarkLinesOfInterest })
+	optFlags.boolFlag("line-numbers", false, "show line numbers", func(o *grepast.TreeContextOptions) *bool { return &o.ShowLineNumber })
+	optFlags.intFlag("tab-width", "expand tabs to spaces with a tab stop every this many columns (0 keeps tabs)", func(o *grepast.TreeContextOptions) *int { return &o.TabWidth })
+	optFlags.boolFlag("preserve-line-endings", false, "print lines ending in CRLF with that ending", func(o *grepast.TreeContextOptions) *bool { return &o.PreserveLineEndings })
+	optFlags.boolFlag("trailing-empty-line", false, "show the empty line after a trailing newline as a line of its own", func(o *grepast.TreeContextOptions) *bool { return &o.ShowTrailingEmptyLine })
+	optFlags.intFlag("max-bytes", "trim the least important context until each file's output is at most this many bytes (0: no limit)", func(o *grepast.TreeContextOptions) *int { return &o.MaxOutputBytes })
+	optFlags.intFlag("max-tokens", "trim the least important context until each file's output is at most this many estimated tokens (0: no limit)", func(o *grepast.TreeContextOptions) *int { return &o.MaxOutputTokens })
+	optFlags.intFlag("max-walk-depth", "do not walk parse-tree nodes nested deeper than this (0: no limit)", func(o *grepast.TreeContextOptions) *int { return &o.MaxWalkDepth })
+	optFlags.intFlag("max-nodes-per-line", "record at most this many parse-tree nodes starting on each line (0: no limit)", func(o *grepast.TreeContextOptions) *int { return &o.MaxNodesPerLine })
+	optFlags.boolFlag("verbose", false, "print debugging details about how the context was chosen", func(o *grepast.TreeContextOptions) *bool { return &o.Verbose })
+	fullScope := flag.Bool("full-scope", false, "show the whole function, method, or class enclosing each match")
+	docComments := flag.Bool("doc-comments", false, "show the doc comments and docstrings of matching declarations")
+	closingLines := flag.Bool("closing-lines", false, "show the closing brace or keyword of every scope whose first line is shown")
+	preamble := flag.Bool("preamble", false, "show the package clause and imports of files with matches")
+	breadcrumbs := flag.Bool("breadcrumbs", false, "show the file and enclosing definitions above each run of shown lines, e.g. main.go ▸ func main()")
+	scopeKinds := flag.Bool("scope-kinds", false, "label the first line of each shown scope with its kind, e.g. [func] or [class]")
+	strictParse := flag.Bool("strict-parse", false, "show only the matching lines of files whose parse is badly broken, as plain grep would")
+	detectEncoding := flag.Bool("detect-encoding", false, "transcode UTF-16 files with a byte order mark, and latin-1 files, to UTF-8 before searching")
+	column := flag.Bool("column", false, "prefix shown lines with file:line: and matched lines with file:line:col: for editors to jump to")
+	format := flag.String("format", "text", "output format: text, json for one JSON object per file, or sarif for a SARIF 2.1.0 log of the matches")
+	jsonOutput := flag.Bool("json", false, "same as -format json")
+	nodeKinds := flag.Bool("node-kinds", false, "with -format json, add the number of parse-tree nodes of each kind in the file, e.g. function_declaration or ERROR")
+	filesWithMatches := flag.Bool("l", false, "print only the paths of files with matches, one per line, instead of their context")
+	flag.BoolVar(filesWithMatches, "files-with-matches", false, "same as -l")
+	count := flag.Bool("c", false, "print only the number of matching lines of each file with matches, as path:count, and their total")
+	flag.BoolVar(count, "count", false, "same as -c")
+	onlyMatching := flag.Bool("o", false, "print only the text matched by the patterns, one match per line, as path:line:col:text")
+	flag.BoolVar(onlyMatching, "only-matching", false, "same as -o")
+	countMatches := flag.Bool("count-matches", false, "like -o, but print each distinct matched text once with its number of matches, most first")
+	nul := flag.Bool("0", false, "with -l, -c, or -files, end each path with a NUL byte instead of a newline or colon, for xargs -0")
+	flag.BoolVar(nul, "null", false, "same as -0")
+	filesFrom := flag.String("files-from", "", "search the files listed in this file, or - for standard input, one per line or NUL-separated as printed by git ls-files -z, instead of walking paths")
+	interactive := flag.Bool("tui", false, "search interactively in a terminal UI, refining the pattern while the matches and the context of the selected one update; Enter prints the location of the selected match")
+	strict := flag.Bool("strict", false, "exit with status 1 if any file or directory cannot be read or searched; such failures are reported on standard error either way")
+	filesOnly := flag.Bool("files", false, "print the paths of the files that would be searched, after ignore files and filters, without searching them; takes no pattern")
+	workspacePath := flag.String("workspace", "", "search the roots listed in this workspace YAML file instead of a path")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: grep-ast [search] [flags] search_pattern [file or directory ...]\n")
+		fmt.Fprintf(os.Stderr, "       grep-ast [search] [flags] -e pattern [-e pattern ...] [file or directory ...]\n")
+		fmt.Fprintf(os.Stderr, "       grep-ast [search] [flags] -query '(tree-sitter query)' [file or directory ...]\n")
+		fmt.Fprintf(os.Stderr, "       grep-ast [search] [flags] -workspace workspace.yaml search_pattern\n")
+		fmt.Fprintf(os.Stderr, "       grep-ast [search] -replace template [-dry-run] structural_pattern [file or directory ...]\n")
+		fmt.Fprintf(os.Stderr, "       grep-ast <command> [flags] [arguments]\n")
+		fmt.Fprintf(os.Stderr, "\nCommands:\n")
+		for _, c := range commands {
+			fmt.Fprintf(os.Stderr, "  %-9s %s\n", c[0], c[1])
+		}
+		fmt.Fprintf(os.Stderr, "\nSearch flags, which may also be given in $%s:\n", optionsEnv)
+		flag.PrintDefaults()
+	}
+	defaults, err := envDefaults(flag.CommandLine, os.Getenv(optionsEnv), os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "bad $%s: %v\n", optionsEnv, err)
+		os.Exit(2)
+	}
+	flag.CommandLine.Parse(append(defaults, os.Args[1:]...))
+	args := flag.Args()
+
+	if *nodeText != "" {
+		grepOpts.NodeText = true
+		patterns = append(patterns, *nodeText)
+	}
+
+	// Get the search patterns, either from -e flags or the first argument, unless a query is given
+	if len(patterns) == 0 && *query == "" && !*filesOnly && !*interactive {
+		if len(args) == 0 {
+			flag.Usage()
+			return
+		}
+		patterns = append(patterns, args[0])
+		args = args[1:]
+	}
+
+	for _, pattern := range include {
+		if _, err := path.Match(pattern, ""); err != nil {
+			fmt.Fprintf(os.Stderr, "bad -include pattern %q: %v\n", pattern, err)
+			os.Exit(1)
+		}
+	}
+
+	if *forceLang != "" && !slices.Contains(grepast.SupportedLanguages(), strings.ToLower(*forceLang)) {
+		fmt.Fprintf(os.Stderr, "bad -force-lang %q: want one of %s\n", *forceLang, strings.Join(grepast.SupportedLanguages(), ", "))
+		os.Exit(1)
+	}
+
+	if *sortBy != "" && !slices.Contains(sortOrders, *sortBy) {
+		fmt.Fprintf(os.Stderr, "bad -sort %q: want one of %s\n", *sortBy, strings.Join(sortOrders, ", "))
+		os.Exit(1)
+	}
+
+	if *rev != "" {
+		for _, name := range flagConflicts["rev"] {
+			if isFlagSet(flag.CommandLine, name) {
+				fmt.Fprintf(os.Stderr, "-rev cannot be used with -%s\n", name)
+				os.Exit(1)
+			}
+		}
+	}
+
+	var langs []string
+	for _, l := range languages {
+		langs = append(langs, strings.Split(l, ",")...)
+	}
+
+	// Smart case: lowercase patterns match any case, unless a flag says otherwise
+	grepOpts.IgnoreCase = *ignoreCase || (!*caseSensitive && !hasUppercase(patterns))
+
+	// Check for the correct number of arguments; -workspace and -files-from replace the paths, and each other
+	if (*workspacePath != "" || *filesFrom != "") && len(args) > 0 || *workspacePath != "" && *filesFrom != "" {
+		flag.Usage()
 		return
 	}
+	var files []string
+	if *filesFrom != "" {
+		var err error
+		if files, err = readFileList(*filesFrom); err != nil {
+			fmt.Fprintf(os.Stderr, "error reading -files-from: %v\n", err)
+			os.Exit(1)
+		}
+	}
 
-	var err error
-	rootPath := "."
+	roots := pathRoots(args, *profile)
+	if *workspacePath != "" {
+		var err error
+		if roots, err = loadWorkspace(*workspacePath, *profile); err != nil {
+			fmt.Fprintf(os.Stderr, "error loading workspace: %v\n", err)
+			os.Exit(1)
+		}
+	}
 
-	// Get the search query
-	searchQuery := os.Args[1]
+	if diff.set {
+		base := diff.base
+		if base == "" {
+			dir := roots[0].path
+			if info, err := os.Stat(dir); err == nil && !info.IsDir() {
+				dir = filepath.Dir(dir)
+			}
+			var err error
+			if base, err = grepast.DefaultDiffBase(dir); err != nil {
+				fmt.Fprintf(os.Stderr, "bad -diff: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		gitFilter.Diff = base
+	}
+
+	failures := &failureLog{}
+	walkerOptions := func(root searchRoot) grepast.WalkerOptions {
+		return grepast.WalkerOptions{
+			OnError: func(path string, err error) error {
+				failures.add(path, err)
+				return nil
+			},
+			IgnoreFileName: root.ignoreFile,
+			Include:        include,
+			Exclude:        exclude,
+			MaxDepth:       *maxDepth,
+			FollowSymlinks: *follow,
+			NoGitIgnore:    *noGitIgnore,
+			Languages:      langs,
+			Files:          files,
+			Revision:       *rev,
+		}
+	}
+
+	// contextOptions returns the context options of a root's profile, with the flags applied.
+	contextOptions := func(root searchRoot) grepast.TreeContextOptions {
+		ctxOpts := resolveContextOptions(root.path, root.profile, *theme, *color)
+		optFlags.apply(&ctxOpts)
+		if *forceLang != "" {
+			ctxOpts.Language = *forceLang
+		}
+		ctxOpts.SyntaxHighlight = ctxOpts.SyntaxHighlight || *syntax
+		ctxOpts.ShowMatchLocation = ctxOpts.ShowMatchLocation || *column
+		ctxOpts.DetectEncoding = ctxOpts.DetectEncoding || *detectEncoding
+		ctxOpts.ExpandFullScope = ctxOpts.ExpandFullScope || *fullScope
+		ctxOpts.ShowBreadcrumbs = ctxOpts.ShowBreadcrumbs || *breadcrumbs
+		ctxOpts.ShowDocComments = ctxOpts.ShowDocComments || *docComments
+		ctxOpts.ShowPreamble = ctxOpts.ShowPreamble || *preamble
+		ctxOpts.ShowClosingLines = ctxOpts.ShowClosingLines || *closingLines
+		ctxOpts.StrictParse = ctxOpts.StrictParse || *strictParse
+		ctxOpts.ShowScopeKinds = ctxOpts.ShowScopeKinds || *scopeKinds
+		ctxOpts.HyperlinkFormat = *hyperlinkFormat
+		if isFlagSet(flag.CommandLine, "A") || isFlagSet(flag.CommandLine, "B") || isFlagSet(flag.CommandLine, "C") {
+			// Line context replaces the padding of the profile, so that e.g. -A 0 shows no lines after matches.
+			ctxOpts.LinesOfInterestPadding = 0
+			ctxOpts.LinesOfInterestPaddingBefore, ctxOpts.LinesOfInterestPaddingAfter = *around, *around
+			if isFlagSet(flag.CommandLine, "B") {
+				ctxOpts.LinesOfInterestPaddingBefore = *before
+			}
+			if isFlagSet(flag.CommandLine, "A") {
+				ctxOpts.LinesOfInterestPaddingAfter = *after
+			}
+		}
+		return ctxOpts
+	}
 
-	// Get the root path
-	if len(os.Args) == 2 {
-		// If only one argument, set rootPath to current working directory
-		rootPath, err = os.Getwd()
+	if *interactive {
+		var initial string
+		if len(patterns) > 0 {
+			initial = patterns[0]
+		}
+		location, err := runTUI(tuiSearch{
+			roots:          roots,
+			walkerOptions:  walkerOptions,
+			contextOptions: contextOptions,
+			grep:           grepOpts,
+			smartCase:      !*ignoreCase && !*caseSensitive,
+			git:            gitFilter,
+		}, initial)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "error getting current working directory: %v\n", err)
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		if location != "" {
+			fmt.Println(location)
+		}
+		return
+	}
+
+	if *filesOnly {
+		if err := listFiles(roots, walkerOptions, gitFilter, *nul); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
 			os.Exit(1)
 		}
-	} else if len(os.Args) == 3 {
-		if os.Args[2] == "." {
-			// Convert "." to current working directory
-			rootPath, err = os.Getwd()
+		if *strict && failures.count() > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if isFlagSet(flag.CommandLine, "replace") {
+		if len(patterns) != 1 {
+			fmt.Fprintf(os.Stderr, "-replace needs exactly one pattern\n")
+			os.Exit(1)
+		}
+		for _, root := range roots {
+			walker := grepast.NewWalker(walkerOptions(root))
+			err := walker.Walk(root.path, func(path, rel string) error {
+				if err := replaceInFile(path, root.displayPath(rel), patterns[0], *replacement, *dryRun); err != nil {
+					fmt.Fprintf(os.Stderr, "%v\n", err)
+				}
+				return nil
+			})
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "error getting current working directory: %v\n", err)
+				fmt.Fprintf(os.Stderr, "error walking %s: %v\n", root.path, err)
 				os.Exit(1)
 			}
-		} else {
-			rootPath = os.Args[2]
 		}
+		if *strict && failures.count() > 0 {
+			os.Exit(1)
+		}
+		return
 	}
 
-	// Get the custom ignore file path
-	customIgnoreFilePath := filepath.Join(rootPath, ".astignore")
-
-	// Load the ignore file
-	gi, err := goignore.CompileIgnoreFile(customIgnoreFilePath)
+	if *jsonOutput {
+		*format = "json"
+	}
+	heading := headingPath
+	switch {
+	case *noHeading:
+		heading = headingNone
+	case *countHeading:
+		heading = headingCount
+	}
+	if !isTerminal(os.Stdout) {
+		// Only terminals render the escape sequences of links; elsewhere they are noise.
+		*hyperlinkFormat = ""
+	}
+	out, formatter, err := newPrinter(*format, heading, *hyperlinkFormat)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error loading ignore file: %v\n", err)
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	switch {
+	case *countMatches:
+		out = &matchPrinter{counts: make(map[string]int)}
+	case *onlyMatching:
+		out = &matchPrinter{}
+	case *count:
+		out = &countPrinter{nul: *nul}
+	case *filesWithMatches:
+		out = pathPrinter{nul: *nul}
+	}
+
+	var report *runReport
+	if *reportPath != "" {
+		report = newRunReport(flag.CommandLine)
+	}
+
+	progress := newProgressLine(*showProgress)
+	var walked func(rel string)
+	if progress != nil {
+		walked = progress.walk
 	}
 
-	// Walk the directory
-	err = filepath.Walk(rootPath, func(path string, info os.FileInfo, err error) error {
-		// Skip errors
+	// Search each root, until the match limit is reached
+	shown, hidden := 0, 0
+	searched := make(map[string]bool) // Files already reported under an earlier root, by absolute path.
+	var held []heldResult             // Files with matches, held back to be printed in -sort order.
+	for _, root := range roots {
+		if *maxMatches > 0 && shown >= *maxMatches {
+			break
+		}
+		ctxOpts := contextOptions(root)
+		err := grepast.SearchTree(root.path, grepast.TreeSearchOptions{
+			Search: grepast.SearchOptions{
+				Patterns:          patterns,
+				Query:             *query,
+				Grep:              grepOpts,
+				Context:           ctxOpts,
+				Formatter:         formatter,
+				MaxMatchesPerFile: *maxCount,
+				CountNodeKinds:    *nodeKinds && *format == "json",
+			},
+			Walker:           walkerOptions(root),
+			Git:              gitFilter,
+			MatchWorkers:     *matchWorkers,
+			FormatWorkers:    *formatWorkers,
+			Unordered:        *unordered,
+			FileTimeout:      *fileTimeout,
+			MaxMatches:       max(0, *maxMatches-shown),
+			FilesWithMatches: *filesWithMatches || *count || *onlyMatching || *countMatches,
+			Walked:           walked,
+		}, func(r grepast.TreeResult) error {
+			progress.searchedFile(root.displayPath(r.Rel))
+			if r.Err != nil {
+				failures.add(root.displayPath(r.Rel), r.Err)
+			}
+			if abs, err := filepath.Abs(root.filePath(r.Rel)); err == nil {
+				if searched[abs] {
+					return nil
+				}
+				searched[abs] = true
+			}
+			if r.Result != nil {
+				shown += len(r.Result.LinesOfInterest)
+				if len(r.Result.LinesOfInterest) == 0 {
+					hidden += r.Result.Suppressed
+				}
+			}
+			if *sortBy != "" && r.Err == nil && len(r.Result.LinesOfInterest) > 0 {
+				held = append(held, heldResult{displayPath: root.displayPath(r.Rel), path: root.filePath(r.Rel), result: r})
+				return nil
+			}
+			if r.Err == nil && len(r.Result.LinesOfInterest) > 0 {
+				progress.clear()
+			}
+			return printResult(root.displayPath(r.Rel), r, out, report)
+		})
+		if errors.Is(err, grepast.ErrorUnsupportedLanguage) {
+			fmt.Fprintf(os.Stderr, "bad -lang: %v\n", err)
+			os.Exit(1)
+		}
 		if err != nil {
-			return err
+			progress.clear()
+			fmt.Fprintf(os.Stderr, "error searching %s: %v\n", root.path, err)
+			os.Exit(1)
 		}
-		// Skip directories
-		if info.IsDir() {
-			return nil
+	}
+
+	progress.clear()
+	sortResults(held, *sortBy)
+	for _, h := range held {
+		if err := printResult(h.displayPath, h.result, out, report); err != nil {
+			fmt.Fprintf(os.Stderr, "error writing output: %v\n", err)
+			os.Exit(1)
 		}
+	}
+
+	if hidden > 0 && *format == "text" && !*filesWithMatches && !*count && !*onlyMatching && !*countMatches {
+		fmt.Printf("\n⋮ %d more matches in other files suppressed\n", hidden)
+	}
 
-		// Skip files that match the ignore patterns
-		if gi.MatchesPath(path) {
+	if err := out.close(); err != nil {
+		fmt.Fprintf(os.Stderr, "error writing output: %v\n", err)
+		os.Exit(1)
+	}
+
+	if report != nil {
+		failures.record(report)
+		if err := report.write(*reportPath); err != nil {
+			fmt.Fprintf(os.Stderr, "error writing report: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if *strict && failures.count() > 0 {
+		os.Exit(1)
+	}
+}
+
+// listFiles prints the display path of every file a search of roots would read, once each, with
+// the walker options of each root, keeping only the files changed according to git if it
+// restricts anything. Paths end with a newline, or with a NUL byte if nul is set.
+func listFiles(roots []searchRoot, walkerOptions func(searchRoot) grepast.WalkerOptions, git grepast.GitFilter, nul bool) error {
+	listed := make(map[string]bool) // Files already listed under an earlier root, by absolute path.
+	for _, root := range roots {
+		var changes *grepast.GitChanges
+		if git.Enabled() {
+			var err error
+			if changes, err = git.Changes(root.path); err != nil {
+				return err
+			}
+		}
+		err := grepast.NewWalker(walkerOptions(root)).Walk(root.path, func(path, rel string) error {
+			if changes != nil && !changes.Contains(rel) {
+				return nil
+			}
+			if abs, err := filepath.Abs(path); err == nil {
+				if listed[abs] {
+					return nil
+				}
+				listed[abs] = true
+			}
+			fmt.Print(root.displayPath(rel), pathEnd(nul, "\n"))
 			return nil
+		})
+		if err != nil {
+			return err
 		}
+	}
+	return nil
+}
 
-		rel, err := filepath.Rel(rootPath, path)
+// readFileList returns the paths listed in the named file, or in standard input if name is "-".
+// They are separated by NUL bytes if there are any, as printed by git ls-files -z or find
+// -print0, or else by newlines. Empty paths are dropped.
+func readFileList(name string) ([]string, error) {
+	var data []byte
+	var err error
+	if name == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sep := "\n"
+	if bytes.IndexByte(data, 0) >= 0 {
+		sep = "\x00"
+	}
+	files := []string{}
+	for _, f := range strings.Split(string(data), sep) {
+		if sep == "\n" {
+			f = strings.TrimSuffix(f, "\r")
+		}
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	return files, nil
+}
+
+// resolveRoot returns the root path given as the first remaining argument, defaulting to the current working directory.
+func resolveRoot(args []string) string {
+	// If no path argument, or ".", use the current working directory
+	if len(args) == 0 || args[0] == "." {
+		rootPath, err := os.Getwd()
 		if err != nil {
-			return nil
+			fmt.Fprintf(os.Stderr, "error getting current working directory: %v\n", err)
+			os.Exit(1)
 		}
+		return rootPath
+	}
+	return args[0]
+}
 
-		parseAndGrep(rel, searchQuery)
-		return nil
+// pathRoots returns the roots to search for the path arguments, each a file or directory. The
+// files of a single root are shown relative to it, while those of several are shown by their
+// paths, so that files with the same name under different roots can be told apart.
+func pathRoots(args []string, profile string) []searchRoot {
+	if len(args) <= 1 {
+		return []searchRoot{{path: resolveRoot(args), profile: profile}}
+	}
+	roots := make([]searchRoot, 0, len(args))
+	for _, arg := range args {
+		root := searchRoot{path: arg, prefix: arg, profile: profile}
+		if info, err := os.Stat(arg); err == nil && !info.IsDir() {
+			// A file is walked as its base name.
+			root.prefix = filepath.Dir(arg)
+		}
+		roots = append(roots, root)
+	}
+	return roots
+}
+
+// hasUppercase reports whether any pattern has an uppercase letter, other than one escaped by a
+// backslash, such as the class \S in a regular expression.
+func hasUppercase(patterns []string) bool {
+	for _, pat := range patterns {
+		escaped := false
+		for _, r := range pat {
+			if !escaped && unicode.IsUpper(r) {
+				return true
+			}
+			escaped = !escaped && r == '\\'
+		}
+	}
+	return false
+}
+
+// isFlagSet reports whether the named flag was given on the command line.
+func isFlagSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
 	})
+	return set
+}
 
-	if err != nil {
-		panic(fmt.Errorf("Error walking the path: %v", err))
+// parsedFile is a file parsed by parseFiles; done is closed once either tc or err is set.
+type parsedFile struct {
+	path, rel string
+	tc        *grepast.TreeContext
+	err       error
+	done      chan struct{}
+}
+
+// parseFiles parses every file under rootPath that is not excluded by the root's ignore file, and
+// that keep accepts unless it is nil, with one worker per CPU. It calls fn with the path, the path
+// relative to rootPath, and the parsed file, from a single goroutine and in walk order. Files that
+// cannot be read or parsed, including those of no supported language, are passed to onError
+// instead, from the same goroutine and in the same order, or skipped if onError is nil. At most a
+// few files per worker are held at once.
+func parseFiles(rootPath string, options grepast.TreeContextOptions, keep func(path string) bool, fn func(path, rel string, tc *grepast.TreeContext), onError func(path string, err error)) error {
+	workers := runtime.NumCPU()
+	jobs := make(chan *parsedFile)
+	pending := make(chan *parsedFile, 4*workers) // Files in walk order, parsed or not.
+	var walkErr error
+	go func() {
+		defer close(jobs)
+		defer close(pending)
+		walkErr = grepast.NewWalker(grepast.WalkerOptions{}).Walk(rootPath, func(path, rel string) error {
+			if keep != nil && !keep(path) {
+				return nil
+			}
+			f := &parsedFile{path: path, rel: rel, done: make(chan struct{})}
+			pending <- f
+			jobs <- f
+			return nil
+		})
+	}()
+	for range workers {
+		go func() {
+			for f := range jobs {
+				var source []byte
+				if source, f.err = os.ReadFile(f.path); f.err == nil {
+					f.tc, f.err = grepast.NewTreeContext(f.path, source, options)
+				}
+				close(f.done)
+			}
+		}()
 	}
 
+	for f := range pending {
+		<-f.done
+		if f.err == nil {
+			fn(f.path, f.rel, f.tc)
+		} else if onError != nil {
+			onError(f.path, f.err)
+		}
+	}
+	return walkErr
 }
 
-func parseAndGrep(filePath, search string) error {
+// renderLines prints the context around the given lines of interest in a file.
+func renderLines(filePath, displayPath string, lois map[int]struct{}, ctxOpts grepast.TreeContextOptions) {
 	source, err := os.ReadFile(filePath)
 	if err != nil {
-		return fmt.Errorf("error reading file %s: %v", filePath, err)
-	}
-
-	// Attempt to create a TreeContext. Non-Go files may fail.
-	tc, err := grepast.NewTreeContext(filePath, source, grepast.TreeContextOptions{
-		Color:                    true,
-		Verbose:                  false,
-		ShowLineNumber:           true,
-		ShowParentContext:        true,
-		ShowChildContext:         true,
-		ShowLastLine:             false,
-		MarginPadding:            3,
-		MarkLinesOfInterest:      true,
-		HeaderMax:                10,
-		ShowTopOfFileParentScope: true,
-		LinesOfInterestPadding:   1,
-	})
+		return
+	}
+	tc, err := grepast.NewTreeContext(filePath, source, ctxOpts)
 	if err != nil {
-		return fmt.Errorf("error parsing file %s: %v", filePath, err)
+		return
 	}
-
-	found := tc.Grep(search, false)
-	tc.AddLinesOfInterest(found)
+	tc.AddLinesOfInterest(lois)
 	tc.AddContext()
+	fmt.Printf("\n%s:\n%s", displayPath, tc.Format())
+}
 
-	// Format and print the output
-	out := tc.Format()
-
-	fmt.Printf("\n%s:%s\n", filePath, out)
+// printResult prints the context of a searched file with p if it matched, recording the outcome in report if it is not nil.
+// Files that could not be read or parsed, such as those of unsupported types, are skipped.
+func printResult(displayPath string, r grepast.TreeResult, p printer, report *runReport) error {
+	if report != nil {
+		report.Files.Scanned++
+	}
+	report.addDuration("search", r.MatchTime)
+	report.addDuration("format", r.FormatTime)
+	if r.Err != nil {
+		if report != nil {
+			report.Files.Skipped++
+		}
+		return nil
+	}
+	if report != nil {
+		report.Files.Searched++
+		report.Languages[r.Result.Language]++
+	}
+	report.addFile(displayPath, r.Result.Metadata)
+	if len(r.Result.LinesOfInterest) == 0 {
+		return nil
+	}
+	if report != nil {
+		report.Files.Matched++
+	}
 
-	return nil
+	start := time.Now()
+	defer report.addPhase("print", start)
+	return p.print(displayPath, r.Result)
 }
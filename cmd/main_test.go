@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	grepast "github.com/cyber-nic/grep-ast"
+)
+
+// TestParseFiles tests that parseFiles reports the parsed files in walk order, however their
+// parsing is scheduled, skipping those keep rejects and those that cannot be parsed.
+func TestParseFiles(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"notes.txt":  "not code\n",
+		"skip/x.go":  "package skip\n",
+		"script.py":  "def f():\n    pass\n",
+		"sub/sub.go": "package sub\n",
+	}
+	for i := range 40 {
+		files[fmt.Sprintf("f%02d.go", i)] = fmt.Sprintf("package p\n\nfunc f%02d() {}\n", i)
+	}
+	for rel, content := range files {
+		path := filepath.Join(root, rel)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	keep := func(path string) bool {
+		return !strings.Contains(filepath.ToSlash(path), "/skip/")
+	}
+
+	var expected []string
+	err := grepast.NewWalker(grepast.WalkerOptions{}).Walk(root, func(path, rel string) error {
+		if keep(path) && !strings.HasSuffix(rel, ".txt") {
+			expected = append(expected, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk() error = %v", err)
+	}
+
+	var got []string
+	err = parseFiles(root, grepast.TreeContextOptions{}, keep, func(path, rel string, tc *grepast.TreeContext) {
+		if tc.Language() == "" {
+			t.Errorf("%s: no language", rel)
+		}
+		got = append(got, filepath.ToSlash(rel))
+	}, nil)
+	if err != nil {
+		t.Fatalf("parseFiles() error = %v", err)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(expected) {
+		t.Errorf("parseFiles() = %v, want %v", got, expected)
+	}
+	if len(got) != 42 {
+		t.Errorf("parseFiles() reported %d files, want 42", len(got))
+	}
+}
+
+// TestParseFiles_Errors tests that parseFiles passes the files it cannot read or parse to onError,
+// in walk order, and not to fn.
+func TestParseFiles_Errors(t *testing.T) {
+	root := t.TempDir()
+	for rel, content := range map[string]string{"a.go": "package a\n", "notes.txt": "not code\n"} {
+		if err := os.WriteFile(filepath.Join(root, rel), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A link to a missing file cannot be read, even by root.
+	if err := os.Symlink("missing.go", filepath.Join(root, "broken.go")); err != nil {
+		t.Skipf("cannot create symbolic links: %v", err)
+	}
+
+	var parsed, failed []string
+	var errs []error
+	err := parseFiles(root, grepast.TreeContextOptions{}, nil, func(path, rel string, tc *grepast.TreeContext) {
+		parsed = append(parsed, rel)
+	}, func(path string, err error) {
+		failed = append(failed, filepath.Base(path))
+		errs = append(errs, err)
+	})
+	if err != nil {
+		t.Fatalf("parseFiles() error = %v", err)
+	}
+	if fmt.Sprint(parsed) != "[a.go]" {
+		t.Errorf("parseFiles() parsed %v, want [a.go]", parsed)
+	}
+	if fmt.Sprint(failed) != "[broken.go notes.txt]" {
+		t.Fatalf("parseFiles() failed %v, want [broken.go notes.txt]", failed)
+	}
+	if !errors.Is(errs[0], fs.ErrNotExist) {
+		t.Errorf("parseFiles() error for broken.go = %v, want %v", errs[0], fs.ErrNotExist)
+	}
+	if !errors.Is(errs[1], grepast.ErrorUnrecognizedFiletype) {
+		t.Errorf("parseFiles() error for notes.txt = %v, want %v", errs[1], grepast.ErrorUnrecognizedFiletype)
+	}
+}
+
+// TestDiffFlag tests parsing -diff alone, with a base, and turned off.
+func TestDiffFlag(t *testing.T) {
+	tests := []struct {
+		args     []string
+		expected diffFlag
+	}{
+		{nil, diffFlag{}},
+		{[]string{"-diff"}, diffFlag{set: true}},
+		{[]string{"-diff=release-1.2"}, diffFlag{set: true, base: "release-1.2"}},
+		{[]string{"-diff=true"}, diffFlag{set: true}},
+		{[]string{"-diff=main", "-diff=false"}, diffFlag{}},
+		// Without "=", the next word is an argument rather than the base.
+		{[]string{"-diff", "main"}, diffFlag{set: true}},
+	}
+
+	for _, tt := range tests {
+		fs := flag.NewFlagSet("grep-ast", flag.ContinueOnError)
+		var got diffFlag
+		fs.Var(&got, "diff", "")
+		if err := fs.Parse(tt.args); err != nil {
+			t.Fatalf("Parse(%q) error = %v", tt.args, err)
+		}
+		if got != tt.expected {
+			t.Errorf("Parse(%q) = %+v, want %+v", tt.args, got, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	grepast "github.com/cyber-nic/grep-ast"
+)
+
+// runOutline implements the "outline" subcommand, which shows the header of every top-level
+// declaration in each file with the bodies elided.
+func runOutline(arguments []string) {
+	fs := flag.NewFlagSet("outline", flag.ExitOnError)
+	profile := fs.String("profile", "", "render context with this config profile")
+	theme := fs.String("theme", "", "highlight matches with this config theme")
+	syntax := fs.Bool("syntax", false, "color shown lines by syntax: keywords, strings, comments, and so on")
+	color := fs.String("color", "auto", "color the output: auto (when printing to a terminal and NO_COLOR is unset), always, or never")
+	strict := fs.Bool("strict", false, "exit with status 1 if any file cannot be read or parsed; such failures are reported on standard error either way")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: grep-ast outline [flags] <file/directory path>\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(arguments)
+	args := fs.Args()
+
+	if len(args) > 1 {
+		fs.Usage()
+		return
+	}
+	rootPath := resolveRoot(args)
+	ctxOpts := resolveContextOptions(rootPath, *profile, *theme, *color)
+	ctxOpts.SyntaxHighlight = ctxOpts.SyntaxHighlight || *syntax
+
+	failures := &failureLog{}
+	err := parseFiles(rootPath, ctxOpts, nil, func(path, rel string, tc *grepast.TreeContext) {
+		fmt.Printf("\n%s:\n%s", rel, tc.Outline())
+	}, failures.add)
+	if err != nil {
+		panic(fmt.Errorf("Error walking the path: %v", err))
+	}
+
+	if *strict && failures.count() > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	grepast "github.com/cyber-nic/grep-ast"
+)
+
+// printer writes the results of a search in one of the output formats chosen with -format.
+type printer interface {
+	// print writes the result of a file that matched.
+	print(displayPath string, result *grepast.FileResult) error
+	// close writes anything held back until the end of the search.
+	close() error
+}
+
+// newPrinter returns the printer for an output format, and the formatter that renders the
+// results it expects. Text output introduces each file with the heading style, linking its path
+// to the hyperlink format if one is set.
+func newPrinter(format string, heading headingStyle, hyperlinkFormat string) (printer, grepast.Formatter, error) {
+	switch format {
+	case "", "text":
+		return textPrinter{heading: heading, hyperlinkFormat: hyperlinkFormat}, grepast.TextFormatter{}, nil
+	case "json":
+		return jsonPrinter{enc: json.NewEncoder(os.Stdout)}, grepast.JSONFormatter{}, nil
+	case "sarif":
+		return &sarifPrinter{log: grepast.NewSARIFLog()}, grepast.SARIFFormatter{}, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown output format %q (want text, json, or sarif)", format)
+	}
+}
+
+// headingStyle is how text output introduces the context of each file.
+type headingStyle int
+
+const (
+	headingPath  headingStyle = iota // A line with the path, e.g. "main.go:".
+	headingCount                     // A heading with the path and number of matching lines, e.g. "== main.go (2 matches) ==".
+	headingNone                      // No heading; every line starts with the path instead, e.g. "main.go:  4█	foo()", as in grep.
+)
+
+// textPrinter prints each file's context introduced as heading says. If hyperlinkFormat is set,
+// the path links to the file's first matching line with an OSC 8 hyperlink.
+type textPrinter struct {
+	heading         headingStyle
+	hyperlinkFormat string
+}
+
+func (p textPrinter) print(displayPath string, result *grepast.FileResult) error {
+	if p.hyperlinkFormat != "" {
+		line := 1
+		if len(result.LinesOfInterest) > 0 {
+			line = result.LinesOfInterest[0] + 1
+		}
+		displayPath = grepast.Hyperlink(grepast.HyperlinkURL(p.hyperlinkFormat, result.Path, line), displayPath)
+	}
+	output := result.Output
+	if result.Suppressed > 0 {
+		output += fmt.Sprintf("⋮ %d more matches suppressed\n", result.Suppressed)
+	}
+	switch p.heading {
+	case headingCount:
+		matches := "matches"
+		if len(result.LinesOfInterest) == 1 {
+			matches = "match"
+		}
+		fmt.Printf("\n== %s (%d %s) ==\n%s", displayPath, len(result.LinesOfInterest), matches, output)
+	case headingNone:
+		for _, line := range strings.SplitAfter(output, "\n") {
+			if line != "" {
+				fmt.Printf("%s:%s", displayPath, line)
+			}
+		}
+	default:
+		fmt.Printf("\n%s:\n%s", displayPath, output)
+	}
+	return nil
+}
+
+func (textPrinter) close() error { return nil }
+
+// pathPrinter prints only the path of each file, for -l, ending it with a newline, or with a NUL
+// byte if nul is set.
+type pathPrinter struct {
+	nul bool
+}
+
+func (p pathPrinter) print(displayPath string, _ *grepast.FileResult) error {
+	fmt.Print(displayPath, pathEnd(p.nul, "\n"))
+	return nil
+}
+
+func (pathPrinter) close() error { return nil }
+
+// countPrinter prints the number of matching lines of each file, for -c, and their total. The
+// paths are followed by a colon, or by a NUL byte if nul is set.
+type countPrinter struct {
+	nul            bool
+	matches, files int
+}
+
+func (p *countPrinter) print(displayPath string, result *grepast.FileResult) error {
+	p.matches += len(result.LinesOfInterest)
+	p.files++
+	fmt.Printf("%s%s%d\n", displayPath, pathEnd(p.nul, ":"), len(result.LinesOfInterest))
+	return nil
+}
+
+func (p *countPrinter) close() error {
+	fmt.Printf("%d matches in %d files\n", p.matches, p.files)
+	return nil
+}
+
+// matchPrinter prints the text of each match, for -o, after its location as path:line:col: with
+// one-based numbers. If counts is set, it counts the matches of each distinct text instead, for
+// -count-matches, and prints the counts at the end, most first.
+type matchPrinter struct {
+	counts map[string]int
+}
+
+func (p *matchPrinter) print(displayPath string, result *grepast.FileResult) error {
+	for _, m := range result.Matches {
+		if p.counts != nil {
+			p.counts[m.Text]++
+			continue
+		}
+		fmt.Printf("%s:%d:%d:%s\n", displayPath, m.Line+1, m.Column+1, m.Text)
+	}
+	return nil
+}
+
+func (p *matchPrinter) close() error {
+	texts := slices.Collect(maps.Keys(p.counts))
+	slices.SortFunc(texts, func(a, b string) int {
+		return cmp.Or(cmp.Compare(p.counts[b], p.counts[a]), strings.Compare(a, b))
+	})
+	for _, text := range texts {
+		fmt.Printf("%7d %s\n", p.counts[text], text)
+	}
+	return nil
+}
+
+// sortOrders are the orders -sort prints files in, other than walk order.
+var sortOrders = []string{"path", "modified", "matches"}
+
+// heldResult is the result of a file held back to be printed in -sort order.
+type heldResult struct {
+	displayPath string
+	path        string // Path of the file, to read its modification time.
+	result      grepast.TreeResult
+	modTime     time.Time
+}
+
+// sortResults sorts held results by display path, by modification time with the most recent
+// first, or by number of matching lines with the most first, according to order. Ties keep
+// the order of their paths.
+func sortResults(held []heldResult, order string) {
+	if order == "modified" {
+		for i := range held {
+			if info, err := os.Stat(held[i].path); err == nil {
+				held[i].modTime = info.ModTime()
+			}
+		}
+	}
+	slices.SortStableFunc(held, func(a, b heldResult) int {
+		var c int
+		switch order {
+		case "modified":
+			c = b.modTime.Compare(a.modTime)
+		case "matches":
+			c = cmp.Compare(len(b.result.Result.LinesOfInterest), len(a.result.Result.LinesOfInterest))
+		}
+		if c == 0 {
+			c = strings.Compare(a.displayPath, b.displayPath)
+		}
+		return c
+	})
+}
+
+// pathEnd returns the text printed after a path, sep unless nul is set, for -0.
+func pathEnd(nul bool, sep string) string {
+	if nul {
+		return "\x00"
+	}
+	return sep
+}
+
+// jsonFile is the JSON object printed for each file that matched.
+type jsonFile struct {
+	Path       string                  `json:"path"`                 // Path of the file, as displayed in text output.
+	Language   string                  `json:"language"`             // Name of the language the file was parsed as.
+	Matches    []grepast.Match         `json:"matches"`              // Pattern matches with their zero-based lines and columns.
+	Suppressed int                     `json:"suppressed,omitempty"` // Matching lines left out by -max-count or -max-matches.
+	Lines      []grepast.FormattedLine `json:"lines"`                // Shown lines of context, in order.
+	Gaps       []grepast.ElidedRange   `json:"gaps"`                 // Runs of lines left out between them.
+	NodeKinds  map[string]int          `json:"node_kinds,omitempty"` // Named parse-tree nodes by kind, with -node-kinds.
+}
+
+// jsonPrinter prints one JSON object per file, on a line of its own.
+type jsonPrinter struct {
+	enc *json.Encoder
+}
+
+func (p jsonPrinter) print(displayPath string, result *grepast.FileResult) error {
+	var fc grepast.FormattedContext
+	if err := json.Unmarshal([]byte(result.Output), &fc); err != nil {
+		return fmt.Errorf("%s: %w", displayPath, err)
+	}
+	file := jsonFile{
+		Path:       filepath.ToSlash(displayPath),
+		Language:   result.Language,
+		Matches:    result.Matches,
+		Suppressed: result.Suppressed,
+		Lines:      fc.Lines,
+		Gaps:       fc.Gaps,
+		NodeKinds:  result.NodeKinds,
+	}
+	if file.Matches == nil {
+		file.Matches = []grepast.Match{}
+	}
+	return p.enc.Encode(file)
+}
+
+func (jsonPrinter) close() error { return nil }
+
+// sarifPrinter collects results into a SARIF log, written as a whole once the search is done.
+type sarifPrinter struct {
+	log *grepast.SARIFLog
+}
+
+func (p *sarifPrinter) print(displayPath string, result *grepast.FileResult) error {
+	var results []grepast.SARIFResult
+	if err := json.Unmarshal([]byte(result.Output), &results); err != nil {
+		return fmt.Errorf("%s: %w", displayPath, err)
+	}
+	// Locate results relative to the searched root so that consumers can map them to the repository.
+	for i := range results {
+		for j := range results[i].Locations {
+			artifact := &results[i].Locations[j].PhysicalLocation.ArtifactLocation
+			artifact.URI = filepath.ToSlash(displayPath)
+			artifact.URIBaseID = "%SRCROOT%"
+		}
+	}
+	p.log.Add(results...)
+	return nil
+}
+
+func (p *sarifPrinter) close() error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(p.log)
+}
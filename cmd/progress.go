@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// progressInterval is how often the progress line is redrawn at most.
+const progressInterval = 100 * time.Millisecond
+
+// progressPathWidth is the number of characters of the current path the progress line shows.
+const progressPathWidth = 60
+
+// progressLine shows how far a search has got on a line of standard error, for -progress: the
+// number of files searched out of those walked so far, and the file searched last. A nil
+// progressLine shows nothing.
+type progressLine struct {
+	walked   atomic.Int64 // Files found by the walks so far; counted from the walks' goroutines.
+	searched int          // Files searched so far.
+	drawn    time.Time    // When the line was last drawn.
+	shown    bool         // Whether the line is on the screen.
+}
+
+// newProgressLine returns a progress line if enabled and standard error is a terminal, as
+// there is no one to watch it otherwise, or nil.
+func newProgressLine(enabled bool) *progressLine {
+	if !enabled || !isTerminal(os.Stderr) {
+		return nil
+	}
+	return &progressLine{}
+}
+
+// walk counts a file found by a walk.
+func (p *progressLine) walk(string) {
+	p.walked.Add(1)
+}
+
+// searchedFile counts a searched file and redraws the line with its display path, unless it was
+// drawn less than progressInterval ago.
+func (p *progressLine) searchedFile(displayPath string) {
+	if p == nil {
+		return
+	}
+	p.searched++
+	if time.Since(p.drawn) < progressInterval {
+		return
+	}
+	if n := len([]rune(displayPath)); n > progressPathWidth {
+		displayPath = "…" + string([]rune(displayPath)[n-progressPathWidth+1:])
+	}
+	fmt.Fprintf(os.Stderr, "\r\033[Ksearched %d/%d files %s", p.searched, p.walked.Load(), displayPath)
+	p.drawn = time.Now()
+	p.shown = true
+}
+
+// clear erases the line, so that output can be printed in its place. It is redrawn by the next
+// searched file at least progressInterval later.
+func (p *progressLine) clear() {
+	if p == nil || !p.shown {
+		return
+	}
+	fmt.Fprint(os.Stderr, "\r\033[K")
+	p.drawn = time.Now()
+	p.shown = false
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	grepast "github.com/cyber-nic/grep-ast"
+)
+
+// replaceInFile rewrites the matches of a structural pattern in a file, printing the change as a
+// unified diff. With dryRun the file is left untouched. Unsupported file types are skipped.
+func replaceInFile(filePath, displayPath, pattern, replacement string, dryRun bool) error {
+	source, err := os.ReadFile(filePath)
+	if err != nil {
+		return fmt.Errorf("error reading file %s: %v", filePath, err)
+	}
+
+	tc, err := grepast.NewTreeContext(filePath, source, grepast.TreeContextOptions{})
+	if err != nil {
+		if errors.Is(err, grepast.ErrorUnrecognizedFiletype) || errors.Is(err, grepast.ErrorUnsupportedLanguage) {
+			return nil
+		}
+		return fmt.Errorf("error parsing file %s: %v", filePath, err)
+	}
+	edits, err := tc.Replace(pattern, replacement)
+	if err != nil {
+		return fmt.Errorf("error replacing in file %s: %v", filePath, err)
+	}
+	if len(edits) == 0 {
+		return nil
+	}
+
+	updated, err := grepast.ApplyEdits(source, edits)
+	if err != nil {
+		return fmt.Errorf("error replacing in file %s: %v", filePath, err)
+	}
+	fmt.Print(grepast.UnifiedDiff(displayPath, source, updated))
+	if dryRun {
+		return nil
+	}
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return fmt.Errorf("error writing file %s: %v", filePath, err)
+	}
+	if err := os.WriteFile(filePath, updated, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("error writing file %s: %v", filePath, err)
+	}
+	return nil
+}
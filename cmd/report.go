@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"math"
+	"os"
+	"sort"
+	"time"
+
+	grepast "github.com/cyber-nic/grep-ast"
+)
+
+// reportSlowestFiles is the number of files listed in a report's slowest_files.
+const reportSlowestFiles = 10
+
+// runReport is the local usage report written by -report. It is never sent anywhere.
+type runReport struct {
+	Options   map[string]string  `json:"options"`   // Flags set explicitly on the command line.
+	PhasesMs  map[string]float64 `json:"phases_ms"` // Time spent per phase, in milliseconds, summed over concurrent workers.
+	Files     reportFileCounts   `json:"files"`     // Number of files per outcome.
+	Languages map[string]int     `json:"languages"` // Number of searched files per language.
+
+	// SlowestFiles lists the files that took longest to parse, slowest first, to help tune
+	// ignore files and size limits.
+	SlowestFiles []reportFile `json:"slowest_files"`
+
+	// Failures lists the files and directories that could not be read or searched, other than
+	// files of unsupported types and binary files.
+	Failures []reportFailure `json:"failures"`
+
+	start time.Time
+}
+
+// reportFileCounts counts files by the outcome of searching them.
+type reportFileCounts struct {
+	Scanned  int `json:"scanned"`  // Files visited by the walk.
+	Searched int `json:"searched"` // Files parsed and searched.
+	Matched  int `json:"matched"`  // Files with at least one match.
+	Skipped  int `json:"skipped"`  // Files that could not be read or parsed.
+	Failed   int `json:"failed"`   // Skipped files, and directories, that failed unexpectedly; see Failures.
+}
+
+// reportFailure is a file or directory that failed unexpectedly.
+type reportFailure struct {
+	Path  string `json:"path"`  // Path of the file or directory as displayed in the output.
+	Error string `json:"error"` // Message of the error.
+}
+
+// reportFile is the size and parse cost of a searched file.
+type reportFile struct {
+	Path string `json:"path"` // Path of the file as displayed in the output.
+	grepast.FileMetadata
+}
+
+// newRunReport starts a report recording the flags explicitly set in fs.
+func newRunReport(fs *flag.FlagSet) *runReport {
+	r := &runReport{
+		Options:      make(map[string]string),
+		PhasesMs:     make(map[string]float64),
+		Languages:    make(map[string]int),
+		SlowestFiles: []reportFile{},
+		Failures:     []reportFailure{},
+		start:        time.Now(),
+	}
+	fs.Visit(func(f *flag.Flag) {
+		r.Options[f.Name] = f.Value.String()
+	})
+	return r
+}
+
+// addPhase adds the time elapsed since start to the named phase. It is a no-op on a nil report.
+func (r *runReport) addPhase(phase string, start time.Time) {
+	r.addDuration(phase, time.Since(start))
+}
+
+// addDuration adds d to the named phase. It is a no-op on a nil report.
+func (r *runReport) addDuration(phase string, d time.Duration) {
+	if r == nil {
+		return
+	}
+	r.PhasesMs[phase] += float64(d.Microseconds()) / 1000
+}
+
+// addFile records the metadata of a searched file, keeping the slowest to parse.
+// It is a no-op on a nil report.
+func (r *runReport) addFile(path string, m grepast.FileMetadata) {
+	if r == nil {
+		return
+	}
+	i := sort.Search(len(r.SlowestFiles), func(i int) bool {
+		return r.SlowestFiles[i].ParseTime < m.ParseTime
+	})
+	if i >= reportSlowestFiles {
+		return
+	}
+	r.SlowestFiles = append(r.SlowestFiles, reportFile{})
+	copy(r.SlowestFiles[i+1:], r.SlowestFiles[i:])
+	r.SlowestFiles[i] = reportFile{Path: path, FileMetadata: m}
+	if len(r.SlowestFiles) > reportSlowestFiles {
+		r.SlowestFiles = r.SlowestFiles[:reportSlowestFiles]
+	}
+}
+
+// write records the total run time and writes the report as JSON to path.
+func (r *runReport) write(path string) error {
+	r.addPhase("total", r.start)
+	for phase, ms := range r.PhasesMs {
+		r.PhasesMs[phase] = math.Round(ms*1000) / 1000
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	grepast "github.com/cyber-nic/grep-ast"
+)
+
+// symbolHit is a fuzzy symbol match found in a file.
+type symbolHit struct {
+	path  string
+	rel   string
+	match grepast.SymbolMatch
+}
+
+// runSym implements the "symbols" subcommand, also called "sym", which fuzzy-matches a query against the definition
+// names in every file and renders the best matching definitions with context.
+func runSym(arguments []string) {
+	fs := flag.NewFlagSet("symbols", flag.ExitOnError)
+	topK := fs.Int("k", 10, "number of definitions to show")
+	profile := fs.String("profile", "", "render context with this config profile")
+	theme := fs.String("theme", "", "highlight matches with this config theme")
+	syntax := fs.Bool("syntax", false, "color shown lines by syntax: keywords, strings, comments, and so on")
+	color := fs.String("color", "auto", "color the output: auto (when printing to a terminal and NO_COLOR is unset), always, or never")
+	strict := fs.Bool("strict", false, "exit with status 1 if any file cannot be read or parsed; such failures are reported on standard error either way")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: grep-ast symbols [flags] query <file/directory path>\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(arguments)
+	args := fs.Args()
+
+	if len(args) < 1 || len(args) > 2 {
+		fs.Usage()
+		return
+	}
+	query := args[0]
+	rootPath := resolveRoot(args[1:])
+	ctxOpts := resolveContextOptions(rootPath, *profile, *theme, *color)
+	ctxOpts.SyntaxHighlight = ctxOpts.SyntaxHighlight || *syntax
+
+	var hits []symbolHit
+	failures := &failureLog{}
+	err := parseFiles(rootPath, grepast.TreeContextOptions{}, nil, func(path, rel string, tc *grepast.TreeContext) {
+		for _, m := range tc.FuzzyDefinitions(query) {
+			hits = append(hits, symbolHit{path: path, rel: rel, match: m})
+		}
+	}, failures.add)
+	if err != nil {
+		panic(fmt.Errorf("Error walking the path: %v", err))
+	}
+
+	sort.SliceStable(hits, func(i, j int) bool {
+		return hits[i].match.Score > hits[j].match.Score
+	})
+	if len(hits) > *topK {
+		hits = hits[:*topK]
+	}
+
+	// Group the top definitions by file, keeping files in order of their best match.
+	var files []symbolHit
+	lois := make(map[string]map[int]struct{})
+	for _, hit := range hits {
+		if _, ok := lois[hit.path]; !ok {
+			files = append(files, hit)
+			lois[hit.path] = make(map[int]struct{})
+		}
+		lois[hit.path][hit.match.StartLine] = struct{}{}
+	}
+
+	for _, file := range files {
+		renderLines(file.path, file.rel, lois[file.path], ctxOpts)
+	}
+
+	if *strict && failures.count() > 0 {
+		os.Exit(1)
+	}
+}
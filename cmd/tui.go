@@ -0,0 +1,492 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	grepast "github.com/cyber-nic/grep-ast"
+)
+
+// tuiMaxMatches is the number of matches the TUI lists at most.
+const tuiMaxMatches = 1000
+
+// tuiDebounce is how long the TUI waits after a key before searching for the edited query.
+const tuiDebounce = 150 * time.Millisecond
+
+// tuiMatch is a match listed by the TUI.
+type tuiMatch struct {
+	displayPath string
+	path        string
+	ctxOpts     grepast.TreeContextOptions // Context options of the match's root, for its preview.
+	line        int                        // Zero-based line of the match.
+	column      int                        // Zero-based byte column of the match.
+	text        string                     // Text of the matching line, without surrounding whitespace.
+}
+
+// tuiAction is what a key asks the TUI to do once it has been applied; see handleKey.
+type tuiAction int
+
+const (
+	tuiRedraw      tuiAction = iota // Redraw the screen.
+	tuiSearchAgain                  // Search for the edited query once typing pauses.
+	tuiQuit                         // Quit without choosing a match.
+	tuiChoose                       // Quit, choosing the selected match.
+)
+
+// tuiResults are the matches of a search run for the query of generation gen.
+type tuiResults struct {
+	gen     int
+	matches []tuiMatch
+	err     error
+}
+
+// tuiSearch describes what the TUI searches, as set by the search flags.
+type tuiSearch struct {
+	roots          []searchRoot
+	walkerOptions  func(searchRoot) grepast.WalkerOptions
+	contextOptions func(searchRoot) grepast.TreeContextOptions
+	grep           grepast.GrepOptions
+	smartCase      bool // Whether patterns without uppercase letters match case-insensitively.
+	git            grepast.GitFilter
+}
+
+// grepOptions returns the grep options to search for pattern with.
+func (s tuiSearch) grepOptions(pattern string) grepast.GrepOptions {
+	options := s.grep
+	if s.smartCase {
+		options.IgnoreCase = !hasUppercase([]string{pattern})
+	}
+	return options
+}
+
+// tui is the state of the interactive search of -tui: a query edited in a search box, the
+// matches of the last search for it, and the one selected, whose context is previewed.
+type tui struct {
+	search   tuiSearch
+	tty      *os.File
+	query    []rune
+	matches  []tuiMatch
+	selected int
+	offset   int    // Index of the first match shown in the list.
+	status   string // Summary of the last search, or its error.
+
+	gen     int                // Generation of the query, incremented on each edit.
+	cancel  context.CancelFunc // Cancels the running search, if any.
+	results chan tuiResults
+
+	previewKey string // Path and line of the cached preview.
+	preview    []string
+}
+
+// runTUI runs the interactive search of -tui on the terminal, starting with query, until it is
+// quit with Esc or Ctrl-C, or a match is chosen with Enter. It returns the location of the
+// chosen match as path:line:col, or "" if none was chosen. It draws on /dev/tty, so that
+// standard output can be redirected, e.g. to pass the location to an editor.
+func runTUI(search tuiSearch, query string) (string, error) {
+	tty, restore, err := openTerminal()
+	if err != nil {
+		return "", err
+	}
+	defer restore()
+	// Switch to the alternate screen and hide the cursor, and back on the way out.
+	fmt.Fprint(tty, "\033[?1049h\033[?25l")
+	defer fmt.Fprint(tty, "\033[?25h\033[?1049l")
+
+	t := &tui{search: search, tty: tty, query: []rune(query), results: make(chan tuiResults, 1)}
+	keys := make(chan string)
+	go readKeys(tty, keys)
+
+	debounce := time.NewTimer(0) // Search for the initial query at once.
+	for {
+		t.draw()
+		select {
+		case key, ok := <-keys:
+			if !ok {
+				return "", nil
+			}
+			rows, _ := terminalSize(t.tty)
+			switch t.handleKey(key, listHeight(rows)) {
+			case tuiQuit:
+				t.stop()
+				return "", nil
+			case tuiChoose:
+				t.stop()
+				return t.location(), nil
+			case tuiSearchAgain:
+				t.edited(debounce)
+			}
+		case <-debounce.C:
+			t.start()
+		case res := <-t.results:
+			if res.gen != t.gen {
+				continue // The query was edited since.
+			}
+			t.cancel = nil
+			t.matches, t.selected, t.offset = res.matches, 0, 0
+			switch {
+			case res.err != nil:
+				t.status = res.err.Error()
+			case len(res.matches) >= tuiMaxMatches:
+				t.status = fmt.Sprintf("first %d matches", len(res.matches))
+			default:
+				t.status = fmt.Sprintf("%d matches", len(res.matches))
+			}
+		}
+	}
+}
+
+// handleKey applies key, as named by parseKeys, to the query and the selection in a match list
+// height rows high, and returns what the TUI should do next.
+func (t *tui) handleKey(key string, height int) tuiAction {
+	switch key {
+	case "esc", "ctrl-c", "ctrl-d":
+		return tuiQuit
+	case "enter":
+		return tuiChoose
+	case "up", "ctrl-p":
+		t.selected = max(0, t.selected-1)
+	case "down", "ctrl-n":
+		t.selected = max(0, min(len(t.matches)-1, t.selected+1))
+	case "pgup":
+		t.selected = max(0, t.selected-height)
+	case "pgdown":
+		t.selected = max(0, min(len(t.matches)-1, t.selected+height))
+	case "backspace":
+		if len(t.query) > 0 {
+			t.query = t.query[:len(t.query)-1]
+			return tuiSearchAgain
+		}
+	case "ctrl-u":
+		t.query = nil
+		return tuiSearchAgain
+	default:
+		if r, _ := utf8.DecodeRuneInString(key); utf8.RuneCountInString(key) == 1 && unicode.IsPrint(r) {
+			t.query = append(t.query, r)
+			return tuiSearchAgain
+		}
+	}
+	return tuiRedraw
+}
+
+// location returns the location of the selected match as path:line:col, with one-based line and
+// column, or "" if there is none.
+func (t *tui) location() string {
+	if t.selected >= len(t.matches) {
+		return ""
+	}
+	m := t.matches[t.selected]
+	return fmt.Sprintf("%s:%d:%d", m.displayPath, m.line+1, m.column+1)
+}
+
+// edited restarts the debounce timer after the query was edited, so that a search starts once
+// typing pauses.
+func (t *tui) edited(debounce *time.Timer) {
+	t.gen++
+	t.stop()
+	debounce.Reset(tuiDebounce)
+}
+
+// stop cancels the running search, if any.
+func (t *tui) stop() {
+	if t.cancel != nil {
+		t.cancel()
+		t.cancel = nil
+	}
+}
+
+// start searches the roots for the query in the background, sending the matches to t.results.
+func (t *tui) start() {
+	if len(t.query) == 0 {
+		t.matches, t.selected, t.offset, t.status = nil, 0, 0, ""
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.cancel = cancel
+	t.status = "searching…"
+	go func(gen int, pattern string) {
+		res := tuiResults{gen: gen}
+		res.matches, res.err = t.find(ctx, pattern)
+		if ctx.Err() == nil {
+			t.results <- res
+		}
+	}(t.gen, string(t.query))
+}
+
+// find returns the first tuiMaxMatches matches of pattern under the roots, and the first error
+// met searching a file, such as a bad pattern, if any.
+func (t *tui) find(ctx context.Context, pattern string) ([]tuiMatch, error) {
+	var matches []tuiMatch
+	var fileErr error
+	for _, root := range t.search.roots {
+		ctxOpts := t.search.contextOptions(root)
+		walkerOptions := t.search.walkerOptions(root)
+		walkerOptions.OnError = func(string, error) error { return nil } // Keep standard error off the screen.
+		err := grepast.SearchTreeCtx(ctx, root.path, grepast.TreeSearchOptions{
+			Search:           grepast.SearchOptions{Patterns: []string{pattern}, Grep: t.search.grepOptions(pattern), Context: ctxOpts},
+			Walker:           walkerOptions,
+			Git:              t.search.git,
+			MaxMatches:       tuiMaxMatches - len(matches),
+			FilesWithMatches: true,
+		}, func(r grepast.TreeResult) error {
+			if r.Err != nil && fileErr == nil && !unsearchable(r.Err) {
+				fileErr = r.Err
+			}
+			if r.Err != nil || len(r.Result.Matches) == 0 {
+				return nil
+			}
+			source, err := os.ReadFile(r.Result.Path)
+			if err != nil {
+				return nil
+			}
+			lines := strings.Split(string(source), "\n")
+			seen := make(map[int]bool)
+			for _, m := range r.Result.Matches {
+				if seen[m.Line] || m.Line >= len(lines) {
+					continue
+				}
+				seen[m.Line] = true
+				matches = append(matches, tuiMatch{
+					displayPath: root.displayPath(r.Rel),
+					path:        r.Result.Path,
+					ctxOpts:     ctxOpts,
+					line:        m.Line,
+					column:      m.Column,
+					text:        strings.TrimSpace(lines[m.Line]),
+				})
+			}
+			return nil
+		})
+		if err != nil {
+			return matches, err
+		}
+		if len(matches) >= tuiMaxMatches {
+			break
+		}
+	}
+	return matches, fileErr
+}
+
+// listHeight returns the number of rows of the match list and preview on a terminal with the
+// given number of rows, below the search box and its rule.
+func listHeight(rows int) int {
+	return max(1, rows-2)
+}
+
+// draw redraws the screen.
+func (t *tui) draw() {
+	rows, cols := terminalSize(t.tty)
+	fmt.Fprint(t.tty, t.render(rows, cols, t.previewLines()))
+}
+
+// render returns the escape sequences and text drawing a screen of the given size: the search
+// box and status on the first row, then the match list beside the lines of preview. The list
+// is first scrolled to show the selected match.
+func (t *tui) render(rows, cols int, preview []string) string {
+	height := listHeight(rows)
+	listWidth := max(20, cols*2/5)
+	previewWidth := max(0, cols-listWidth-1)
+
+	if t.selected < t.offset {
+		t.offset = t.selected
+	} else if t.selected >= t.offset+height {
+		t.offset = t.selected - height + 1
+	}
+
+	var sb strings.Builder
+	sb.WriteString("\033[H")
+	box := "> " + string(t.query) + "▏"
+	fmt.Fprintf(&sb, "%s%*s\033[K\r\n", box, max(0, cols-utf8.RuneCountInString(box)), truncate(t.status, cols/2))
+	sb.WriteString(strings.Repeat("─", cols))
+	sb.WriteString("\033[K\r\n")
+	for row := range height {
+		cell := ""
+		if i := t.offset + row; i < len(t.matches) {
+			m := t.matches[i]
+			cell = truncate(fmt.Sprintf("%s:%d: %s", m.displayPath, m.line+1, m.text), listWidth)
+			if i == t.selected {
+				cell = "\033[7m" + cell + strings.Repeat(" ", listWidth-utf8.RuneCountInString(cell)) + "\033[0m"
+			}
+		}
+		sb.WriteString(cell)
+		sb.WriteString(strings.Repeat(" ", max(0, listWidth-visibleWidth(cell))))
+		sb.WriteString("│")
+		if row < len(preview) {
+			sb.WriteString(truncateANSI(preview[row], previewWidth))
+		}
+		sb.WriteString("\033[K")
+		if row < height-1 {
+			sb.WriteString("\r\n")
+		}
+	}
+	return sb.String()
+}
+
+// previewLines returns the lines of the context of the selected match, as the search would show
+// it, with the match highlighted. Previews are cached until another match is selected.
+func (t *tui) previewLines() []string {
+	if t.selected >= len(t.matches) {
+		return nil
+	}
+	m := t.matches[t.selected]
+	key := fmt.Sprintf("%s:%d", m.path, m.line)
+	if key == t.previewKey {
+		return t.preview
+	}
+	t.previewKey, t.preview = key, nil
+
+	ctxOpts := m.ctxOpts
+	ctxOpts.Color = true
+	ctxOpts.ShowLineNumber = true
+	ctxOpts.MarkLinesOfInterest = true
+	ctxOpts.HyperlinkFormat = ""
+	ctxOpts.TabWidth = max(ctxOpts.TabWidth, 4)
+	source, err := os.ReadFile(m.path)
+	if err != nil {
+		t.preview = []string{err.Error()}
+		return t.preview
+	}
+	tc, err := grepast.NewTreeContext(m.path, source, ctxOpts)
+	if err != nil {
+		t.preview = []string{err.Error()}
+		return t.preview
+	}
+	tc.GrepPatterns([]string{string(t.query)}, t.search.grepOptions(string(t.query))) // Only to highlight the matches.
+	tc.AddLinesOfInterest(map[int]struct{}{m.line: {}})
+	tc.AddContext()
+	t.preview = strings.Split(strings.TrimSuffix(tc.Format(), "\n"), "\n")
+	return t.preview
+}
+
+// readKeys reads keys from the terminal in raw mode and sends their names, such as "a",
+// "enter", or "up", to keys, until reading fails.
+func readKeys(tty *os.File, keys chan<- string) {
+	defer close(keys)
+	buf := make([]byte, 64)
+	for {
+		n, err := tty.Read(buf)
+		if err != nil {
+			return
+		}
+		for _, key := range parseKeys(buf[:n]) {
+			keys <- key
+		}
+	}
+}
+
+// tuiEscapeKeys names the escape sequences of the keys the TUI handles.
+var tuiEscapeKeys = map[string]string{
+	"\033[A": "up", "\033[B": "down", "\033OA": "up", "\033OB": "down",
+	"\033[5~": "pgup", "\033[6~": "pgdown",
+}
+
+// tuiControlKeys names the control characters the TUI handles.
+var tuiControlKeys = map[byte]string{
+	'\r': "enter", '\n': "enter", 0x7f: "backspace", 0x08: "backspace",
+	0x03: "ctrl-c", 0x04: "ctrl-d", 0x0e: "ctrl-n", 0x10: "ctrl-p", 0x15: "ctrl-u",
+}
+
+// parseKeys splits the bytes of a read from the terminal into key names. Unknown escape
+// sequences and control characters are dropped.
+func parseKeys(b []byte) []string {
+	var keys []string
+	for len(b) > 0 {
+		switch {
+		case b[0] == 0x1b && len(b) == 1:
+			keys = append(keys, "esc")
+			b = b[1:]
+		case b[0] == 0x1b:
+			// A sequence runs to its first letter or tilde after the introducer.
+			end := 2
+			for end < len(b) && !(b[end] >= 'A' && b[end] <= 'Z' || b[end] >= 'a' && b[end] <= 'z' || b[end] == '~') {
+				end++
+			}
+			end = min(end+1, len(b))
+			if key, ok := tuiEscapeKeys[string(b[:end])]; ok {
+				keys = append(keys, key)
+			}
+			b = b[end:]
+		case b[0] < 0x20 || b[0] == 0x7f:
+			if key, ok := tuiControlKeys[b[0]]; ok {
+				keys = append(keys, key)
+			}
+			b = b[1:]
+		default:
+			_, size := utf8.DecodeRune(b)
+			keys = append(keys, string(b[:size]))
+			b = b[size:]
+		}
+	}
+	return keys
+}
+
+// truncate shortens s to at most width runes, ending it with an ellipsis if it was cut.
+func truncate(s string, width int) string {
+	if utf8.RuneCountInString(s) <= width {
+		return s
+	}
+	if width <= 0 {
+		return ""
+	}
+	return string([]rune(s)[:width-1]) + "…"
+}
+
+// truncateANSI shortens s to at most width visible runes, keeping its ANSI escape sequences,
+// and resets its colors if it was cut.
+func truncateANSI(s string, width int) string {
+	var sb strings.Builder
+	visible := 0
+	for i := 0; i < len(s); {
+		if s[i] == 0x1b {
+			end := ansiSequenceEnd(s, i)
+			sb.WriteString(s[i:end])
+			i = end
+			continue
+		}
+		if visible == width {
+			sb.WriteString("\033[0m")
+			break
+		}
+		_, size := utf8.DecodeRuneInString(s[i:])
+		sb.WriteString(s[i : i+size])
+		visible++
+		i += size
+	}
+	return sb.String()
+}
+
+// visibleWidth returns the number of runes of s outside its ANSI escape sequences.
+func visibleWidth(s string) int {
+	width := 0
+	for i := 0; i < len(s); {
+		if s[i] == 0x1b {
+			i = ansiSequenceEnd(s, i)
+			continue
+		}
+		_, size := utf8.DecodeRuneInString(s[i:])
+		width++
+		i += size
+	}
+	return width
+}
+
+// ansiSequenceEnd returns the index just past the ANSI escape sequence starting at s[i]: a CSI
+// sequence such as "\033[1;31m" up to its final letter, or an OSC sequence such as a hyperlink
+// up to its string terminator.
+func ansiSequenceEnd(s string, i int) int {
+	if i+1 < len(s) && s[i+1] == ']' {
+		if end := strings.Index(s[i:], "\033\\"); end >= 0 {
+			return i + end + 2
+		}
+		return len(s)
+	}
+	j := i + 2
+	for j < len(s) && !(s[j] >= '@' && s[j] <= '~') {
+		j++
+	}
+	return min(j+1, len(s))
+}
@@ -0,0 +1,18 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// openTerminal fails, as the TUI drives the terminal with stty, which only Unix systems have.
+func openTerminal() (*os.File, func(), error) {
+	return nil, nil, errors.New("-tui is only supported in Unix terminals")
+}
+
+// terminalSize returns the default size of 24 by 80, as the TUI does not run here.
+func terminalSize(*os.File) (rows, cols int) {
+	return 24, 80
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// TestParseKeys tests naming the keys of a read from the terminal.
+func TestParseKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected []string
+	}{
+		{"Text", "aé", []string{"a", "é"}},
+		{"Esc", "\033", []string{"esc"}},
+		{"Arrows", "\033[A\033[B\033OA", []string{"up", "down", "up"}},
+		{"Pages", "\033[5~x\033[6~", []string{"pgup", "x", "pgdown"}},
+		{"Controls", "\r\x7f\x03\x0e\x10\x15", []string{"enter", "backspace", "ctrl-c", "ctrl-n", "ctrl-p", "ctrl-u"}},
+		{"Unknown", "\033[1;5C\x01b", []string{"b"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseKeys([]byte(tt.input)); fmt.Sprintf("%q", got) != fmt.Sprintf("%q", tt.expected) {
+				t.Errorf("parseKeys(%q) = %q, want %q", tt.input, got, tt.expected)
+			}
+		})
+	}
+}
+
+// TestTUI_HandleKey tests editing the query and moving the selection with keys.
+func TestTUI_HandleKey(t *testing.T) {
+	matches := make([]tuiMatch, 10)
+	for i := range matches {
+		matches[i] = tuiMatch{displayPath: "a.go", line: i, column: 2}
+	}
+	ui := &tui{query: []rune("fo"), matches: matches}
+
+	steps := []struct {
+		key      string
+		action   tuiAction
+		query    string
+		selected int
+	}{
+		{"o", tuiSearchAgain, "foo", 0},
+		{"\t", tuiRedraw, "foo", 0},
+		{"backspace", tuiSearchAgain, "fo", 0},
+		{"down", tuiRedraw, "fo", 1},
+		{"pgdown", tuiRedraw, "fo", 5},
+		{"pgdown", tuiRedraw, "fo", 9},
+		{"ctrl-n", tuiRedraw, "fo", 9},
+		{"pgup", tuiRedraw, "fo", 5},
+		{"up", tuiRedraw, "fo", 4},
+		{"ctrl-u", tuiSearchAgain, "", 4},
+		{"backspace", tuiRedraw, "", 4},
+		{"enter", tuiChoose, "", 4},
+		{"esc", tuiQuit, "", 4},
+	}
+	for _, s := range steps {
+		if got := ui.handleKey(s.key, 4); got != s.action {
+			t.Errorf("handleKey(%q) = %v, want %v", s.key, got, s.action)
+		}
+		if string(ui.query) != s.query || ui.selected != s.selected {
+			t.Errorf("after %q: query %q, selected %d, want %q, %d", s.key, string(ui.query), ui.selected, s.query, s.selected)
+		}
+	}
+	if got := ui.location(); got != "a.go:5:3" {
+		t.Errorf("location() = %q, want a.go:5:3", got)
+	}
+	if got := (&tui{}).location(); got != "" {
+		t.Errorf("location() without matches = %q, want none", got)
+	}
+}
+
+// TestTUI_Render tests laying out the search box, the scrolled match list, and the preview.
+func TestTUI_Render(t *testing.T) {
+	ui := &tui{query: []rune("foo"), status: "5 matches", selected: 4}
+	for i := range 5 {
+		ui.matches = append(ui.matches, tuiMatch{displayPath: "a.go", line: i, text: fmt.Sprintf("foo%d()", i)})
+	}
+
+	screen := ui.render(5, 50, []string{"\033[31mpreview line that is far too long\033[0m", "second"})
+	if ui.offset != 2 {
+		t.Errorf("render() offset = %d, want 2 to show the selected match", ui.offset)
+	}
+	rows := strings.Split(screen, "\r\n")
+	if len(rows) != 5 {
+		t.Fatalf("render() = %d rows, want 5:\n%q", len(rows), screen)
+	}
+
+	ansi := regexp.MustCompile("\033\\[[0-9;?]*[A-Za-z]")
+	expected := []string{
+		"> foo▏" + strings.Repeat(" ", 35) + "5 matches",
+		strings.Repeat("─", 50),
+		"a.go:3: foo2()" + strings.Repeat(" ", 6) + "│preview line that is far too ",
+		"a.go:4: foo3()" + strings.Repeat(" ", 6) + "│second",
+		"a.go:5: foo4()" + strings.Repeat(" ", 6) + "│",
+	}
+	for i, row := range rows {
+		if got := ansi.ReplaceAllString(row, ""); got != expected[i] {
+			t.Errorf("render() row %d = %q, want %q", i, got, expected[i])
+		}
+	}
+	if !strings.Contains(rows[4], "\033[7ma.go:5: foo4()") {
+		t.Errorf("render() selected row = %q, want it in reverse video", rows[4])
+	}
+	if !strings.Contains(rows[2], "far too \033[0m") {
+		t.Errorf("render() cut preview row = %q, want its colors reset", rows[2])
+	}
+}
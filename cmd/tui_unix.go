@@ -0,0 +1,55 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// openTerminal opens the controlling terminal in raw mode, without echo, for the TUI. It returns
+// the terminal and a function restoring its mode and closing it.
+func openTerminal() (*os.File, func(), error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("-tui needs a terminal: %w", err)
+	}
+	saved, err := stty(tty, "-g")
+	if err != nil {
+		tty.Close()
+		return nil, nil, fmt.Errorf("-tui needs a Unix terminal: %w", err)
+	}
+	if _, err := stty(tty, "raw", "-echo"); err != nil {
+		tty.Close()
+		return nil, nil, err
+	}
+	return tty, func() {
+		stty(tty, strings.TrimSpace(saved))
+		tty.Close()
+	}, nil
+}
+
+// stty runs stty with args on the terminal and returns its output.
+func stty(tty *os.File, args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = tty
+	out, err := cmd.Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		err = fmt.Errorf("stty %s: %s", strings.Join(args, " "), strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	return string(out), err
+}
+
+// terminalSize returns the number of rows and columns of the terminal, or 24 by 80 if it
+// cannot be found out.
+func terminalSize(tty *os.File) (rows, cols int) {
+	out, err := stty(tty, "size")
+	if _, scanErr := fmt.Sscan(out, &rows, &cols); err != nil || scanErr != nil || rows <= 0 || cols <= 0 {
+		return 24, 80
+	}
+	return rows, cols
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// workspace lists several roots searched together, read from the file given to -workspace:
+//
+//	roots:
+//	  - path: ../service-a
+//	  - path: ../service-b
+//	    name: b
+//	    ignore_file: .gitignore
+//	    profile: compact
+type workspace struct {
+	Roots []workspaceRoot `yaml:"roots"`
+}
+
+// workspaceRoot is a root of a workspace with its overrides.
+type workspaceRoot struct {
+	Path       string `yaml:"path"`        // Directory to search, relative to the workspace file.
+	Name       string `yaml:"name"`        // Prefix of the paths reported for this root; defaults to the directory's base name.
+	IgnoreFile string `yaml:"ignore_file"` // Ignore file read from the root; defaults to .astignore.
+	Profile    string `yaml:"profile"`     // Config profile used for this root; defaults to the -profile flag or the root's config.
+}
+
+// searchRoot is a directory to search and how to report and render its files.
+type searchRoot struct {
+	path       string
+	prefix     string // Prepended to the relative paths of the files reported.
+	ignoreFile string
+	profile    string
+}
+
+// loadWorkspace reads a workspace file and returns its roots, resolved against the file's directory.
+// Each root inherits the given profile unless it overrides it.
+func loadWorkspace(path, profile string) ([]searchRoot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var ws workspace
+	if err := yaml.Unmarshal(data, &ws); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if len(ws.Roots) == 0 {
+		return nil, fmt.Errorf("%s: no roots", path)
+	}
+
+	dir := filepath.Dir(path)
+	roots := make([]searchRoot, len(ws.Roots))
+	for i, r := range ws.Roots {
+		if r.Path == "" {
+			return nil, fmt.Errorf("%s: root %d has no path", path, i+1)
+		}
+		rootPath := r.Path
+		if !filepath.IsAbs(rootPath) {
+			rootPath = filepath.Join(dir, rootPath)
+		}
+		name := r.Name
+		if name == "" {
+			name = filepath.Base(rootPath)
+		}
+		roots[i] = searchRoot{
+			path:       rootPath,
+			prefix:     name,
+			ignoreFile: r.IgnoreFile,
+			profile:    r.Profile,
+		}
+		if roots[i].profile == "" {
+			roots[i].profile = profile
+		}
+	}
+	return roots, nil
+}
+
+// filePath returns the path of the file walked as rel, which is the root itself if it is a file,
+// or rel itself if it is absolute, as files listed by -files-from may be.
+func (r searchRoot) filePath(rel string) string {
+	if filepath.IsAbs(rel) {
+		return rel
+	}
+	if info, err := os.Stat(r.path); err == nil && !info.IsDir() {
+		return r.path
+	}
+	return filepath.Join(r.path, rel)
+}
+
+// displayPath returns the path of a file of the root as reported to the user.
+func (r searchRoot) displayPath(rel string) string {
+	if r.prefix == "" {
+		return rel
+	}
+	return filepath.Join(r.prefix, rel)
+}
@@ -0,0 +1,133 @@
+package grepast
+
+import (
+	"slices"
+
+	sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+// CommentSyntax describes how comments and documentation are written in a language.
+type CommentSyntax struct {
+	// NodeKinds are the parse-tree node kinds of comments, e.g. "comment" or "line_comment".
+	NodeKinds []string `json:"node_kinds"`
+
+	// DocstringKinds are the kinds of nodes that document a module, class, or function when they
+	// are the first statement of its body, such as Python's string docstrings. Most languages
+	// have none.
+	DocstringKinds []string `json:"docstring_kinds,omitempty"`
+
+	LineMarkers  []string    `json:"line_markers,omitempty"`  // Markers of comments running to the end of the line, e.g. "//".
+	BlockMarkers [][2]string `json:"block_markers,omitempty"` // Opening and closing markers of block comments, e.g. {"/*", "*/"}.
+	DocMarkers   []string    `json:"doc_markers,omitempty"`   // Markers that start documentation comments or docstrings, e.g. "///" or "/**".
+}
+
+// commentSyntaxes holds the comment syntax of each language with a parser, by language name.
+var commentSyntaxes = map[string]CommentSyntax{
+	"bash": {
+		NodeKinds:   []string{"comment"},
+		LineMarkers: []string{"#"},
+	},
+	"c_sharp": {
+		NodeKinds:    []string{"comment"},
+		LineMarkers:  []string{"//"},
+		BlockMarkers: [][2]string{{"/*", "*/"}},
+		DocMarkers:   []string{"///", "/**"},
+	},
+	"css": {
+		NodeKinds:    []string{"comment", "js_comment"},
+		LineMarkers:  []string{"//"},
+		BlockMarkers: [][2]string{{"/*", "*/"}},
+	},
+	"go": {
+		NodeKinds:    []string{"comment"},
+		LineMarkers:  []string{"//"},
+		BlockMarkers: [][2]string{{"/*", "*/"}},
+	},
+	"html": {
+		NodeKinds:    []string{"comment"},
+		BlockMarkers: [][2]string{{"<!--", "-->"}},
+	},
+	"java": {
+		NodeKinds:    []string{"line_comment", "block_comment"},
+		LineMarkers:  []string{"//"},
+		BlockMarkers: [][2]string{{"/*", "*/"}},
+		DocMarkers:   []string{"/**"},
+	},
+	"javascript": {
+		NodeKinds:    []string{"comment", "html_comment"},
+		LineMarkers:  []string{"//"},
+		BlockMarkers: [][2]string{{"/*", "*/"}},
+		DocMarkers:   []string{"/**"},
+	},
+	"python": {
+		NodeKinds:      []string{"comment"},
+		DocstringKinds: []string{"string"},
+		LineMarkers:    []string{"#"},
+		DocMarkers:     []string{`"""`, `'''`},
+	},
+	"rust": {
+		NodeKinds:    []string{"line_comment", "block_comment"},
+		LineMarkers:  []string{"//"},
+		BlockMarkers: [][2]string{{"/*", "*/"}},
+		DocMarkers:   []string{"///", "//!", "/**", "/*!"},
+	},
+	"typescript": {
+		NodeKinds:    []string{"comment", "html_comment"},
+		LineMarkers:  []string{"//"},
+		BlockMarkers: [][2]string{{"/*", "*/"}},
+		DocMarkers:   []string{"/**"},
+	},
+}
+
+// CommentSyntaxOf returns the comment syntax of a language, named as by TreeContext.Language,
+// e.g. "go", and reports whether the language is known.
+func CommentSyntaxOf(language string) (CommentSyntax, bool) {
+	cs, ok := commentSyntaxes[language]
+	if !ok {
+		return CommentSyntax{}, false
+	}
+	// Copy the slices so that callers cannot change the shared table.
+	return CommentSyntax{
+		NodeKinds:      slices.Clone(cs.NodeKinds),
+		DocstringKinds: slices.Clone(cs.DocstringKinds),
+		LineMarkers:    slices.Clone(cs.LineMarkers),
+		BlockMarkers:   slices.Clone(cs.BlockMarkers),
+		DocMarkers:     slices.Clone(cs.DocMarkers),
+	}, true
+}
+
+// IsCommentKind reports whether kind is the parse-tree node kind of a comment in language.
+func IsCommentKind(language, kind string) bool {
+	return slices.Contains(commentSyntaxes[language].NodeKinds, kind)
+}
+
+// isCommentNode reports whether node is a comment or a docstring of the context's language.
+func (tc *TreeContext) isCommentNode(node *sitter.Node) bool {
+	cs := commentSyntaxes[tc.language]
+	if slices.Contains(cs.NodeKinds, node.Kind()) {
+		return true
+	}
+	return slices.Contains(cs.DocstringKinds, node.Kind()) && isDocstring(node)
+}
+
+// isDocstring reports whether node is the sole expression of the first statement of a module or
+// of the body of a definition.
+func isDocstring(node *sitter.Node) bool {
+	stmt := node.Parent()
+	if stmt == nil || stmt.Kind() != "expression_statement" || stmt.NamedChildCount() != 1 {
+		return false
+	}
+	body := stmt.Parent()
+	if body == nil {
+		return false
+	}
+	if first := body.NamedChild(0); first == nil || first.Id() != stmt.Id() {
+		return false
+	}
+	def := body.Parent()
+	if def == nil {
+		return true // The module itself.
+	}
+	b := def.ChildByFieldName("body")
+	return b != nil && b.Id() == body.Id()
+}
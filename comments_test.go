@@ -0,0 +1,59 @@
+package grepast
+
+import (
+	"testing"
+)
+
+// TestCommentSyntax tests that the comment node kinds of each language match its grammar.
+func TestCommentSyntax(t *testing.T) {
+	// The comment on the first line of each sample also selects the language.
+	samples := map[string]string{
+		"bash":       "# grep-ast: lang=bash\necho hi\n",
+		"c_sharp":    "// grep-ast: lang=c_sharp\nclass A {}\n",
+		"css":        "/* grep-ast: lang=css */\na { color: red; }\n",
+		"go":         "// grep-ast: lang=go\npackage p\n",
+		"html":       "<!-- grep-ast: lang=html -->\n<p>hi</p>\n",
+		"java":       "// grep-ast: lang=java\nclass A {}\n",
+		"javascript": "// grep-ast: lang=javascript\nlet a = 1;\n",
+		"python":     "# grep-ast: lang=python\na = 1\n",
+		"rust":       "// grep-ast: lang=rust\nfn f() {}\n",
+		"typescript": "// grep-ast: lang=typescript\nlet a = 1;\n",
+	}
+	for lang := range commentSyntaxes {
+		if _, ok := samples[lang]; !ok {
+			t.Errorf("no sample for %s", lang)
+		}
+	}
+
+	for lang, source := range samples {
+		t.Run(lang, func(t *testing.T) {
+			tc, err := NewTreeContext("sample", []byte(source), TreeContextOptions{})
+			if err != nil {
+				t.Fatalf("NewTreeContext() error = %v", err)
+			}
+			found := false
+			for _, node := range tc.nodes[0] {
+				found = found || IsCommentKind(lang, node.Kind())
+			}
+			if !found {
+				t.Errorf("no node of line 0 of %q has a comment kind of %s", source, lang)
+			}
+		})
+	}
+}
+
+// TestCommentSyntaxOf tests that CommentSyntaxOf returns copies and reports unknown languages.
+func TestCommentSyntaxOf(t *testing.T) {
+	cs, ok := CommentSyntaxOf("rust")
+	if !ok || len(cs.NodeKinds) == 0 || len(cs.DocMarkers) == 0 {
+		t.Fatalf("CommentSyntaxOf(rust) = %+v, %v", cs, ok)
+	}
+	cs.NodeKinds[0] = "changed"
+	if IsCommentKind("rust", "changed") {
+		t.Errorf("CommentSyntaxOf() returned shared state")
+	}
+
+	if _, ok := CommentSyntaxOf("cobol"); ok {
+		t.Errorf("CommentSyntaxOf(cobol) reported a known language")
+	}
+}
@@ -0,0 +1,343 @@
+package grepast
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around each change in a unified diff.
+const diffContext = 3
+
+// hunkHeader matches the header of a unified diff hunk, capturing its old and new line ranges.
+var hunkHeader = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// diffOp is a line of a line-based diff: kept (' '), deleted ('-'), or inserted ('+').
+type diffOp struct {
+	kind byte
+	line string // Line text, including its newline if it has one.
+}
+
+// UnifiedDiff returns a unified diff turning before into after, with path as the name of both
+// files, or an empty string if they are equal.
+func UnifiedDiff(path string, before, after []byte) string {
+	ops := diffLines(splitLinesKeepEnds(string(before)), splitLinesKeepEnds(string(after)))
+
+	var sb strings.Builder
+	for i := 0; i < len(ops); {
+		// Find the next change.
+		for i < len(ops) && ops[i].kind == ' ' {
+			i++
+		}
+		if i == len(ops) {
+			break
+		}
+
+		// Extend the hunk while changes are close enough for their context to touch.
+		start := max(0, i-diffContext)
+		end := i
+		for j := i; j < len(ops); j++ {
+			if ops[j].kind != ' ' {
+				end = j + 1
+			} else if j-end >= 2*diffContext {
+				break
+			}
+		}
+		end = min(len(ops), end+diffContext)
+
+		if sb.Len() == 0 {
+			fmt.Fprintf(&sb, "--- a/%s\n+++ b/%s\n", path, path)
+		}
+		writeHunk(&sb, ops, start, end)
+		i = end
+	}
+	return sb.String()
+}
+
+// writeHunk writes the hunk covering ops[start:end].
+func writeHunk(sb *strings.Builder, ops []diffOp, start, end int) {
+	oldLine, newLine := 0, 0
+	for _, op := range ops[:start] {
+		if op.kind != '+' {
+			oldLine++
+		}
+		if op.kind != '-' {
+			newLine++
+		}
+	}
+	oldLen, newLen := 0, 0
+	for _, op := range ops[start:end] {
+		if op.kind != '+' {
+			oldLen++
+		}
+		if op.kind != '-' {
+			newLen++
+		}
+	}
+	// An empty range is numbered by the line before it.
+	if oldLen > 0 {
+		oldLine++
+	}
+	if newLen > 0 {
+		newLine++
+	}
+
+	fmt.Fprintf(sb, "@@ -%d,%d +%d,%d @@\n", oldLine, oldLen, newLine, newLen)
+	for _, op := range ops[start:end] {
+		sb.WriteByte(op.kind)
+		sb.WriteString(op.line)
+		if !strings.HasSuffix(op.line, "\n") {
+			sb.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+// splitLinesKeepEnds splits s into lines, each keeping its trailing newline.
+func splitLinesKeepEnds(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// maxDiffEdits caps the edits diffLines searches for, as Myers' algorithm takes time and memory
+// growing with their square. Files further apart are diffed as a single replacement.
+const maxDiffEdits = 2000
+
+// diffLines returns a shortest edit script turning a into b, using Myers' algorithm on the lines
+// between their common prefix and suffix. If more than maxDiffEdits lines differ, those lines are
+// all deleted and then inserted instead.
+func diffLines(a, b []string) []diffOp {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var ops []diffOp
+	for _, line := range a[:prefix] {
+		ops = append(ops, diffOp{kind: ' ', line: line})
+	}
+	middleA, middleB := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if middle, ok := myersDiff(middleA, middleB, maxDiffEdits); ok {
+		ops = append(ops, middle...)
+	} else {
+		for _, line := range middleA {
+			ops = append(ops, diffOp{kind: '-', line: line})
+		}
+		for _, line := range middleB {
+			ops = append(ops, diffOp{kind: '+', line: line})
+		}
+	}
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, diffOp{kind: ' ', line: line})
+	}
+	return ops
+}
+
+// myersDiff returns a shortest edit script turning a into b, using Myers' algorithm, or false if
+// it takes more than maxEdits edits. Each step of the trace only keeps the diagonals it reached,
+// so memory grows with the square of the edits rather than with the edits times the lines.
+func myersDiff(a, b []string, maxEdits int) ([]diffOp, bool) {
+	n, m := len(a), len(b)
+	offset := n + m + 1
+	v := make([]int, 2*offset+1)
+	// trace[d] holds v[offset-d : offset+d+1] as it was before step d.
+	var trace [][]int
+
+	found := false
+search:
+	for d := 0; d <= min(n+m, maxEdits); d++ {
+		trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				found = true
+				break search
+			}
+		}
+	}
+	if !found {
+		return nil, false
+	}
+
+	// Walk back through the trace to recover the edit script.
+	var ops []diffOp
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && v[d+k-1] < v[d+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := 0
+		if d > 0 {
+			prevX = v[d+prevK]
+		}
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			ops = append(ops, diffOp{kind: ' ', line: a[x-1]})
+			x--
+			y--
+		}
+		if d > 0 {
+			if x == prevX {
+				ops = append(ops, diffOp{kind: '+', line: b[y-1]})
+			} else {
+				ops = append(ops, diffOp{kind: '-', line: a[x-1]})
+			}
+			x, y = prevX, prevY
+		}
+	}
+
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops, true
+}
+
+// DiffChangedLines parses a unified diff, such as the output of git diff or UnifiedDiff, and
+// returns the zero-based lines of each new file that the diff inserts or changes, keyed by path
+// without any "a/" or "b/" prefix. Where lines are only deleted, the line following the deletion
+// is reported instead, so that every hunk yields at least one line. Hunks not preceded by file
+// names are keyed by "", and deleted files are left out.
+func DiffChangedLines(unifiedDiff []byte) (map[string]map[int]struct{}, error) {
+	changed := make(map[string]map[int]struct{})
+	path := ""
+	oldLeft, newLeft, line := 0, 0, 0
+
+	mark := func(line int) {
+		if path == "/dev/null" {
+			return
+		}
+		if changed[path] == nil {
+			changed[path] = make(map[int]struct{})
+		}
+		changed[path][line] = struct{}{}
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(unifiedDiff))
+	scanner.Buffer(nil, len(unifiedDiff)+1)
+	for scanner.Scan() {
+		text := scanner.Text()
+
+		// Within a hunk, every line is part of it until its line counts are used up, even one
+		// looking like a file header.
+		if oldLeft > 0 || newLeft > 0 {
+			switch {
+			case strings.HasPrefix(text, "+"):
+				mark(line)
+				line++
+				newLeft--
+			case strings.HasPrefix(text, "-"):
+				mark(line)
+				oldLeft--
+			case strings.HasPrefix(text, "\\"):
+				// "\ No newline at end of file"
+			default:
+				line++
+				oldLeft--
+				newLeft--
+			}
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(text, "+++ "):
+			path = diffPath(text[len("+++ "):])
+		case strings.HasPrefix(text, "@@"):
+			m := hunkHeader.FindStringSubmatch(text)
+			if m == nil {
+				return nil, fmt.Errorf("malformed hunk header %q", text)
+			}
+			oldLeft, newLeft = hunkLength(m[2]), hunkLength(m[4])
+			line, _ = strconv.Atoi(m[3])
+			// An empty range is numbered by the line before it.
+			if newLeft > 0 {
+				line--
+			}
+		}
+	}
+	return changed, scanner.Err()
+}
+
+// hunkLength returns the length of a hunk range given its optional length field, which is 1 if absent.
+func hunkLength(field string) int {
+	if field == "" {
+		return 1
+	}
+	n, _ := strconv.Atoi(field)
+	return n
+}
+
+// diffPath returns the path named by the file header of a unified diff, without any trailing
+// timestamp or "a/" or "b/" prefix. Git ends the names containing spaces with a tab, and quotes
+// those containing control characters, quotes, or backslashes as C strings, which are unquoted.
+func diffPath(header string) string {
+	if i := strings.IndexByte(header, '\t'); i >= 0 {
+		header = header[:i]
+	}
+	if strings.HasPrefix(header, `"`) {
+		if path, err := strconv.Unquote(header); err == nil {
+			header = path
+		}
+	}
+	if header == "/dev/null" {
+		return header
+	}
+	if strings.HasPrefix(header, "a/") || strings.HasPrefix(header, "b/") {
+		header = header[2:]
+	}
+	return header
+}
+
+// AddLinesOfInterestFromDiff marks the lines a unified diff inserts or changes in this file as
+// lines of interest, so that AddContext renders the context around a patch; see
+// DiffChangedLines. The diff may cover other files too: only the hunks of the file whose path
+// equals the context's file name, or whose trailing path elements match it, are used, along
+// with hunks without file names.
+func (tc *TreeContext) AddLinesOfInterestFromDiff(unifiedDiff []byte) error {
+	changed, err := DiffChangedLines(unifiedDiff)
+	if err != nil {
+		return err
+	}
+	for path, lines := range changed {
+		if path != "" && !samePathSuffix(filepath.ToSlash(tc.filename), path) {
+			continue
+		}
+		for line := range lines {
+			// Deletions at the end of the file are reported past its last line.
+			tc.linesOfInterest[max(0, min(line, tc.renderedLineCount()-1))] = struct{}{}
+		}
+	}
+	return nil
+}
+
+// samePathSuffix reports whether the slash-separated paths a and b are equal, or one ends with
+// the other as whole path elements, e.g. "/src/repo/pkg/f.go" and "pkg/f.go".
+func samePathSuffix(a, b string) bool {
+	return a == b || strings.HasSuffix(a, "/"+b) || strings.HasSuffix(b, "/"+a)
+}
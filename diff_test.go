@@ -0,0 +1,145 @@
+package grepast
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// TestDiffChangedLines tests finding the lines changed by a unified diff.
+func TestDiffChangedLines(t *testing.T) {
+	diff := `diff --git a/pkg/f.go b/pkg/f.go
+--- a/pkg/f.go
++++ b/pkg/f.go
+@@ -2,3 +2,4 @@ func f() {
+ 	a()
+-	b()
++	c()
++	d()
+ }
+@@ -9,2 +10 @@
+ 	e()
+--- looks like a header
+diff --git a/gone.go b/gone.go
+--- a/gone.go
++++ /dev/null
+@@ -1 +0,0 @@
+-package gone
+--- /dev/null
++++ b/new.go	2024-01-01 00:00:00
+@@ -0,0 +1,2 @@
++package p
++
+--- "a/\303\251.go"
++++ "b/\303\251.go"
+@@ -1,0 +2 @@
++x
+--- a/a b.go	
++++ b/a b.go	
+@@ -1,0 +2 @@
++x
+`
+	changed, err := DiffChangedLines([]byte(diff))
+	if err != nil {
+		t.Fatalf("DiffChangedLines() error = %v", err)
+	}
+	got := fmt.Sprint(mapKeysSorted(changed["pkg/f.go"]), mapKeysSorted(changed["new.go"]),
+		mapKeysSorted(changed["é.go"]), mapKeysSorted(changed["a b.go"]), len(changed))
+	if expected := "[2 3 10] [0 1] [1] [1] 4"; got != expected {
+		t.Errorf("DiffChangedLines() = %s, want %s", got, expected)
+	}
+
+	if _, err := DiffChangedLines([]byte("@@ -1 +x @@\n")); err == nil {
+		t.Errorf("DiffChangedLines() with a malformed hunk header: error = nil, want an error")
+	}
+}
+
+// TestTreeContext_AddLinesOfInterestFromDiff tests rendering the context around a patch.
+func TestTreeContext_AddLinesOfInterestFromDiff(t *testing.T) {
+	before := "package p\n\nfunc f() {\n\ta()\n\tb()\n}\n\nfunc g() {\n\tc()\n}\n"
+	after := "package p\n\nfunc f() {\n\ta()\n\tb()\n}\n\nfunc g() {\n\td()\n}\n"
+	diff := UnifiedDiff("pkg/example.go", []byte(before), []byte(after)) +
+		UnifiedDiff("other.go", []byte("a\n"), []byte("b\n"))
+
+	tc, err := NewTreeContext("/src/repo/pkg/example.go", []byte(after), TreeContextOptions{ShowParentContext: true})
+	if err != nil {
+		t.Fatalf("NewTreeContext() error = %v", err)
+	}
+	if err := tc.AddLinesOfInterestFromDiff([]byte(diff)); err != nil {
+		t.Fatalf("AddLinesOfInterestFromDiff() error = %v", err)
+	}
+	if got := fmt.Sprint(tc.LinesOfInterest()); got != "[8]" {
+		t.Errorf("LinesOfInterest() = %s, want [8]", got)
+	}
+	tc.AddContext()
+	if got, expected := tc.Format(), "⋮...\n│func g() {\n│\td()\n│}\n"; got != expected {
+		t.Errorf("Format() = %q, want %q", got, expected)
+	}
+}
+
+// TestDiffLines tests that diffLines turns a into b with the fewest edits, and that files more
+// than maxDiffEdits lines apart are diffed as one replacement between their common lines.
+func TestDiffLines(t *testing.T) {
+	// sides returns the lines ops keeps or deletes, and those it keeps or inserts.
+	sides := func(ops []diffOp) (string, string, int) {
+		var before, after strings.Builder
+		edits := 0
+		for _, op := range ops {
+			if op.kind != '+' {
+				before.WriteString(op.line)
+			}
+			if op.kind != '-' {
+				after.WriteString(op.line)
+			}
+			if op.kind != ' ' {
+				edits++
+			}
+		}
+		return before.String(), after.String(), edits
+	}
+
+	tests := []struct {
+		a, b  string
+		edits int
+	}{
+		{"", "", 0},
+		{"a\n", "", 1},
+		{"", "a\nb\n", 2},
+		{"a\nb\nc\n", "a\nc\n", 1},
+		{"a\nb\nc\na\nb\nb\na\n", "c\nb\na\nb\na\nc\n", 5},
+		{"x\na\nb\ny\n", "x\nb\na\ny\n", 2},
+	}
+	for _, tt := range tests {
+		a, b := splitLinesKeepEnds(tt.a), splitLinesKeepEnds(tt.b)
+		before, after, edits := sides(diffLines(a, b))
+		if before != tt.a || after != tt.b || edits != tt.edits {
+			t.Errorf("diffLines(%q, %q) turns %q into %q with %d edits, want %d edits", tt.a, tt.b, before, after, edits, tt.edits)
+		}
+	}
+
+	var a, b []string
+	a = append(a, "same\n")
+	b = append(b, "same\n")
+	for i := range maxDiffEdits/2 + 1 {
+		a = append(a, fmt.Sprintf("old %d\n", i))
+		b = append(b, fmt.Sprintf("new %d\n", i))
+	}
+	a = append(a, "end\n")
+	b = append(b, "end\n")
+	ops := diffLines(a, b)
+	if len(ops) != len(a)+len(b)-2 {
+		t.Fatalf("diffLines() = %d lines, want %d", len(ops), len(a)+len(b)-2)
+	}
+	for i, op := range ops {
+		kind := byte('-')
+		switch {
+		case i == 0 || i == len(ops)-1:
+			kind = ' '
+		case i > len(a)-2:
+			kind = '+'
+		}
+		if op.kind != kind {
+			t.Fatalf("diffLines()[%d] = %c%q, want %c", i, op.kind, op.line, kind)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+// Package grepast searches source files and renders matching lines together with the
+// surrounding syntax-tree context (enclosing functions, classes, loops, etc.).
+//
+// The stable API consists of exactly these identifiers:
+//
+//   - SearchFile, SearchFileCtx, SearchOptions, FileResult, and Match for searching a single
+//     file in one call;
+//   - Builder, NewBuilder, and the methods of Builder for assembling such a search step by step;
+//   - SearchTree, SearchTreeCtx, TreeSearchOptions, and TreeResult for searching every file
+//     under a root concurrently;
+//   - Walker, NewWalker, WalkerOptions, and WalkFunc for enumerating the files under a root;
+//   - Formatter, TextFormatter, JSONFormatter, and SARIFFormatter for rendering results;
+//   - Report, NewReport, ReportOptions, and ReportOrder for rendering the results of several
+//     files as one document;
+//   - TreeContext, NewTreeContext, TreeContextOptions, and GrepOptions, with only these methods
+//     of TreeContext: AddLinesOfInterest, AddContext, Grep, GrepWithOptions, GrepPatterns,
+//     LinesOfInterest, Matches, Format, and Language;
+//   - ErrorUnrecognizedFiletype, ErrorUnsupportedLanguage, and ErrorBinaryFile.
+//
+// Every other exported identifier, including the other methods of TreeContext and the
+// repomap package, may still change in a minor release.
+//
+// Rendering is deterministic: identical sources, patterns, and options always produce
+// byte-identical output, so results can be compared against golden files and cached.
+//
+// The stable identifiers follow semantic versioning: within a major version they only gain
+// fields and methods. Breaking changes are made under a new major module path
+// (github.com/cyber-nic/grep-ast/v2). Identifiers marked Deprecated are kept until then.
+package grepast
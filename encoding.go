@@ -0,0 +1,61 @@
+package grepast
+
+import (
+	"bytes"
+	"encoding/binary"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// binarySniffLen is the number of leading bytes searched for a NUL byte to tell binary content
+// from text, as git does.
+const binarySniffLen = 8000
+
+// Names of the encodings DecodeSource transcodes from.
+const (
+	EncodingUTF8BOM = "utf-8-bom" // UTF-8 with a byte order mark, which is dropped.
+	EncodingUTF16LE = "utf-16le"  // Little-endian UTF-16 with a byte order mark.
+	EncodingUTF16BE = "utf-16be"  // Big-endian UTF-16 with a byte order mark.
+	EncodingLatin1  = "latin-1"   // ISO 8859-1, assumed for sources that are not valid UTF-8.
+)
+
+// DecodeSource returns source as UTF-8 without a byte order mark, along with the name of the
+// encoding it was transcoded from, or "" if it already was plain UTF-8. Sources starting with a
+// UTF-8 or UTF-16 byte order mark are decoded accordingly; any other source that is not valid
+// UTF-8 is decoded as latin-1, in which every byte is a character.
+func DecodeSource(source []byte) ([]byte, string) {
+	switch {
+	case bytes.HasPrefix(source, []byte{0xef, 0xbb, 0xbf}):
+		return source[3:], EncodingUTF8BOM
+	case bytes.HasPrefix(source, []byte{0xff, 0xfe}):
+		return decodeUTF16(source[2:], binary.LittleEndian), EncodingUTF16LE
+	case bytes.HasPrefix(source, []byte{0xfe, 0xff}):
+		return decodeUTF16(source[2:], binary.BigEndian), EncodingUTF16BE
+	case !utf8.Valid(source):
+		decoded := make([]byte, 0, len(source)+len(source)/8)
+		for _, b := range source {
+			decoded = utf8.AppendRune(decoded, rune(b))
+		}
+		return decoded, EncodingLatin1
+	}
+	return source, ""
+}
+
+// decodeUTF16 decodes UTF-16 data of the given byte order to UTF-8. A trailing odd byte is dropped.
+func decodeUTF16(data []byte, order binary.ByteOrder) []byte {
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		units[i] = order.Uint16(data[2*i:])
+	}
+	decoded := make([]byte, 0, len(units))
+	for _, r := range utf16.Decode(units) {
+		decoded = utf8.AppendRune(decoded, r)
+	}
+	return decoded
+}
+
+// IsBinary reports whether source looks like binary content rather than text: whether it holds
+// a NUL byte within its first few kilobytes.
+func IsBinary(source []byte) bool {
+	return bytes.IndexByte(source[:min(len(source), binarySniffLen)], 0) >= 0
+}
@@ -0,0 +1,71 @@
+package grepast
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+	"unicode/utf16"
+)
+
+// TestDecodeSource tests that DecodeSource recognizes and transcodes each supported encoding.
+func TestDecodeSource(t *testing.T) {
+	utf16le := []byte{0xff, 0xfe}
+	utf16be := []byte{0xfe, 0xff}
+	for _, u := range utf16.Encode([]rune("x = \"é😀\"\n")) {
+		utf16le = binary.LittleEndian.AppendUint16(utf16le, u)
+		utf16be = binary.BigEndian.AppendUint16(utf16be, u)
+	}
+
+	tests := []struct {
+		name     string
+		source   []byte
+		expected string
+		encoding string
+	}{
+		{"UTF-8", []byte("x = \"é\"\n"), "x = \"é\"\n", ""},
+		{"UTF-8 BOM", []byte("\xef\xbb\xbfx = 1\n"), "x = 1\n", EncodingUTF8BOM},
+		{"UTF-16LE", utf16le, "x = \"é😀\"\n", EncodingUTF16LE},
+		{"UTF-16BE", utf16be, "x = \"é😀\"\n", EncodingUTF16BE},
+		{"latin-1", []byte("x = \"caf\xe9\"\n"), "x = \"café\"\n", EncodingLatin1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, encoding := DecodeSource(tt.source)
+			if string(got) != tt.expected || encoding != tt.encoding {
+				t.Errorf("DecodeSource() = %q, %q, want %q, %q", got, encoding, tt.expected, tt.encoding)
+			}
+		})
+	}
+}
+
+// TestNewTreeContext_Encoding tests that NewTreeContext rejects binary content and, with
+// DetectEncoding, searches transcoded sources.
+func TestNewTreeContext_Encoding(t *testing.T) {
+	if _, err := NewTreeContext("example.go", []byte("package p\x00\x01\x02"), TreeContextOptions{}); err != ErrorBinaryFile {
+		t.Errorf("NewTreeContext() on binary content error = %v, want %v", err, ErrorBinaryFile)
+	}
+
+	source := []byte{0xff, 0xfe}
+	for _, u := range utf16.Encode([]rune("package p\n\n// naïve\nfunc f() {}\n")) {
+		source = binary.LittleEndian.AppendUint16(source, u)
+	}
+	// Undecoded, UTF-16 text is full of NUL bytes.
+	if _, err := NewTreeContext("example.go", source, TreeContextOptions{}); err != ErrorBinaryFile {
+		t.Errorf("NewTreeContext() on UTF-16 without DetectEncoding error = %v, want %v", err, ErrorBinaryFile)
+	}
+
+	tc, err := NewTreeContext("example.go", source, TreeContextOptions{DetectEncoding: true})
+	if err != nil {
+		t.Fatalf("NewTreeContext() error = %v", err)
+	}
+	found, err := tc.GrepWithOptions("naïve", GrepOptions{CommentsOnly: true})
+	if err != nil {
+		t.Fatalf("GrepWithOptions() error = %v", err)
+	}
+	if got := fmt.Sprint(mapKeysSorted(found)); got != "[2]" {
+		t.Errorf("GrepWithOptions() = %s, want [2]", got)
+	}
+	if got := tc.Metadata().Encoding; got != EncodingUTF16LE {
+		t.Errorf("Metadata().Encoding = %q, want %q", got, EncodingUTF16LE)
+	}
+}
@@ -0,0 +1,69 @@
+package grepast
+
+import (
+	"encoding/json"
+)
+
+// FormattedContext is the machine-readable form of the output of Format.
+type FormattedContext struct {
+	File            string          `json:"file"`              // Name of the file, as given to NewTreeContext.
+	Language        string          `json:"language"`          // Name of the language the file was parsed as.
+	LinesOfInterest []int           `json:"lines_of_interest"` // Sorted zero-based numbers of the lines of interest.
+	Shown           []LineRange     `json:"shown"`             // Runs of shown lines, in order.
+	Gaps            []ElidedRange   `json:"gaps"`              // Runs of lines left out, each rendered by Format as "⋮...".
+	Lines           []FormattedLine `json:"lines"`             // Shown lines, in order.
+}
+
+// LineRange is a run of consecutive lines.
+type LineRange struct {
+	Start int `json:"start"` // First zero-based line.
+	End   int `json:"end"`   // Last zero-based line.
+}
+
+// FormattedLine is a line shown by Format.
+type FormattedLine struct {
+	Line     int    `json:"line"`               // Zero-based line number.
+	Text     string `json:"text"`               // Source text of the line, without highlighting.
+	Interest bool   `json:"interest,omitempty"` // Whether the line is a line of interest.
+}
+
+// Formatted returns the lines Format shows, and the gaps between them, as plain data rather than
+// annotated text. Output budgets are honored as they are by Format.
+func (tc *TreeContext) Formatted() FormattedContext {
+	fc := FormattedContext{
+		File:            tc.filename,
+		Language:        tc.language,
+		LinesOfInterest: mapKeysSorted(tc.linesOfInterest),
+		Shown:           []LineRange{},
+		Gaps:            tc.ElidedRanges(),
+		Lines:           []FormattedLine{},
+	}
+	if fc.LinesOfInterest == nil {
+		fc.LinesOfInterest = []int{}
+	}
+	if fc.Gaps == nil {
+		fc.Gaps = []ElidedRange{}
+	}
+
+	count := tc.renderedLineCount()
+	for _, i := range mapKeysSorted(tc.renderedLines()) {
+		if i < 0 || i >= count {
+			continue
+		}
+		_, interest := tc.linesOfInterest[i]
+		fc.Lines = append(fc.Lines, FormattedLine{Line: i, Text: tc.lines[i], Interest: interest})
+
+		if n := len(fc.Shown); n > 0 && fc.Shown[n-1].End == i-1 {
+			fc.Shown[n-1].End = i
+		} else {
+			fc.Shown = append(fc.Shown, LineRange{Start: i, End: i})
+		}
+	}
+	return fc
+}
+
+// FormatJSON returns Formatted encoded as JSON, for tools that would otherwise parse the
+// annotated text of Format.
+func (tc *TreeContext) FormatJSON() ([]byte, error) {
+	return json.Marshal(tc.Formatted())
+}
@@ -0,0 +1,43 @@
+package grepast
+
+import (
+	"testing"
+)
+
+// TestTreeContext_FormatJSON tests the FormatJSON method of TreeContext.
+func TestTreeContext_FormatJSON(t *testing.T) {
+	source := []byte("package p\n\nfunc f() {\n\ta()\n\tb()\n\tc()\n\td()\n}\n")
+	tc, err := NewTreeContext("example.go", source, TreeContextOptions{ShowParentContext: true})
+	if err != nil {
+		t.Fatalf("NewTreeContext() error = %v", err)
+	}
+	tc.AddLinesOfInterest(map[int]struct{}{6: {}})
+	tc.AddContext()
+
+	got, err := tc.FormatJSON()
+	if err != nil {
+		t.Fatalf("FormatJSON() error = %v", err)
+	}
+	expected := `{"file":"example.go","language":"go","lines_of_interest":[6],` +
+		`"shown":[{"start":2,"end":7}],` +
+		`"gaps":[{"start":0,"end":1}],` +
+		`"lines":[{"line":2,"text":"func f() {"},{"line":3,"text":"\ta()"},{"line":4,"text":"\tb()"},` +
+		`{"line":5,"text":"\tc()"},{"line":6,"text":"\td()","interest":true},{"line":7,"text":"}"}]}`
+	if string(got) != expected {
+		t.Errorf("FormatJSON() =\n%s\nwant\n%s", got, expected)
+	}
+
+	// Nothing shown still encodes empty lists rather than nulls.
+	tc, err = NewTreeContext("example.go", source, TreeContextOptions{})
+	if err != nil {
+		t.Fatalf("NewTreeContext() error = %v", err)
+	}
+	got, err = tc.FormatJSON()
+	if err != nil {
+		t.Fatalf("FormatJSON() error = %v", err)
+	}
+	expected = `{"file":"example.go","language":"go","lines_of_interest":[],"shown":[],"gaps":[],"lines":[]}`
+	if string(got) != expected {
+		t.Errorf("FormatJSON() with nothing shown =\n%s\nwant\n%s", got, expected)
+	}
+}
@@ -0,0 +1,192 @@
+package grepast
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Scores awarded by FuzzyScore for subsequence matches.
+const (
+	fuzzyMatchScore       = 16 // Each pattern rune found in the text.
+	fuzzyConsecutiveBonus = 8  // Matched rune immediately follows the previous match.
+	fuzzyBoundaryBonus    = 12 // Matched rune starts a word (text start, after a separator, or a camelCase hump).
+	fuzzyCaseBonus        = 1  // Matched rune has the same case as the pattern rune.
+)
+
+// FuzzyScore reports how well pattern fuzzy-matches text. Patterns whose runes all appear in text
+// in order (ignoring case) match as a subsequence, scored fzf-style with bonuses for consecutive
+// runes and word boundaries and a penalty for gaps. Otherwise, texts within a small Levenshtein
+// distance of the pattern still match with a negative score, so subsequence matches always rank
+// first. The second return value is false if text does not match at all.
+func FuzzyScore(pattern, text string) (int, bool) {
+	if pattern == "" {
+		return 0, true
+	}
+
+	if score, ok := subsequenceScore([]rune(pattern), []rune(text)); ok {
+		return score, true
+	}
+
+	dist := levenshtein(strings.ToLower(pattern), strings.ToLower(text))
+	if dist <= max(1, len([]rune(pattern))/3) {
+		return -dist, true
+	}
+	return 0, false
+}
+
+// subsequenceScore scores pattern as a case-insensitive subsequence of text.
+func subsequenceScore(pattern, text []rune) (int, bool) {
+	score, _, ok := subsequenceMatch(pattern, text, 0)
+	if !ok {
+		return 0, false
+	}
+	// Prefer shorter texts among otherwise equal matches.
+	return score - (len(text) - len(pattern)), true
+}
+
+// subsequenceMatch greedily matches pattern as a case-insensitive subsequence of text[from:],
+// returning its score, without regard to the length of text, and the positions of the matched runes.
+func subsequenceMatch(pattern, text []rune, from int) (int, []int, bool) {
+	score := 0
+	p := 0
+	prev := -2
+	var positions []int
+	for t := from; t < len(text) && p < len(pattern); t++ {
+		if unicode.ToLower(text[t]) != unicode.ToLower(pattern[p]) {
+			continue
+		}
+
+		score += fuzzyMatchScore
+		if text[t] == pattern[p] {
+			score += fuzzyCaseBonus
+		}
+		if t == prev+1 {
+			score += fuzzyConsecutiveBonus
+		} else if prev >= 0 {
+			score -= t - prev - 1
+		}
+		if isWordBoundary(text, t) {
+			score += fuzzyBoundaryBonus
+		}
+		positions = append(positions, t)
+		prev = t
+		p++
+	}
+
+	if p < len(pattern) {
+		return 0, nil, false
+	}
+	return score, positions, true
+}
+
+// FuzzyLineMatch is a line whose text fuzzy-matches a pattern, with its score.
+type FuzzyLineMatch struct {
+	Line  int `json:"line"`  // Zero-based line number.
+	Score int `json:"score"` // Score of the best match within the line; higher is better.
+}
+
+// FuzzyLines returns the lines containing pattern as a case-insensitive subsequence, best match
+// first, then by position. Each line is scored by its best-scoring match, fzf-style as by
+// FuzzyScore, but regardless of the length of the line. An empty pattern matches no line.
+func (tc *TreeContext) FuzzyLines(pattern string) []FuzzyLineMatch {
+	var matches []FuzzyLineMatch
+	for i, line := range tc.lines {
+		if score, _, ok := fuzzyLineMatch([]rune(pattern), line); ok {
+			matches = append(matches, FuzzyLineMatch{Line: i, Score: score})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Score > matches[j].Score
+	})
+	return matches
+}
+
+// matchFuzzy finds the lines containing pattern as a case-insensitive subsequence, with a span
+// per run of consecutive matched runes.
+func (tc *TreeContext) matchFuzzy(pattern string, p int) map[int][]matchSpan {
+	matches := make(map[int][]matchSpan)
+	for i, line := range tc.lines {
+		if _, spans, ok := fuzzyLineMatch([]rune(pattern), line); ok {
+			for _, span := range spans {
+				matches[i] = append(matches[i], matchSpan{start: span[0], end: span[1], pattern: p})
+			}
+		}
+	}
+	return matches
+}
+
+// fuzzyLineMatch finds the best-scoring match of pattern as a subsequence of line, trying every
+// rune matching its first rune as the start. It returns the score and the byte ranges of the
+// runs of consecutive matched runes, or false if line does not contain pattern.
+func fuzzyLineMatch(pattern []rune, line string) (int, [][2]int, bool) {
+	if len(pattern) == 0 {
+		return 0, nil, false
+	}
+	text := []rune(line)
+	best, bestScore := []int(nil), 0
+	for from := range text {
+		if unicode.ToLower(text[from]) != unicode.ToLower(pattern[0]) {
+			continue
+		}
+		score, positions, ok := subsequenceMatch(pattern, text, from)
+		if !ok {
+			break // No later start can match either.
+		}
+		if best == nil || score > bestScore {
+			best, bestScore = positions, score
+		}
+	}
+	if best == nil {
+		return 0, nil, false
+	}
+
+	// Convert rune positions to byte ranges, merging consecutive runes.
+	offsets := make([]int, len(text)+1)
+	for i, r := range text {
+		offsets[i+1] = offsets[i] + len(string(r))
+	}
+	var spans [][2]int
+	for _, pos := range best {
+		if n := len(spans); n > 0 && spans[n-1][1] == offsets[pos] {
+			spans[n-1][1] = offsets[pos+1]
+		} else {
+			spans = append(spans, [2]int{offsets[pos], offsets[pos+1]})
+		}
+	}
+	return bestScore, spans, true
+}
+
+// isWordBoundary reports whether text[i] starts a word.
+func isWordBoundary(text []rune, i int) bool {
+	if i == 0 {
+		return true
+	}
+	prev, cur := text[i-1], text[i]
+	if !unicode.IsLetter(prev) && !unicode.IsDigit(prev) {
+		return true
+	}
+	return unicode.IsLower(prev) && unicode.IsUpper(cur)
+}
+
+// levenshtein returns the edit distance between a and b.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
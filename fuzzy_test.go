@@ -0,0 +1,101 @@
+package grepast
+
+import (
+	"fmt"
+	"testing"
+)
+
+// TestFuzzyScore tests the FuzzyScore function.
+func TestFuzzyScore(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		text    string
+		wantOK  bool
+	}{
+		{name: "Exact", pattern: "httpHandler", text: "httpHandler", wantOK: true},
+		{name: "Subsequence", pattern: "httpHndlr", text: "httpHandler", wantOK: true},
+		{name: "Case-insensitive subsequence", pattern: "hh", text: "HttpHandler", wantOK: true},
+		{name: "Typo within edit distance", pattern: "hnadler", text: "handler", wantOK: true},
+		{name: "Unrelated", pattern: "server", text: "handler", wantOK: false},
+		{name: "Empty pattern", pattern: "", text: "anything", wantOK: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ok := FuzzyScore(tt.pattern, tt.text)
+			if ok != tt.wantOK {
+				t.Errorf("FuzzyScore(%q, %q) ok = %v, want %v", tt.pattern, tt.text, ok, tt.wantOK)
+			}
+		})
+	}
+}
+
+// TestFuzzyScoreRanking tests that better matches receive higher scores.
+func TestFuzzyScoreRanking(t *testing.T) {
+	tests := []struct {
+		pattern string
+		better  string
+		worse   string
+	}{
+		{pattern: "httpHndlr", better: "httpHandler", worse: "httpsHeaderNodeListener"},
+		{pattern: "parse", better: "parse", worse: "parseAndGrep"},
+		{pattern: "tc", better: "TreeContext", worse: "fetch"},
+		{pattern: "handler", better: "handlers", worse: "hnadler"},
+	}
+
+	for _, tt := range tests {
+		better, ok := FuzzyScore(tt.pattern, tt.better)
+		if !ok {
+			t.Fatalf("FuzzyScore(%q, %q) did not match", tt.pattern, tt.better)
+		}
+		worse, ok := FuzzyScore(tt.pattern, tt.worse)
+		if !ok {
+			t.Fatalf("FuzzyScore(%q, %q) did not match", tt.pattern, tt.worse)
+		}
+		if better <= worse {
+			t.Errorf("FuzzyScore(%q): %q scored %d, expected more than %q with %d", tt.pattern, tt.better, better, tt.worse, worse)
+		}
+	}
+}
+
+// TestTreeContext_FuzzyLines tests ranking lines by fuzzy match and fuzzy grepping.
+func TestTreeContext_FuzzyLines(t *testing.T) {
+	sourceCode := []byte(`package main
+
+func main() {
+	h := newHTTPHandler()
+	hostname := "handler"
+	serve(h)
+}
+`)
+
+	tc, err := NewTreeContext("example.go", sourceCode, TreeContextOptions{Color: true})
+	if err != nil {
+		t.Fatalf("NewTreeContext() error = %v", err)
+	}
+
+	// Line 4 matches at the start of a word, so it outranks the match within a name on line 3.
+	var lines []int
+	for _, m := range tc.FuzzyLines("hndlr") {
+		lines = append(lines, m.Line)
+	}
+	if fmt.Sprint(lines) != "[4 3]" {
+		t.Errorf("FuzzyLines() lines = %v, want [4 3]", lines)
+	}
+	if got := tc.FuzzyLines(""); len(got) != 0 {
+		t.Errorf("FuzzyLines(\"\") = %v, want none", got)
+	}
+
+	found, err := tc.GrepWithOptions("srvh", GrepOptions{Fuzzy: true})
+	if err != nil {
+		t.Fatalf("GrepWithOptions() error = %v", err)
+	}
+	if got := mapKeysSorted(found); fmt.Sprint(got) != "[5]" {
+		t.Errorf("GrepWithOptions() = %v, want [5]", got)
+	}
+	expected := "\t\033[1;31ms\033[0me\033[1;31mrv\033[0me(\033[1;31mh\033[0m)"
+	if got := tc.outputLines[5]; got != expected {
+		t.Errorf("highlighted line = %q, want %q", got, expected)
+	}
+}
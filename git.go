@@ -0,0 +1,275 @@
+package grepast
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// GitFilter restricts a tree search to the files, and optionally the lines, changed by the git
+// commits matching its criteria. The zero value matches everything.
+type GitFilter struct {
+	Since  string // Only consider commits newer than this date, in any form git log --since accepts, e.g. "2weeks" or "2024-01-31".
+	Author string // Only consider commits whose author matches this regular expression, as with git log --author.
+
+	// BlameLines also drops the lines of interest that git blame does not attribute to a
+	// matching commit, so only matches on recently changed or the author's own lines remain.
+	BlameLines bool
+
+	// Diff, if set, replaces the commit criteria with the changes git diff shows between the
+	// work tree and the merge base of this revision and HEAD, e.g. "main", so that only the lines
+	// a branch added or changed, committed or not, remain as lines of interest. Untracked files
+	// are not part of the diff. See DefaultDiffBase.
+	Diff string
+
+	// Staged, like Diff, replaces the commit criteria with a diff: that of the changes staged in
+	// the index, against HEAD or the merge base of Diff if set. Files are then read from the
+	// index rather than the work tree, as they would be committed.
+	Staged bool
+}
+
+// Enabled reports whether f restricts anything.
+func (f GitFilter) Enabled() bool {
+	return f.Since != "" || f.Author != "" || f.Diff != "" || f.Staged
+}
+
+// filtersLines reports whether f restricts the lines of interest of the files it keeps.
+func (f GitFilter) filtersLines() bool {
+	return f.Diff != "" || f.Staged || f.BlameLines && f.Enabled()
+}
+
+// GitChanges holds the commits matching a GitFilter in a repository, and the files they changed,
+// or the changes of its diff.
+type GitChanges struct {
+	dir     string                      // Directory git is run in; file paths are relative to it.
+	commits map[string]struct{}         // Hashes of the matching commits.
+	files   map[string]int              // Number of commits changing each file, by slash-separated path relative to dir; 1 for a diff.
+	lines   map[string]map[int]struct{} // Zero-based lines added or changed in each file by the diff, if any.
+	staged  bool                        // Whether files are read from the index.
+}
+
+// Changes runs git log in dir, which must lie within a git work tree, and returns the commits
+// matching f along with the files they changed under dir. If f has a Diff or is Staged, it runs
+// git diff instead, and returns the files it changes under dir along with their changed lines.
+func (f GitFilter) Changes(dir string) (*GitChanges, error) {
+	if f.Diff != "" || f.Staged {
+		var revs []string
+		if f.Staged {
+			revs = append(revs, "--cached")
+		}
+		if f.Diff != "" {
+			revs = append(revs, "--merge-base", f.Diff)
+		}
+		c, err := diffChanges(dir, revs...)
+		if err != nil {
+			return nil, err
+		}
+		c.staged = f.Staged
+		return c, nil
+	}
+	args := []string{"log", "--format=%x00%H", "--name-only", "--relative", "--no-renames"}
+	if f.Since != "" {
+		args = append(args, "--since="+f.Since)
+	}
+	if f.Author != "" {
+		args = append(args, "--author="+f.Author)
+	}
+	out, err := runGit(dir, append(args, "--", ".")...)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &GitChanges{
+		dir:     dir,
+		commits: make(map[string]struct{}),
+		files:   make(map[string]int),
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+		case line[0] == 0:
+			c.commits[line[1:]] = struct{}{}
+		default:
+			c.files[line]++
+		}
+	}
+	return c, scanner.Err()
+}
+
+// Contains reports whether a matching commit changed the file at rel, relative to the directory
+// the changes were read in.
+func (c *GitChanges) Contains(rel string) bool {
+	_, ok := c.files[filepath.ToSlash(rel)]
+	return ok
+}
+
+// Commits returns the number of matching commits that changed the file at rel, relative to the
+// directory the changes were read in. For the changes of a diff, it is 1 for each changed file.
+func (c *GitChanges) Commits(rel string) int {
+	return c.files[filepath.ToSlash(rel)]
+}
+
+// Lines runs git blame on the file at rel, relative to the directory the changes were read in,
+// and returns the zero-based lines last changed by a matching commit. Uncommitted lines never match.
+// For the changes of a diff, it returns the lines the diff adds or changes in the file instead.
+func (c *GitChanges) Lines(rel string) (map[int]struct{}, error) {
+	if c.lines != nil {
+		lines := make(map[int]struct{}, len(c.lines[filepath.ToSlash(rel)]))
+		for line := range c.lines[filepath.ToSlash(rel)] {
+			lines[line] = struct{}{}
+		}
+		return lines, nil
+	}
+	out, err := runGit(c.dir, "blame", "--porcelain", "--", filepath.ToSlash(rel))
+	if err != nil {
+		return nil, err
+	}
+
+	lines := make(map[int]struct{})
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	scanner.Buffer(nil, len(out)+1)
+	for scanner.Scan() {
+		// Each line of the file is introduced by "<hash> <original line> <final line> [<group size>]",
+		// followed by commit details the first time a commit is seen, then the tab-prefixed line itself.
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || !isCommitHash(fields[0]) {
+			continue
+		}
+		if _, ok := c.commits[fields[0]]; !ok {
+			continue
+		}
+		final, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("git blame %s: bad line number %q", rel, fields[2])
+		}
+		lines[final-1] = struct{}{}
+	}
+	return lines, scanner.Err()
+}
+
+// readFile returns the contents of the file at path, whose path relative to the directory the
+// changes were read in is rel: from the index if they are staged changes, otherwise from disk.
+// It may be called on nil changes.
+func (c *GitChanges) readFile(path, rel string) ([]byte, error) {
+	if c == nil || !c.staged {
+		return os.ReadFile(path)
+	}
+	return runGit(c.dir, "cat-file", "blob", ":./"+filepath.ToSlash(rel))
+}
+
+// revisionFile returns the contents of the file at rel, relative to dir, in the git commit rev.
+func revisionFile(dir, rev, rel string) ([]byte, error) {
+	return runGit(dir, "cat-file", "blob", rev+":./"+filepath.ToSlash(rel))
+}
+
+// diffHunk matches the header of a hunk of a unified diff, capturing the first line and line
+// count of its new side; a missing count means one line.
+var diffHunk = regexp.MustCompile(`^@@ -\d+(?:,\d+)? \+(\d+)(?:,(\d+))? @@`)
+
+// diffChanges runs git diff in dir with revs, such as "--cached", selecting what to compare,
+// and returns the files it changes under dir with the lines it adds or changes in them.
+func diffChanges(dir string, revs ...string) (*GitChanges, error) {
+	args := append([]string{"diff"}, revs...)
+	out, err := runGit(dir, append(args, "--relative", "--no-renames", "--src-prefix=a/", "--dst-prefix=b/",
+		"--no-color", "--no-ext-diff", "-U0", "--", ".")...)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &GitChanges{
+		dir:   dir,
+		files: make(map[string]int),
+		lines: make(map[string]map[int]struct{}),
+	}
+	var file string  // File of the hunks being read; "" for deleted files.
+	header := false  // Whether the lines read are the header of a file's diff, before its hunks.
+	oldPath := false // Whether the header's "---" line was just read.
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	scanner.Buffer(nil, len(out)+1)
+	for scanner.Scan() {
+		line := scanner.Text()
+		// Lines of hunks may look like headers, e.g. an added line starting with "++ ", so
+		// headers are only recognized between "diff --git" and the first hunk.
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			file, header, oldPath = "", true, false
+		case header && strings.HasPrefix(line, "--- "):
+			oldPath = true
+		case header && oldPath && strings.HasPrefix(line, "+++ "):
+			oldPath = false
+			if file = diffPath(strings.TrimPrefix(line, "+++ ")); file == "/dev/null" {
+				file = ""
+				continue
+			}
+			c.files[file] = 1
+			c.lines[file] = make(map[int]struct{})
+		case strings.HasPrefix(line, "@@ "):
+			header = false
+			if file == "" {
+				continue
+			}
+			m := diffHunk.FindStringSubmatch(line)
+			if m == nil {
+				return nil, fmt.Errorf("git diff: bad hunk header %q", line)
+			}
+			start, _ := strconv.Atoi(m[1])
+			count := 1
+			if m[2] != "" {
+				count, _ = strconv.Atoi(m[2])
+			}
+			for i := range count {
+				c.lines[file][start-1+i] = struct{}{}
+			}
+		}
+	}
+	return c, scanner.Err()
+}
+
+// DefaultDiffBase returns the revision a Diff compares against by default in the git work tree
+// enclosing dir: the first of main, master, origin/main, and origin/master that exists.
+func DefaultDiffBase(dir string) (string, error) {
+	for _, rev := range []string{"main", "master", "origin/main", "origin/master"} {
+		if _, err := runGit(dir, "rev-parse", "--verify", "--quiet", rev+"^{commit}"); err == nil {
+			return rev, nil
+		}
+	}
+	return "", fmt.Errorf("no main or master branch to diff against")
+}
+
+// isCommitHash reports whether s is a full SHA-1 or SHA-256 commit hash.
+func isCommitHash(s string) bool {
+	if len(s) != 40 && len(s) != 64 {
+		return false
+	}
+	for _, r := range s {
+		if !strings.ContainsRune("0123456789abcdef", r) {
+			return false
+		}
+	}
+	return true
+}
+
+// runGit runs git with args in dir and returns its standard output, or an error including what
+// git printed to standard error.
+func runGit(dir string, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", append([]string{"-c", "core.quotePath=false"}, args...)...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("git %s: %s", args[0], msg)
+		}
+		return nil, fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return out, nil
+}
@@ -0,0 +1,299 @@
+package grepast
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// gitCommit writes files into the git repository at dir and commits them as author at date.
+func gitCommit(t *testing.T, dir, author string, date time.Time, files map[string]string) {
+	t.Helper()
+	writeTree(t, dir, files)
+	stamp := date.Format(time.RFC3339)
+	for _, args := range [][]string{{"add", "-A"}, {"commit", "-q", "-m", "change"}} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"GIT_CONFIG_GLOBAL="+os.DevNull, "GIT_CONFIG_NOSYSTEM=1",
+			"GIT_AUTHOR_NAME="+author, "GIT_AUTHOR_EMAIL="+author+"@example.com", "GIT_AUTHOR_DATE="+stamp,
+			"GIT_COMMITTER_NAME="+author, "GIT_COMMITTER_EMAIL="+author+"@example.com", "GIT_COMMITTER_DATE="+stamp,
+		)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+}
+
+// matchedLines returns the lines of the matches of res, each once and in order.
+func matchedLines(res *FileResult) []int {
+	lines := make(map[int]struct{})
+	for _, m := range res.Matches {
+		lines[m.Line] = struct{}{}
+	}
+	return mapKeysSorted(lines)
+}
+
+// TestSearchTree_Git tests restricting a tree search by commit date and author.
+func TestSearchTree_Git(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	root := t.TempDir()
+	if out, err := exec.Command("git", "init", "-q", root).CombinedOutput(); err != nil {
+		t.Fatalf("git init: %v\n%s", err, out)
+	}
+
+	long := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	gitCommit(t, root, "bob", long, map[string]string{
+		"old.go":    "package p\n\nfunc old() {\n\tneedle()\n}\n",
+		"shared.go": "package p\n\nfunc a() {\n\tneedle()\n}\n",
+	})
+	gitCommit(t, root, "alice", time.Now(), map[string]string{
+		"shared.go": "package p\n\nfunc a() {\n\tneedle()\n}\n\nfunc b() {\n\tneedle()\n}\n",
+	})
+	// Uncommitted files are never selected.
+	writeTree(t, root, map[string]string{"new.go": "package p\n\n// needle\n"})
+
+	tests := []struct {
+		name     string
+		filter   GitFilter
+		expected string
+	}{
+		{"author", GitFilter{Author: "alice"}, "[shared.go:[3 7]]"},
+		{"since", GitFilter{Since: "2021-01-01"}, "[shared.go:[3 7]]"},
+		{"since before all", GitFilter{Since: "2019-01-01"}, "[old.go:[3] shared.go:[3 7]]"},
+		{"blame", GitFilter{Author: "alice", BlameLines: true}, "[shared.go:[7]]"},
+		{"nobody", GitFilter{Author: "carol"}, "[]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			err := SearchTree(root, TreeSearchOptions{
+				Search: SearchOptions{Patterns: []string{"needle"}},
+				Git:    tt.filter,
+			}, func(r TreeResult) error {
+				if r.Err != nil {
+					t.Errorf("%s: Err = %v", r.Rel, r.Err)
+					return nil
+				}
+				got = append(got, fmt.Sprintf("%s:%v", filepath.ToSlash(r.Rel), r.Result.LinesOfInterest))
+				// Matches on lines blamed on other commits are dropped with them.
+				if lines := matchedLines(r.Result); fmt.Sprint(lines) != fmt.Sprint(r.Result.LinesOfInterest) {
+					t.Errorf("%s: matches on lines %v, want %v", r.Rel, lines, r.Result.LinesOfInterest)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("SearchTree() error = %v", err)
+			}
+			if fmt.Sprint(got) != tt.expected {
+				t.Errorf("SearchTree() = %v, want %s", got, tt.expected)
+			}
+		})
+	}
+
+	// Outside a work tree the filter fails rather than matching nothing.
+	err := SearchTree(t.TempDir(), TreeSearchOptions{Git: GitFilter{Author: "alice"}}, func(TreeResult) error { return nil })
+	if err == nil {
+		t.Errorf("SearchTree() outside a work tree: error = nil, want an error")
+	}
+}
+
+// TestSearchTree_GitDiff tests restricting a tree search to the lines a branch changed since it
+// forked from main, including uncommitted changes.
+func TestSearchTree_GitDiff(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	root := t.TempDir()
+	git := func(args ...string) {
+		t.Helper()
+		if out, err := exec.Command("git", append([]string{"-C", root}, args...)...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	git("init", "-q", "-b", "main")
+
+	now := time.Now()
+	gitCommit(t, root, "bob", now, map[string]string{
+		"a.go": "package p\n\nfunc a() {\n\tneedle()\n}\n",
+		"b.go": "package p\n\nfunc b() {\n\tneedle()\n}\n",
+	})
+	git("checkout", "-q", "-b", "feature")
+	gitCommit(t, root, "alice", now, map[string]string{
+		"a.go": "package p\n\nfunc a() {\n\tneedle()\n}\n\nfunc c() {\n\tneedle()\n}\n",
+	})
+	// Changes on main since the branch forked are not part of its diff.
+	git("checkout", "-q", "main")
+	gitCommit(t, root, "bob", now, map[string]string{"b.go": "package p\n\nfunc b() {\n\tneedle()\n\tneedle()\n}\n"})
+	git("checkout", "-q", "feature")
+	writeTree(t, root, map[string]string{"b.go": "package p\n\nfunc b() {\n\tneedle(1)\n}\n"})
+
+	base, err := DefaultDiffBase(root)
+	if err != nil || base != "main" {
+		t.Fatalf("DefaultDiffBase() = %q, %v, want main", base, err)
+	}
+	var got []string
+	err = SearchTree(root, TreeSearchOptions{
+		Search: SearchOptions{Patterns: []string{"needle"}},
+		Git:    GitFilter{Diff: base},
+	}, func(r TreeResult) error {
+		if r.Err != nil {
+			t.Errorf("%s: Err = %v", r.Rel, r.Err)
+			return nil
+		}
+		got = append(got, fmt.Sprintf("%s:%v", filepath.ToSlash(r.Rel), r.Result.LinesOfInterest))
+		// Matches on the lines outside the diff are dropped with them.
+		if lines := matchedLines(r.Result); fmt.Sprint(lines) != fmt.Sprint(r.Result.LinesOfInterest) {
+			t.Errorf("%s: matches on lines %v, want %v", r.Rel, lines, r.Result.LinesOfInterest)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("SearchTree() error = %v", err)
+	}
+	if expected := "[a.go:[7] b.go:[3]]"; fmt.Sprint(got) != expected {
+		t.Errorf("SearchTree() = %v, want %s", got, expected)
+	}
+}
+
+// TestGitFilter_ChangesDiffPaths tests reading the diff of files whose names git ends with a tab
+// or quotes, and of added lines that look like file headers.
+func TestGitFilter_ChangesDiffPaths(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	root := t.TempDir()
+	if out, err := exec.Command("git", "init", "-q", root).CombinedOutput(); err != nil {
+		t.Fatalf("git init: %v\n%s", err, out)
+	}
+	files := []string{"a b.go", "é.go", `q"uote.go`}
+	committed := make(map[string]string)
+	for _, rel := range files {
+		committed[rel] = "package p\n"
+	}
+	gitCommit(t, root, "bob", time.Now(), committed)
+	changed := make(map[string]string)
+	for _, rel := range files {
+		// With -U0 the added line reads "+++ x", like the header of a file called x.
+		changed[rel] = "package p\n\n++ x\n"
+	}
+	writeTree(t, root, changed)
+
+	c, err := GitFilter{Diff: "HEAD"}.Changes(root)
+	if err != nil {
+		t.Fatalf("Changes() error = %v", err)
+	}
+	for _, rel := range files {
+		if !c.Contains(rel) {
+			t.Errorf("Contains(%q) = false, want true", rel)
+		}
+		lines, err := c.Lines(rel)
+		if err != nil {
+			t.Fatalf("Lines(%q) error = %v", rel, err)
+		}
+		if got := fmt.Sprint(mapKeysSorted(lines)); got != "[1 2]" {
+			t.Errorf("Lines(%q) = %s, want [1 2]", rel, got)
+		}
+	}
+	if c.Contains("x") {
+		t.Error(`Contains("x") = true, want false`)
+	}
+}
+
+// TestSearchTree_GitStaged tests searching the changes staged in the index, as read from the
+// index, leaving out those only in the work tree.
+func TestSearchTree_GitStaged(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	root := t.TempDir()
+	if out, err := exec.Command("git", "init", "-q", root).CombinedOutput(); err != nil {
+		t.Fatalf("git init: %v\n%s", err, out)
+	}
+	gitCommit(t, root, "bob", time.Now(), map[string]string{
+		"a.go": "package p\n\nfunc a() {\n\tneedle()\n}\n",
+		"c.go": "package p\n",
+	})
+	writeTree(t, root, map[string]string{"a.go": "package p\n\nfunc a() {\n\tneedle()\n}\n\nfunc b() {\n\tneedle()\n}\n"})
+	if out, err := exec.Command("git", "-C", root, "add", "a.go").CombinedOutput(); err != nil {
+		t.Fatalf("git add: %v\n%s", err, out)
+	}
+	// Unstaged changes are neither searched nor read.
+	writeTree(t, root, map[string]string{
+		"a.go": "package p\n\n// needle\n\nfunc a() {\n\tneedle()\n}\n\nfunc b() {\n\tneedle()\n}\n",
+		"c.go": "package p\n\n// needle\n",
+	})
+
+	var got []string
+	err := SearchTree(root, TreeSearchOptions{
+		Search: SearchOptions{Patterns: []string{"needle"}},
+		Git:    GitFilter{Staged: true},
+	}, func(r TreeResult) error {
+		if r.Err != nil {
+			t.Errorf("%s: Err = %v", r.Rel, r.Err)
+			return nil
+		}
+		got = append(got, fmt.Sprintf("%s:%v", filepath.ToSlash(r.Rel), r.Result.LinesOfInterest))
+		// Matches outside the staged hunks are dropped with their lines.
+		if lines := matchedLines(r.Result); fmt.Sprint(lines) != fmt.Sprint(r.Result.LinesOfInterest) {
+			t.Errorf("%s: matches on lines %v, want %v", r.Rel, lines, r.Result.LinesOfInterest)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("SearchTree() error = %v", err)
+	}
+	if expected := "[a.go:[7]]"; fmt.Sprint(got) != expected {
+		t.Errorf("SearchTree() = %v, want %s", got, expected)
+	}
+}
+
+// TestSearchTree_Revision tests searching the files of an earlier commit, read from the
+// repository, with the walker's filters applied to them.
+func TestSearchTree_Revision(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	root := t.TempDir()
+	if out, err := exec.Command("git", "init", "-q", root).CombinedOutput(); err != nil {
+		t.Fatalf("git init: %v\n%s", err, out)
+	}
+	gitCommit(t, root, "bob", time.Now(), map[string]string{
+		"a.go":        "package p\n\nfunc a() {\n\tneedle()\n}\n",
+		"sub/b.go":    "package sub\n\nfunc b() {\n\tneedle()\n}\n",
+		"vendor/c.go": "package c\n\n// needle\n",
+	})
+	gitCommit(t, root, "bob", time.Now(), map[string]string{"a.go": "package p\n"})
+	if err := os.RemoveAll(filepath.Join(root, "sub")); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	err := SearchTree(root, TreeSearchOptions{
+		Search: SearchOptions{Patterns: []string{"needle"}},
+		Walker: WalkerOptions{Revision: "HEAD~1", Exclude: []string{"vendor/"}},
+	}, func(r TreeResult) error {
+		if r.Err != nil {
+			t.Errorf("%s: Err = %v", r.Rel, r.Err)
+			return nil
+		}
+		got = append(got, fmt.Sprintf("%s:%v", filepath.ToSlash(r.Rel), r.Result.LinesOfInterest))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("SearchTree() error = %v", err)
+	}
+	if expected := "[a.go:[3] sub/b.go:[3]]"; fmt.Sprint(got) != expected {
+		t.Errorf("SearchTree() = %v, want %s", got, expected)
+	}
+
+	err = SearchTree(root, TreeSearchOptions{Walker: WalkerOptions{Revision: "nonexistent"}}, func(TreeResult) error { return nil })
+	if err == nil {
+		t.Error("SearchTree() of a missing revision: error = nil, want an error")
+	}
+}
@@ -0,0 +1,141 @@
+package grepast
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	goignore "github.com/cyber-nic/go-gitignore"
+)
+
+// GitIgnoreFileName is the name of the ignore files git reads in every directory of a work tree.
+const GitIgnoreFileName = ".gitignore"
+
+// ignoreLayer holds the patterns of one ignore file, which apply to the paths below the directory
+// holding it. Paths are matched relative to that directory, so that anchored patterns such as
+// "/build" only match at its top.
+type ignoreLayer struct {
+	dir     string              // Slash path of the directory relative to the walk root, ending in a slash, or "" for the root and directories above it.
+	prefix  string              // Slash path of the walk root relative to the directory, for directories above the root.
+	ignore  *goignore.GitIgnore // The patterns of the file.
+	include *goignore.GitIgnore // The patterns after one ignoring every path, so that only negated patterns can leave a path out.
+}
+
+// newIgnoreLayer compiles the lines of an ignore file in the directory dir, or above the walk
+// root at prefix.
+func newIgnoreLayer(lines []string, dir, prefix string) *ignoreLayer {
+	return &ignoreLayer{
+		dir:     dir,
+		prefix:  prefix,
+		ignore:  goignore.CompileIgnoreLines(lines...),
+		include: goignore.CompileIgnoreLines(append([]string{"*"}, lines...)...),
+	}
+}
+
+// readIgnoreLayer reads the ignore file at file, returning nil if there is none.
+func readIgnoreLayer(file, dir, prefix string) (*ignoreLayer, error) {
+	lines, err := readIgnoreLines(file)
+	if lines == nil || err != nil {
+		return nil, err
+	}
+	return newIgnoreLayer(lines, dir, prefix), nil
+}
+
+// readIgnoreLines returns the lines of the ignore file at file, or nil if there is none.
+func readIgnoreLines(file string) ([]string, error) {
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(string(data), "\n"), nil
+}
+
+// match reports whether the layer ignores the path rel, relative to the walk root, and whether it
+// decides it at all: a path matching none of its patterns is left to the layers below it.
+func (l *ignoreLayer) match(rel string, isDir bool) (ignored, decided bool) {
+	p := path.Join(l.prefix, strings.TrimPrefix(rel, l.dir))
+	if isDir {
+		p += "/"
+	}
+	if l.ignore.MatchesPath(p) {
+		return true, true
+	}
+	return false, !l.include.MatchesPath(p)
+}
+
+// ignored reports whether the layers ignore the path rel. Later layers take precedence, as deeper
+// .gitignore files do in git.
+func ignored(layers []*ignoreLayer, rel string, isDir bool) bool {
+	rel = filepath.ToSlash(rel)
+	for i := len(layers) - 1; i >= 0; i-- {
+		if ignored, decided := layers[i].match(rel, isDir); decided {
+			return ignored
+		}
+	}
+	return false
+}
+
+// ignoredPath reports whether the layers ignore the file at rel or one of the directories
+// leading to it, as a walk would have skipped it then.
+func ignoredPath(layers []*ignoreLayer, rel string) bool {
+	rel = filepath.ToSlash(rel)
+	for i := range len(rel) {
+		if rel[i] == '/' && ignored(layers, rel[:i], true) {
+			return true
+		}
+	}
+	return ignored(layers, rel, false)
+}
+
+// gitIgnoreLayers returns the layers of the git work tree enclosing root that apply above it:
+// .git/info/exclude, then the .gitignore files of the directories from the top of the work tree
+// down to root's parent. It returns none if root is not in a work tree.
+func gitIgnoreLayers(root string) ([]*ignoreLayer, error) {
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	var dirs []string // From root's parent up to the top of the work tree.
+	top := abs
+	for {
+		if _, err := os.Lstat(filepath.Join(top, ".git")); err == nil {
+			break
+		}
+		parent := filepath.Dir(top)
+		if parent == top {
+			return nil, nil
+		}
+		top = parent
+		dirs = append(dirs, top)
+	}
+
+	var layers []*ignoreLayer
+	prefix := func(dir string) string {
+		rel, _ := filepath.Rel(dir, abs)
+		return filepath.ToSlash(rel)
+	}
+	// .git is a file rather than a directory in linked work trees, whose exclude file is not read.
+	if info, err := os.Stat(filepath.Join(top, ".git")); err == nil && info.IsDir() {
+		layer, err := readIgnoreLayer(filepath.Join(top, ".git", "info", "exclude"), "", prefix(top))
+		if err != nil {
+			return nil, err
+		}
+		if layer != nil {
+			layers = append(layers, layer)
+		}
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		layer, err := readIgnoreLayer(filepath.Join(dirs[i], GitIgnoreFileName), "", prefix(dirs[i]))
+		if err != nil {
+			return nil, err
+		}
+		if layer != nil {
+			layers = append(layers, layer)
+		}
+	}
+	return layers, nil
+}
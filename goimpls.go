@@ -0,0 +1,174 @@
+package grepast
+
+import (
+	"sort"
+
+	sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+// GoInterface is an interface type declared in Go source.
+type GoInterface struct {
+	Name      string   // Name of the interface type.
+	Methods   []string // Names of the methods declared directly in the interface.
+	Embedded  []string // Names of embedded interfaces.
+	StartLine int      // Zero-based line on which the type spec starts.
+	EndLine   int      // Zero-based line on which the type spec ends.
+}
+
+// GoMethod is a method declared in Go source.
+type GoMethod struct {
+	Receiver  string // Name of the receiver's base type, without pointer or type arguments.
+	Name      string // Name of the method.
+	StartLine int    // Zero-based line on which the method declaration starts.
+	EndLine   int    // Zero-based line on which the method declaration ends.
+}
+
+// GoInterfaces returns the interface types declared in Go source, ordered by position.
+func (tc *TreeContext) GoInterfaces() []GoInterface {
+	var ifaces []GoInterface
+	for _, nodes := range tc.nodes {
+		for _, node := range nodes {
+			if node.Kind() != "type_spec" {
+				continue
+			}
+			name := node.ChildByFieldName("name")
+			typ := node.ChildByFieldName("type")
+			if name == nil || typ == nil || typ.Kind() != "interface_type" {
+				continue
+			}
+
+			iface := GoInterface{
+				Name:      name.Utf8Text(tc.source),
+				StartLine: int(node.StartPosition().Row),
+				EndLine:   int(node.EndPosition().Row),
+			}
+			for i := uint(0); i < typ.NamedChildCount(); i++ {
+				elem := typ.NamedChild(i)
+				switch elem.Kind() {
+				case "method_elem":
+					if n := elem.ChildByFieldName("name"); n != nil {
+						iface.Methods = append(iface.Methods, n.Utf8Text(tc.source))
+					}
+				case "type_elem":
+					if n := firstDescendantOfKind(elem, "type_identifier"); n != nil {
+						iface.Embedded = append(iface.Embedded, n.Utf8Text(tc.source))
+					}
+				}
+			}
+			ifaces = append(ifaces, iface)
+		}
+	}
+	return ifaces
+}
+
+// GoMethods returns the methods declared in Go source, ordered by position.
+func (tc *TreeContext) GoMethods() []GoMethod {
+	var methods []GoMethod
+	for _, nodes := range tc.nodes {
+		for _, node := range nodes {
+			if node.Kind() != "method_declaration" {
+				continue
+			}
+			name := node.ChildByFieldName("name")
+			receiver := node.ChildByFieldName("receiver")
+			if name == nil || receiver == nil {
+				continue
+			}
+			recvType := firstDescendantOfKind(receiver, "type_identifier")
+			if recvType == nil {
+				continue
+			}
+			methods = append(methods, GoMethod{
+				Receiver:  recvType.Utf8Text(tc.source),
+				Name:      name.Utf8Text(tc.source),
+				StartLine: int(node.StartPosition().Row),
+				EndLine:   int(node.EndPosition().Row),
+			})
+		}
+	}
+	return methods
+}
+
+// GoInterfaceMethodSet returns the sorted method names of the named interface, following
+// interfaces it embeds when they are declared among ifaces. It returns nil if no interface
+// with that name is declared.
+func GoInterfaceMethodSet(name string, ifaces []GoInterface) []string {
+	byName := make(map[string][]GoInterface)
+	for _, iface := range ifaces {
+		byName[iface.Name] = append(byName[iface.Name], iface)
+	}
+	if _, ok := byName[name]; !ok {
+		return nil
+	}
+
+	set := make(map[string]struct{})
+	visited := make(map[string]struct{})
+	var collect func(name string)
+	collect = func(name string) {
+		if _, done := visited[name]; done {
+			return
+		}
+		visited[name] = struct{}{}
+		for _, iface := range byName[name] {
+			for _, m := range iface.Methods {
+				set[m] = struct{}{}
+			}
+			for _, embedded := range iface.Embedded {
+				collect(embedded)
+			}
+		}
+	}
+	collect(name)
+
+	methodSet := make([]string, 0, len(set))
+	for m := range set {
+		methodSet = append(methodSet, m)
+	}
+	sort.Strings(methodSet)
+	return methodSet
+}
+
+// GoImplementations returns, for each receiver type whose methods textually include every name in
+// methodSet, the methods that satisfy it. Signatures are not compared. An empty method set matches nothing.
+func GoImplementations(methodSet []string, methods []GoMethod) map[string][]GoMethod {
+	impls := make(map[string][]GoMethod)
+	if len(methodSet) == 0 {
+		return impls
+	}
+
+	want := make(map[string]struct{}, len(methodSet))
+	for _, m := range methodSet {
+		want[m] = struct{}{}
+	}
+
+	byReceiver := make(map[string][]GoMethod)
+	for _, m := range methods {
+		if _, ok := want[m.Name]; ok {
+			byReceiver[m.Receiver] = append(byReceiver[m.Receiver], m)
+		}
+	}
+
+	for receiver, ms := range byReceiver {
+		have := make(map[string]struct{}, len(ms))
+		for _, m := range ms {
+			have[m.Name] = struct{}{}
+		}
+		if len(have) == len(want) {
+			impls[receiver] = ms
+		}
+	}
+	return impls
+}
+
+// firstDescendantOfKind returns the first named node of the given kind in a depth-first walk from node.
+func firstDescendantOfKind(node *sitter.Node, kind string) *sitter.Node {
+	if node.Kind() == kind {
+		return node
+	}
+	for i := uint(0); i < node.NamedChildCount(); i++ {
+		if found := firstDescendantOfKind(node.NamedChild(i), kind); found != nil {
+			return found
+		}
+	}
+	return nil
+}
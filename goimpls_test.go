@@ -0,0 +1,92 @@
+package grepast
+
+import (
+	"fmt"
+	"testing"
+)
+
+func getExampleInterfaceSource() []byte {
+	return []byte(`package store
+
+type Reader interface {
+	Get(key string) ([]byte, error)
+}
+
+type ReadWriter interface {
+	Reader
+	Put(key string, value []byte) error
+}
+
+type memStore struct{}
+
+func (m *memStore) Get(key string) ([]byte, error) { return nil, nil }
+
+func (m *memStore) Put(key string, value []byte) error { return nil }
+
+type readOnly struct{}
+
+func (r readOnly) Get(key string) ([]byte, error) { return nil, nil }
+`)
+}
+
+// TestTreeContext_GoInterfaces tests the GoInterfaces method of TreeContext.
+func TestTreeContext_GoInterfaces(t *testing.T) {
+	tc, err := NewTreeContext("store.go", getExampleInterfaceSource(), TreeContextOptions{})
+	if err != nil {
+		t.Fatalf("NewTreeContext() error = %v", err)
+	}
+
+	ifaces := tc.GoInterfaces()
+	if len(ifaces) != 2 {
+		t.Fatalf("GoInterfaces() = %v, want 2 interfaces", ifaces)
+	}
+	if got := fmt.Sprintf("%s %v", ifaces[0].Name, ifaces[0].Methods); got != "Reader [Get]" {
+		t.Errorf("GoInterfaces()[0] = %s, want Reader [Get]", got)
+	}
+	if got := fmt.Sprintf("%s %v %v", ifaces[1].Name, ifaces[1].Methods, ifaces[1].Embedded); got != "ReadWriter [Put] [Reader]" {
+		t.Errorf("GoInterfaces()[1] = %s, want ReadWriter [Put] [Reader]", got)
+	}
+}
+
+// TestGoImplementations tests resolving implementations of an interface from declared methods.
+func TestGoImplementations(t *testing.T) {
+	tc, err := NewTreeContext("store.go", getExampleInterfaceSource(), TreeContextOptions{})
+	if err != nil {
+		t.Fatalf("NewTreeContext() error = %v", err)
+	}
+	ifaces := tc.GoInterfaces()
+	methods := tc.GoMethods()
+
+	tests := []struct {
+		iface     string
+		methodSet []string
+		receivers []string
+	}{
+		{iface: "Reader", methodSet: []string{"Get"}, receivers: []string{"memStore", "readOnly"}},
+		{iface: "ReadWriter", methodSet: []string{"Get", "Put"}, receivers: []string{"memStore"}},
+		{iface: "Missing", methodSet: nil, receivers: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.iface, func(t *testing.T) {
+			methodSet := GoInterfaceMethodSet(tt.iface, ifaces)
+			if fmt.Sprint(methodSet) != fmt.Sprint(tt.methodSet) {
+				t.Errorf("GoInterfaceMethodSet(%q) = %v, want %v", tt.iface, methodSet, tt.methodSet)
+			}
+
+			impls := GoImplementations(methodSet, methods)
+			receivers := make([]string, 0, len(impls))
+			for r := range impls {
+				receivers = append(receivers, r)
+			}
+			if len(receivers) != len(tt.receivers) {
+				t.Fatalf("GoImplementations() = %v, want receivers %v", impls, tt.receivers)
+			}
+			for _, r := range tt.receivers {
+				if _, ok := impls[r]; !ok {
+					t.Errorf("GoImplementations() missing receiver %q", r)
+				}
+			}
+		})
+	}
+}
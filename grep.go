@@ -0,0 +1,548 @@
+package grepast
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+// GrepOptions specifies options controlling how patterns are matched by GrepWithOptions.
+type GrepOptions struct {
+	IgnoreCase bool // Match the pattern case-insensitively.
+	Literal    bool // Treat the pattern as a plain substring rather than a regular expression.
+	Multiline  bool // Match the pattern against the whole source so matches may span several lines.
+	Invert     bool // Report the lines that do not match instead of those that do.
+	Structural bool // Treat the pattern as a structural pattern with :[name] placeholders; see StructuralSearch.
+	NodeText   bool // Match the parse-tree nodes whose source text equals the pattern, ignoring whitespace; see NodeTextSearch.
+	Fuzzy      bool // Match the lines containing the pattern's characters in order, ignoring case; see FuzzyLines.
+
+	// NodeKinds restricts matches to text covered by a parse-tree node of one of these kinds,
+	// e.g. "comment", "interpreted_string_literal", or "block". Empty means no restriction.
+	NodeKinds []string
+
+	// IdentifiersOnly restricts matches to the identifiers naming definitions, such as function,
+	// type, and variable names, so that references to them, comments, strings, and other text are
+	// never matched. Definitions are recognized as by Definitions.
+	IdentifiersOnly bool
+
+	// CommentsOnly restricts matches to comments and docstrings, as described by the language's
+	// CommentSyntax, so that code is never matched.
+	CommentsOnly bool
+
+	// MatchAllInScope reports matches only when every pattern matches within the same
+	// enclosing scope (e.g. the same function body), rather than when any pattern matches.
+	MatchAllInScope bool
+
+	// StartLine and EndLine restrict matches to the zero-based lines from StartLine up to, but
+	// not including, EndLine. An EndLine of zero means the end of the source.
+	StartLine int
+	EndLine   int
+
+	// Scope restricts matches to the lines within a function, method, class, or other definition
+	// of this name, e.g. "HandleLogin", or qualified by the names of its enclosing definitions,
+	// e.g. "Server.login".
+	Scope string
+}
+
+// Grep finds lines matching a pattern and highlights them.
+// It panics if the pattern is not a valid regular expression; use GrepWithOptions to get an error instead.
+func (tc *TreeContext) Grep(pat string, ignoreCase bool) map[int]struct{} {
+	found, err := tc.GrepWithOptions(pat, GrepOptions{IgnoreCase: ignoreCase})
+	if err != nil {
+		panic(err)
+	}
+	return found
+}
+
+// GrepWithOptions finds lines matching a pattern according to the given options and highlights them.
+// In multiline mode every line covered by a match is reported.
+func (tc *TreeContext) GrepWithOptions(pat string, options GrepOptions) (map[int]struct{}, error) {
+	return tc.GrepPatterns([]string{pat}, options)
+}
+
+// GrepInNodeKinds finds lines where pattern matches text covered by a parse-tree node of one of
+// the given kinds, e.g. only inside comments or string literals, and highlights them.
+func (tc *TreeContext) GrepInNodeKinds(pat string, kinds []string) (map[int]struct{}, error) {
+	return tc.GrepWithOptions(pat, GrepOptions{NodeKinds: kinds})
+}
+
+// GrepPatterns finds lines matching any of the given patterns in a single pass and highlights them,
+// using a distinct color per pattern.
+func (tc *TreeContext) GrepPatterns(patterns []string, options GrepOptions) (map[int]struct{}, error) {
+	return tc.GrepPatternsCtx(context.Background(), patterns, options)
+}
+
+// GrepPatternsCtx is like GrepPatterns, but gives up once ctx is done, returning ctx.Err()
+// without recording any match.
+func (tc *TreeContext) GrepPatternsCtx(ctx context.Context, patterns []string, options GrepOptions) (map[int]struct{}, error) {
+	res := make([]*regexp.Regexp, len(patterns))
+	for i, pat := range patterns {
+		if options.Structural || options.NodeText || options.Fuzzy {
+			continue
+		}
+		re, err := compilePattern(pat, options)
+		if err != nil {
+			return nil, err
+		}
+		res[i] = re
+	}
+
+	perPattern := make([]map[int][]matchSpan, len(res))
+	for p, re := range res {
+		switch {
+		case options.Structural:
+			found, err := tc.matchStructural(patterns[p], p, options.IgnoreCase)
+			if err != nil {
+				return nil, err
+			}
+			perPattern[p] = found
+		case options.NodeText:
+			perPattern[p] = tc.matchNodeText(patterns[p], p, options.IgnoreCase)
+		case options.Fuzzy:
+			perPattern[p] = tc.matchFuzzy(patterns[p], p)
+		case options.Multiline:
+			perPattern[p] = tc.matchSource(re, p)
+		default:
+			perPattern[p] = tc.matchLines(ctx, re, p)
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if len(options.NodeKinds) > 0 {
+			perPattern[p] = tc.filterByNodeKinds(perPattern[p], options.NodeKinds)
+		}
+		if options.IdentifiersOnly {
+			perPattern[p] = tc.filterByRanges(perPattern[p], tc.definitionNameRanges())
+		}
+		if options.CommentsOnly {
+			perPattern[p] = tc.filterByNodes(perPattern[p], tc.isCommentNode)
+		}
+		if options.restrictsLines() {
+			perPattern[p] = tc.filterByLines(perPattern[p], options)
+		}
+	}
+
+	matches := make(map[int][]matchSpan)
+	for _, found := range perPattern {
+		for i, spans := range found {
+			if options.MatchAllInScope && !tc.inScopeMatchingAll(i, perPattern) {
+				continue
+			}
+			matches[i] = append(matches[i], spans...)
+		}
+	}
+
+	if options.Invert {
+		found := tc.unmatchedLines(matches)
+		if options.restrictsLines() {
+			for i := range found {
+				if !tc.inSearchedLines(i, options) {
+					delete(found, i)
+				}
+			}
+		}
+		return found, nil
+	}
+
+	found := make(map[int]struct{}, len(matches))
+	for i, spans := range matches {
+		tc.matchSpans[i] = append(tc.matchSpans[i], spans...)
+		// highlight
+		if tc.color {
+			tc.outputLines[i] = highlightSpans(tc.lines[i], spans, tc.palette())
+		}
+		found[i] = struct{}{}
+	}
+	return found, nil
+}
+
+// filterByNodeKinds keeps only the spans lying entirely within a node of one of the given kinds.
+func (tc *TreeContext) filterByNodeKinds(matches map[int][]matchSpan, kinds []string) map[int][]matchSpan {
+	wanted := make(map[string]struct{}, len(kinds))
+	for _, kind := range kinds {
+		wanted[kind] = struct{}{}
+	}
+	return tc.filterByNodes(matches, func(node *sitter.Node) bool {
+		_, ok := wanted[node.Kind()]
+		return ok
+	})
+}
+
+// filterByNodes keeps only the spans lying entirely within a node satisfying keep.
+func (tc *TreeContext) filterByNodes(matches map[int][]matchSpan, keep func(node *sitter.Node) bool) map[int][]matchSpan {
+	var ranges [][2]int
+	for _, nodes := range tc.nodes {
+		for _, node := range nodes {
+			if keep(node) {
+				ranges = append(ranges, [2]int{int(node.StartByte()), int(node.EndByte())})
+			}
+		}
+	}
+	return tc.filterByRanges(matches, ranges)
+}
+
+// definitionNameRanges returns the byte ranges of the identifiers naming the definitions found
+// by Definitions.
+func (tc *TreeContext) definitionNameRanges() [][2]int {
+	var ranges [][2]int
+	for _, nodes := range tc.nodes {
+		for _, node := range nodes {
+			if !isDefinitionKind(node.Kind()) {
+				continue
+			}
+			if name := node.ChildByFieldName("name"); name != nil && isIdentifier(name) {
+				ranges = append(ranges, [2]int{int(name.StartByte()), int(name.EndByte())})
+			}
+		}
+	}
+	return ranges
+}
+
+// filterByRanges keeps only the spans lying entirely within one of the given byte ranges.
+func (tc *TreeContext) filterByRanges(matches map[int][]matchSpan, ranges [][2]int) map[int][]matchSpan {
+	filtered := make(map[int][]matchSpan)
+	for i, spans := range matches {
+		for _, span := range spans {
+			start := tc.lineStarts[i] + span.start
+			end := tc.lineStarts[i] + span.end
+			for _, r := range ranges {
+				if start >= r[0] && end <= r[1] {
+					filtered[i] = append(filtered[i], span)
+					break
+				}
+			}
+		}
+	}
+	return filtered
+}
+
+// restrictsLines reports whether options restrict matches to some lines of the source.
+func (options GrepOptions) restrictsLines() bool {
+	return options.StartLine > 0 || options.EndLine > 0 || options.Scope != ""
+}
+
+// filterByLines keeps only the matches on the lines options restrict matches to.
+func (tc *TreeContext) filterByLines(matches map[int][]matchSpan, options GrepOptions) map[int][]matchSpan {
+	filtered := make(map[int][]matchSpan)
+	for i, spans := range matches {
+		if tc.inSearchedLines(i, options) {
+			filtered[i] = spans
+		}
+	}
+	return filtered
+}
+
+// inSearchedLines reports whether line i lies within the line range and scope of options.
+func (tc *TreeContext) inSearchedLines(i int, options GrepOptions) bool {
+	if i < options.StartLine || (options.EndLine > 0 && i >= options.EndLine) {
+		return false
+	}
+	return options.Scope == "" || tc.inNamedScope(i, options.Scope)
+}
+
+// inNamedScope reports whether line i lies within a definition called name, either plainly or
+// qualified by the names of its enclosing definitions, joined by dots; see DefinitionsAt.
+func (tc *TreeContext) inNamedScope(i int, name string) bool {
+	var qualified string
+	for _, def := range tc.definitionsAt(i) {
+		if qualified != "" {
+			qualified += "."
+		}
+		qualified += def.ChildByFieldName("name").Utf8Text(tc.source)
+		if qualified == name || strings.HasSuffix(qualified, "."+name) {
+			return true
+		}
+	}
+	return false
+}
+
+// isIdentifier reports whether a node is an identifier, e.g. of kind "identifier",
+// "type_identifier", or "field_identifier".
+func isIdentifier(node *sitter.Node) bool {
+	return strings.HasSuffix(node.Kind(), "identifier")
+}
+
+// unmatchedLines returns every line without a match, ignoring the empty line after a trailing newline.
+func (tc *TreeContext) unmatchedLines(matches map[int][]matchSpan) map[int]struct{} {
+	found := make(map[int]struct{})
+	for i, line := range tc.lines {
+		if _, ok := matches[i]; ok {
+			continue
+		}
+		if i == len(tc.lines)-1 && line == "" {
+			continue
+		}
+		found[i] = struct{}{}
+	}
+	return found
+}
+
+// inScopeMatchingAll reports whether line i lies within a scope, other than the file itself,
+// in which every pattern matched at least one line.
+func (tc *TreeContext) inScopeMatchingAll(i int, perPattern []map[int][]matchSpan) bool {
+	for start := range tc.scopes[i] {
+		end := tc.scopeEnd(start)
+		if end < i {
+			continue
+		}
+
+		matchesAll := true
+		for _, found := range perPattern {
+			if !anyLineInRange(found, start, end) {
+				matchesAll = false
+				break
+			}
+		}
+		if matchesAll {
+			return true
+		}
+	}
+	return false
+}
+
+// anyLineInRange reports whether any line of found lies within [start, end].
+func anyLineInRange(found map[int][]matchSpan, start, end int) bool {
+	for line := range found {
+		if line >= start && line <= end {
+			return true
+		}
+	}
+	return false
+}
+
+// matchSpan is the [start, end) byte range of a match within a line and the index of the pattern that produced it.
+type matchSpan struct {
+	start   int
+	end     int
+	pattern int
+}
+
+// Match is the part of a line matched by a pattern, as found by Grep. A match spanning several
+// lines in multiline mode is reported once per line.
+type Match struct {
+	Line      int    `json:"line"`       // Zero-based line of the match.
+	Column    int    `json:"column"`     // Zero-based byte offset in the line at which the match starts.
+	EndColumn int    `json:"end_column"` // Zero-based byte offset in the line at which the match ends, exclusive.
+	StartByte int    `json:"start_byte"` // Byte offset in the source at which the match starts.
+	EndByte   int    `json:"end_byte"`   // Byte offset in the source at which the match ends, exclusive.
+	Pattern   int    `json:"pattern"`    // Index of the pattern that matched.
+	Text      string `json:"text"`       // Matched text.
+
+	// RuneColumn and EndRuneColumn are Column and EndColumn counted in runes (Unicode code
+	// points) rather than bytes, as editors counting characters expect.
+	RuneColumn    int `json:"rune_column"`
+	EndRuneColumn int `json:"end_rune_column"`
+}
+
+// Matches returns the matches found by the Grep methods so far, ordered by position. Lines
+// reported by inverted searches or added with AddLinesOfInterest have no matches.
+func (tc *TreeContext) Matches() []Match {
+	var matches []Match
+	for i, spans := range tc.matchSpans {
+		for _, span := range spans {
+			matches = append(matches, Match{
+				Line:      i,
+				Column:    span.start,
+				EndColumn: span.end,
+				StartByte: tc.lineStarts[i] + span.start,
+				EndByte:   tc.lineStarts[i] + span.end,
+				Pattern:   span.pattern,
+				Text:      tc.lines[i][span.start:span.end],
+
+				RuneColumn:    utf8.RuneCountInString(tc.lines[i][:span.start]),
+				EndRuneColumn: utf8.RuneCountInString(tc.lines[i][:span.end]),
+			})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].StartByte != matches[j].StartByte {
+			return matches[i].StartByte < matches[j].StartByte
+		}
+		return matches[i].Pattern < matches[j].Pattern
+	})
+	return matches
+}
+
+// matchLocation returns the "file:line:col:" prefix of line i, with one-based numbers, where col
+// is the column of the line's first match; lines without a match get "file:line:".
+func (tc *TreeContext) matchLocation(i int) string {
+	spans := tc.matchSpans[i]
+	if len(spans) == 0 {
+		return fmt.Sprintf("%s:%d:", tc.filename, i+1)
+	}
+	col := spans[0].start
+	for _, span := range spans[1:] {
+		col = min(col, span.start)
+	}
+	return fmt.Sprintf("%s:%d:%d:", tc.filename, i+1, col+1)
+}
+
+// matchColors are the default highlight colors assigned to patterns in order, cycling when there are more patterns.
+var matchColors = []string{
+	"\033[1;31m", // red
+	"\033[1;32m", // green
+	"\033[1;33m", // yellow
+	"\033[1;34m", // blue
+	"\033[1;35m", // magenta
+	"\033[1;36m", // cyan
+}
+
+// palette returns the highlight colors of the context, falling back to matchColors.
+func (tc *TreeContext) palette() []string {
+	if len(tc.matchColors) > 0 {
+		return tc.matchColors
+	}
+	return matchColors
+}
+
+// compilePattern turns a search pattern into a regular expression honoring the given options.
+func compilePattern(pat string, options GrepOptions) (*regexp.Regexp, error) {
+	if options.Literal {
+		pat = regexp.QuoteMeta(pat)
+	}
+	if options.Multiline {
+		// Let ^ and $ keep matching at line boundaries when searching the whole source.
+		pat = "(?m)" + pat
+	}
+	if options.IgnoreCase {
+		// Go's regex doesn't have "IGNORECASE" as a flag (like Python),
+		// you compile different patterns or use (?i).
+		pat = "(?i)" + pat
+	}
+	return regexp.Compile(pat)
+}
+
+// matchLines runs re against each line and returns the matched spans by line number. It stops
+// early once ctx is done.
+func (tc *TreeContext) matchLines(ctx context.Context, re *regexp.Regexp, pattern int) map[int][]matchSpan {
+	matches := make(map[int][]matchSpan)
+	for i, line := range tc.lines {
+		if i%1024 == 0 && ctx.Err() != nil {
+			break
+		}
+		for _, m := range re.FindAllStringIndex(line, -1) {
+			matches[i] = append(matches[i], matchSpan{start: m[0], end: m[1], pattern: pattern})
+		}
+	}
+	return matches
+}
+
+// matchSource runs re against the whole source and maps each match back onto
+// the lines it covers, returning the per-line spans.
+func (tc *TreeContext) matchSource(re *regexp.Regexp, pattern int) map[int][]matchSpan {
+	matches := make(map[int][]matchSpan)
+	for _, m := range re.FindAllIndex(tc.source, -1) {
+		tc.addRangeSpans(matches, m[0], m[1], pattern)
+	}
+	return matches
+}
+
+// matchStructural finds the matches of a structural pattern and returns the matched spans by line number.
+func (tc *TreeContext) matchStructural(pat string, pattern int, ignoreCase bool) (map[int][]matchSpan, error) {
+	found, err := tc.structuralSearch(pat, ignoreCase)
+	if err != nil {
+		return nil, err
+	}
+	matches := make(map[int][]matchSpan)
+	for _, m := range found {
+		tc.addRangeSpans(matches, m.StartByte, m.EndByte, pattern)
+	}
+	return matches, nil
+}
+
+// matchNodeText finds the nodes whose text equals pat, returning the spans they cover per line.
+func (tc *TreeContext) matchNodeText(pat string, pattern int, ignoreCase bool) map[int][]matchSpan {
+	matches := make(map[int][]matchSpan)
+	for _, m := range tc.nodeTextSearch(pat, ignoreCase) {
+		tc.addRangeSpans(matches, m.StartByte, m.EndByte, pattern)
+	}
+	return matches
+}
+
+// addRangeSpans adds a span to matches for each line covered by the source byte range [start, end).
+func (tc *TreeContext) addRangeSpans(matches map[int][]matchSpan, start, end, pattern int) {
+	lineStarts := tc.lineStarts
+	last := tc.lineAt(start)
+	if end > start {
+		last = tc.lineAt(end - 1)
+	}
+	for i := tc.lineAt(start); i <= last; i++ {
+		spanStart := max(start, lineStarts[i]) - lineStarts[i]
+		spanEnd := min(end, lineStarts[i]+len(tc.lines[i])) - lineStarts[i]
+		matches[i] = append(matches[i], matchSpan{start: spanStart, end: max(spanStart, spanEnd), pattern: pattern})
+	}
+}
+
+// lineAt returns the zero-based line containing the byte offset pos.
+func (tc *TreeContext) lineAt(pos int) int {
+	return sort.Search(len(tc.lineStarts), func(i int) bool { return tc.lineStarts[i] > pos }) - 1
+}
+
+// highlightSpans wraps each span of line in its pattern's color from colors. Spans are widened
+// to whole grapheme clusters, so that color codes never split a character from its combining
+// marks. Spans overlapping an earlier span are skipped.
+func highlightSpans(line string, spans []matchSpan, colors []string) string {
+	sorted := make([]matchSpan, len(spans))
+	for i, span := range spans {
+		span.start, span.end = graphemeBounds(line, span.start, span.end)
+		sorted[i] = span
+	}
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].start < sorted[j].start })
+
+	var sb strings.Builder
+	last := 0
+	for _, span := range sorted {
+		if span.start < last {
+			continue
+		}
+		sb.WriteString(line[last:span.start])
+		color := colors[span.pattern%len(colors)]
+		fmt.Fprintf(&sb, "%s%s\033[0m", color, line[span.start:span.end])
+		last = span.end
+	}
+	sb.WriteString(line[last:])
+	return sb.String()
+}
+
+// graphemeBounds widens the byte range [start, end) of line to the boundaries of the grapheme
+// clusters it touches: it never ends inside a multi-byte rune, before a combining mark, or
+// around a zero-width joiner.
+func graphemeBounds(line string, start, end int) (int, int) {
+	start = max(0, min(start, len(line)))
+	end = max(start, min(end, len(line)))
+
+	for start > 0 {
+		r, size := utf8.DecodeLastRuneInString(line[:start])
+		if !utf8.RuneStart(line[start]) || extendsGrapheme(line[start:]) || r == zeroWidthJoiner {
+			start -= size
+			continue
+		}
+		break
+	}
+	for end < len(line) && end > start {
+		prev, _ := utf8.DecodeLastRuneInString(line[:end])
+		if !utf8.RuneStart(line[end]) || extendsGrapheme(line[end:]) || prev == zeroWidthJoiner {
+			_, size := utf8.DecodeRuneInString(line[end:])
+			end += size
+			continue
+		}
+		break
+	}
+	return start, end
+}
+
+// zeroWidthJoiner joins the characters around it into a single grapheme, as in emoji sequences.
+const zeroWidthJoiner = '\u200d'
+
+// extendsGrapheme reports whether s starts with a rune that belongs to the grapheme cluster
+// before it: a combining mark, variation selector, or zero-width joiner.
+func extendsGrapheme(s string) bool {
+	r, _ := utf8.DecodeRuneInString(s)
+	return unicode.Is(unicode.M, r) || r == zeroWidthJoiner
+}
@@ -0,0 +1,486 @@
+package grepast
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// TestTreeContext_GrepWithOptions tests the GrepWithOptions method of TreeContext.
+func TestTreeContext_GrepWithOptions(t *testing.T) {
+	sourceCode := []byte(`package main
+
+func main() {
+	foo(bar)
+	foo(baz)
+	FOO(bar)
+}
+`)
+
+	tests := []struct {
+		name     string
+		pattern  string
+		options  GrepOptions
+		expected []int
+		wantErr  bool
+	}{
+		{
+			name:     "Regex",
+			pattern:  `foo\(ba.\)`,
+			expected: []int{3, 4},
+		},
+		{
+			name:     "Literal",
+			pattern:  "foo(bar)",
+			options:  GrepOptions{Literal: true},
+			expected: []int{3},
+		},
+		{
+			name:     "Literal ignore case",
+			pattern:  "foo(bar)",
+			options:  GrepOptions{Literal: true, IgnoreCase: true},
+			expected: []int{3, 5},
+		},
+		{
+			name:    "Invalid regex",
+			pattern: "foo(bar",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc, err := NewTreeContext("example.go", sourceCode, TreeContextOptions{})
+			if err != nil {
+				t.Fatalf("NewTreeContext() error = %v", err)
+			}
+
+			found, err := tc.GrepWithOptions(tt.pattern, tt.options)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("GrepWithOptions(%q) expected an error but got none", tt.pattern)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GrepWithOptions(%q) error = %v", tt.pattern, err)
+			}
+
+			got := mapKeysSorted(found)
+			if fmt.Sprint(got) != fmt.Sprint(tt.expected) {
+				t.Errorf("GrepWithOptions(%q) = %v, want %v", tt.pattern, got, tt.expected)
+			}
+		})
+	}
+}
+
+// TestTreeContext_GrepMultiline tests multiline matching in GrepWithOptions.
+func TestTreeContext_GrepMultiline(t *testing.T) {
+	sourceCode := []byte(`package main
+
+func handle(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	serve(w, r)
+}
+`)
+
+	tc, err := NewTreeContext("example.go", sourceCode, TreeContextOptions{Color: true})
+	if err != nil {
+		t.Fatalf("NewTreeContext() error = %v", err)
+	}
+
+	found, err := tc.GrepWithOptions(`handle\(\s*w http`, GrepOptions{Multiline: true})
+	if err != nil {
+		t.Fatalf("GrepWithOptions() error = %v", err)
+	}
+
+	got := mapKeysSorted(found)
+	if fmt.Sprint(got) != fmt.Sprint([]int{2, 3}) {
+		t.Errorf("GrepWithOptions() = %v, want %v", got, []int{2, 3})
+	}
+
+	// Each covered line is highlighted only on its part of the match.
+	if want := "func \033[1;31mhandle(\033[0m"; tc.outputLines[2] != want {
+		t.Errorf("outputLines[2] = %q, want %q", tc.outputLines[2], want)
+	}
+	if want := "\033[1;31m\tw http\033[0m.ResponseWriter,"; tc.outputLines[3] != want {
+		t.Errorf("outputLines[3] = %q, want %q", tc.outputLines[3], want)
+	}
+
+	// Without multiline mode the pattern cannot match across lines.
+	found, err = tc.GrepWithOptions(`handle\(\s*w http`, GrepOptions{})
+	if err != nil {
+		t.Fatalf("GrepWithOptions() error = %v", err)
+	}
+	if len(found) != 0 {
+		t.Errorf("GrepWithOptions() without multiline = %v, want none", mapKeysSorted(found))
+	}
+}
+
+// TestTreeContext_GrepPatterns tests matching several patterns in a single pass.
+func TestTreeContext_GrepPatterns(t *testing.T) {
+	sourceCode := []byte(`package main
+
+func main() {
+	open()
+	read()
+	close()
+}
+`)
+
+	tc, err := NewTreeContext("example.go", sourceCode, TreeContextOptions{Color: true})
+	if err != nil {
+		t.Fatalf("NewTreeContext() error = %v", err)
+	}
+
+	found, err := tc.GrepPatterns([]string{"open", "close"}, GrepOptions{})
+	if err != nil {
+		t.Fatalf("GrepPatterns() error = %v", err)
+	}
+
+	got := mapKeysSorted(found)
+	if fmt.Sprint(got) != fmt.Sprint([]int{3, 5}) {
+		t.Errorf("GrepPatterns() = %v, want %v", got, []int{3, 5})
+	}
+
+	// Each pattern gets its own highlight color.
+	if want := "\t\033[1;31mopen\033[0m()"; tc.outputLines[3] != want {
+		t.Errorf("outputLines[3] = %q, want %q", tc.outputLines[3], want)
+	}
+	if want := "\t\033[1;32mclose\033[0m()"; tc.outputLines[5] != want {
+		t.Errorf("outputLines[5] = %q, want %q", tc.outputLines[5], want)
+	}
+
+	if _, err := tc.GrepPatterns([]string{"open", "("}, GrepOptions{}); err == nil {
+		t.Errorf("GrepPatterns() expected an error for an invalid pattern but got none")
+	}
+}
+
+// TestTreeContext_GrepMatchAllInScope tests scope-level AND matching of several patterns.
+func TestTreeContext_GrepMatchAllInScope(t *testing.T) {
+	sourceCode := []byte(`package main
+
+func both() {
+	lock()
+	unlock()
+}
+
+func onlyLock() {
+	lock()
+}
+
+func onlyUnlock() {
+	unlock()
+}
+`)
+
+	tc, err := NewTreeContext("example.go", sourceCode, TreeContextOptions{})
+	if err != nil {
+		t.Fatalf("NewTreeContext() error = %v", err)
+	}
+
+	found, err := tc.GrepPatterns([]string{`\block\(`, `\bunlock\(`}, GrepOptions{MatchAllInScope: true})
+	if err != nil {
+		t.Fatalf("GrepPatterns() error = %v", err)
+	}
+
+	got := mapKeysSorted(found)
+	if fmt.Sprint(got) != fmt.Sprint([]int{3, 4}) {
+		t.Errorf("GrepPatterns() = %v, want %v", got, []int{3, 4})
+	}
+
+	// Without the option, every match is reported.
+	found, err = tc.GrepPatterns([]string{`\block\(`, `\bunlock\(`}, GrepOptions{})
+	if err != nil {
+		t.Fatalf("GrepPatterns() error = %v", err)
+	}
+	got = mapKeysSorted(found)
+	if fmt.Sprint(got) != fmt.Sprint([]int{3, 4, 8, 12}) {
+		t.Errorf("GrepPatterns() = %v, want %v", got, []int{3, 4, 8, 12})
+	}
+}
+
+// TestTreeContext_GrepInvert tests invert-match mode in GrepWithOptions.
+func TestTreeContext_GrepInvert(t *testing.T) {
+	sourceCode := []byte(`package main
+
+func main() {
+	authz()
+	serve()
+}
+`)
+
+	tc, err := NewTreeContext("example.go", sourceCode, TreeContextOptions{Color: true})
+	if err != nil {
+		t.Fatalf("NewTreeContext() error = %v", err)
+	}
+
+	found, err := tc.GrepWithOptions(`authz|^$`, GrepOptions{Invert: true})
+	if err != nil {
+		t.Fatalf("GrepWithOptions() error = %v", err)
+	}
+
+	got := mapKeysSorted(found)
+	if fmt.Sprint(got) != fmt.Sprint([]int{0, 2, 4, 5}) {
+		t.Errorf("GrepWithOptions() = %v, want %v", got, []int{0, 2, 4, 5})
+	}
+	if len(tc.outputLines) != 0 {
+		t.Errorf("GrepWithOptions() highlighted %d lines, want none", len(tc.outputLines))
+	}
+}
+
+// TestTreeContext_GrepInNodeKinds tests restricting matches to nodes of given kinds.
+func TestTreeContext_GrepInNodeKinds(t *testing.T) {
+	sourceCode := []byte(`package main
+
+// TODO: remove legacy path
+func main() {
+	legacy := "TODO"
+	_ = legacy
+}
+`)
+
+	tc, err := NewTreeContext("example.go", sourceCode, TreeContextOptions{})
+	if err != nil {
+		t.Fatalf("NewTreeContext() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		pattern  string
+		kinds    []string
+		expected []int
+	}{
+		{name: "Comments only", pattern: "TODO", kinds: []string{"comment"}, expected: []int{2}},
+		{name: "Strings only", pattern: "TODO", kinds: []string{"interpreted_string_literal"}, expected: []int{4}},
+		{name: "Comments or strings", pattern: "TODO", kinds: []string{"comment", "interpreted_string_literal"}, expected: []int{2, 4}},
+		{name: "Function bodies only", pattern: "legacy", kinds: []string{"block"}, expected: []int{4, 5}},
+		{name: "Match must be fully covered", pattern: `legacy := "TODO"`, kinds: []string{"interpreted_string_literal"}, expected: []int{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			found, err := tc.GrepInNodeKinds(tt.pattern, tt.kinds)
+			if err != nil {
+				t.Fatalf("GrepInNodeKinds() error = %v", err)
+			}
+			got := mapKeysSorted(found)
+			if fmt.Sprint(got) != fmt.Sprint(tt.expected) {
+				t.Errorf("GrepInNodeKinds(%q, %v) = %v, want %v", tt.pattern, tt.kinds, got, tt.expected)
+			}
+		})
+	}
+}
+
+// TestTreeContext_GrepIdentifiersOnly tests that identifier-only matching finds the names of
+// definitions, but not references to them such as calls.
+func TestTreeContext_GrepIdentifiersOnly(t *testing.T) {
+	sourceCode := []byte(`package main
+
+// Run starts the server.
+func Run() {
+	msg := "Run"
+	runner.Run(msg)
+}
+`)
+
+	tc, err := NewTreeContext("example.go", sourceCode, TreeContextOptions{})
+	if err != nil {
+		t.Fatalf("NewTreeContext() error = %v", err)
+	}
+
+	found, err := tc.GrepWithOptions("Run", GrepOptions{IdentifiersOnly: true})
+	if err != nil {
+		t.Fatalf("GrepWithOptions() error = %v", err)
+	}
+
+	got := mapKeysSorted(found)
+	if fmt.Sprint(got) != fmt.Sprint([]int{3}) {
+		t.Errorf("GrepWithOptions() = %v, want %v", got, []int{3})
+	}
+}
+
+// TestTreeContext_GrepCommentsOnly tests that CommentsOnly restricts matches to comments and docstrings.
+func TestTreeContext_GrepCommentsOnly(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		source   string
+		expected []int
+	}{
+		{
+			name:     "Go",
+			filename: "example.go",
+			source: `package main
+
+// TODO: rename.
+func TODO() {
+	s := "TODO" /* TODO */
+}
+`,
+			expected: []int{2, 4},
+		},
+		{
+			name:     "Python",
+			filename: "example.py",
+			source: `"""TODO: module docs."""
+
+def f():
+    """TODO: function docs."""
+    x = "TODO"
+    "TODO"  # TODO
+`,
+			expected: []int{0, 3, 5},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc, err := NewTreeContext(tt.filename, []byte(tt.source), TreeContextOptions{})
+			if err != nil {
+				t.Fatalf("NewTreeContext() error = %v", err)
+			}
+			found, err := tc.GrepWithOptions("TODO", GrepOptions{CommentsOnly: true})
+			if err != nil {
+				t.Fatalf("GrepWithOptions() error = %v", err)
+			}
+			if got := mapKeysSorted(found); fmt.Sprint(got) != fmt.Sprint(tt.expected) {
+				t.Errorf("GrepWithOptions() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+// TestTreeContext_Matches tests that Grep records match columns and byte offsets, and that
+// ShowMatchLocation prefixes shown lines with them.
+func TestTreeContext_Matches(t *testing.T) {
+	source := []byte("package p\n\nfunc f() {\n\tx := foo(foo)\n}\n")
+	tc, err := NewTreeContext("p/example.go", source, TreeContextOptions{ShowParentContext: true, ShowMatchLocation: true})
+	if err != nil {
+		t.Fatalf("NewTreeContext() error = %v", err)
+	}
+	found, err := tc.GrepPatterns([]string{"foo", `x :=`}, GrepOptions{})
+	if err != nil {
+		t.Fatalf("GrepPatterns() error = %v", err)
+	}
+
+	expected := []Match{
+		{Line: 3, Column: 1, EndColumn: 5, StartByte: 23, EndByte: 27, Pattern: 1, Text: "x :=", RuneColumn: 1, EndRuneColumn: 5},
+		{Line: 3, Column: 6, EndColumn: 9, StartByte: 28, EndByte: 31, Pattern: 0, Text: "foo", RuneColumn: 6, EndRuneColumn: 9},
+		{Line: 3, Column: 10, EndColumn: 13, StartByte: 32, EndByte: 35, Pattern: 0, Text: "foo", RuneColumn: 10, EndRuneColumn: 13},
+	}
+	if got := tc.Matches(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Matches() = %+v, want %+v", got, expected)
+	}
+
+	tc.AddLinesOfInterest(found)
+	tc.AddContext()
+	expectedOutput := "⋮...\np/example.go:3:│func f() {\np/example.go:4:2:│\tx := foo(foo)\np/example.go:5:│}\n"
+	if got := tc.Format(); got != expectedOutput {
+		t.Errorf("Format() =\n%s\nwant\n%s", got, expectedOutput)
+	}
+
+	// The SARIF region narrows to the first match of the line.
+	region := tc.SARIFResults("example.go")[0].Locations[0].PhysicalLocation.Region
+	if region.StartColumn != 2 || region.EndColumn != 6 {
+		t.Errorf("SARIFResults() region columns = %d-%d, want 2-6", region.StartColumn, region.EndColumn)
+	}
+}
+
+// TestTreeContext_UnicodeMatches tests rune columns of matches after multi-byte characters and
+// that highlighting keeps combining marks with the character they modify.
+func TestTreeContext_UnicodeMatches(t *testing.T) {
+	source := []byte("package p\n\n// héllo wörld cafe\u0301!\n")
+	tc, err := NewTreeContext("example.go", source, TreeContextOptions{Color: true})
+	if err != nil {
+		t.Fatalf("NewTreeContext() error = %v", err)
+	}
+	if _, err := tc.GrepPatterns([]string{"wörld", "cafe"}, GrepOptions{}); err != nil {
+		t.Fatalf("GrepPatterns() error = %v", err)
+	}
+
+	matches := tc.Matches()
+	if len(matches) != 2 {
+		t.Fatalf("Matches() = %v, want 2 matches", matches)
+	}
+	if m := matches[0]; m.Column != 10 || m.EndColumn != 16 || m.RuneColumn != 9 || m.EndRuneColumn != 14 {
+		t.Errorf("Matches()[0] columns = %d-%d, runes %d-%d, want 10-16, runes 9-14",
+			m.Column, m.EndColumn, m.RuneColumn, m.EndRuneColumn)
+	}
+
+	want := "// héllo \033[1;31mwörld\033[0m \033[1;32mcafe\u0301\033[0m!"
+	if got := tc.outputLines[2]; got != want {
+		t.Errorf("highlighted line = %q, want %q", got, want)
+	}
+}
+
+// TestGraphemeBounds tests that graphemeBounds widens byte ranges to whole grapheme clusters.
+func TestGraphemeBounds(t *testing.T) {
+	tests := []struct {
+		line       string
+		start, end int
+		expected   string
+	}{
+		{"abc", 1, 2, "b"},
+		{"aéb", 1, 2, "é"},
+		{"ae\u0301b", 1, 2, "e\u0301"},
+		{"ae\u0301b", 2, 4, "e\u0301"},
+		{"x\U0001F469\u200d\U0001F4BBy", 1, 5, "\U0001F469\u200d\U0001F4BB"},
+		{"abc", 2, 9, "c"},
+	}
+	for _, tt := range tests {
+		start, end := graphemeBounds(tt.line, tt.start, tt.end)
+		if got := tt.line[start:end]; got != tt.expected {
+			t.Errorf("graphemeBounds(%q, %d, %d) = %q, want %q", tt.line, tt.start, tt.end, got, tt.expected)
+		}
+	}
+}
+
+// TestTreeContext_GrepLinesAndScope tests restricting matches to a line range or a named scope.
+func TestTreeContext_GrepLinesAndScope(t *testing.T) {
+	sourceCode := []byte(`class Server:
+    def login(self):
+        check()
+
+    def logout(self):
+        check()
+
+def login():
+    check()
+`)
+
+	tc, err := NewTreeContext("example.py", sourceCode, TreeContextOptions{})
+	if err != nil {
+		t.Fatalf("NewTreeContext() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		options  GrepOptions
+		expected []int
+	}{
+		{name: "Unrestricted", options: GrepOptions{}, expected: []int{2, 5, 8}},
+		{name: "Line range", options: GrepOptions{StartLine: 3, EndLine: 8}, expected: []int{5}},
+		{name: "From a line", options: GrepOptions{StartLine: 3}, expected: []int{5, 8}},
+		{name: "Scope", options: GrepOptions{Scope: "login"}, expected: []int{2, 8}},
+		{name: "Qualified scope", options: GrepOptions{Scope: "Server.login"}, expected: []int{2}},
+		{name: "Enclosing scope", options: GrepOptions{Scope: "Server"}, expected: []int{2, 5}},
+		{name: "Scope and line range", options: GrepOptions{Scope: "login", StartLine: 3}, expected: []int{8}},
+		{name: "Unknown scope", options: GrepOptions{Scope: "missing"}, expected: []int{}},
+		{name: "Inverted", options: GrepOptions{Scope: "logout", Invert: true}, expected: []int{4}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			found, err := tc.GrepWithOptions(`check\(`, tt.options)
+			if err != nil {
+				t.Fatalf("GrepWithOptions() error = %v", err)
+			}
+			if got := mapKeysSorted(found); fmt.Sprint(got) != fmt.Sprint(tt.expected) {
+				t.Errorf("GrepWithOptions() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
@@ -1,131 +1,400 @@
 package grepast
 
 import (
+	"context"
 	"fmt"
-	"regexp"
+	"io"
+	"maps"
+	"slices"
 	"sort"
 	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
 
 	sitter "github.com/tree-sitter/go-tree-sitter"
 )
 
 // TreeContext stores context about source code lines, parsing, scopes, and line-of-interest management.
 type TreeContext struct {
-	filename                 string             // Name of the file being processed.
-	source                   []byte             // Source code content as a byte array.
-	color                    bool               // Whether to use color for highlighted output.
-	verbose                  bool               // Whether to enable verbose output for debugging.
-	showLineNumber           bool               // Whether to include line numbers in the output.
-	showLastLine             bool               // Whether to always include the larger context's last line in the output.
-	margin                   int                // Number of lines to include as a margin at the top of the output.
-	markLOIs                 bool               // Whether to visually mark lines of interest (LOI).
-	headerMax                int                // Maximum number of header lines to display.
-	loiPad                   int                // Number of lines of padding around lines of interest.
-	showTopOfFileParentScope bool               // Whether to include the parent scope starting from the top of the file.
-	parentContext            bool               // Whether to include parent context in the output.
-	showChildContext         bool               // Whether to include child context in the output.
-	lines                    []string           // Source code split into individual lines.
-	numLines                 int                // Total number of lines in the source code (including an optional trailing newline adjustment).
-	outputLines              map[int]string     // Map of output lines, optionally with highlights.
-	scopes                   []map[int]struct{} // Tracks scope relationships by line.
-	header                   [][]int            // Each element is a slice representing [startLine, endLine] of headers.
-	nodes                    [][]*sitter.Node   // Tracks parse-tree nodes indexed by their start line.
-	showLines                map[int]struct{}   // Lines to show in the final output.
-	linesOfInterest          map[int]struct{}   // Lines explicitly marked as "lines of interest" (LOI).
-	doneParentScopes         map[int]struct{}   // Tracks parent scopes that have already been processed.
+	filename                 string              // Name of the file being processed.
+	language                 string              // Name of the language the source was parsed as.
+	source                   []byte              // Source code content as a byte array.
+	encoding                 string              // Encoding the source was transcoded from to UTF-8, or "" if it was not.
+	tree                     *sitter.Tree        // Syntax tree parsed from source.
+	color                    bool                // Whether to use color for highlighted output.
+	colorReset               bool                // Whether to emit an ANSI reset line before colored output.
+	matchColors              []string            // ANSI escape sequences used to highlight matches, by pattern.
+	syntaxHighlight          bool                // Whether to color shown lines by syntax.
+	syntaxColors             map[string]string   // ANSI escape sequences used for syntax highlighting, by capture name.
+	syntaxLines              map[int]string      // Syntax-highlighted lines, computed on first use.
+	showTrailingEmptyLine    bool                // Whether to render the empty line after a trailing newline.
+	verbose                  bool                // Whether to enable verbose output for debugging.
+	showLineNumber           bool                // Whether to include line numbers in the output.
+	showLastLine             bool                // Whether to always include the larger context's last line in the output.
+	margin                   int                 // Number of lines to include as a margin at the top of the output.
+	markLOIs                 bool                // Whether to visually mark lines of interest (LOI).
+	headerMax                int                 // Maximum number of header lines to display.
+	loiPad                   int                 // Number of lines of padding around lines of interest.
+	loiPadAfter              int                 // Number of lines of padding after lines of interest, if more than loiPad.
+	loiPadBefore             int                 // Number of lines of padding before lines of interest, if more than loiPad.
+	showTopOfFileParentScope bool                // Whether to include the parent scope starting from the top of the file.
+	parentContext            bool                // Whether to include parent context in the output.
+	showPreamble             bool                // Whether to show the package clause and imports along with lines of interest.
+	showBreadcrumbs          bool                // Whether to show a breadcrumb line above each run of shown lines.
+	showScopeKinds           bool                // Whether to label the first line of each shown scope with its kind.
+	showChildContext         bool                // Whether to include child context in the output.
+	showClosingLines         bool                // Whether to show the closing lines of the scopes whose first line is shown.
+	showDocComments          bool                // Whether to show the doc comments of declarations that are lines of interest.
+	expandFullScope          bool                // Whether to show the whole named scope enclosing each line of interest.
+	signatureOnly            bool                // Whether parent scopes show only their signature instead of header lines.
+	strictParse              bool                // Whether to show only the lines of interest when the parse is badly broken.
+	headerAnnotations        bool                // Whether headers extend over the decorators, annotations, and comments above them.
+	maxOutputBytes           int                 // Maximum size of the formatted output in bytes, or 0 for no limit.
+	maxOutputTokens          int                 // Maximum estimated tokens in the formatted output, or 0 for no limit.
+	lines                    []string            // Source code split into individual lines.
+	lineStarts               []int               // Byte offset in source at which each line starts.
+	crlfLines                map[int]struct{}    // Lines ending in "\r\n" in the source; their "\r" is not part of lines.
+	preserveLineEndings      bool                // Whether to render lines with their original line endings.
+	numLines                 int                 // Total number of lines in the source code (including an optional trailing newline adjustment).
+	outputLines              map[int]string      // Map of output lines, optionally with highlights.
+	scopes                   []map[int]struct{}  // Tracks scope relationships by line.
+	header                   [][]int             // Each element is a slice representing [startLine, endLine] of headers.
+	nodes                    [][]*sitter.Node    // Tracks parse-tree nodes indexed by their start line.
+	showLines                map[int]struct{}    // Lines to show in the final output.
+	linesOfInterest          map[int]struct{}    // Lines explicitly marked as "lines of interest" (LOI).
+	linePriority             map[int]int         // Importance of each shown line when trimming to a budget; lower is more important.
+	doneParentScopes         map[int]struct{}    // Tracks parent scopes that have already been processed.
+	contextAdded             bool                // Whether AddContext has been called.
+	nodeKinds                map[string]int      // Counts of named parse-tree nodes by kind.
+	nodeCount                int                 // Number of parse-tree nodes walked.
+	maxDepth                 int                 // Depth of the deepest parse-tree node walked; the root has depth 0.
+	parseTime                time.Duration       // Time taken to parse the source.
+	maxWalkDepth             int                 // Depth below which parse-tree nodes are not walked, or 0 for no limit.
+	maxNodesPerLine          int                 // Maximum number of parse-tree nodes recorded per start line, or 0 for no limit.
+	annotationStarts         map[int]int         // Start line of the annotation or comment ending on each line, if any.
+	matchSpans               map[int][]matchSpan // Spans matched by Grep on each line.
+	showMatchLocation        bool                // Whether to prefix shown lines with their file:line:col location.
+	tabWidth                 int                 // Distance between tab stops when expanding tabs in the output, or 0 to keep tabs.
+	lineHook                 LineHook            // Called for each rendered line to produce its gutter, or nil.
+	hyperlinkFormat          string              // URL template the line numbers link to, or "" for none.
 }
 
 // TreeContextOptions specifies various options for initializing TreeContext.
 type TreeContextOptions struct {
-	Color                    bool // Use colored output for matches or highlights.
-	HeaderMax                int  // Maximum number of header lines to display.
-	LinesOfInterestPadding   int  // Number of lines of padding around each line of interest.
-	MarginPadding            int  // Number of lines to add as a margin at the top of the output.
-	MarkLinesOfInterest      bool // Visually mark lines of interest (LOI) in the output.
-	ShowChildContext         bool // Show the child scope of lines of interest in the output.
-	ShowLastLine             bool // Always include the overall context's last line in the output.
-	ShowLineNumber           bool // Include line numbers in the output.
-	ShowParentContext        bool // Show the parent scope of lines of interest in the output.
-	ShowTopOfFileParentScope bool // Always include the top-most parent scope from the file's beginning.
-	Verbose                  bool // Enable verbose mode for additional debugging or insights.
+	Color                        bool              // Use colored output for matches or highlights.
+	ColorReset                   bool              // Emit an ANSI reset line at the start of colored output.
+	DetectEncoding               bool              // Transcode UTF-16 sources with a byte order mark, and latin-1 sources that are not valid UTF-8, to UTF-8 before parsing; see DecodeSource.
+	ExpandFullScope              bool              // Show the whole innermost named scope, such as the function or method, enclosing each line of interest.
+	HeaderAnnotations            bool              // Extend headers upward over the decorators, annotations, and comments directly above them.
+	HeaderMax                    int               // Maximum number of header lines to display.
+	HyperlinkFormat              string            // With ShowLineNumber, link line numbers to this URL template with OSC 8 escape sequences; see HyperlinkURL.
+	Language                     string            // Name of the language to parse the source as, e.g. "go"; detected from the source and file name if empty.
+	LineHook                     LineHook          // Called for each line Format renders; its result is written before the line, e.g. a custom gutter.
+	LinesOfInterestPadding       int               // Number of lines of padding around each line of interest.
+	LinesOfInterestPaddingAfter  int               // Number of lines of padding after each line of interest, if more than LinesOfInterestPadding.
+	LinesOfInterestPaddingBefore int               // Number of lines of padding before each line of interest, if more than LinesOfInterestPadding.
+	MarginPadding                int               // Number of lines to add as a margin at the top of the output.
+	MarkLinesOfInterest          bool              // Visually mark lines of interest (LOI) in the output.
+	MaxNodesPerLine              int               // Record at most this many parse-tree nodes starting on each line, keeping the outermost; 0 means no limit.
+	MaxOutputBytes               int               // Trim the least important context until Format's output is at most this many bytes; 0 means no limit.
+	MaxOutputTokens              int               // Trim the least important context until Format's output is at most this many estimated tokens; 0 means no limit.
+	MaxWalkDepth                 int               // Do not walk parse-tree nodes nested deeper than this, leaving their lines to the enclosing scopes; 0 means no limit.
+	MatchColors                  []string          // ANSI escape sequences used to highlight the matches of each pattern, in order; defaults to a built-in palette.
+	PreserveLineEndings          bool              // Render source lines ending in "\r\n" with that ending rather than "\n".
+	ShowBreadcrumbs              bool              // Show a line naming the file and enclosing definitions above each run of shown lines; see Breadcrumb.
+	ShowChildContext             bool              // Show the child scope of lines of interest in the output.
+	ShowClosingLines             bool              // Show the closing line, e.g. a closing brace, of every scope whose first line is shown.
+	ShowDocComments              bool              // Show the doc comments above, and docstrings within, declarations that are lines of interest.
+	ShowLastLine                 bool              // Always include the overall context's last line in the output.
+	ShowLineNumber               bool              // Include line numbers in the output.
+	ShowMatchLocation            bool              // Prefix shown lines with "file:line:", and matched lines with "file:line:col:", for editors to jump to.
+	ShowParentContext            bool              // Show the parent scope of lines of interest in the output.
+	ShowPreamble                 bool              // Show the package clause and imports of the file along with any line of interest.
+	ShowScopeKinds               bool              // Label the first line of each shown scope with its kind, e.g. "[func]" or "[class]".
+	ShowTopOfFileParentScope     bool              // Always include the top-most parent scope from the file's beginning.
+	ShowTrailingEmptyLine        bool              // Render the empty line that follows a trailing newline as a line of its own.
+	SignatureOnly                bool              // Show only the signature lines of parent scopes, with their bodies collapsed.
+	StrictParse                  bool              // Show only the lines of interest, as plain grep does, when the parse is badly broken; see ParseBroken.
+	SyntaxColors                 map[string]string // ANSI escape sequences used for syntax highlighting, by highlight capture name such as "keyword" or "string"; defaults to a built-in palette.
+	SyntaxHighlight              bool              // With Color, color the keywords, strings, comments, etc. of shown lines using the language's highlight query.
+	TabWidth                     int               // Expand tabs in Format's output to spaces, with a tab stop every this many columns; 0 keeps tabs.
+	Verbose                      bool              // Enable verbose mode for additional debugging or insights.
 }
 
 // NewTreeContext is the Go-equivalent constructor for TreeContext.
 // It initializes the context for analyzing and working with source code.
 func NewTreeContext(filename string, source []byte, options TreeContextOptions) (*TreeContext, error) {
-	// Get the language from the filename.
-	// Determines the programming language to use for parsing based on the file extension.
-	lang, _, err := GetLanguageFromFileName(filename)
+	return NewTreeContextCtx(context.Background(), filename, source, options)
+}
+
+// NewTreeContextCtx is like NewTreeContext, but gives up parsing once ctx is done, returning
+// ctx.Err(), so that pathological files cannot hold up the caller.
+func NewTreeContextCtx(ctx context.Context, filename string, source []byte, options TreeContextOptions) (*TreeContext, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// Transcode the source to UTF-8 if asked to, then refuse binary content, which would only
+	// parse into errors.
+	var encoding string
+	if options.DetectEncoding {
+		source, encoding = DecodeSource(source)
+	}
+	if IsBinary(source) {
+		return nil, ErrorBinaryFile
+	}
+
+	// Get the language from the options, or else from the filename.
+	// Determines the programming language to use for parsing based on an in-file directive or the file extension.
+	var lang *sitter.Language
+	var langName string
+	var err error
+	if options.Language != "" {
+		langName = strings.ToLower(options.Language)
+		lang, err = getLanguage(langName)
+	} else {
+		lang, langName, err = GetLanguageFromSource(filename, source)
+	}
 	if err != nil {
 		return nil, err // Return an error if the file type cannot be recognized.
 	}
 
 	// Return an error if the language is not supported.
 	if lang == nil {
-		return nil, fmt.Errorf("unrecognized or unsupported file type (%s)", filename)
+		return nil, fmt.Errorf("%w (%s)", ErrorUnsupportedLanguage, filename)
 	}
 
 	// Initialize Tree-sitter parser for parsing source code into an abstract syntax tree (AST).
 	parser := sitter.NewParser()
+	defer parser.Close()
 	parser.SetLanguage(lang) // Set the parser's language to match the file type.
 
-	// Parse the source code into a syntax tree.
+	// Parse the source code into a syntax tree, letting ctx stop the parser through its
+	// cancellation flag, which is unset by default. Parser.ParseCtx is not used as its watcher
+	// may still read the flag through the parser once it has been closed.
+	var cancelled uintptr
+	parser.SetCancellationFlag(&cancelled)
+	defer parser.SetCancellationFlag(nil)
+	stop := context.AfterFunc(ctx, func() { atomic.StoreUintptr(&cancelled, 1) })
+	defer stop()
+	parseStart := time.Now()
 	tree := parser.Parse(source, nil)
+	parseTime := time.Since(parseStart)
+	if tree == nil {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("parsing %s failed", filename)
+	}
 
-	// Retrieve the root node of the syntax tree for traversal.
-	rootNode := tree.RootNode()
+	tc := newTreeContext(filename, langName, source, tree, options)
+	tc.encoding = encoding
+	tc.parseTime = parseTime
 
+	// Return the initialized TreeContext object.
+	return tc, nil
+}
+
+// NewTreeContextFromReader is like NewTreeContext, but reads the source from r.
+func NewTreeContextFromReader(filename string, r io.Reader, options TreeContextOptions) (*TreeContext, error) {
+	source, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return NewTreeContext(filename, source, options)
+}
+
+// NewTreeContextFromTree creates a TreeContext for source from a tree already parsed from it,
+// e.g. by an editor, rather than parsing it again. The language is that of the tree; Language
+// returns "" if it is not one grep-ast knows. The tree is used as is, so DetectEncoding and the
+// Language option have no effect, and it must not be edited or closed while the TreeContext is in use.
+func NewTreeContextFromTree(filename string, tree *sitter.Tree, source []byte, options TreeContextOptions) *TreeContext {
+	return newTreeContext(filename, languageName(tree.Language()), source, tree, options)
+}
+
+// newTreeContext creates a TreeContext for source, parsed as the named language into tree.
+func newTreeContext(filename, langName string, source []byte, tree *sitter.Tree, options TreeContextOptions) *TreeContext {
 	// Split the source code into lines for easier processing.
 	lines := strings.Split(string(source), "\n")
 
 	// Determine the total number of lines
 	numLines := len(lines)
 
-	// Initialize scopes, headers, and nodes for tracking relationships and parsing metadata.
-	scopes := make([]map[int]struct{}, numLines)
-	header := make([][]int, numLines)         // Track start and end lines for each header.
-	nodes := make([][]*sitter.Node, numLines) // Track AST nodes by their starting line.
-
-	for i := 0; i <= numLines-1; i++ {
-		scopes[i] = make(map[int]struct{})
-		header[i] = []int{0, 0}
-		nodes[i] = []*sitter.Node{}
+	// Record the byte offset at which each line starts, then drop the carriage returns of CRLF
+	// line endings so that lines hold only their text.
+	lineStarts := make([]int, numLines)
+	crlf := make(map[int]struct{})
+	offset := 0
+	for i, line := range lines {
+		lineStarts[i] = offset
+		offset += len(line) + 1
+		if strings.HasSuffix(line, "\r") && i < numLines-1 {
+			lines[i] = line[:len(line)-1]
+			crlf[i] = struct{}{}
+		}
 	}
 
-	// Create and populate the TreeContext object with initialized values.
+	// Create the TreeContext object with the parsed source, then apply the options.
 	tc := &TreeContext{
-		filename:                 filename,
-		source:                   source,
-		color:                    options.Color,
-		verbose:                  options.Verbose,
-		showLineNumber:           options.ShowLineNumber,
-		parentContext:            options.ShowParentContext,
-		showChildContext:         options.ShowChildContext,
-		showLastLine:             options.ShowLastLine,
-		margin:                   options.MarginPadding,
-		markLOIs:                 options.MarkLinesOfInterest,
-		headerMax:                options.HeaderMax,
-		loiPad:                   options.LinesOfInterestPadding,
-		showTopOfFileParentScope: options.ShowTopOfFileParentScope,
-		lines:                    lines,
-		numLines:                 numLines,
-		outputLines:              make(map[int]string),
-		scopes:                   scopes,
-		header:                   header,
-		nodes:                    nodes,
-		showLines:                make(map[int]struct{}),
-		linesOfInterest:          make(map[int]struct{}),
-		doneParentScopes:         make(map[int]struct{}),
+		filename:         filename,
+		language:         langName,
+		source:           source,
+		tree:             tree,
+		lines:            lines,
+		lineStarts:       lineStarts,
+		crlfLines:        crlf,
+		numLines:         numLines,
+		outputLines:      make(map[int]string),
+		showLines:        make(map[int]struct{}),
+		linesOfInterest:  make(map[int]struct{}),
+		linePriority:     make(map[int]int),
+		doneParentScopes: make(map[int]struct{}),
+		matchSpans:       make(map[int][]matchSpan),
 	}
+	tc.applyOptions(options)
 
 	// Walk through the parse tree to populate headers, scopes, and nodes.
-	tc.walkTree(rootNode, 0)
+	tc.walk()
+
+	return tc
+}
+
+// applyOptions sets the options of tc. DetectEncoding and Language only apply when the source is parsed.
+func (tc *TreeContext) applyOptions(options TreeContextOptions) {
+	tc.color = options.Color
+	tc.colorReset = options.ColorReset
+	tc.matchColors = options.MatchColors
+	tc.syntaxHighlight = options.SyntaxHighlight
+	tc.syntaxColors = options.SyntaxColors
+	tc.showTrailingEmptyLine = options.ShowTrailingEmptyLine
+	tc.verbose = options.Verbose
+	tc.showLineNumber = options.ShowLineNumber
+	tc.parentContext = options.ShowParentContext
+	tc.showPreamble = options.ShowPreamble
+	tc.showScopeKinds = options.ShowScopeKinds
+	tc.showChildContext = options.ShowChildContext
+	tc.showClosingLines = options.ShowClosingLines
+	tc.showDocComments = options.ShowDocComments
+	tc.showBreadcrumbs = options.ShowBreadcrumbs
+	tc.expandFullScope = options.ExpandFullScope
+	tc.signatureOnly = options.SignatureOnly
+	tc.strictParse = options.StrictParse
+	tc.headerAnnotations = options.HeaderAnnotations
+	tc.maxOutputBytes = options.MaxOutputBytes
+	tc.maxOutputTokens = options.MaxOutputTokens
+	tc.showLastLine = options.ShowLastLine
+	tc.margin = options.MarginPadding
+	tc.markLOIs = options.MarkLinesOfInterest
+	tc.headerMax = options.HeaderMax
+	tc.loiPad = options.LinesOfInterestPadding
+	tc.loiPadAfter = options.LinesOfInterestPaddingAfter
+	tc.loiPadBefore = options.LinesOfInterestPaddingBefore
+	tc.lineHook = options.LineHook
+	tc.hyperlinkFormat = options.HyperlinkFormat
+	tc.showTopOfFileParentScope = options.ShowTopOfFileParentScope
+	tc.preserveLineEndings = options.PreserveLineEndings
+	tc.maxWalkDepth = options.MaxWalkDepth
+	tc.maxNodesPerLine = options.MaxNodesPerLine
+	tc.showMatchLocation = options.ShowMatchLocation
+	tc.tabWidth = options.TabWidth
+}
+
+// walk walks the parse tree to populate scopes, headers, and nodes, discarding those of any
+// earlier walk.
+func (tc *TreeContext) walk() {
+	// Initialize scopes, headers, and nodes for tracking relationships and parsing metadata.
+	tc.scopes = make([]map[int]struct{}, tc.numLines)
+	tc.header = make([][]int, tc.numLines)         // Track start and end lines for each header.
+	tc.nodes = make([][]*sitter.Node, tc.numLines) // Track AST nodes by their starting line.
+	for i := 0; i <= tc.numLines-1; i++ {
+		tc.scopes[i] = make(map[int]struct{})
+		tc.header[i] = []int{0, 0}
+		tc.nodes[i] = []*sitter.Node{}
+	}
+	tc.nodeKinds = make(map[string]int)
+	tc.nodeCount = 0
+	tc.maxDepth = 0
+	tc.annotationStarts = make(map[int]int)
+
+	tc.walkTree(tc.tree.RootNode(), 0)
 
 	// Perform additional processing on scopes and headers after tree traversal.
 	tc.postWalkProcessing()
+}
 
-	// Return the initialized TreeContext object.
-	return tc, nil
+// SetOptions replaces the options of tc without parsing the source again, so that the same
+// search can be rendered several ways. Lines of interest and matches are kept. If AddContext has
+// been called, the context is gathered again for the new options, ready for Format. Scopes are
+// only detected again if an option affecting them changed, such as HeaderMax or MaxWalkDepth.
+// DetectEncoding and Language have no effect after the context is created.
+func (tc *TreeContext) SetOptions(options TreeContextOptions) {
+	rewalk := options.HeaderMax != tc.headerMax ||
+		options.HeaderAnnotations != tc.headerAnnotations ||
+		options.ShowDocComments != tc.showDocComments ||
+		options.MaxWalkDepth != tc.maxWalkDepth ||
+		options.MaxNodesPerLine != tc.maxNodesPerLine
+	tc.applyOptions(options)
+	if rewalk {
+		tc.walk()
+	}
+
+	// Match highlighting depends on the colors.
+	tc.outputLines = make(map[int]string)
+	if tc.color {
+		for i, spans := range tc.matchSpans {
+			tc.outputLines[i] = highlightSpans(tc.lines[i], spans, tc.palette())
+		}
+	}
+	tc.syntaxLines = nil
+
+	if tc.contextAdded {
+		tc.ClearContext()
+		tc.AddContext()
+	}
+}
+
+// ClearContext forgets the lines shown around the lines of interest, so that nothing is shown
+// until AddContext is called again. Lines of interest and matches are kept.
+func (tc *TreeContext) ClearContext() {
+	tc.showLines = make(map[int]struct{})
+	tc.linePriority = make(map[int]int)
+	tc.doneParentScopes = make(map[int]struct{})
+	tc.contextAdded = false
+}
+
+// ResetLinesOfInterest forgets the lines of interest, the matches found by Grep, and the context
+// around them, so that the TreeContext can serve another search of the same file.
+func (tc *TreeContext) ResetLinesOfInterest() {
+	tc.linesOfInterest = make(map[int]struct{})
+	tc.matchSpans = make(map[int][]matchSpan)
+	tc.outputLines = make(map[int]string)
+	tc.ClearContext()
+}
+
+// Clone returns a copy of tc with its own lines of interest, matches, context, and options,
+// sharing the parsed source, tree, and scopes, which are never changed once detected. Clones of
+// the same TreeContext can be searched and rendered concurrently, so that a cached parse serves
+// several queries at once; a single TreeContext is not safe for concurrent use. Clone itself may
+// be called concurrently, as long as tc is not being changed.
+func (tc *TreeContext) Clone() *TreeContext {
+	c := *tc
+	c.linesOfInterest = maps.Clone(tc.linesOfInterest)
+	c.showLines = maps.Clone(tc.showLines)
+	c.linePriority = maps.Clone(tc.linePriority)
+	c.doneParentScopes = maps.Clone(tc.doneParentScopes)
+	c.outputLines = maps.Clone(tc.outputLines)
+	c.matchSpans = make(map[int][]matchSpan, len(tc.matchSpans))
+	for i, spans := range tc.matchSpans {
+		// Grep appends to the spans of a line, which must not write into the original's array.
+		c.matchSpans[i] = slices.Clip(spans)
+	}
+	return &c
+}
+
+// Language returns the name of the language the source was parsed as, e.g. "go" or "python".
+func (tc *TreeContext) Language() string {
+	return tc.language
 }
 
 // postWalkProcessing sets header ranges and optionally prints scopes.
@@ -171,31 +440,6 @@ func (tc *TreeContext) postWalkProcessing() {
 	}
 }
 
-// Grep finds lines matching a pattern and highlights them.
-func (tc *TreeContext) Grep(pat string, ignoreCase bool) map[int]struct{} {
-	found := make(map[int]struct{})
-	if ignoreCase {
-		// Go's regex doesn't have "IGNORECASE" as a flag (like Python),
-		// you compile different patterns or use (?i).
-		pat = "(?i)" + pat
-	}
-	re := regexp.MustCompile(pat)
-
-	for i, line := range tc.lines {
-		if re.FindStringIndex(line) != nil {
-			// highlight
-			if tc.color {
-				highlighted := re.ReplaceAllStringFunc(line, func(m string) string {
-					return fmt.Sprintf("\033[1;31m%s\033[0m", m)
-				})
-				tc.outputLines[i] = highlighted
-			}
-			found[i] = struct{}{}
-		}
-	}
-	return found
-}
-
 // AddLinesOfInterest adds lines of interest.
 func (tc *TreeContext) AddLinesOfInterest(lineNums map[int]struct{}) {
 	for ln := range lineNums {
@@ -205,21 +449,39 @@ func (tc *TreeContext) AddLinesOfInterest(lineNums map[int]struct{}) {
 
 // AddContext expands lines to show (showLines) based on linesOfInterest.
 func (tc *TreeContext) AddContext() {
+	_ = tc.AddContextCtx(context.Background())
+}
+
+// AddContextCtx is like AddContext, but stops gathering context once ctx is done, returning
+// ctx.Err(). The context gathered so far is kept.
+func (tc *TreeContext) AddContextCtx(ctx context.Context) error {
+	tc.contextAdded = true
 	if len(tc.linesOfInterest) == 0 {
-		return
+		return nil
 	}
 
+	// Lines of interest are visited in order, since how much context each adds can depend on
+	// what is already shown, and identical inputs must render identical output.
+	lois := mapKeysSorted(tc.linesOfInterest)
+
 	// Ensure all linesOfInterest are in showLines
-	for line := range tc.linesOfInterest {
+	for _, line := range lois {
 		tc.showLines[line] = struct{}{}
 	}
+	tc.setPriority(priorityLineOfInterest)
+
+	// Scopes cannot be trusted in a badly broken parse, so show the lines of interest alone.
+	if tc.strictParse && tc.ParseBroken() {
+		return nil
+	}
 
 	// Add padding lines around each LOI
-	if tc.loiPad > 0 {
+	before, after := max(tc.loiPad, tc.loiPadBefore), max(tc.loiPad, tc.loiPadAfter)
+	if before > 0 || after > 0 {
 		var toAdd []int
 		for line := range tc.showLines {
-			start := line - tc.loiPad
-			end := line + tc.loiPad
+			start := line - before
+			end := line + after
 			for nl := start; nl <= end; nl++ {
 				if nl < 0 || nl >= tc.numLines {
 					continue
@@ -230,6 +492,7 @@ func (tc *TreeContext) AddContext() {
 		for _, x := range toAdd {
 			tc.showLines[x] = struct{}{}
 		}
+		tc.setPriority(priorityOther)
 	}
 
 	// Optionally add bottom line (plus parent context)
@@ -237,22 +500,52 @@ func (tc *TreeContext) AddContext() {
 		bottomLine := tc.numLines - 1
 		tc.showLines[bottomLine] = struct{}{}
 		tc.addParentScopes(bottomLine)
+		tc.setPriority(priorityOther)
+	}
+
+	// Add the doc comments of declarations
+	if tc.showDocComments {
+		for _, i := range lois {
+			tc.addDocComments(i)
+		}
+		tc.setPriority(priorityHeader)
 	}
 
 	// Add parent contexts
 	if tc.parentContext {
-		for i := range tc.linesOfInterest {
+		for _, i := range lois {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
 			tc.addParentScopes(i)
 		}
+		tc.setPriority(priorityParent)
 	}
 
 	// Add child contexts
 	// NOTE: This is where we fix partial expansions. If you want the entire function body,
 	// you can remove or adjust the logic in addChildContext.
 	if tc.showChildContext {
-		for i := range tc.linesOfInterest {
+		for _, i := range lois {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
 			tc.addChildContext(i)
 		}
+		tc.setPriority(priorityChild)
+	}
+
+	// Show whole enclosing functions, methods, and classes
+	if tc.expandFullScope {
+		for _, i := range lois {
+			tc.addFullScope(i)
+		}
+		tc.setPriority(priorityChild)
+	}
+
+	// Add the package clause and imports
+	if tc.showPreamble {
+		tc.addPreamble()
 	}
 
 	// Add top margin lines
@@ -262,8 +555,15 @@ func (tc *TreeContext) AddContext() {
 		}
 	}
 
+	// Balance the shown scopes with their closing lines
+	if tc.showClosingLines {
+		tc.addClosingLines()
+	}
+
 	// Close small gaps between lines to produce a smoother snippet
 	tc.closeSmallGaps()
+	tc.setPriority(priorityOther)
+	return nil
 }
 
 // addChildContext tries to show a child scope for the line i (e.g. function body)
@@ -327,6 +627,55 @@ func (tc *TreeContext) addChildContext(i int) {
 	}
 }
 
+// addFullScope shows every line of the innermost named scope enclosing line i, such as the
+// function or method it lies in, including its annotations if headers extend over them.
+func (tc *TreeContext) addFullScope(i int) {
+	for _, scope := range tc.ParentChain(i) {
+		if scope.Name == "" {
+			continue
+		}
+		start := scope.Start
+		if tc.headerAnnotations {
+			start = tc.annotatedStart(start)
+		}
+		for line := start; line <= scope.End && line < tc.numLines; line++ {
+			tc.showLines[line] = struct{}{}
+		}
+		return
+	}
+}
+
+// addClosingLines shows the closing line of the scope starting on each shown line, if any.
+func (tc *TreeContext) addClosingLines() {
+	for _, line := range mapKeysSorted(tc.showLines) {
+		if end := tc.closingLine(line); end >= 0 {
+			tc.showLines[end] = struct{}{}
+		}
+	}
+}
+
+// closingLine returns the line closing the scope starting at line start, or -1 if there is
+// none. A scope is closed by a line starting with the token ending it, such as a closing brace,
+// "end", or "done"; scopes ending in other code, such as a Python function body, have none.
+func (tc *TreeContext) closingLine(start int) int {
+	node := tc.scopeNode(start)
+	if node == nil {
+		return -1
+	}
+	// Find the last token of the scope.
+	for node.ChildCount() > 0 {
+		node = node.Child(node.ChildCount() - 1)
+	}
+	end := int(node.StartPosition().Row)
+	if node.IsNamed() || end <= start || end >= len(tc.lines) {
+		return -1
+	}
+	if !isBlank(tc.lines[end][:node.StartPosition().Column]) {
+		return -1
+	}
+	return end
+}
+
 // findAllChildren gathers all descendants (recursive)
 func (tc *TreeContext) findAllChildren(node *sitter.Node) []*sitter.Node {
 	out := []*sitter.Node{node}
@@ -374,6 +723,36 @@ func (tc *TreeContext) getScopeBoundry(i int) scopeBoundry {
 	return scopeBoundry{start: i, end: i}
 }
 
+// scopeEnd returns the last line of the largest node, other than the root node, that starts
+// at line start. It returns -1 if no such node exists.
+func (tc *TreeContext) scopeEnd(start int) int {
+	end := -1
+	if start < 0 || start >= len(tc.nodes) {
+		return end
+	}
+	for _, node := range tc.nodes[start] {
+		if node.Parent() == nil {
+			continue
+		}
+		end = max(end, int(node.EndPosition().Row))
+	}
+	return end
+}
+
+// scopeNode returns the largest node, other than the root node, that starts at line start,
+// or nil if there is none.
+func (tc *TreeContext) scopeNode(start int) *sitter.Node {
+	if start < 0 || start >= len(tc.nodes) {
+		return nil
+	}
+	for _, node := range tc.nodes[start] {
+		if node.Parent() != nil {
+			return node
+		}
+	}
+	return nil
+}
+
 // getLastLineOfScope finds the last line number of a code block starting at line i.
 // It iterates over all syntax tree nodes on line i and determines the maximum end line.
 func (tc *TreeContext) getLastLineOfScope(i int) int {
@@ -463,24 +842,38 @@ func (tc *TreeContext) closeSmallGaps() {
 // if the first line is NOT in showLines, replicating the Python code's
 // "dots = not (0 in self.show_lines)" behavior.
 func (tc *TreeContext) Format() string {
-	if len(tc.showLines) == 0 {
+	return tc.render(tc.renderedLines())
+}
+
+// renderedLines returns the shown lines that Format renders: all of them, or the most important
+// ones when an output budget is set.
+func (tc *TreeContext) renderedLines() map[int]struct{} {
+	if tc.maxOutputBytes > 0 || tc.maxOutputTokens > 0 {
+		return tc.budgetLines()
+	}
+	return tc.showLines
+}
+
+// render renders the given lines of the source, with an ellipsis in place of each run of skipped lines.
+func (tc *TreeContext) render(showLines map[int]struct{}) string {
+	if len(showLines) == 0 {
 		return ""
 	}
 
 	var sb strings.Builder
 
 	// Optional color reset at the start
-	if tc.color {
+	if tc.color && tc.colorReset {
 		sb.WriteString("\033[0m\n")
 	}
 
 	// If the first line is *not* in showLines, we begin in "ellipses" mode,
 	// so we will print an ellipsis when we next skip lines.
-	_, firstLineShown := tc.showLines[0]
+	_, firstLineShown := showLines[0]
 	printEllipsis := !firstLineShown
 
-	for i, line := range tc.lines {
-		_, shouldShow := tc.showLines[i]
+	for i, line := range tc.lines[:tc.renderedLineCount()] {
+		_, shouldShow := showLines[i]
 		if !shouldShow {
 			// Print ellipsis once after last shown line
 			if printEllipsis {
@@ -490,13 +883,33 @@ func (tc *TreeContext) Format() string {
 			continue
 		}
 
+		// Locate each run of shown lines with a breadcrumb.
+		if _, prevShown := showLines[i-1]; tc.showBreadcrumbs && (i == 0 || !prevShown) {
+			sb.WriteString(tc.Breadcrumb(i))
+			sb.WriteString(tc.lineEnding(i))
+		}
+
 		// Show the line
+		if tc.lineHook != nil {
+			sb.WriteString(tc.lineHook(tc.lineInfo(i)))
+		}
+		if tc.showMatchLocation {
+			sb.WriteString(tc.matchLocation(i))
+		}
 		spacer := tc.lineOfInterestSpacer(i)
 		oline := tc.highlightedOrOriginalLine(i, line)
+		if tc.showScopeKinds {
+			// Keep the indentation, so that the label sits with the code it describes.
+			indent := len(oline) - len(strings.TrimLeft(oline, " \t"))
+			oline = oline[:indent] + tc.scopeKindLabel(i) + oline[indent:]
+		}
+		if tc.tabWidth > 0 {
+			oline = expandTabs(oline, tc.tabWidth)
+		}
 		if tc.showLineNumber {
-			fmt.Fprintf(&sb, "%3d%s%s\n", i+1, spacer, oline)
+			fmt.Fprintf(&sb, "%s%s%s%s", tc.lineNumber(i), spacer, oline, tc.lineEnding(i))
 		} else {
-			fmt.Fprintf(&sb, "%s%s\n", spacer, oline)
+			fmt.Fprintf(&sb, "%s%s%s", spacer, oline, tc.lineEnding(i))
 		}
 
 		// If we skip lines after this, we want an ellipsis
@@ -506,6 +919,78 @@ func (tc *TreeContext) Format() string {
 	return sb.String()
 }
 
+// lineEnding returns the ending rendered after line i: "\r\n" if it has one in the source and
+// preserveLineEndings is set, otherwise "\n".
+func (tc *TreeContext) lineEnding(i int) string {
+	if _, ok := tc.crlfLines[i]; ok && tc.preserveLineEndings {
+		return "\r\n"
+	}
+	return "\n"
+}
+
+// renderedLineCount returns the number of lines Format renders. The empty line after a trailing
+// newline is not a line of the file and is left out unless showTrailingEmptyLine is set.
+func (tc *TreeContext) renderedLineCount() int {
+	n := len(tc.lines)
+	if n > 1 && tc.lines[n-1] == "" && !tc.showTrailingEmptyLine {
+		n--
+	}
+	return n
+}
+
+// ElidedRange is a run of consecutive lines that Format leaves out, shown as "⋮...".
+type ElidedRange struct {
+	Start int    `json:"start"`           // First elided zero-based line.
+	End   int    `json:"end"`             // Last elided zero-based line.
+	Scope string `json:"scope,omitempty"` // Name of the innermost named scope enclosing the range, if any.
+}
+
+// ElidedRanges returns the runs of lines that Format leaves out, so that callers can request them later.
+// It returns nil when nothing is shown.
+func (tc *TreeContext) ElidedRanges() []ElidedRange {
+	showLines := tc.renderedLines()
+	if len(showLines) == 0 {
+		return nil
+	}
+
+	var ranges []ElidedRange
+	count := tc.renderedLineCount()
+	for i := 0; i < count; i++ {
+		if _, shown := showLines[i]; shown {
+			continue
+		}
+		start := i
+		for i+1 < count {
+			if _, shown := showLines[i+1]; shown {
+				break
+			}
+			i++
+		}
+		ranges = append(ranges, ElidedRange{Start: start, End: i, Scope: tc.scopeName(start, i)})
+	}
+	return ranges
+}
+
+// scopeName returns the name of the innermost named node (e.g. a function or class) enclosing lines [start, end].
+func (tc *TreeContext) scopeName(start, end int) string {
+	if tc.tree == nil {
+		return ""
+	}
+	from := sitter.Point{Row: uint(start)}
+	to := sitter.Point{Row: uint(end), Column: uint(len(tc.lines[end]))}
+	node := tc.tree.RootNode().NamedDescendantForPointRange(from, to)
+	for ; node != nil; node = node.Parent() {
+		if int(node.StartPosition().Row) >= start {
+			// The node lies within the range rather than enclosing it.
+			continue
+		}
+		if name := node.ChildByFieldName("name"); name != nil {
+			return name.Utf8Text(tc.source)
+		}
+	}
+	return ""
+}
+
 // lineOfInterestSpacer returns "│" or "█" (with color if needed)
 func (tc *TreeContext) lineOfInterestSpacer(i int) string {
 	if _, isLOI := tc.linesOfInterest[i]; isLOI && tc.markLOIs {
@@ -517,11 +1002,14 @@ func (tc *TreeContext) lineOfInterestSpacer(i int) string {
 	return "│"
 }
 
-// highlightedOrOriginalLine uses the highlighted version if present
+// highlightedOrOriginalLine uses the match-highlighted version if present, then the syntax-highlighted one if enabled.
 func (tc *TreeContext) highlightedOrOriginalLine(i int, original string) string {
 	if hl, ok := tc.outputLines[i]; ok {
 		return hl
 	}
+	if tc.color && tc.syntaxHighlight {
+		return tc.syntaxHighlightedLine(i)
+	}
 	return original
 }
 
@@ -540,7 +1028,7 @@ func (tc *TreeContext) addParentScopes(i int) {
 	tc.doneParentScopes[i] = struct{}{} // Mark this index as processed.
 
 	// Iterate over all scope start line numbers at index i.
-	for lineNum := range tc.scopes[i] {
+	for _, lineNum := range mapKeysSorted(tc.scopes[i]) {
 		// Retrieve the scope header (expected to be a slice of at least two elements).
 		headerSlice := tc.header[lineNum]
 
@@ -558,6 +1046,22 @@ func (tc *TreeContext) addParentScopes(i int) {
 			continue
 		}
 
+		// Extend the header over the annotations and comments directly above it.
+		if tc.headerAnnotations {
+			headStart = tc.annotatedStart(headStart)
+		}
+
+		// Show just the signature of the scope, leaving its body collapsed.
+		if tc.signatureOnly {
+			if node := tc.scopeNode(lineNum); node != nil {
+				r := tc.headerRange(node)
+				for ln := r[0]; ln <= r[1] && ln < tc.numLines; ln++ {
+					tc.showLines[ln] = struct{}{}
+				}
+			}
+			continue
+		}
+
 		// Show lines within the header if either:
 		// - headStart is non-zero (ensuring it's part of a meaningful scope)
 		// - tc.showTopOfFileParentScope is enabled (forcing top-of-file scopes to be shown)
@@ -582,6 +1086,44 @@ func (tc *TreeContext) addParentScopes(i int) {
 	}
 }
 
+// NodeKindCounts returns the number of named parse-tree nodes of each kind (e.g. "function_declaration",
+// "import_declaration", "ERROR"), giving a cheap fingerprint of the file without reparsing it. Nodes
+// beyond MaxWalkDepth are not counted, so the fingerprints of files are only comparable when
+// gathered with the same MaxWalkDepth.
+func (tc *TreeContext) NodeKindCounts() map[string]int {
+	counts := make(map[string]int, len(tc.nodeKinds))
+	for kind, n := range tc.nodeKinds {
+		counts[kind] = n
+	}
+	return counts
+}
+
+// FileMetadata describes the size of a parsed file and the cost of parsing it, to help find the
+// files that dominate search time.
+type FileMetadata struct {
+	Language    string        `json:"language"`               // Name of the language the file was parsed as.
+	Encoding    string        `json:"encoding,omitempty"`     // Encoding the source was transcoded from to UTF-8, if any; see DecodeSource.
+	SourceBytes int           `json:"source_bytes"`           // Size of the source in bytes, after any transcoding.
+	ParseTime   time.Duration `json:"parse_ns"`               // Time taken to parse the source.
+	NodeCount   int           `json:"node_count"`             // Number of named parse-tree nodes.
+	MaxDepth    int           `json:"max_depth"`              // Depth of the deepest named node; the root has depth 0.
+	ParseErrors int           `json:"parse_errors,omitempty"` // Number of syntax errors; see ParseErrors.
+}
+
+// Metadata returns the size and parse cost of the file. Nodes beyond MaxWalkDepth are not
+// counted.
+func (tc *TreeContext) Metadata() FileMetadata {
+	return FileMetadata{
+		Language:    tc.language,
+		Encoding:    tc.encoding,
+		SourceBytes: len(tc.source),
+		ParseTime:   tc.parseTime,
+		NodeCount:   tc.nodeCount,
+		MaxDepth:    tc.maxDepth,
+		ParseErrors: len(tc.ParseErrors()),
+	}
+}
+
 // walkTree populates scopes, headers, etc.
 func (tc *TreeContext) walkTree(node *sitter.Node, depth int) (int, int) {
 	startLine := int(node.StartPosition().Row)
@@ -591,7 +1133,24 @@ func (tc *TreeContext) walkTree(node *sitter.Node, depth int) (int, int) {
 	if startLine < 0 || startLine >= len(tc.nodes) {
 		return startLine, endLine
 	}
+	// Past the depth limit, nodes are left to the scopes of their ancestors.
+	if tc.maxWalkDepth > 0 && depth > tc.maxWalkDepth {
+		return startLine, endLine
+	}
+	tc.nodeKinds[node.Kind()]++
+	tc.nodeCount++
+	tc.maxDepth = max(tc.maxDepth, depth)
+
+	// Past the per-line limit, nodes are not recorded, but their descendants on later lines are.
+	// The outermost nodes of a line come first and already cover the lines of the later ones.
+	if tc.maxNodesPerLine > 0 && len(tc.nodes[startLine]) >= tc.maxNodesPerLine {
+		tc.walkChildren(node, depth)
+		return startLine, endLine
+	}
 	tc.nodes[startLine] = append(tc.nodes[startLine], node)
+	if tc.headerAnnotations || tc.showDocComments {
+		tc.recordAnnotation(node)
+	}
 
 	// if tc.verbose && node.IsNamed() {
 	// 	textLine := strings.Split(node.Utf8Text(tc.source), "\n")[0]
@@ -622,13 +1181,18 @@ func (tc *TreeContext) walkTree(node *sitter.Node, depth int) (int, int) {
 		tc.scopes[i][startLine] = struct{}{}
 	}
 
+	tc.walkChildren(node, depth)
+
+	return startLine, endLine
+}
+
+// walkChildren walks the named children of node, which is at the given depth.
+func (tc *TreeContext) walkChildren(node *sitter.Node, depth int) {
 	for i := uint(0); i < node.ChildCount(); i++ {
 		if child := node.NamedChild(i); child != nil {
 			tc.walkTree(child, depth+1)
 		}
 	}
-
-	return startLine, endLine
 }
 
 // --- Helper functions ---
@@ -650,9 +1214,44 @@ func mapKeysSorted(m map[int]struct{}) []int {
 	return out
 }
 
-// sortBySize sorts a slice of items by their computed size in descending order.
+// expandTabs replaces the tabs of line with spaces up to the next multiple of width columns.
+// Columns count runes, and ANSI escape sequences such as highlight colors take no columns.
+func expandTabs(line string, width int) string {
+	if !strings.Contains(line, "\t") {
+		return line
+	}
+	var sb strings.Builder
+	col := 0
+	for i := 0; i < len(line); {
+		if line[i] == '\033' && i+1 < len(line) && line[i+1] == '[' {
+			// Copy the escape sequence up to and including its final letter.
+			j := i + 2
+			for j < len(line) && (line[j] < 0x40 || line[j] > 0x7e) {
+				j++
+			}
+			end := min(j+1, len(line))
+			sb.WriteString(line[i:end])
+			i = end
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(line[i:])
+		if r == '\t' {
+			n := width - col%width
+			sb.WriteString(strings.Repeat(" ", n))
+			col += n
+		} else {
+			sb.WriteString(line[i : i+size])
+			col++
+		}
+		i += size
+	}
+	return sb.String()
+}
+
+// sortBySize sorts a slice of items by their computed size in descending order. Items of equal
+// size keep their order, so the result depends only on the input.
 func sortBySize[T any](items []T, getSize func(T) uint) {
-	sort.Slice(items, func(i, j int) bool {
+	sort.SliceStable(items, func(i, j int) bool {
 		return getSize(items[j]) > getSize(items[i])
 	})
 }
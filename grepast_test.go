@@ -2,8 +2,14 @@ package grepast
 
 import (
 	"fmt"
+	"reflect"
+	"regexp"
 	"strings"
+	"sync"
 	"testing"
+
+	sitter "github.com/tree-sitter/go-tree-sitter"
+	sitter_go "github.com/tree-sitter/tree-sitter-go/bindings/go"
 )
 
 // options := TreeContextOptions{
@@ -703,3 +709,853 @@ func TestTreeContext_addParentScopesWithFileTop(t *testing.T) {
 // 	//	25│}
 // 	//	26│
 // }
+
+// TestTreeContext_NodeKindCounts tests the NodeKindCounts method of TreeContext.
+func TestTreeContext_NodeKindCounts(t *testing.T) {
+	tc, err := NewTreeContext("example.go", getExampleSourceCode(), TreeContextOptions{})
+	if err != nil {
+		t.Fatalf("NewTreeContext() error = %v", err)
+	}
+
+	counts := tc.NodeKindCounts()
+
+	expected := map[string]int{
+		"source_file":          1,
+		"import_declaration":   1,
+		"function_declaration": 3,
+		"func_literal":         1,
+	}
+	for kind, want := range expected {
+		if got := counts[kind]; got != want {
+			t.Errorf("NodeKindCounts()[%q] = %d, want %d", kind, got, want)
+		}
+	}
+	if got := counts["ERROR"]; got != 0 {
+		t.Errorf("NodeKindCounts()[%q] = %d, want 0", "ERROR", got)
+	}
+
+	// The returned map is a copy and must not affect the context.
+	counts["source_file"] = 42
+	if got := tc.NodeKindCounts()["source_file"]; got != 1 {
+		t.Errorf("NodeKindCounts() returned shared state, got %d after mutation", got)
+	}
+}
+
+// TestTreeContext_Metadata tests the Metadata method of TreeContext.
+func TestTreeContext_Metadata(t *testing.T) {
+	source := []byte("package p\n\nfunc f() {\n\tg(1)\n}\n")
+	tc, err := NewTreeContext("example.go", source, TreeContextOptions{})
+	if err != nil {
+		t.Fatalf("NewTreeContext() error = %v", err)
+	}
+
+	m := tc.Metadata()
+	if m.Language != "go" || m.SourceBytes != len(source) {
+		t.Errorf("Metadata() = %+v, want language go and %d source bytes", m, len(source))
+	}
+	total := 0
+	for _, n := range tc.NodeKindCounts() {
+		total += n
+	}
+	if m.NodeCount != total {
+		t.Errorf("Metadata().NodeCount = %d, want %d", m.NodeCount, total)
+	}
+	// The call's argument is nested several levels below the root.
+	if m.MaxDepth < 5 {
+		t.Errorf("Metadata().MaxDepth = %d, want at least 5", m.MaxDepth)
+	}
+	if m.ParseTime <= 0 {
+		t.Errorf("Metadata().ParseTime = %v, want > 0", m.ParseTime)
+	}
+}
+
+// TestTreeContext_WalkLimits tests that MaxWalkDepth and MaxNodesPerLine cap the nodes recorded
+// while still rendering context.
+func TestTreeContext_WalkLimits(t *testing.T) {
+	const levels = 200
+	source := []byte("x = " + strings.Repeat("[\n", levels) + "1\n" + strings.Repeat("]", levels) + "\n")
+	loi := levels
+
+	tc, err := NewTreeContext("deep.js", source, TreeContextOptions{})
+	if err != nil {
+		t.Fatalf("NewTreeContext() error = %v", err)
+	}
+	if got := tc.Metadata().MaxDepth; got < levels {
+		t.Fatalf("Metadata().MaxDepth = %d without a limit, want at least %d", got, levels)
+	}
+
+	tc, err = NewTreeContext("deep.js", source, TreeContextOptions{MaxWalkDepth: 10, ShowParentContext: true})
+	if err != nil {
+		t.Fatalf("NewTreeContext() error = %v", err)
+	}
+	if got := tc.Metadata().MaxDepth; got != 10 {
+		t.Errorf("Metadata().MaxDepth = %d with MaxWalkDepth 10, want 10", got)
+	}
+	tc.AddLinesOfInterest(map[int]struct{}{loi: {}})
+	tc.AddContext()
+	if got := tc.Format(); !strings.Contains(got, "│1\n") {
+		t.Errorf("Format() with MaxWalkDepth 10 dropped the line of interest:\n%s", got)
+	}
+
+	tc, err = NewTreeContext("calls.js", []byte("f(g(h(i(j(k(1))))));\n"), TreeContextOptions{MaxNodesPerLine: 3})
+	if err != nil {
+		t.Fatalf("NewTreeContext() error = %v", err)
+	}
+	if got := len(tc.nodes[0]); got != 3 {
+		t.Errorf("recorded %d nodes on line 0 with MaxNodesPerLine 3, want 3", got)
+	}
+	if got := tc.scopeNode(0).Kind(); got != "expression_statement" {
+		t.Errorf("scopeNode(0) = %s, want the outermost node expression_statement", got)
+	}
+}
+
+// TestTreeContext_ElidedRanges tests the ElidedRanges method of TreeContext.
+func TestTreeContext_ElidedRanges(t *testing.T) {
+	tc, err := NewTreeContext("example.go", getExampleSourceCode(), TreeContextOptions{})
+	if err != nil {
+		t.Fatalf("NewTreeContext() error = %v", err)
+	}
+
+	if got := tc.ElidedRanges(); got != nil {
+		t.Errorf("ElidedRanges() before AddContext = %v, want nil", got)
+	}
+
+	// Lines 10 and 17 are comments inside largeScope.
+	tc.AddLinesOfInterest(map[int]struct{}{10: {}, 17: {}})
+	tc.AddContext()
+
+	expected := []ElidedRange{
+		{Start: 0, End: 9},
+		{Start: 11, End: 16, Scope: "largeScope"},
+		{Start: 18, End: 28},
+	}
+
+	got := tc.ElidedRanges()
+	if fmt.Sprint(got) != fmt.Sprint(expected) {
+		t.Errorf("ElidedRanges() = %v, want %v", got, expected)
+	}
+}
+
+// TestTreeContext_FormatEdges tests the leading color reset and trailing empty line options of Format.
+func TestTreeContext_FormatEdges(t *testing.T) {
+	source := []byte("package main\n\nfunc main() {}\n")
+
+	tests := []struct {
+		name    string
+		options TreeContextOptions
+		want    string
+	}{
+		{
+			name:    "defaults",
+			options: TreeContextOptions{},
+			want:    "│package main\n│\n│func main() {}\n",
+		},
+		{
+			name:    "trailing empty line",
+			options: TreeContextOptions{ShowTrailingEmptyLine: true},
+			want:    "│package main\n│\n│func main() {}\n│\n",
+		},
+		{
+			name:    "color without reset",
+			options: TreeContextOptions{Color: true},
+			want:    "│package main\n│\n│func main() {}\n",
+		},
+		{
+			name:    "color with reset",
+			options: TreeContextOptions{Color: true, ColorReset: true},
+			want:    "\033[0m\n│package main\n│\n│func main() {}\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc, err := NewTreeContext("main.go", source, tt.options)
+			if err != nil {
+				t.Fatalf("NewTreeContext() error = %v", err)
+			}
+			tc.AddLinesOfInterest(map[int]struct{}{0: {}, 1: {}, 2: {}, 3: {}})
+			tc.AddContext()
+
+			if got := tc.Format(); got != tt.want {
+				t.Errorf("Format() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestTreeContext_SignatureOnly tests that parent scopes show only their signatures with the SignatureOnly option.
+func TestTreeContext_SignatureOnly(t *testing.T) {
+	tc, err := NewTreeContext("example.go", getExampleSourceCode(), TreeContextOptions{
+		ShowParentContext: true,
+		SignatureOnly:     true,
+		HeaderMax:         10,
+	})
+	if err != nil {
+		t.Fatalf("NewTreeContext() error = %v", err)
+	}
+	tc.AddLinesOfInterest(map[int]struct{}{15: {}, 26: {}})
+	tc.AddContext()
+
+	expected := `⋮...
+│func largeScope() {
+⋮...
+│	// line 5
+⋮...
+│func main() {
+⋮...
+│	go func() {
+│		smallScope()
+⋮...
+`
+	if got := tc.Format(); got != expected {
+		t.Errorf("Format() =\n%s\nwant\n%s", got, expected)
+	}
+}
+
+// TestTreeContext_FormatBudget tests that Format trims the least important context to fit the output budget.
+func TestTreeContext_FormatBudget(t *testing.T) {
+	newContext := func(options TreeContextOptions) *TreeContext {
+		options.ShowParentContext = true
+		options.ShowChildContext = true
+		options.LinesOfInterestPadding = 1
+		options.HeaderMax = 10
+		tc, err := NewTreeContext("example.go", getExampleSourceCode(), options)
+		if err != nil {
+			t.Fatalf("NewTreeContext() error = %v", err)
+		}
+		tc.AddLinesOfInterest(map[int]struct{}{26: {}})
+		tc.AddContext()
+		return tc
+	}
+
+	full := newContext(TreeContextOptions{}).Format()
+
+	for _, budget := range []int{len(full), len(full) - 1, 80, 20} {
+		got := newContext(TreeContextOptions{MaxOutputBytes: budget}).Format()
+		if len(got) > budget {
+			t.Errorf("Format() with MaxOutputBytes %d = %d bytes:\n%s", budget, len(got), got)
+		}
+		if budget == len(full) && got != full {
+			t.Errorf("Format() with a budget that fits = \n%s\nwant\n%s", got, full)
+		}
+		if budget >= 40 && !strings.Contains(got, "\t\tsmallScope()") {
+			t.Errorf("Format() with MaxOutputBytes %d dropped the line of interest:\n%s", budget, got)
+		}
+	}
+
+	// The headers of the enclosing scopes outrank padding lines.
+	got := newContext(TreeContextOptions{MaxOutputBytes: 80}).Format()
+	if !strings.Contains(got, "func main() {") {
+		t.Errorf("Format() with MaxOutputBytes 80 dropped the parent header:\n%s", got)
+	}
+
+	tokens := newContext(TreeContextOptions{MaxOutputTokens: 10}).Format()
+	if EstimateTokens(tokens) > 10 {
+		t.Errorf("Format() with MaxOutputTokens 10 = %d tokens:\n%s", EstimateTokens(tokens), tokens)
+	}
+}
+
+// TestTreeContext_HeaderAnnotations tests that parent headers include the decorators, annotations,
+// and comments directly above the definition only when HeaderAnnotations is set.
+func TestTreeContext_HeaderAnnotations(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		source   string
+		loi      int
+		expected string
+	}{
+		{
+			name:     "Python",
+			filename: "example.py",
+			source: `import os
+
+
+def g():
+    pass
+
+
+# f does things.
+@dec
+def f():
+    a = 1
+    return a
+`,
+			loi: 10,
+			expected: `⋮...
+│# f does things.
+│@dec
+│def f():
+│    a = 1
+│    return a
+`,
+		},
+		{
+			name:     "Rust",
+			filename: "example.rs",
+			source: `fn g() {}
+
+/// f does things.
+#[inline]
+fn f() {
+    let a = 1;
+    a
+}
+`,
+			loi: 6,
+			expected: `⋮...
+│/// f does things.
+│#[inline]
+│fn f() {
+│    let a = 1;
+│    a
+│}
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		for _, annotations := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s/%v", tt.name, annotations), func(t *testing.T) {
+				tc, err := NewTreeContext(tt.filename, []byte(tt.source), TreeContextOptions{
+					ShowParentContext: true,
+					HeaderMax:         10,
+					HeaderAnnotations: annotations,
+				})
+				if err != nil {
+					t.Fatalf("NewTreeContext() error = %v", err)
+				}
+				tc.AddLinesOfInterest(map[int]struct{}{tt.loi: {}})
+				tc.AddContext()
+				got := tc.Format()
+				if annotations && got != tt.expected {
+					t.Errorf("Format() =\n%s\nwant\n%s", got, tt.expected)
+				}
+				if !annotations && strings.Contains(got, "does things") {
+					t.Errorf("Format() without HeaderAnnotations shows the comment:\n%s", got)
+				}
+			})
+		}
+	}
+}
+
+// TestTreeContext_CRLF tests that CRLF line endings are dropped from lines, so that matches
+// anchored at the end of a line work and output has no stray carriage returns, unless
+// PreserveLineEndings is set.
+func TestTreeContext_CRLF(t *testing.T) {
+	source := []byte("package p\r\n\r\nfunc f() {\r\n\ta := \"x\"\n\tb()\r\n}\r\n")
+
+	for _, preserve := range []bool{false, true} {
+		tc, err := NewTreeContext("example.go", source, TreeContextOptions{
+			ShowParentContext:   true,
+			Color:               true,
+			SyntaxHighlight:     true,
+			PreserveLineEndings: preserve,
+		})
+		if err != nil {
+			t.Fatalf("NewTreeContext() error = %v", err)
+		}
+		found, err := tc.GrepWithOptions(`b\(\)$`, GrepOptions{})
+		if err != nil {
+			t.Fatalf("GrepWithOptions() error = %v", err)
+		}
+		if got := fmt.Sprint(mapKeysSorted(found)); got != "[4]" {
+			t.Errorf("GrepWithOptions() = %s, want [4]", got)
+		}
+		expectedMatches := []Match{{Line: 4, Column: 1, EndColumn: 4, StartByte: 36, EndByte: 39, Text: "b()", RuneColumn: 1, EndRuneColumn: 4}}
+		if got := tc.Matches(); !reflect.DeepEqual(got, expectedMatches) {
+			t.Errorf("Matches() = %+v, want %+v", got, expectedMatches)
+		}
+
+		tc.AddLinesOfInterest(found)
+		tc.AddContext()
+		// Syntax highlighting must not carry the carriage returns into the colored lines either.
+		got := regexp.MustCompile("\033\\[[0-9;]*m").ReplaceAllString(tc.Format(), "")
+		expected := "⋮...\n│func f() {\n│\ta := \"x\"\n│\tb()\n│}\n"
+		if preserve {
+			expected = "⋮...\n│func f() {\r\n│\ta := \"x\"\n│\tb()\r\n│}\r\n"
+		}
+		if got != expected {
+			t.Errorf("Format() with PreserveLineEndings %v = %q, want %q", preserve, got, expected)
+		}
+	}
+}
+
+// TestExpandTabs tests that expandTabs aligns to tab stops, counting runes and ignoring ANSI
+// escape sequences.
+func TestExpandTabs(t *testing.T) {
+	tests := []struct {
+		line     string
+		width    int
+		expected string
+	}{
+		{"no tabs", 4, "no tabs"},
+		{"\tx", 4, "    x"},
+		{"ab\tc\td", 4, "ab  c   d"},
+		{"abcd\te", 4, "abcd    e"},
+		{"é\tx", 4, "é   x"},
+		{"\033[1;31mab\033[0m\tc", 4, "\033[1;31mab\033[0m  c"},
+		{"\t\tx", 2, "    x"},
+	}
+	for _, tt := range tests {
+		if got := expandTabs(tt.line, tt.width); got != tt.expected {
+			t.Errorf("expandTabs(%q, %d) = %q, want %q", tt.line, tt.width, got, tt.expected)
+		}
+	}
+}
+
+// TestTreeContext_ExpandFullScope tests that ExpandFullScope shows the whole innermost named
+// scope around each line of interest, and no more.
+func TestTreeContext_ExpandFullScope(t *testing.T) {
+	var body strings.Builder
+	for i := range 30 {
+		fmt.Fprintf(&body, "        x%d = %d\n", i, i)
+	}
+	source := "class C:\n    def before(self):\n        pass\n\n    def f(self):\n" + body.String() +
+		"        return lambda: (\n            x0\n        )\n\n    def after(self):\n        pass\n"
+
+	// The line of interest lies in a lambda, which has no name, inside the method f.
+	tc, err := NewTreeContext("example.py", []byte(source), TreeContextOptions{ExpandFullScope: true})
+	if err != nil {
+		t.Fatalf("NewTreeContext() error = %v", err)
+	}
+	tc.AddLinesOfInterest(map[int]struct{}{36: {}})
+	tc.AddContext()
+
+	var expected []int
+	for i := 4; i <= 37; i++ {
+		expected = append(expected, i)
+	}
+	if got := tc.ShownLines(); fmt.Sprint(got) != fmt.Sprint(expected) {
+		t.Errorf("ShownLines() = %v, want %v", got, expected)
+	}
+}
+
+// TestTreeContext_SetOptions tests rendering one parsed and searched file with several sets of options.
+func TestTreeContext_SetOptions(t *testing.T) {
+	source := []byte("package p\n\nfunc f() {\n\ta()\n\tb()\n\tc()\n}\n")
+	tc, err := NewTreeContext("example.go", source, TreeContextOptions{})
+	if err != nil {
+		t.Fatalf("NewTreeContext() error = %v", err)
+	}
+	tree := tc.Tree()
+	found, err := tc.GrepWithOptions("b", GrepOptions{})
+	if err != nil {
+		t.Fatalf("GrepWithOptions() error = %v", err)
+	}
+	tc.AddLinesOfInterest(found)
+	tc.AddContext()
+
+	tests := []struct {
+		name     string
+		options  TreeContextOptions
+		expected string
+	}{
+		{"plain", TreeContextOptions{}, "⋮...\n│\tb()\n⋮...\n"},
+		{"line numbers and padding", TreeContextOptions{ShowLineNumber: true, LinesOfInterestPadding: 1},
+			"⋮...\n  4│\ta()\n  5│\tb()\n  6│\tc()\n⋮...\n"},
+		{"color and parent", TreeContextOptions{Color: true, ShowParentContext: true, HeaderMax: 10},
+			"⋮...\n│func f() {\n│\ta()\n│\t\033[1;31mb\033[0m()\n│\tc()\n│}\n"},
+		{"plain again", TreeContextOptions{}, "⋮...\n│\tb()\n⋮...\n"},
+	}
+	for _, tt := range tests {
+		tc.SetOptions(tt.options)
+		if got := tc.Format(); got != tt.expected {
+			t.Errorf("%s: Format() = %q, want %q", tt.name, got, tt.expected)
+		}
+	}
+	if tc.Tree() != tree {
+		t.Errorf("SetOptions() replaced the parse tree")
+	}
+}
+
+// TestTreeContext_ResetLinesOfInterest tests serving successive searches from one TreeContext.
+func TestTreeContext_ResetLinesOfInterest(t *testing.T) {
+	source := []byte("package p\n\nfunc f() {\n\ta()\n\tb()\n\tc()\n}\n")
+	tc, err := NewTreeContext("example.go", source, TreeContextOptions{Color: true})
+	if err != nil {
+		t.Fatalf("NewTreeContext() error = %v", err)
+	}
+
+	for _, tt := range []struct{ pattern, expected string }{
+		{`a\(`, "⋮...\n│\t\033[1;31ma(\033[0m)\n⋮...\n"},
+		{`c\(`, "⋮...\n│\t\033[1;31mc(\033[0m)\n⋮...\n"},
+	} {
+		tc.ResetLinesOfInterest()
+		found, err := tc.GrepWithOptions(tt.pattern, GrepOptions{})
+		if err != nil {
+			t.Fatalf("GrepWithOptions() error = %v", err)
+		}
+		tc.AddLinesOfInterest(found)
+		tc.AddContext()
+		if got := tc.Format(); got != tt.expected {
+			t.Errorf("Format() after searching %q = %q, want %q", tt.pattern, got, tt.expected)
+		}
+		if got := len(tc.Matches()); got != 1 {
+			t.Errorf("Matches() after searching %q has %d matches, want 1", tt.pattern, got)
+		}
+	}
+
+	// Clearing the context keeps the lines of interest, ready for AddContext.
+	tc.ClearContext()
+	if got := tc.Format(); got != "" {
+		t.Errorf("Format() after ClearContext() = %q, want empty", got)
+	}
+	if got := fmt.Sprint(tc.LinesOfInterest()); got != "[5]" {
+		t.Errorf("LinesOfInterest() after ClearContext() = %s, want [5]", got)
+	}
+}
+
+// TestNewTreeContextFromTree tests building TreeContexts from a reader and from a pre-parsed tree.
+func TestNewTreeContextFromTree(t *testing.T) {
+	source := []byte("package p\n\nfunc f() {\n\ta()\n\tb()\n}\n")
+	format := func(tc *TreeContext) string {
+		tc.AddLinesOfInterest(map[int]struct{}{3: {}})
+		tc.AddContext()
+		return tc.Format()
+	}
+	want, err := NewTreeContext("example.go", source, TreeContextOptions{})
+	if err != nil {
+		t.Fatalf("NewTreeContext() error = %v", err)
+	}
+	expected := format(want)
+
+	fromReader, err := NewTreeContextFromReader("example.go", strings.NewReader(string(source)), TreeContextOptions{})
+	if err != nil {
+		t.Fatalf("NewTreeContextFromReader() error = %v", err)
+	}
+	if got := format(fromReader); got != expected {
+		t.Errorf("NewTreeContextFromReader() formats %q, want %q", got, expected)
+	}
+
+	parser := sitter.NewParser()
+	defer parser.Close()
+	if err := parser.SetLanguage(sitter.NewLanguage(sitter_go.Language())); err != nil {
+		t.Fatalf("SetLanguage() error = %v", err)
+	}
+	tree := parser.Parse(source, nil)
+	defer tree.Close()
+	fromTree := NewTreeContextFromTree("example.go", tree, source, TreeContextOptions{})
+	if got := fromTree.Language(); got != "go" {
+		t.Errorf("Language() = %q, want %q", got, "go")
+	}
+	if got := format(fromTree); got != expected {
+		t.Errorf("NewTreeContextFromTree() formats %q, want %q", got, expected)
+	}
+}
+
+// TestNewTreeContext_Language tests parsing a source as an explicitly given language.
+func TestNewTreeContext_Language(t *testing.T) {
+	source := []byte("def f():\n    a()\n")
+	tests := []struct {
+		name         string
+		filename     string
+		language     string
+		expectedLang string
+		expectedErr  error
+	}{
+		{"no extension", "template", "python", "python", nil},
+		{"overrides extension", "f.go", "Python", "python", nil},
+		{"unrecognized without language", "template", "", "", ErrorUnrecognizedFiletype},
+		{"unsupported language", "f.py", "cobol", "", ErrorUnsupportedLanguage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc, err := NewTreeContext(tt.filename, source, TreeContextOptions{Language: tt.language})
+			if err != tt.expectedErr {
+				t.Fatalf("NewTreeContext() error = %v, want %v", err, tt.expectedErr)
+			}
+			if err == nil && tc.Language() != tt.expectedLang {
+				t.Errorf("Language() = %q, want %q", tc.Language(), tt.expectedLang)
+			}
+		})
+	}
+}
+
+// TestTreeContext_ShowDocComments tests showing the doc comments of declarations of interest.
+func TestTreeContext_ShowDocComments(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		source   string
+		loi      int
+		expected string
+	}{
+		{
+			name:     "Go",
+			filename: "example.go",
+			source:   "package p\n\n// F does things.\n// It is documented.\nfunc F() {\n\ta()\n}\n",
+			loi:      4,
+			expected: "⋮...\n│// F does things.\n│// It is documented.\n│func F() {\n⋮...\n",
+		},
+		{
+			name:     "Go statement",
+			filename: "example.go",
+			source:   "package p\n\nfunc F() {\n\t// Not a doc comment.\n\ta()\n}\n",
+			loi:      4,
+			expected: "⋮...\n│\ta()\n⋮...\n",
+		},
+		{
+			name:     "Python",
+			filename: "example.py",
+			source:   "# f does things.\n@dec\ndef f():\n    \"\"\"Docstring.\n\n    More.\n    \"\"\"\n    a = 1\n    return a\n",
+			loi:      2,
+			expected: "│# f does things.\n│@dec\n│def f():\n│    \"\"\"Docstring.\n│\n│    More.\n│    \"\"\"\n⋮...\n",
+		},
+		{
+			name:     "JavaScript",
+			filename: "example.js",
+			source:   "const a = 1;\n\n/**\n * f does things.\n */\nfunction f() {\n  return a;\n}\n",
+			loi:      5,
+			expected: "⋮...\n│/**\n│ * f does things.\n│ */\n│function f() {\n⋮...\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc, err := NewTreeContext(tt.filename, []byte(tt.source), TreeContextOptions{ShowDocComments: true})
+			if err != nil {
+				t.Fatalf("NewTreeContext() error = %v", err)
+			}
+			tc.AddLinesOfInterest(map[int]struct{}{tt.loi: {}})
+			tc.AddContext()
+			if got := tc.Format(); got != tt.expected {
+				t.Errorf("Format() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+// TestTreeContext_ShowPreamble tests showing the package clause and imports with matches.
+func TestTreeContext_ShowPreamble(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		source   string
+		loi      int
+		expected string
+	}{
+		{
+			name:     "Go",
+			filename: "example.go",
+			source:   "// Package p does things.\npackage p\n\nimport (\n\t\"fmt\"\n\t\"os\"\n)\n\nfunc f() {\n\tfmt.Println(os.Args)\n}\n",
+			loi:      9,
+			expected: "⋮...\n│package p\n│\n│import (\n│\t\"fmt\"\n│\t\"os\"\n│)\n⋮...\n│\tfmt.Println(os.Args)\n⋮...\n",
+		},
+		{
+			name:     "Python",
+			filename: "example.py",
+			source:   "import os\nfrom sys import (\n    argv,\n)\n\n\ndef f():\n    return os.sep\n",
+			loi:      7,
+			expected: "│import os\n│from sys import (\n│    argv,\n│)\n⋮...\n│    return os.sep\n",
+		},
+		{
+			name:     "No preamble",
+			filename: "example.bash",
+			source:   "#!/bin/sh\n\necho hi\n",
+			loi:      2,
+			expected: "⋮...\n│echo hi\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc, err := NewTreeContext(tt.filename, []byte(tt.source), TreeContextOptions{ShowPreamble: true})
+			if err != nil {
+				t.Fatalf("NewTreeContext() error = %v", err)
+			}
+			tc.AddLinesOfInterest(map[int]struct{}{tt.loi: {}})
+			tc.AddContext()
+			if got := tc.Format(); got != tt.expected {
+				t.Errorf("Format() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+// TestTreeContext_ShowClosingLines tests balancing shown scopes with their closing lines.
+func TestTreeContext_ShowClosingLines(t *testing.T) {
+	body := func(indent string) string { return strings.Repeat(indent+"z()\n", 6) }
+	tests := []struct {
+		name     string
+		filename string
+		source   string
+		loi      int
+		expected string
+	}{
+		{
+			name:     "Go",
+			filename: "example.go",
+			source:   "package p\n\nfunc f() {\n\tfor {\n\t\ta()\n" + body("\t\t") + "\t}\n" + body("\t") + "}\n",
+			loi:      4,
+			expected: "⋮...\n│func f() {\n│\tfor {\n│\t\ta()\n⋮...\n│\t}\n⋮...\n│}\n",
+		},
+		{
+			name:     "Python",
+			filename: "example.py",
+			source:   "import y\n\ndef f():\n    for x in y:\n        a()\n" + body("        ") + body("    "),
+			loi:      4,
+			// Python scopes end in code rather than a closing token, so nothing is added.
+			expected: "⋮...\n│def f():\n│    for x in y:\n│        a()\n" + strings.ReplaceAll(body("        "), "        z", "│        z") + "⋮...\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc, err := NewTreeContext(tt.filename, []byte(tt.source), TreeContextOptions{
+				ShowParentContext: true,
+				SignatureOnly:     true,
+				ShowClosingLines:  true,
+			})
+			if err != nil {
+				t.Fatalf("NewTreeContext() error = %v", err)
+			}
+			tc.AddLinesOfInterest(map[int]struct{}{tt.loi: {}})
+			tc.AddContext()
+			if got := tc.Format(); got != tt.expected {
+				t.Errorf("Format() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+// TestTreeContext_DeterministicOutput tests that identical inputs always render identical output,
+// even when expanding child scopes stops early.
+func TestTreeContext_DeterministicOutput(t *testing.T) {
+	var sb strings.Builder
+	sb.WriteString("package p\n")
+	for f := 0; f < 8; f++ {
+		fmt.Fprintf(&sb, "\nfunc f%d() {\n", f)
+		for s := 0; s < 6; s++ {
+			fmt.Fprintf(&sb, "\tif x%d {\n\t\ty()\n\t\tz()\n\t}\n", s)
+		}
+		sb.WriteString("}\n")
+	}
+	source := []byte(sb.String())
+
+	var first string
+	for run := 0; run < 50; run++ {
+		tc, err := NewTreeContext("example.go", source, TreeContextOptions{ShowParentContext: true, ShowChildContext: true})
+		if err != nil {
+			t.Fatalf("NewTreeContext() error = %v", err)
+		}
+		found, err := tc.GrepWithOptions(`^func |if x[24]`, GrepOptions{})
+		if err != nil {
+			t.Fatalf("GrepWithOptions() error = %v", err)
+		}
+		tc.AddLinesOfInterest(found)
+		tc.AddContext()
+		got := tc.Format()
+		if run == 0 {
+			first = got
+		} else if got != first {
+			t.Fatalf("Format() on run %d = %q, want %q as on the first run", run, got, first)
+		}
+	}
+}
+
+// TestTreeContext_Clone tests that clones of a TreeContext can be searched concurrently without
+// affecting each other or the original.
+func TestTreeContext_Clone(t *testing.T) {
+	options := TreeContextOptions{ShowParentContext: true, Color: true}
+	search := func(tc *TreeContext, pattern string) string {
+		found, err := tc.GrepWithOptions(pattern, GrepOptions{})
+		if err != nil {
+			t.Errorf("GrepWithOptions() error = %v", err)
+		}
+		tc.AddLinesOfInterest(found)
+		tc.AddContext()
+		return tc.Format()
+	}
+
+	patterns := []string{"smallScope", "fmt", "largeScope", "func"}
+	want := make([]string, len(patterns))
+	for i, pattern := range patterns {
+		tc, err := NewTreeContext("example.go", getExampleSourceCode(), options)
+		if err != nil {
+			t.Fatalf("NewTreeContext() error = %v", err)
+		}
+		want[i] = search(tc, pattern)
+	}
+
+	base, err := NewTreeContext("example.go", getExampleSourceCode(), options)
+	if err != nil {
+		t.Fatalf("NewTreeContext() error = %v", err)
+	}
+	got := make([]string, len(patterns))
+	var wg sync.WaitGroup
+	for i, pattern := range patterns {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got[i] = search(base.Clone(), pattern)
+		}()
+	}
+	wg.Wait()
+
+	for i := range patterns {
+		if got[i] != want[i] {
+			t.Errorf("Format() of the clone searching %q = %q, want %q", patterns[i], got[i], want[i])
+		}
+	}
+	if len(base.LinesOfInterest()) != 0 || base.Format() != "" {
+		t.Errorf("searching clones changed the original")
+	}
+
+	// A clone starts with the lines of interest and matches of the original.
+	clone := base.Clone()
+	search(base, "smallScope")
+	if len(clone.LinesOfInterest()) != 0 {
+		t.Errorf("searching the original changed an earlier clone")
+	}
+	if got := base.Clone().Format(); got != want[0] {
+		t.Errorf("Format() of a clone of a searched TreeContext = %q, want %q", got, want[0])
+	}
+}
+
+// TestTreeContext_PaddingBeforeAfter tests padding lines of interest by different amounts before
+// and after them.
+func TestTreeContext_PaddingBeforeAfter(t *testing.T) {
+	source := []byte("package p\n\nvar (\n\ta = 1\n\tb = 2\n\tc = 3\n\td = 4\n\te = 5\n)\n")
+	tests := []struct {
+		options  TreeContextOptions
+		expected string
+	}{
+		{TreeContextOptions{LinesOfInterestPaddingBefore: 2}, "[3 4 5]"},
+		{TreeContextOptions{LinesOfInterestPaddingAfter: 1}, "[5 6]"},
+		{TreeContextOptions{LinesOfInterestPadding: 1, LinesOfInterestPaddingAfter: 2}, "[4 5 6 7]"},
+		{TreeContextOptions{LinesOfInterestPadding: 2, LinesOfInterestPaddingBefore: 1}, "[3 4 5 6 7]"},
+	}
+	for _, tt := range tests {
+		tc, err := NewTreeContext("p.go", source, tt.options)
+		if err != nil {
+			t.Fatalf("NewTreeContext() error = %v", err)
+		}
+		tc.AddLinesOfInterest(map[int]struct{}{5: {}})
+		tc.AddContext()
+		if got := fmt.Sprint(tc.ShownLines()); got != tt.expected {
+			t.Errorf("ShownLines() with %+v = %s, want %s", tt.options, got, tt.expected)
+		}
+	}
+}
+
+// TestTreeContext_TabWidth tests that Format expands tabs to the next tab stop, counting runes
+// and not counting the escape sequences of highlighted matches.
+func TestTreeContext_TabWidth(t *testing.T) {
+	source := []byte("package p\n\nfunc f() {\n\tx := 1\t// one\n\té := 22\t// two\n}\n")
+	tc, err := NewTreeContext("example.go", source, TreeContextOptions{TabWidth: 4})
+	if err != nil {
+		t.Fatalf("NewTreeContext() error = %v", err)
+	}
+	tc.AddLinesOfInterest(map[int]struct{}{3: {}, 4: {}})
+	tc.AddContext()
+
+	expected := "⋮...\n│    x := 1  // one\n│    é := 22 // two\n⋮...\n"
+	if got := tc.Format(); got != expected {
+		t.Errorf("Format() =\n%q\nwant\n%q", got, expected)
+	}
+
+	if got := expandTabs("\033[1;31mab\033[0m\tc", 4); got != "\033[1;31mab\033[0m  c" {
+		t.Errorf("expandTabs() = %q, want %q", got, "\033[1;31mab\033[0m  c")
+	}
+}
@@ -0,0 +1,203 @@
+package grepast
+
+import (
+	"embed"
+	"sort"
+	"strings"
+	"sync"
+
+	sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+// highlightQueries holds the per-language highlight queries, named "<language>-highlights.scm".
+//
+//go:embed queries/*-highlights.scm
+var highlightQueries embed.FS
+
+// highlightQueryBases lists languages whose highlight query extends another language's query.
+var highlightQueryBases = map[string]string{
+	"typescript": "javascript",
+}
+
+var (
+	highlightQueryCacheMu sync.Mutex
+	highlightQueryCache   = make(map[string]*sitter.Query) // Compiled highlight queries by language name.
+)
+
+// syntaxColors are the default colors of highlight captures, by capture name. A capture without
+// a color of its own, e.g. "function.method", takes the color of its nearest parent name, e.g.
+// "function". Captures without any color, such as "variable", are left plain.
+var syntaxColors = map[string]string{
+	"attribute": "\033[36m", // cyan
+	"comment":   "\033[90m", // gray
+	"constant":  "\033[36m", // cyan
+	"function":  "\033[34m", // blue
+	"keyword":   "\033[35m", // magenta
+	"number":    "\033[36m", // cyan
+	"string":    "\033[32m", // green
+	"tag":       "\033[34m", // blue
+	"type":      "\033[33m", // yellow
+}
+
+// syntaxSpan is a highlighted byte range of the source.
+type syntaxSpan struct {
+	start, end uint
+	color      string
+	pattern    uint // Index of the query pattern that captured the span.
+}
+
+// syntaxHighlightedLine returns line i colored by the language's highlight query, or the line
+// unchanged if the language has no highlight query.
+func (tc *TreeContext) syntaxHighlightedLine(i int) string {
+	if tc.syntaxLines == nil {
+		tc.syntaxLines = tc.highlightSyntax()
+	}
+	if line, ok := tc.syntaxLines[i]; ok {
+		return line
+	}
+	return tc.lines[i]
+}
+
+// highlightSyntax colors every line of the source that holds a highlight capture.
+func (tc *TreeContext) highlightSyntax() map[int]string {
+	lines := make(map[int]string)
+	if tc.tree == nil {
+		return lines
+	}
+	q, err := highlightQuery(tc.language, tc.tree.Language())
+	if err != nil {
+		return lines
+	}
+
+	cursor := sitter.NewQueryCursor()
+	defer cursor.Close()
+
+	names := q.CaptureNames()
+	var spans []syntaxSpan
+	matches := cursor.Matches(q, tc.tree.RootNode(), tc.source)
+	for m := matches.Next(); m != nil; m = matches.Next() {
+		for _, c := range m.Captures {
+			color := tc.syntaxColor(names[c.Index])
+			if color == "" || c.Node.StartByte() == c.Node.EndByte() {
+				continue
+			}
+			spans = append(spans, syntaxSpan{
+				start:   c.Node.StartByte(),
+				end:     c.Node.EndByte(),
+				color:   color,
+				pattern: m.PatternIndex,
+			})
+		}
+	}
+
+	// Paint outer spans first so that nested spans, e.g. escapes within strings, show through,
+	// and let later patterns override earlier ones for the same span.
+	sort.SliceStable(spans, func(i, j int) bool {
+		a, b := spans[i], spans[j]
+		if a.end-a.start != b.end-b.start {
+			return a.end-a.start > b.end-b.start
+		}
+		return a.pattern < b.pattern
+	})
+	colors := make(map[int][]string)
+	for _, span := range spans {
+		for pos := int(span.start); pos < int(span.end); {
+			i := tc.lineAt(pos)
+			if colors[i] == nil {
+				colors[i] = make([]string, len(tc.lines[i]))
+			}
+			lineEnd := tc.lineStarts[i] + len(tc.lines[i])
+			for ; pos < int(span.end) && pos < lineEnd; pos++ {
+				colors[i][pos-tc.lineStarts[i]] = span.color
+			}
+			if i+1 == len(tc.lineStarts) {
+				break
+			}
+			pos = tc.lineStarts[i+1] // Skip the line ending.
+		}
+	}
+
+	for i, lineColors := range colors {
+		lines[i] = paintLine(tc.lines[i], lineColors)
+	}
+	return lines
+}
+
+// syntaxColor returns the color of a highlight capture, looking up its parent names in turn,
+// or "" if it has none.
+func (tc *TreeContext) syntaxColor(capture string) string {
+	palette := tc.syntaxColors
+	if len(palette) == 0 {
+		palette = syntaxColors
+	}
+	for {
+		if color, ok := palette[capture]; ok {
+			return color
+		}
+		i := strings.LastIndexByte(capture, '.')
+		if i < 0 {
+			return ""
+		}
+		capture = capture[:i]
+	}
+}
+
+// paintLine wraps each run of bytes of line sharing a color in that color.
+func paintLine(line string, colors []string) string {
+	var sb strings.Builder
+	for start := 0; start < len(line); {
+		end := start + 1
+		for end < len(line) && colors[end] == colors[start] {
+			end++
+		}
+		if colors[start] == "" {
+			sb.WriteString(line[start:end])
+		} else {
+			sb.WriteString(colors[start])
+			sb.WriteString(line[start:end])
+			sb.WriteString("\033[0m")
+		}
+		start = end
+	}
+	return sb.String()
+}
+
+// highlightQuery returns the compiled highlight query for a language, compiling and caching it on first use.
+func highlightQuery(lang string, language *sitter.Language) (*sitter.Query, error) {
+	highlightQueryCacheMu.Lock()
+	defer highlightQueryCacheMu.Unlock()
+
+	if q, ok := highlightQueryCache[lang]; ok {
+		return q, nil
+	}
+
+	source, err := highlightQuerySource(lang)
+	if err != nil {
+		return nil, err
+	}
+	q, qerr := sitter.NewQuery(language, source)
+	if qerr != nil {
+		return nil, qerr
+	}
+	highlightQueryCache[lang] = q
+	return q, nil
+}
+
+// highlightQuerySource returns the highlight query text for a language, including the query of
+// the language it extends.
+func highlightQuerySource(lang string) (string, error) {
+	data, err := highlightQueries.ReadFile("queries/" + lang + "-highlights.scm")
+	if err != nil {
+		return "", ErrorNoHighlightQuery
+	}
+	source := string(data)
+
+	if base, ok := highlightQueryBases[lang]; ok {
+		baseSource, err := highlightQuerySource(base)
+		if err != nil {
+			return "", err
+		}
+		source = baseSource + "\n" + source
+	}
+	return source, nil
+}
@@ -0,0 +1,70 @@
+package grepast
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestHighlightQueries tests that every embedded highlight query compiles against its grammar.
+func TestHighlightQueries(t *testing.T) {
+	entries, err := highlightQueries.ReadDir("queries")
+	if err != nil {
+		t.Fatalf("ReadDir() error = %v", err)
+	}
+	for _, e := range entries {
+		lang, ok := strings.CutSuffix(e.Name(), "-highlights.scm")
+		if !ok {
+			continue
+		}
+		t.Run(lang, func(t *testing.T) {
+			language, err := getLanguage(lang)
+			if err != nil {
+				t.Fatalf("getLanguage() error = %v", err)
+			}
+			if _, err := highlightQuery(lang, language); err != nil {
+				t.Errorf("highlightQuery() error = %v", err)
+			}
+		})
+	}
+}
+
+// TestTreeContext_SyntaxHighlight tests that Format colors shown lines by syntax when enabled.
+func TestTreeContext_SyntaxHighlight(t *testing.T) {
+	source := []byte("package p\n\n// f is here.\nfunc f() string {\n\treturn \"x\"\n}\n")
+	colors := map[string]string{
+		"comment": "<c>",
+		"keyword": "<k>",
+		"string":  "<s>",
+	}
+
+	tc, err := NewTreeContext("example.go", source, TreeContextOptions{
+		Color:           true,
+		SyntaxHighlight: true,
+		SyntaxColors:    colors,
+	})
+	if err != nil {
+		t.Fatalf("NewTreeContext() error = %v", err)
+	}
+	tc.AddLinesOfInterest(map[int]struct{}{2: {}, 3: {}, 4: {}})
+	tc.AddContext()
+
+	expected := "⋮...\n" +
+		"│<c>// f is here.\033[0m\n" +
+		"│<k>func\033[0m f() string {\n" +
+		"│\t<k>return\033[0m <s>\"x\"\033[0m\n" +
+		"⋮...\n"
+	if got := tc.Format(); got != expected {
+		t.Errorf("Format() = %q, want %q", got, expected)
+	}
+
+	// Without Color, lines are left plain.
+	tc, err = NewTreeContext("example.go", source, TreeContextOptions{SyntaxHighlight: true, SyntaxColors: colors})
+	if err != nil {
+		t.Fatalf("NewTreeContext() error = %v", err)
+	}
+	tc.AddLinesOfInterest(map[int]struct{}{3: {}})
+	tc.AddContext()
+	if got := tc.Format(); strings.Contains(got, "<") {
+		t.Errorf("Format() without Color = %q, want no highlighting", got)
+	}
+}
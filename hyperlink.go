@@ -0,0 +1,45 @@
+package grepast
+
+import (
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Hyperlink wraps text in OSC 8 escape sequences linking it to url, which terminals supporting
+// them render as a clickable link and others ignore.
+func Hyperlink(url, text string) string {
+	return "\033]8;;" + url + "\033\\" + text + "\033]8;;\033\\"
+}
+
+// HyperlinkURL expands a URL template such as "vscode://file{path}:{line}", replacing {path}
+// with the absolute slash path of file, which starts with a slash, and {line} with the one-based
+// line. A {path} following "file://" is escaped as a URL path.
+func HyperlinkURL(template, file string, line int) string {
+	path, err := filepath.Abs(file)
+	if err != nil {
+		path = file
+	}
+	path = filepath.ToSlash(path)
+	if !strings.HasPrefix(path, "/") {
+		// Windows paths such as C:/x need a leading slash to form a URL path.
+		path = "/" + path
+	}
+	escaped := (&url.URL{Path: path}).EscapedPath()
+	template = strings.ReplaceAll(template, "file://{path}", "file://"+escaped)
+	return strings.NewReplacer("{path}", path, "{line}", strconv.Itoa(line)).Replace(template)
+}
+
+// lineNumber returns the number of line i as Format shows it, linked to the line's location
+// with an OSC 8 hyperlink if a hyperlink format is set.
+func (tc *TreeContext) lineNumber(i int) string {
+	number := strconv.Itoa(i + 1)
+	if len(number) < 3 {
+		number = strings.Repeat(" ", 3-len(number)) + number
+	}
+	if tc.hyperlinkFormat == "" {
+		return number
+	}
+	return Hyperlink(HyperlinkURL(tc.hyperlinkFormat, tc.filename, i+1), number)
+}
@@ -0,0 +1,51 @@
+package grepast
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestHyperlinkURL tests expanding URL templates with absolute paths and line numbers.
+func TestHyperlinkURL(t *testing.T) {
+	abs, err := filepath.Abs("dir/my file.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	abs = filepath.ToSlash(abs)
+	if !strings.HasPrefix(abs, "/") {
+		abs = "/" + abs
+	}
+	escaped := strings.ReplaceAll(abs, " ", "%20")
+
+	for _, tt := range []struct {
+		template, want string
+	}{
+		{"vscode://file{path}:{line}", "vscode://file" + abs + ":7"},
+		{"file://{path}", "file://" + escaped},
+		{"idea://open?file={path}&line={line}", "idea://open?file=" + abs + "&line=7"},
+	} {
+		if got := HyperlinkURL(tt.template, "dir/my file.go", 7); got != tt.want {
+			t.Errorf("HyperlinkURL(%q) = %q, want %q", tt.template, got, tt.want)
+		}
+	}
+}
+
+// TestTreeContext_HyperlinkFormat tests linking the line numbers of rendered lines.
+func TestTreeContext_HyperlinkFormat(t *testing.T) {
+	source := "package p\n\nfunc f() {\n\tx()\n}\n"
+	tc, err := NewTreeContext("/src/p.go", []byte(source), TreeContextOptions{
+		ShowLineNumber:  true,
+		HyperlinkFormat: "editor://{path}:{line}",
+	})
+	if err != nil {
+		t.Fatalf("NewTreeContext() error = %v", err)
+	}
+	tc.AddLinesOfInterest(map[int]struct{}{3: {}})
+	tc.AddContext()
+
+	want := "⋮...\n" + Hyperlink(HyperlinkURL("editor://{path}:{line}", "/src/p.go", 4), "  4") + "│\tx()\n⋮...\n"
+	if got := tc.Format(); got != want {
+		t.Errorf("Format() = %q, want %q", got, want)
+	}
+}
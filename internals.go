@@ -6,6 +6,8 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"strings"
 
 	sitter "github.com/tree-sitter/go-tree-sitter"
@@ -24,6 +26,9 @@ import (
 var (
 	ErrorUnrecognizedFiletype = fmt.Errorf("unrecognized file type")
 	ErrorUnsupportedLanguage  = fmt.Errorf("unsupported language")
+	ErrorNoTagsQuery          = fmt.Errorf("no tags query for language")
+	ErrorNoHighlightQuery     = fmt.Errorf("no highlight query for language")
+	ErrorBinaryFile           = fmt.Errorf("binary file")
 )
 
 var extensionMap = map[string]string{
@@ -75,51 +80,175 @@ var extensionMap = map[string]string{
 	".yaml":   "yaml",
 }
 
+// FileType is a kind of file recognized by its name, and the language it is parsed as.
+type FileType struct {
+	Pattern   string // File name extension, e.g. ".go", or whole file name, e.g. "Dockerfile".
+	Language  string // Name of the language, e.g. "go".
+	Supported bool   // Whether the grammar of the language is compiled in, so that the files can be parsed.
+}
+
+// FileTypes returns the kinds of files recognized by their names, sorted by pattern, so that
+// users can check which of them can be parsed.
+func FileTypes() []FileType {
+	types := []FileType{{Pattern: "Dockerfile", Language: "Dockerfile"}}
+	for ext, lang := range extensionMap {
+		types = append(types, FileType{Pattern: ext, Language: lang, Supported: slices.Contains(languageNames, lang)})
+	}
+	slices.SortFunc(types, func(a, b FileType) int { return strings.Compare(a.Pattern, b.Pattern) })
+	return types
+}
+
+// knownLanguage reports whether name, ignoring case, is the name of a language files are
+// recognized as by their names, whether or not it can be parsed.
+func knownLanguage(name string) bool {
+	if strings.EqualFold(name, "Dockerfile") {
+		return true
+	}
+	for _, lang := range extensionMap {
+		if strings.EqualFold(name, lang) {
+			return true
+		}
+	}
+	return false
+}
+
+// languageDirective matches an in-file language override such as "# grep-ast: lang=python".
+var languageDirective = regexp.MustCompile(`grep-ast:\s*lang=([\w+#-]+)`)
+
+// languageDirectiveLines is the number of leading lines searched for a language directive.
+const languageDirectiveLines = 5
+
 // GetLanguageFromFileName maps file name to tree-sitter Language instances
 func GetLanguageFromFileName(path string) (*sitter.Language, string, error) {
 
+	name := fileNameLanguage(path)
+	switch name {
+	case "":
+		return nil, "", ErrorUnrecognizedFiletype
+	case "Dockerfile":
+		return nil, name, nil
+	}
+	lang, err := getLanguage(name)
+	if err != nil {
+		return nil, "", err
+	}
+	return lang, name, nil
+}
+
+// fileNameLanguage returns the name of the language of the file at path according to its name,
+// or "" if it is not recognized.
+func fileNameLanguage(path string) string {
 	if strings.EqualFold(filepath.Base(path), "Dockerfile") {
-		return nil, "Dockerfile", nil
+		return "Dockerfile"
+	}
+	return extensionMap[strings.ToLower(filepath.Ext(path))]
+}
+
+// GetLanguageFromSource maps a file to a tree-sitter Language instance. A "grep-ast: lang=<name>"
+// directive within the first few lines of source overrides detection from the file name.
+func GetLanguageFromSource(path string, source []byte) (*sitter.Language, string, error) {
+	if name := languageFromDirective(source); name != "" {
+		lang, err := getLanguage(name)
+		if err != nil {
+			return nil, "", err
+		}
+		return lang, name, nil
 	}
+	return GetLanguageFromFileName(path)
+}
 
-	ext := strings.ToLower(filepath.Ext(path))
-
-	if lang, ok := extensionMap[ext]; ok {
-		switch lang {
-		case "bash":
-			return sitter.NewLanguage(sitter_bash.Language()), lang, nil
-		case "c_sharp":
-			return sitter.NewLanguage(sitter_c_sharp.Language()), lang, nil
-		case "css":
-			return sitter.NewLanguage(sitter_css.Language()), lang, nil
-		case "go":
-			return sitter.NewLanguage(sitter_go.Language()), lang, nil
-		case "java":
-			return sitter.NewLanguage(sitter_java.Language()), lang, nil
-		case "javascript":
-			return sitter.NewLanguage(sitter_javascript.Language()), lang, nil
-		case "html":
-			return sitter.NewLanguage(sitter_html.Language()), lang, nil
-		case "python":
-			return sitter.NewLanguage(sitter_python.Language()), lang, nil
-		case "typescript":
-			return sitter.NewLanguage(sitter_typescript.LanguageTypescript()), lang, nil
-		case "rust":
-			return sitter.NewLanguage(sitter_rust.Language()), lang, nil
-		default:
-			return nil, "", ErrorUnsupportedLanguage
+// languageFromDirective returns the language named by a directive in the leading lines of source, if any.
+func languageFromDirective(source []byte) string {
+	head := source
+	for i, n := 0, 0; i < len(source); i++ {
+		if source[i] == '\n' {
+			n++
+			if n == languageDirectiveLines {
+				head = source[:i]
+				break
+			}
 		}
 	}
 
-	return nil, "", ErrorUnrecognizedFiletype
+	if m := languageDirective.FindSubmatch(head); m != nil {
+		return strings.ToLower(string(m[1]))
+	}
+	return ""
+}
+
+// getLanguage maps a language name to its tree-sitter Language instance.
+func getLanguage(name string) (*sitter.Language, error) {
+	switch name {
+	case "bash":
+		return sitter.NewLanguage(sitter_bash.Language()), nil
+	case "c_sharp":
+		return sitter.NewLanguage(sitter_c_sharp.Language()), nil
+	case "css":
+		return sitter.NewLanguage(sitter_css.Language()), nil
+	case "go":
+		return sitter.NewLanguage(sitter_go.Language()), nil
+	case "java":
+		return sitter.NewLanguage(sitter_java.Language()), nil
+	case "javascript":
+		return sitter.NewLanguage(sitter_javascript.Language()), nil
+	case "html":
+		return sitter.NewLanguage(sitter_html.Language()), nil
+	case "python":
+		return sitter.NewLanguage(sitter_python.Language()), nil
+	case "typescript":
+		return sitter.NewLanguage(sitter_typescript.LanguageTypescript()), nil
+	case "rust":
+		return sitter.NewLanguage(sitter_rust.Language()), nil
+	default:
+		return nil, ErrorUnsupportedLanguage
+	}
+}
+
+// languageNames lists the languages getLanguage supports.
+var languageNames = []string{"bash", "c_sharp", "css", "go", "html", "java", "javascript", "python", "rust", "typescript"}
+
+// SupportedLanguages returns the sorted names of the languages that can be parsed, such as "go",
+// for TreeContextOptions.Language.
+func SupportedLanguages() []string {
+	return slices.Clone(languageNames)
+}
+
+// languageName returns the name of a tree-sitter Language instance, or "" if it is not one of
+// the supported languages.
+func languageName(lang *sitter.Language) string {
+	if lang == nil {
+		return ""
+	}
+	for _, name := range languageNames {
+		if l, err := getLanguage(name); err == nil && l.Inner == lang.Inner {
+			return name
+		}
+	}
+	return ""
 }
 
 // PrintStruct prints a struct as JSON.
+//
+// Deprecated: PrintStruct is a debugging helper outside the stable API and will be removed in v2.
+// Use the String methods of the result types, such as FileResult.String, or encoding/json instead.
 func PrintStruct(w io.Writer, t interface{}) {
-	j, _ := json.MarshalIndent(t, "", "  ")
-	fmt.Fprintln(w, string(j))
+	fmt.Fprintln(w, debugString(t))
 }
 
+// PrintStructOut prints a struct as JSON to standard output.
+//
+// Deprecated: PrintStructOut is a debugging helper outside the stable API and will be removed in v2.
+// Use the String methods of the result types, such as FileResult.String, or encoding/json instead.
 func PrintStructOut(t interface{}) {
 	PrintStruct(os.Stdout, t)
 }
+
+// debugString renders v as indented JSON for debugging. A value that cannot be marshaled is
+// rendered as the marshaling error rather than dropped.
+func debugString(v any) string {
+	j, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Sprintf("%%!(json: %v)", err)
+	}
+	return string(j)
+}
@@ -1,6 +1,8 @@
 package grepast
 
 import (
+	"slices"
+	"strings"
 	"testing"
 )
 
@@ -86,3 +88,101 @@ func TestGetLanguageFromFileName(t *testing.T) {
 		})
 	}
 }
+
+// TestGetLanguageFromSource tests the GetLanguageFromSource function
+func TestGetLanguageFromSource(t *testing.T) {
+	tests := []struct {
+		name          string
+		filePath      string
+		source        string
+		expectedLang  string
+		expectedError error
+	}{
+		{
+			name:         "Directive in extensionless script",
+			filePath:     "deploy",
+			source:       "#!/usr/bin/env python3\n# grep-ast: lang=python\nprint('hi')\n",
+			expectedLang: "python",
+		},
+		{
+			name:         "Directive overrides extension",
+			filePath:     "query.tmpl",
+			source:       "// grep-ast: lang=javascript\nconst a = 1;\n",
+			expectedLang: "javascript",
+		},
+		{
+			name:         "Directive beyond leading lines is ignored",
+			filePath:     "main.go",
+			source:       "package main\n\n\n\n\n\n// grep-ast: lang=python\n",
+			expectedLang: "go",
+		},
+		{
+			name:         "No directive falls back to file name",
+			filePath:     "main.go",
+			source:       "package main\n",
+			expectedLang: "go",
+		},
+		{
+			name:          "Unsupported directive language",
+			filePath:      "main.go",
+			source:        "// grep-ast: lang=cobol\n",
+			expectedError: ErrorUnsupportedLanguage,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lang, detectedLang, err := GetLanguageFromSource(tt.filePath, []byte(tt.source))
+
+			if tt.expectedError != nil {
+				if err != tt.expectedError {
+					t.Errorf("expected error %v, got %v", tt.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if detectedLang != tt.expectedLang {
+				t.Errorf("expected language %q, got %q", tt.expectedLang, detectedLang)
+			}
+			if lang == nil {
+				t.Errorf("expected a valid *sitter.Language instance, got nil")
+			}
+		})
+	}
+}
+
+// TestSupportedLanguages tests that every supported language can be parsed.
+func TestSupportedLanguages(t *testing.T) {
+	names := SupportedLanguages()
+	if !slices.IsSorted(names) {
+		t.Errorf("SupportedLanguages() = %v, want sorted names", names)
+	}
+	for _, name := range names {
+		if _, err := NewTreeContext("file", []byte("x\n"), TreeContextOptions{Language: name}); err != nil {
+			t.Errorf("NewTreeContext() with Language %q error = %v", name, err)
+		}
+	}
+}
+
+// TestFileTypes tests that FileTypes lists the recognized files, and which of them can be parsed.
+func TestFileTypes(t *testing.T) {
+	types := FileTypes()
+	if !slices.IsSortedFunc(types, func(a, b FileType) int { return strings.Compare(a.Pattern, b.Pattern) }) {
+		t.Errorf("FileTypes() is not sorted by pattern")
+	}
+	for _, want := range []FileType{{".go", "go", true}, {".tsx", "typescript", true}, {".rb", "ruby", false}, {"Dockerfile", "Dockerfile", false}} {
+		if !slices.Contains(types, want) {
+			t.Errorf("FileTypes() lacks %+v", want)
+		}
+	}
+	for _, ft := range types {
+		if ft.Pattern == "Dockerfile" {
+			continue
+		}
+		if _, _, err := GetLanguageFromFileName("file" + ft.Pattern); ft.Supported != (err == nil) {
+			t.Errorf("FileTypes() says %s is supported: %v, but GetLanguageFromFileName() error = %v", ft.Pattern, ft.Supported, err)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package grepast
+
+// LineInfo describes a line rendered by Format, for a LineHook.
+type LineInfo struct {
+	Line           int    // Zero-based line number.
+	LineOfInterest bool   // Whether the line is a line of interest.
+	ScopeDepth     int    // Number of multi-line scopes enclosing the line, not counting those starting or ending on it.
+	Text           string // Source text of the line, without highlighting or line ending.
+}
+
+// LineHook returns the text to write before a line rendered by Format, e.g. a gutter showing
+// coverage, blame, or lint markers. It may be called more than once for the same line, such as
+// while trimming the output to a budget, so it should return the same text each time.
+type LineHook func(info LineInfo) string
+
+// lineInfo describes line i for the line hook.
+func (tc *TreeContext) lineInfo(i int) LineInfo {
+	_, loi := tc.linesOfInterest[i]
+	depth := 0
+	for _, scope := range tc.ParentChain(i) {
+		if scope.Start < i && i < scope.End {
+			depth++
+		}
+	}
+	return LineInfo{Line: i, LineOfInterest: loi, ScopeDepth: depth, Text: tc.lines[i]}
+}
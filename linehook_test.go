@@ -0,0 +1,34 @@
+package grepast
+
+import (
+	"fmt"
+	"testing"
+)
+
+// TestTreeContext_LineHook tests writing a custom gutter before each rendered line.
+func TestTreeContext_LineHook(t *testing.T) {
+	source := "package p\n\nfunc f() {\n\tif x {\n\t\ty()\n\t}\n}\n"
+	var texts []string
+	hook := func(info LineInfo) string {
+		texts = append(texts, info.Text)
+		mark := " "
+		if info.LineOfInterest {
+			mark = "*"
+		}
+		return fmt.Sprintf("%d%s ", info.ScopeDepth, mark)
+	}
+	tc, err := NewTreeContext("example.go", []byte(source), TreeContextOptions{ShowParentContext: true, LineHook: hook})
+	if err != nil {
+		t.Fatalf("NewTreeContext() error = %v", err)
+	}
+	tc.AddLinesOfInterest(map[int]struct{}{4: {}})
+	tc.AddContext()
+
+	expected := "⋮...\n0  │func f() {\n1  │\tif x {\n2* │\t\ty()\n1  │\t}\n0  │}\n"
+	if got := tc.Format(); got != expected {
+		t.Errorf("Format() = %q, want %q", got, expected)
+	}
+	if fmt.Sprint(texts) != fmt.Sprint([]string{"func f() {", "\tif x {", "\t\ty()", "\t}", "}"}) {
+		t.Errorf("LineHook() got texts %q", texts)
+	}
+}
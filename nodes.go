@@ -0,0 +1,43 @@
+package grepast
+
+import (
+	"sort"
+
+	sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+// Tree returns the syntax tree parsed from the source. It belongs to the TreeContext and must
+// not be closed or edited by the caller.
+func (tc *TreeContext) Tree() *sitter.Tree {
+	return tc.tree
+}
+
+// NodesAt returns the named parse-tree nodes covering line, other than the root node, ordered
+// by position with ancestors before their descendants. Only nodes walked when the context was
+// created are included; see MaxWalkDepth and MaxNodesPerLine. Use NodeText for a node's source text.
+func (tc *TreeContext) NodesAt(line int) []*sitter.Node {
+	if line < 0 || line >= len(tc.scopes) {
+		return nil
+	}
+
+	var nodes []*sitter.Node
+	for start := range tc.scopes[line] {
+		for _, node := range tc.nodes[start] {
+			if node.Parent() != nil && int(node.EndPosition().Row) >= line {
+				nodes = append(nodes, node)
+			}
+		}
+	}
+	sort.SliceStable(nodes, func(i, j int) bool {
+		if nodes[i].StartByte() != nodes[j].StartByte() {
+			return nodes[i].StartByte() < nodes[j].StartByte()
+		}
+		return nodes[i].EndByte() > nodes[j].EndByte()
+	})
+	return nodes
+}
+
+// NodeText returns the source text of a node of the tree.
+func (tc *TreeContext) NodeText(node *sitter.Node) string {
+	return string(tc.source[node.StartByte():node.EndByte()])
+}
@@ -0,0 +1,33 @@
+package grepast
+
+import (
+	"fmt"
+	"testing"
+)
+
+// TestTreeContext_NodesAt tests the NodesAt and NodeText methods of TreeContext.
+func TestTreeContext_NodesAt(t *testing.T) {
+	source := []byte("package p\n\nfunc f() {\n\ta(1); b()\n}\n")
+	tc, err := NewTreeContext("example.go", source, TreeContextOptions{})
+	if err != nil {
+		t.Fatalf("NewTreeContext() error = %v", err)
+	}
+
+	var got []string
+	for _, node := range tc.NodesAt(3) {
+		got = append(got, fmt.Sprintf("%s %q", node.Kind(), tc.NodeText(node)))
+	}
+	expected := `[function_declaration "func f() {\n\ta(1); b()\n}" block "{\n\ta(1); b()\n}" ` +
+		`expression_statement "a(1)" call_expression "a(1)" identifier "a" ` +
+		`argument_list "(1)" int_literal "1" expression_statement "b()" call_expression "b()" identifier "b" argument_list "()"]`
+	if fmt.Sprint(got) != expected {
+		t.Errorf("NodesAt(3) =\n%v\nwant\n%s", got, expected)
+	}
+
+	if got := tc.NodesAt(1); len(got) != 0 {
+		t.Errorf("NodesAt(1) = %v, want none", got)
+	}
+	if got := tc.NodesAt(-1); got != nil {
+		t.Errorf("NodesAt(-1) = %v, want nil", got)
+	}
+}
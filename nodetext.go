@@ -0,0 +1,86 @@
+package grepast
+
+import (
+	"strings"
+	"unicode"
+)
+
+// NodeTextMatch is a parse-tree node whose source text equals a searched text.
+type NodeTextMatch struct {
+	Kind      string `json:"kind"`       // Tree-sitter node kind of the node, e.g. "call_expression".
+	Text      string `json:"text"`       // Source text of the node.
+	StartLine int    `json:"start_line"` // Zero-based line on which the node starts.
+	EndLine   int    `json:"end_line"`   // Zero-based line on which the node ends.
+	StartByte int    `json:"start_byte"` // Byte offset in the source at which the node starts.
+	EndByte   int    `json:"end_byte"`   // Byte offset in the source at which the node ends.
+}
+
+// NodeTextSearch returns the named nodes whose source text equals text, ignoring differences in
+// whitespace, ordered by position. Whitespace between two word characters must be present in
+// both but may differ in amount and kind; elsewhere it is ignored, so `errors.New( "x" )` finds
+// `errors.New("x")`. When several nested nodes span the same text, only the outermost is returned.
+func (tc *TreeContext) NodeTextSearch(text string) []NodeTextMatch {
+	return tc.nodeTextSearch(text, false)
+}
+
+// nodeTextSearch implements NodeTextSearch, optionally comparing case-insensitively.
+func (tc *TreeContext) nodeTextSearch(text string, ignoreCase bool) []NodeTextMatch {
+	want := normalizeNodeText(text, -1)
+	if want == "" {
+		return nil
+	}
+
+	var matches []NodeTextMatch
+	seen := make(map[[2]uint]struct{})
+	for _, nodes := range tc.nodes {
+		for _, node := range nodes {
+			start, end := node.StartByte(), node.EndByte()
+			if int(end-start) < len(want) {
+				continue
+			}
+			if _, dup := seen[[2]uint{start, end}]; dup {
+				continue
+			}
+			// Normalizing one byte past the wanted length is enough to tell a longer text apart.
+			got := normalizeNodeText(string(tc.source[start:end]), len(want)+1)
+			if got != want && !(ignoreCase && strings.EqualFold(got, want)) {
+				continue
+			}
+			seen[[2]uint{start, end}] = struct{}{}
+			matches = append(matches, NodeTextMatch{
+				Kind:      node.Kind(),
+				Text:      string(tc.source[start:end]),
+				StartLine: int(node.StartPosition().Row),
+				EndLine:   int(node.EndPosition().Row),
+				StartByte: int(start),
+				EndByte:   int(end),
+			})
+		}
+	}
+	return matches
+}
+
+// normalizeNodeText drops the whitespace of s that does not separate two word characters and
+// collapses the rest to single spaces. If limit is not negative, it stops once the result is
+// limit bytes long.
+func normalizeNodeText(s string, limit int) string {
+	var sb strings.Builder
+	var prev rune // Last rune written, or 0.
+	space := false
+	for _, r := range s {
+		if limit >= 0 && sb.Len() >= limit {
+			break
+		}
+		if unicode.IsSpace(r) {
+			space = true
+			continue
+		}
+		if space && isWordRune(prev) && isWordRune(r) {
+			sb.WriteByte(' ')
+		}
+		space = false
+		sb.WriteRune(r)
+		prev = r
+	}
+	return sb.String()
+}
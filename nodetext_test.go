@@ -0,0 +1,59 @@
+package grepast
+
+import (
+	"fmt"
+	"testing"
+)
+
+// TestTreeContext_NodeTextSearch tests the NodeTextSearch method of TreeContext.
+func TestTreeContext_NodeTextSearch(t *testing.T) {
+	source := []byte(`package p
+
+func f() error {
+	if err := g(); err != nil {
+		return errors.New("not found")
+	}
+	return errors.New(
+		"not found",
+	)
+}
+`)
+	tc, err := NewTreeContext("example.go", source, TreeContextOptions{})
+	if err != nil {
+		t.Fatalf("NewTreeContext() error = %v", err)
+	}
+
+	tests := []struct {
+		text     string
+		expected string // Kind and lines of each match.
+	}{
+		// The call is the only element of the returned expression list, which spans the same text.
+		{text: `errors.New("not found")`, expected: "[expression_list 4-4]"},
+		{text: `errors.New( "not found", )`, expected: "[expression_list 6-8]"},
+		{text: `err!=nil`, expected: "[binary_expression 3-3]"},
+		{text: "return   errors.New(\"not found\")", expected: "[return_statement 4-4]"},
+		{text: `returnerrors.New("not found")`, expected: "[]"},
+		{text: `errors.New`, expected: "[selector_expression 4-4 selector_expression 6-6]"},
+		{text: "", expected: "[]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			var got []string
+			for _, m := range tc.NodeTextSearch(tt.text) {
+				got = append(got, fmt.Sprintf("%s %d-%d", m.Kind, m.StartLine, m.EndLine))
+			}
+			if fmt.Sprint(got) != tt.expected {
+				t.Errorf("NodeTextSearch(%q) = %v, want %v", tt.text, got, tt.expected)
+			}
+		})
+	}
+
+	found, err := tc.GrepPatterns([]string{`ERRORS.NEW("NOT FOUND")`}, GrepOptions{NodeText: true, IgnoreCase: true})
+	if err != nil {
+		t.Fatalf("GrepPatterns() error = %v", err)
+	}
+	if got := mapKeysSorted(found); fmt.Sprint(got) != "[4]" {
+		t.Errorf("GrepPatterns(NodeText, IgnoreCase) = %v, want [4]", got)
+	}
+}
@@ -0,0 +1,122 @@
+package grepast
+
+import (
+	sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+// Outline shows the header of every top-level declaration, such as functions, types, and classes,
+// and of the declarations directly inside their bodies, such as methods, with the bodies elided.
+// It returns the rendered outline, as Format does.
+func (tc *TreeContext) Outline() string {
+	for _, r := range tc.outlineHeaders() {
+		for i := r[0]; i <= r[1] && i < tc.numLines; i++ {
+			tc.showLines[i] = struct{}{}
+		}
+	}
+	return tc.Format()
+}
+
+// outlineHeaders returns the [start, end] line ranges of the headers of the declarations shown by Outline.
+func (tc *TreeContext) outlineHeaders() [][2]int {
+	if tc.tree == nil {
+		return nil
+	}
+
+	var headers [][2]int
+	root := tc.tree.RootNode()
+	for i := uint(0); i < root.NamedChildCount(); i++ {
+		node := root.NamedChild(i)
+		if node == nil || IsCommentKind(tc.language, node.Kind()) {
+			continue
+		}
+		headers = append(headers, tc.headerRange(node))
+
+		// Members declared directly in the body, e.g. the methods of a class.
+		body := node.ChildByFieldName("body")
+		if body == nil {
+			continue
+		}
+		for j := uint(0); j < body.NamedChildCount(); j++ {
+			member := body.NamedChild(j)
+			if member == nil || IsCommentKind(tc.language, member.Kind()) || !hasName(member) {
+				continue
+			}
+			headers = append(headers, tc.headerRange(member))
+		}
+	}
+	return headers
+}
+
+// hasName reports whether node, or the definition it decorates, has a "name" field.
+func hasName(node *sitter.Node) bool {
+	if node.ChildByFieldName("name") != nil {
+		return true
+	}
+	if def := node.ChildByFieldName("definition"); def != nil {
+		return def.ChildByFieldName("name") != nil
+	}
+	return false
+}
+
+// headerRange returns the lines of node that precede its body: its signature, including any
+// decorators. A node without a body has a header of at most headerMax lines, like a scope header.
+// With HeaderAnnotations, the range also covers the annotations and comments directly above node.
+func (tc *TreeContext) headerRange(node *sitter.Node) [2]int {
+	r := tc.signatureRange(node)
+	if tc.headerAnnotations {
+		r[0] = tc.annotatedStart(r[0])
+	}
+	return r
+}
+
+// signatureRange returns the lines of node that precede its body, as headerRange does, without
+// any annotations or comments above node.
+func (tc *TreeContext) signatureRange(node *sitter.Node) [2]int {
+	start := int(node.StartPosition().Row)
+	end := int(node.EndPosition().Row)
+
+	body := bodyOf(node)
+	if body == nil {
+		if tc.headerMax > 0 && end-start >= tc.headerMax {
+			end = start + tc.headerMax - 1
+		}
+		return [2]int{start, end}
+	}
+
+	bodyLine := int(body.StartPosition().Row)
+	// A body starting on a line of its own, as in Python, is not part of the header.
+	if col := int(body.StartPosition().Column); bodyLine > start && isBlank(tc.lines[bodyLine][:col]) {
+		bodyLine--
+	}
+	return [2]int{start, bodyLine}
+}
+
+// bodyOf returns the node holding the body of node: its "body" field or, failing that, the body of
+// its last named child spanning several lines, e.g. the field list of a struct type. It returns nil
+// if node has no multi-line part.
+func bodyOf(node *sitter.Node) *sitter.Node {
+	if body := node.ChildByFieldName("body"); body != nil {
+		return body
+	}
+	for i := int(node.NamedChildCount()) - 1; i >= 0; i-- {
+		child := node.NamedChild(uint(i))
+		if child == nil || child.StartPosition().Row == child.EndPosition().Row {
+			continue
+		}
+		if body := bodyOf(child); body != nil {
+			return body
+		}
+		return child
+	}
+	return nil
+}
+
+// isBlank reports whether s consists of whitespace only.
+func isBlank(s string) bool {
+	for _, r := range s {
+		if r != ' ' && r != '\t' {
+			return false
+		}
+	}
+	return true
+}
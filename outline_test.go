@@ -0,0 +1,77 @@
+package grepast
+
+import (
+	"testing"
+)
+
+// TestTreeContext_Outline tests the Outline method of TreeContext.
+func TestTreeContext_Outline(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		source   string
+		expected string
+	}{
+		{
+			name:     "Go",
+			filename: "example.go",
+			source: `package x
+
+// T is a type.
+type T struct {
+	A int
+}
+
+func (t *T) M(
+	a int,
+) error {
+	return nil
+}
+`,
+			expected: `│package x
+⋮...
+│type T struct {
+⋮...
+│func (t *T) M(
+│	a int,
+│) error {
+⋮...
+`,
+		},
+		{
+			name:     "Python",
+			filename: "example.py",
+			source: `@dec
+def f(a,
+      b):
+    return 1
+
+class C:
+    x = 1
+    def m(self):
+        pass
+`,
+			expected: `│@dec
+│def f(a,
+│      b):
+⋮...
+│class C:
+⋮...
+│    def m(self):
+⋮...
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc, err := NewTreeContext(tt.filename, []byte(tt.source), TreeContextOptions{})
+			if err != nil {
+				t.Fatalf("NewTreeContext() error = %v", err)
+			}
+			if got := tc.Outline(); got != tt.expected {
+				t.Errorf("Outline() =\n%s\nwant\n%s", got, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package grepast
+
+import (
+	sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+// brokenParseRatio is the share of the source that must lie in ERROR nodes for ParseBroken to
+// consider its parse badly broken.
+const brokenParseRatio = 0.1
+
+// ParseError is a syntax error found by the parser: a run of source it could not parse, or a
+// token it assumed missing in order to carry on.
+type ParseError struct {
+	Line      int    `json:"line"`       // Zero-based line the error starts on.
+	Column    int    `json:"column"`     // Zero-based byte column the error starts at.
+	EndLine   int    `json:"end_line"`   // Zero-based line the error ends on.
+	EndColumn int    `json:"end_column"` // Zero-based byte column just past the error.
+	Missing   bool   `json:"missing"`    // Whether the parser inserted a missing token rather than skipping source.
+	Kind      string `json:"kind"`       // "ERROR" for skipped source, or the kind of the missing token, e.g. ";".
+}
+
+// ParseErrors returns the syntax errors of the source in source order. Errors nested within a
+// run of source that could not be parsed are not reported separately.
+func (tc *TreeContext) ParseErrors() []ParseError {
+	root := tc.tree.RootNode()
+	if !root.HasError() {
+		return nil
+	}
+
+	var errs []ParseError
+	var visit func(node *sitter.Node)
+	visit = func(node *sitter.Node) {
+		if node.IsError() || node.IsMissing() {
+			start, end := node.StartPosition(), node.EndPosition()
+			errs = append(errs, ParseError{
+				Line:      int(start.Row),
+				Column:    int(start.Column),
+				EndLine:   int(end.Row),
+				EndColumn: int(end.Column),
+				Missing:   node.IsMissing(),
+				Kind:      node.Kind(),
+			})
+			return
+		}
+		if !node.HasError() {
+			return
+		}
+		for i := uint(0); i < node.ChildCount(); i++ {
+			visit(node.Child(i))
+		}
+	}
+	visit(root)
+	return errs
+}
+
+// ParseBroken reports whether the parse of the source is badly broken, with more than a tenth
+// of it lying in runs the parser could not parse, so that its scopes cannot be trusted.
+func (tc *TreeContext) ParseBroken() bool {
+	if len(tc.source) == 0 {
+		return false
+	}
+	errorBytes := 0
+	for _, e := range tc.ParseErrors() {
+		if e.Missing {
+			continue
+		}
+		errorBytes += tc.lineStarts[e.EndLine] + e.EndColumn - tc.lineStarts[e.Line] - e.Column
+	}
+	return float64(errorBytes) > brokenParseRatio*float64(len(tc.source))
+}
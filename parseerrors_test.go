@@ -0,0 +1,75 @@
+package grepast
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// TestTreeContext_ParseErrors tests listing syntax errors and detecting broken parses.
+func TestTreeContext_ParseErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		source   string
+		expected string
+		broken   bool
+	}{
+		{
+			name:     "Valid",
+			source:   "package p\n\nfunc f() {\n\ta()\n}\n",
+			expected: "[]",
+		},
+		{
+			name:     "Missing token",
+			source:   "package p\n\nfunc f() {\n\ta()\n",
+			expected: "[{4 0 4 0 true }}]",
+		},
+		{
+			name:     "Unparsable source",
+			source:   "package p\n\nfunc f() {\n\t@@@ ### $$$\n}\n",
+			expected: "[{3 1 3 12 false ERROR}]",
+			broken:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc, err := NewTreeContext("example.go", []byte(tt.source), TreeContextOptions{})
+			if err != nil {
+				t.Fatalf("NewTreeContext() error = %v", err)
+			}
+			errs := tc.ParseErrors()
+			if got := fmt.Sprint(errs); got != tt.expected {
+				t.Errorf("ParseErrors() = %s, want %s", got, tt.expected)
+			}
+			if got := tc.ParseBroken(); got != tt.broken {
+				t.Errorf("ParseBroken() = %v, want %v", got, tt.broken)
+			}
+			if got := tc.Metadata().ParseErrors; got != len(errs) {
+				t.Errorf("Metadata().ParseErrors = %d, want %d", got, len(errs))
+			}
+		})
+	}
+}
+
+// TestTreeContext_StrictParse tests falling back to plain grep output for broken parses.
+func TestTreeContext_StrictParse(t *testing.T) {
+	source := "package p\n\nfunc f() {\n" + strings.Repeat("\t@@@ ###\n", 3) + "\tneedle()\n}\n"
+	for _, tt := range []struct {
+		strict   bool
+		expected string
+	}{
+		{false, "⋮...\n│func f() {\n⋮...\n│\tneedle()\n⋮...\n"},
+		{true, "⋮...\n│\tneedle()\n⋮...\n"},
+	} {
+		tc, err := NewTreeContext("example.go", []byte(source), TreeContextOptions{ShowParentContext: true, SignatureOnly: true, StrictParse: tt.strict})
+		if err != nil {
+			t.Fatalf("NewTreeContext() error = %v", err)
+		}
+		tc.AddLinesOfInterest(map[int]struct{}{6: {}})
+		tc.AddContext()
+		if got := tc.Format(); got != tt.expected {
+			t.Errorf("Format() with StrictParse %v = %q, want %q", tt.strict, got, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,378 @@
+package grepast
+
+import (
+	"container/heap"
+	"context"
+	"encoding/json"
+	"errors"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// DefaultMaxPending is the default limit on the number of files SearchTree holds at once.
+const DefaultMaxPending = 256
+
+// errStopped ends the walk of a search that was stopped early.
+var errStopped = errors.New("search stopped")
+
+// TreeSearchOptions specifies how SearchTree walks, searches, and renders a directory tree.
+type TreeSearchOptions struct {
+	Search SearchOptions // How each file is searched and rendered.
+	Walker WalkerOptions // How files under the root are enumerated.
+	Git    GitFilter     // Which files, and optionally lines, to keep according to their git history.
+
+	// MatchWorkers is the number of files read, parsed, and searched at once. Parsing is
+	// CPU-bound and goes through cgo. Defaults to runtime.NumCPU().
+	MatchWorkers int
+
+	// FormatWorkers is the number of files whose context is gathered and rendered at once.
+	// Defaults to runtime.NumCPU().
+	FormatWorkers int
+
+	// MaxPending bounds the number of files being searched or waiting to be emitted, and so the
+	// memory held by results. The walk pauses while the limit is reached. Defaults to DefaultMaxPending.
+	MaxPending int
+
+	// Unordered emits results as soon as they are ready instead of in walk order, so a slow
+	// file does not hold back the results behind it.
+	Unordered bool
+
+	// MaxMatches keeps only the first lines of interest across all files, up to this many, in
+	// walk order unless Unordered is set. The lines of interest left out are counted in each
+	// file's FileResult.Suppressed, and files past the limit keep none. Zero means no limit.
+	MaxMatches int
+
+	// FilesWithMatches only finds the lines of interest of each file, leaving out their context,
+	// so that FileResult.Output is empty; its Matches are still found. Plain patterns are then matched line by line without
+	// parsing the files, which is much faster; other searches still parse them.
+	FilesWithMatches bool
+
+	// FileTimeout bounds the time spent reading, parsing, and searching each file, and again the
+	// time spent gathering and rendering its context. A file running out of time is reported
+	// with context.DeadlineExceeded as its error. Zero means no limit.
+	FileTimeout time.Duration
+
+	// Walked, if set, is called with the path relative to the root of each file the walk finds
+	// to search, before it is searched, e.g. to show how far a long search has got. It is called
+	// from the walk's goroutine, so it may run concurrently with fn.
+	Walked func(rel string)
+}
+
+// TreeResult is the outcome of searching one file of a tree.
+type TreeResult struct {
+	Rel        string        // Path of the file relative to the root.
+	Result     *FileResult   // Search result; nil if Err is set.
+	Err        error         // Error reading, parsing, or searching the file, e.g. ErrorUnrecognizedFiletype.
+	MatchTime  time.Duration // Time spent reading, parsing, and searching the file.
+	FormatTime time.Duration // Time spent gathering and rendering the file's context.
+}
+
+// treeResultJSON is the JSON encoding of a TreeResult. It keeps the field names and nanosecond
+// times of the default encoding, but encodes the error as its message, or null.
+type treeResultJSON struct {
+	Rel        string
+	Result     *FileResult
+	Err        *string
+	MatchTime  time.Duration
+	FormatTime time.Duration
+}
+
+// MarshalJSON encodes r with its fields in declaration order.
+func (r TreeResult) MarshalJSON() ([]byte, error) {
+	j := treeResultJSON{
+		Rel:        r.Rel,
+		Result:     r.Result,
+		MatchTime:  r.MatchTime,
+		FormatTime: r.FormatTime,
+	}
+	if r.Err != nil {
+		msg := r.Err.Error()
+		j.Err = &msg
+	}
+	return json.Marshal(j)
+}
+
+// String renders r as indented JSON for debugging.
+func (r TreeResult) String() string {
+	return debugString(r)
+}
+
+// SearchTree searches every file under root and calls fn with the result for each, in walk order
+// unless options.Unordered is set.
+//
+// Files go through two concurrent stages with their own worker pools: reading, parsing, and
+// matching, then context gathering and formatting. Large outputs being rendered therefore do not
+// hold up the parser workers. Results are emitted while the search runs, and at most
+// options.MaxPending files are held at once. fn is never called concurrently. If fn returns an
+// error, the search stops and SearchTree returns that error.
+//
+// If options.Git restricts anything, only the files it selects are searched; root must then lie
+// within a git work tree.
+func SearchTree(root string, options TreeSearchOptions, fn func(TreeResult) error) error {
+	return SearchTreeCtx(context.Background(), root, options, fn)
+}
+
+// SearchTreeCtx is like SearchTree, but stops once ctx is done, abandoning the files being
+// searched and returning ctx.Err().
+func SearchTreeCtx(ctx context.Context, root string, options TreeSearchOptions, fn func(TreeResult) error) error {
+	matchWorkers := options.MatchWorkers
+	if matchWorkers <= 0 {
+		matchWorkers = runtime.NumCPU()
+	}
+	formatWorkers := options.FormatWorkers
+	if formatWorkers <= 0 {
+		formatWorkers = runtime.NumCPU()
+	}
+	maxPending := options.MaxPending
+	if maxPending <= 0 {
+		maxPending = DefaultMaxPending
+	}
+
+	var changes *GitChanges
+	if options.Git.Enabled() {
+		var err error
+		if changes, err = options.Git.Changes(root); err != nil {
+			return err
+		}
+	}
+
+	// walkedFile is a file found by the walk, numbered in walk order.
+	type walkedFile struct {
+		index     int
+		path, rel string
+	}
+
+	done := make(chan struct{})
+	var stopOnce sync.Once
+	stop := func() { stopOnce.Do(func() { close(done) }) }
+	defer stop()
+
+	// Each file takes a slot from pending when walked and returns it once emitted.
+	pending := make(chan struct{}, maxPending)
+
+	// Walk.
+	files := make(chan walkedFile, matchWorkers)
+	walkErr := make(chan error, 1)
+	go func() {
+		defer close(files)
+		index := 0
+		walkErr <- NewWalker(options.Walker).Walk(root, func(path, rel string) error {
+			if changes != nil && !changes.Contains(rel) {
+				return nil
+			}
+			if options.Walked != nil {
+				options.Walked(rel)
+			}
+			select {
+			case pending <- struct{}{}:
+			case <-done:
+				return errStopped
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			select {
+			case files <- walkedFile{index: index, path: path, rel: rel}:
+				index++
+				return nil
+			case <-done:
+				return errStopped
+			}
+		})
+	}()
+
+	// Read, parse, and match.
+	matched := make(chan matchedFile, formatWorkers)
+	var matchWG sync.WaitGroup
+	for range matchWorkers {
+		matchWG.Add(1)
+		go func() {
+			defer matchWG.Done()
+			for f := range files {
+				start := time.Now()
+				out := matchedFile{index: f.index, res: TreeResult{Rel: f.rel}}
+				var source []byte
+				var err error
+				if options.Walker.Revision != "" {
+					source, err = revisionFile(root, options.Walker.Revision, f.rel)
+				} else {
+					source, err = changes.readFile(f.path, f.rel)
+				}
+				if err == nil {
+					fileCtx, cancel := options.fileContext(ctx)
+					if options.FilesWithMatches && options.Search.scansWithoutParsing() {
+						out.match, err = scanFile(fileCtx, f.path, source, options.Search)
+					} else {
+						out.match, err = matchFile(fileCtx, f.path, source, options.Search)
+					}
+					cancel()
+				}
+				if err == nil && changes != nil && options.Git.filtersLines() && len(out.match.found) > 0 {
+					if err = out.match.keepBlamed(changes, f.rel); err != nil {
+						out.match = nil
+					}
+				}
+				if err == nil && options.Search.MaxMatchesPerFile > 0 {
+					out.match.limit(options.Search.MaxMatchesPerFile)
+				}
+				out.res.Err = err
+				out.res.MatchTime = time.Since(start)
+
+				select {
+				case matched <- out:
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		matchWG.Wait()
+		close(matched)
+	}()
+
+	// Cap the matches across files.
+	toFormat := (<-chan matchedFile)(matched)
+	if options.MaxMatches > 0 {
+		toFormat = limitMatches(matched, options.MaxMatches, options.Unordered, done)
+	}
+
+	// Gather context and format.
+	formatted := make(chan matchedFile, formatWorkers)
+	var formatWG sync.WaitGroup
+	for range formatWorkers {
+		formatWG.Add(1)
+		go func() {
+			defer formatWG.Done()
+			for f := range toFormat {
+				if f.match != nil && options.FilesWithMatches {
+					f.res.Result = f.match.summary()
+					f.match = nil
+				} else if f.match != nil {
+					start := time.Now()
+					fileCtx, cancel := options.fileContext(ctx)
+					f.res.Result, f.res.Err = f.match.format(fileCtx, options.Search)
+					cancel()
+					f.res.FormatTime = time.Since(start)
+					f.match = nil
+				}
+
+				select {
+				case formatted <- f:
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		formatWG.Wait()
+		close(formatted)
+	}()
+
+	// Emit the results, holding back those that arrive ahead of their turn unless unordered.
+	emit := func(f matchedFile) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		err := fn(f.res)
+		<-pending
+		return err
+	}
+	var early resultHeap
+	next := 0
+	for f := range formatted {
+		if options.Unordered {
+			if err := emit(f); err != nil {
+				return err
+			}
+			continue
+		}
+
+		heap.Push(&early, f)
+		for len(early) > 0 && early[0].index == next {
+			if err := emit(heap.Pop(&early).(matchedFile)); err != nil {
+				return err
+			}
+			next++
+		}
+	}
+
+	if err := <-walkErr; err != nil && err != errStopped {
+		return err
+	}
+	return ctx.Err()
+}
+
+// limitMatches passes on the files from in, keeping only their first limit lines of interest
+// in all, in walk order unless unordered. Files are held back until those before them are
+// passed on, so that the matches kept do not depend on the order files are searched in.
+func limitMatches(in <-chan matchedFile, limit int, unordered bool, done <-chan struct{}) <-chan matchedFile {
+	out := make(chan matchedFile, cap(in))
+	go func() {
+		defer close(out)
+		remaining := limit
+		send := func(f matchedFile) bool {
+			if f.match != nil {
+				remaining -= f.match.limit(remaining)
+			}
+			select {
+			case out <- f:
+				return true
+			case <-done:
+				return false
+			}
+		}
+
+		var early resultHeap
+		next := 0
+		for f := range in {
+			if unordered {
+				if !send(f) {
+					return
+				}
+				continue
+			}
+			heap.Push(&early, f)
+			for len(early) > 0 && early[0].index == next {
+				if !send(heap.Pop(&early).(matchedFile)) {
+					return
+				}
+				next++
+			}
+		}
+	}()
+	return out
+}
+
+// fileContext returns the context for one stage of the search of a file: ctx, bounded by
+// FileTimeout if it is set.
+func (options TreeSearchOptions) fileContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if options.FileTimeout > 0 {
+		return context.WithTimeout(ctx, options.FileTimeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// matchedFile is a file that went through the match stage of SearchTree, numbered in walk order.
+type matchedFile struct {
+	index int
+	match *fileMatch
+	res   TreeResult
+}
+
+// resultHeap is a min-heap of files by walk index, implementing heap.Interface.
+type resultHeap []matchedFile
+
+func (h resultHeap) Len() int           { return len(h) }
+func (h resultHeap) Less(i, j int) bool { return h[i].index < h[j].index }
+func (h resultHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *resultHeap) Push(x any) { *h = append(*h, x.(matchedFile)) }
+
+func (h *resultHeap) Pop() any {
+	old := *h
+	f := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return f
+}
@@ -0,0 +1,303 @@
+package grepast
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestSearchTree tests that SearchTree searches every file, reports results in walk order, and
+// calls Walked for each file in walk order.
+func TestSearchTree(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"notes.txt": "needle\n",
+	}
+	for i := range 20 {
+		files[fmt.Sprintf("pkg%02d/file.go", i)] = fmt.Sprintf("package pkg\n\nfunc f%d() {\n\tneedle()\n}\n", i)
+	}
+	writeTree(t, root, files)
+
+	var rels, walked []string
+	matched := 0
+	err := SearchTree(root, TreeSearchOptions{
+		Search:        SearchOptions{Patterns: []string{"needle"}},
+		MatchWorkers:  4,
+		FormatWorkers: 2,
+		Walked:        func(rel string) { walked = append(walked, filepath.ToSlash(rel)) },
+	}, func(r TreeResult) error {
+		rels = append(rels, filepath.ToSlash(r.Rel))
+		switch {
+		case r.Rel == "notes.txt":
+			if r.Err != ErrorUnrecognizedFiletype {
+				t.Errorf("%s: Err = %v, want %v", r.Rel, r.Err, ErrorUnrecognizedFiletype)
+			}
+		case r.Err != nil:
+			t.Errorf("%s: Err = %v", r.Rel, r.Err)
+		case fmt.Sprint(r.Result.LinesOfInterest) == "[3]":
+			matched++
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("SearchTree() error = %v", err)
+	}
+
+	if matched != 20 {
+		t.Errorf("SearchTree() matched %d files, want 20", matched)
+	}
+	want := walkRel(t, NewWalker(WalkerOptions{}), root)
+	if fmt.Sprint(rels) != fmt.Sprint(want) {
+		t.Errorf("SearchTree() order = %v, want %v", rels, want)
+	}
+	if fmt.Sprint(walked) != fmt.Sprint(want) {
+		t.Errorf("SearchTree() walked %v, want %v", walked, want)
+	}
+}
+
+// TestSearchTree_Bounded tests ordered and unordered emission with a small pending limit.
+func TestSearchTree_Bounded(t *testing.T) {
+	root := t.TempDir()
+	files := make(map[string]string)
+	for i := range 30 {
+		files[fmt.Sprintf("f%02d.go", i)] = "package p\n\n// needle\n"
+	}
+	writeTree(t, root, files)
+	want := walkRel(t, NewWalker(WalkerOptions{}), root)
+
+	for _, unordered := range []bool{false, true} {
+		seen := make(map[string]bool)
+		var rels []string
+		err := SearchTree(root, TreeSearchOptions{
+			Search:     SearchOptions{Patterns: []string{"needle"}},
+			MaxPending: 2,
+			Unordered:  unordered,
+		}, func(r TreeResult) error {
+			rels = append(rels, filepath.ToSlash(r.Rel))
+			seen[filepath.ToSlash(r.Rel)] = true
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("SearchTree(Unordered: %v) error = %v", unordered, err)
+		}
+		if len(seen) != len(want) || len(rels) != len(want) {
+			t.Errorf("SearchTree(Unordered: %v) emitted %v, want each of %v once", unordered, rels, want)
+		}
+		if !unordered && fmt.Sprint(rels) != fmt.Sprint(want) {
+			t.Errorf("SearchTree() order = %v, want %v", rels, want)
+		}
+	}
+}
+
+// TestSearchTree_Stop tests that an error returned by the callback stops the search.
+func TestSearchTree_Stop(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{"a.go": "package a\n", "b.go": "package b\n"})
+
+	stop := errors.New("stop")
+	calls := 0
+	err := SearchTree(root, TreeSearchOptions{}, func(r TreeResult) error {
+		calls++
+		return stop
+	})
+	if err != stop || calls != 1 {
+		t.Errorf("SearchTree() = %v after %d calls, want %v after 1", err, calls, stop)
+	}
+}
+
+// TestTreeResult_MarshalJSON tests that TreeResult encodes its error as its message, keeping the
+// field names and nanosecond times of the default encoding that scripts read.
+func TestTreeResult_MarshalJSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		result   TreeResult
+		expected string
+	}{
+		{
+			name:     "Error",
+			result:   TreeResult{Rel: "notes.txt", Err: ErrorUnrecognizedFiletype, MatchTime: 1500 * time.Microsecond},
+			expected: `{"Rel":"notes.txt","Result":null,"Err":"` + ErrorUnrecognizedFiletype.Error() + `","MatchTime":1500000,"FormatTime":0}`,
+		},
+		{
+			name:     "Result",
+			result:   TreeResult{Rel: "a.go", Result: &FileResult{Path: "a.go", Language: "go"}, FormatTime: time.Millisecond},
+			expected: `{"Rel":"a.go","Result":{"path":"a.go","language":"go","lines_of_interest":null,"output":"","metadata":{"language":"","source_bytes":0,"parse_ns":0,"node_count":0,"max_depth":0}},"Err":null,"MatchTime":0,"FormatTime":1000000}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := json.Marshal(tt.result)
+			if err != nil {
+				t.Fatalf("json.Marshal() error = %v", err)
+			}
+			if string(got) != tt.expected {
+				t.Errorf("json.Marshal() = %s, want %s", got, tt.expected)
+			}
+
+			// Every field keeps the name it has in Go.
+			var fields map[string]any
+			if err := json.Unmarshal(got, &fields); err != nil {
+				t.Fatalf("json.Unmarshal() error = %v", err)
+			}
+			for _, f := range reflect.VisibleFields(reflect.TypeOf(TreeResult{})) {
+				if _, ok := fields[f.Name]; !ok {
+					t.Errorf("json.Marshal() = %s, want a %s field", got, f.Name)
+				}
+			}
+		})
+	}
+}
+
+// TestSearchTree_Context tests cancelling a tree search and bounding the time spent per file.
+func TestSearchTree_Context(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"a.go": "package p\n\n// needle\n",
+		"b.go": "package p\n\n// needle\n",
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	calls := 0
+	err := SearchTreeCtx(ctx, root, TreeSearchOptions{Search: SearchOptions{Patterns: []string{"needle"}}}, func(TreeResult) error {
+		calls++
+		return nil
+	})
+	if err != context.Canceled || calls != 0 {
+		t.Errorf("SearchTreeCtx() with a cancelled context = %v after %d results, want %v after none", err, calls, context.Canceled)
+	}
+
+	err = SearchTree(root, TreeSearchOptions{
+		Search:      SearchOptions{Patterns: []string{"needle"}},
+		FileTimeout: time.Nanosecond,
+	}, func(r TreeResult) error {
+		if !errors.Is(r.Err, context.DeadlineExceeded) {
+			t.Errorf("%s: Err = %v, want %v", r.Rel, r.Err, context.DeadlineExceeded)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("SearchTree() with a file timeout error = %v", err)
+	}
+}
+
+// TestNewTreeContextCtx tests that parsing and searching give up once the context is done.
+func TestNewTreeContextCtx(t *testing.T) {
+	source := []byte("package p\n\nfunc f() {}\n")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := NewTreeContextCtx(ctx, "example.go", source, TreeContextOptions{}); err != context.Canceled {
+		t.Errorf("NewTreeContextCtx() error = %v, want %v", err, context.Canceled)
+	}
+
+	tc, err := NewTreeContext("example.go", source, TreeContextOptions{})
+	if err != nil {
+		t.Fatalf("NewTreeContext() error = %v", err)
+	}
+	if _, err := tc.GrepPatternsCtx(ctx, []string{"f"}, GrepOptions{}); err != context.Canceled {
+		t.Errorf("GrepPatternsCtx() error = %v, want %v", err, context.Canceled)
+	}
+	if got := tc.Matches(); len(got) != 0 {
+		t.Errorf("Matches() after a cancelled search = %v, want none", got)
+	}
+	tc.AddLinesOfInterest(map[int]struct{}{2: {}})
+	if err := tc.AddContextCtx(ctx); err != nil {
+		t.Errorf("AddContextCtx() without parent or child context error = %v, want nil", err)
+	}
+}
+
+// TestSearchTree_MaxMatches tests capping the matches per file and across the tree.
+func TestSearchTree_MaxMatches(t *testing.T) {
+	root := t.TempDir()
+	files := make(map[string]string)
+	for i := range 6 {
+		files[fmt.Sprintf("pkg%d/file.go", i)] = "package pkg\n\nfunc f() {\n\tneedle()\n\tneedle()\n\tneedle()\n}\n"
+	}
+	writeTree(t, root, files)
+
+	var got []string
+	err := SearchTree(root, TreeSearchOptions{
+		Search:       SearchOptions{Patterns: []string{"needle"}, MaxMatchesPerFile: 2},
+		MaxMatches:   5,
+		MatchWorkers: 4,
+	}, func(r TreeResult) error {
+		if r.Err != nil {
+			t.Fatalf("%s: Err = %v", r.Rel, r.Err)
+		}
+		res := r.Result
+		if len(res.Matches) != len(res.LinesOfInterest) {
+			t.Errorf("%s: %d matches for %d lines of interest", r.Rel, len(res.Matches), len(res.LinesOfInterest))
+		}
+		if (res.Output == "") != (len(res.LinesOfInterest) == 0) {
+			t.Errorf("%s: Output = %q for lines of interest %v", r.Rel, res.Output, res.LinesOfInterest)
+		}
+		got = append(got, fmt.Sprintf("%v+%d", res.LinesOfInterest, res.Suppressed))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("SearchTree() error = %v", err)
+	}
+	if expected := "[[3 4]+1 [3 4]+1 [3]+2 []+3 []+3 []+3]"; fmt.Sprint(got) != expected {
+		t.Errorf("SearchTree() = %v, want %s", got, expected)
+	}
+}
+
+// TestSearchTree_FilesWithMatches tests that FilesWithMatches finds the same lines of interest
+// without rendering any output, whether or not the search needs to parse the files.
+func TestSearchTree_FilesWithMatches(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"a.go":      "package a\n\nfunc f() {\n\tneedle()\n}\n",
+		"b.go":      "package b\n\n// needle\nfunc g() {}\n",
+		"c.go":      "package c\n",
+		"notes.txt": "needle\n",
+	})
+
+	for _, tt := range []struct {
+		name string
+		grep GrepOptions
+		want string
+	}{
+		{"scan", GrepOptions{}, "[a.go:[3][3.1=needle] b.go:[2][2.3=needle] c.go:[][]]"},
+		{"parse", GrepOptions{NodeKinds: []string{"call_expression"}}, "[a.go:[3][3.1=needle] b.go:[][] c.go:[][]]"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			err := SearchTree(root, TreeSearchOptions{
+				Search:           SearchOptions{Patterns: []string{"needle"}, Grep: tt.grep},
+				FilesWithMatches: true,
+			}, func(r TreeResult) error {
+				if r.Err != nil {
+					if r.Rel != "notes.txt" {
+						t.Errorf("%s: Err = %v", r.Rel, r.Err)
+					}
+					return nil
+				}
+				if r.Result.Output != "" {
+					t.Errorf("%s: Output = %q, want none", r.Rel, r.Result.Output)
+				}
+				if r.Result.Language != "go" {
+					t.Errorf("%s: Language = %q, want go", r.Rel, r.Result.Language)
+				}
+				matches := []string{}
+				for _, m := range r.Result.Matches {
+					matches = append(matches, fmt.Sprintf("%d.%d=%s", m.Line, m.Column, m.Text))
+				}
+				got = append(got, fmt.Sprintf("%s:%v%v", r.Rel, r.Result.LinesOfInterest, matches))
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("SearchTree() error = %v", err)
+			}
+			if fmt.Sprint(got) != tt.want {
+				t.Errorf("SearchTree() = %v, want %s", got, tt.want)
+			}
+		})
+	}
+}
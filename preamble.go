@@ -0,0 +1,48 @@
+package grepast
+
+// preambleKinds lists, per language, the kinds of top-level nodes making up the preamble of a
+// file: its package clause and imports. Languages not listed have no preamble.
+var preambleKinds = map[string]map[string]bool{
+	"c_sharp":    {"using_directive": true, "extern_alias_directive": true},
+	"css":        {"import_statement": true, "charset_statement": true},
+	"go":         {"package_clause": true, "import_declaration": true},
+	"java":       {"package_declaration": true, "import_declaration": true},
+	"javascript": {"import_statement": true},
+	"python":     {"import_statement": true, "import_from_statement": true, "future_import_statement": true},
+	"rust":       {"use_declaration": true, "extern_crate_declaration": true},
+	"typescript": {"import_statement": true, "import_alias": true},
+}
+
+// PreambleLines returns the sorted zero-based lines of the preamble of the file, the package
+// clause and imports found at its top level, wherever they are.
+func (tc *TreeContext) PreambleLines() []int {
+	kinds := preambleKinds[tc.language]
+	if len(kinds) == 0 {
+		return nil
+	}
+
+	var lines []int
+	root := tc.tree.RootNode()
+	for i := uint(0); i < root.NamedChildCount(); i++ {
+		node := root.NamedChild(i)
+		if !kinds[node.Kind()] {
+			continue
+		}
+		start, end := int(node.StartPosition().Row), int(node.EndPosition().Row)
+		// Nodes may include their newline, ending at the start of the next line.
+		if end > start && node.EndPosition().Column == 0 {
+			end--
+		}
+		for line := start; line <= end; line++ {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// addPreamble shows the preamble of the file.
+func (tc *TreeContext) addPreamble() {
+	for _, line := range tc.PreambleLines() {
+		tc.showLines[line] = struct{}{}
+	}
+}
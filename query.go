@@ -0,0 +1,75 @@
+package grepast
+
+import (
+	"fmt"
+	"sort"
+
+	sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+// QueryCapture is a node captured by a tree-sitter query.
+type QueryCapture struct {
+	Name      string // Capture name, without the leading "@".
+	Kind      string // Tree-sitter node kind of the captured node.
+	Text      string // Source text of the captured node.
+	StartLine int    // Zero-based line on which the node starts.
+	EndLine   int    // Zero-based line on which the node ends.
+	StartByte int    // Byte offset in the source at which the node starts.
+	EndByte   int    // Byte offset in the source at which the node ends.
+}
+
+// RunQuery runs a tree-sitter S-expression query, e.g. `(call_expression function: (identifier) @fn)`,
+// against the parsed source and returns its captures ordered by position. Text predicates such
+// as #eq? and #match? are honored.
+func (tc *TreeContext) RunQuery(query string) ([]QueryCapture, error) {
+	if tc.tree == nil {
+		return nil, fmt.Errorf("no syntax tree for %s", tc.filename)
+	}
+
+	q, qerr := sitter.NewQuery(tc.tree.Language(), query)
+	if qerr != nil {
+		return nil, fmt.Errorf("invalid query: %w", qerr)
+	}
+	defer q.Close()
+
+	cursor := sitter.NewQueryCursor()
+	defer cursor.Close()
+
+	names := q.CaptureNames()
+	seen := make(map[QueryCapture]struct{})
+	var captures []QueryCapture
+
+	matches := cursor.Matches(q, tc.tree.RootNode(), tc.source)
+	for m := matches.Next(); m != nil; m = matches.Next() {
+		for _, c := range m.Captures {
+			capture := QueryCapture{
+				Name:      names[c.Index],
+				Kind:      c.Node.Kind(),
+				Text:      c.Node.Utf8Text(tc.source),
+				StartLine: int(c.Node.StartPosition().Row),
+				EndLine:   int(c.Node.EndPosition().Row),
+				StartByte: int(c.Node.StartByte()),
+				EndByte:   int(c.Node.EndByte()),
+			}
+			if _, dup := seen[capture]; dup {
+				continue
+			}
+			seen[capture] = struct{}{}
+			captures = append(captures, capture)
+		}
+	}
+
+	sort.SliceStable(captures, func(i, j int) bool {
+		return captures[i].StartByte < captures[j].StartByte
+	})
+	return captures, nil
+}
+
+// CaptureLines returns the start line of each capture, suitable for AddLinesOfInterest.
+func CaptureLines(captures []QueryCapture) map[int]struct{} {
+	lines := make(map[int]struct{}, len(captures))
+	for _, c := range captures {
+		lines[c.StartLine] = struct{}{}
+	}
+	return lines
+}
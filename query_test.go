@@ -0,0 +1,60 @@
+package grepast
+
+import (
+	"fmt"
+	"testing"
+)
+
+// TestTreeContext_RunQuery tests the RunQuery method of TreeContext.
+func TestTreeContext_RunQuery(t *testing.T) {
+	tc, err := NewTreeContext("example.go", getExampleSourceCode(), TreeContextOptions{})
+	if err != nil {
+		t.Fatalf("NewTreeContext() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		query    string
+		expected []string
+		lines    []int
+	}{
+		{
+			name:     "Function names",
+			query:    `(function_declaration name: (identifier) @name)`,
+			expected: []string{"name:smallScope", "name:largeScope", "name:main"},
+			lines:    []int{4, 9, 21},
+		},
+		{
+			name:     "Predicate",
+			query:    `((call_expression function: (identifier) @call) (#eq? @call "smallScope"))`,
+			expected: []string{"call:smallScope", "call:smallScope"},
+			lines:    []int{22, 26},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			captures, err := tc.RunQuery(tt.query)
+			if err != nil {
+				t.Fatalf("RunQuery() error = %v", err)
+			}
+
+			var got []string
+			for _, c := range captures {
+				got = append(got, c.Name+":"+c.Text)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.expected) {
+				t.Errorf("RunQuery() = %v, want %v", got, tt.expected)
+			}
+
+			lines := mapKeysSorted(CaptureLines(captures))
+			if fmt.Sprint(lines) != fmt.Sprint(tt.lines) {
+				t.Errorf("CaptureLines() = %v, want %v", lines, tt.lines)
+			}
+		})
+	}
+
+	if _, err := tc.RunQuery(`(not_a_node) @x`); err == nil {
+		t.Errorf("RunQuery() expected an error for an invalid query but got none")
+	}
+}
@@ -0,0 +1,98 @@
+package grepast
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Edit replaces a span of the source with new text.
+type Edit struct {
+	StartLine int    `json:"start_line"` // Zero-based line on which the replaced span starts.
+	EndLine   int    `json:"end_line"`   // Zero-based line on which the replaced span ends.
+	StartByte int    `json:"start_byte"` // Byte offset in the source at which the replaced span starts.
+	EndByte   int    `json:"end_byte"`   // Byte offset in the source at which the replaced span ends.
+	Old       string `json:"old"`        // Text being replaced.
+	New       string `json:"new"`        // Replacement text.
+}
+
+// String renders e as indented JSON for debugging.
+func (e Edit) String() string {
+	return debugString(e)
+}
+
+// Replace returns the edits that replace each match of a structural pattern (see StructuralSearch)
+// with replacement. Placeholders of the pattern may be used in replacement, where :[name] stands
+// for the text the placeholder matched. Matches whose replacement leaves them unchanged are omitted.
+// The source is not modified; see ApplyEdits.
+func (tc *TreeContext) Replace(pattern, replacement string) ([]Edit, error) {
+	matches, err := tc.StructuralSearch(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	var edits []Edit
+	for _, m := range matches {
+		text, err := expandReplacement(replacement, m.Holes)
+		if err != nil {
+			return nil, err
+		}
+		if text == m.Text {
+			continue
+		}
+		edits = append(edits, Edit{
+			StartLine: m.StartLine,
+			EndLine:   m.EndLine,
+			StartByte: m.StartByte,
+			EndByte:   m.EndByte,
+			Old:       m.Text,
+			New:       text,
+		})
+	}
+	return edits, nil
+}
+
+// expandReplacement substitutes the text bound to each :[name] placeholder in replacement.
+func expandReplacement(replacement string, holes map[string]string) (string, error) {
+	var sb strings.Builder
+	for {
+		i := strings.Index(replacement, ":[")
+		if i < 0 {
+			sb.WriteString(replacement)
+			return sb.String(), nil
+		}
+		sb.WriteString(replacement[:i])
+
+		rest := replacement[i+2:]
+		j := strings.IndexByte(rest, ']')
+		if j < 0 {
+			return "", fmt.Errorf("unterminated placeholder in replacement")
+		}
+		text, ok := holes[rest[:j]]
+		if !ok {
+			return "", fmt.Errorf("replacement uses placeholder :[%s] not bound by the pattern", rest[:j])
+		}
+		sb.WriteString(text)
+		replacement = rest[j+1:]
+	}
+}
+
+// ApplyEdits returns a copy of source with the given non-overlapping edits applied.
+// It returns an error if edits overlap or fall outside the source.
+func ApplyEdits(source []byte, edits []Edit) ([]byte, error) {
+	sorted := make([]Edit, len(edits))
+	copy(sorted, edits)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].StartByte < sorted[j].StartByte })
+
+	var out []byte
+	last := 0
+	for _, e := range sorted {
+		if e.StartByte < last || e.EndByte < e.StartByte || e.EndByte > len(source) {
+			return nil, fmt.Errorf("invalid edit of bytes %d-%d", e.StartByte, e.EndByte)
+		}
+		out = append(out, source[last:e.StartByte]...)
+		out = append(out, e.New...)
+		last = e.EndByte
+	}
+	return append(out, source[last:]...), nil
+}
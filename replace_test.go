@@ -0,0 +1,124 @@
+package grepast
+
+import (
+	"testing"
+)
+
+// TestTreeContext_Replace tests the Replace method of TreeContext and ApplyEdits.
+func TestTreeContext_Replace(t *testing.T) {
+	sourceCode := []byte(`package main
+
+func main() {
+	oldLog("a", x)
+	oldLog(f(1, 2), y)
+	// oldLog("comment", z)
+}
+`)
+
+	tests := []struct {
+		name        string
+		pattern     string
+		replacement string
+		expected    string
+		edits       int
+		wantErr     bool
+	}{
+		{
+			name:        "Swap placeholders",
+			pattern:     "oldLog(:[msg], :[v])",
+			replacement: "newLog(:[v], :[msg])",
+			expected: `package main
+
+func main() {
+	newLog(x, "a")
+	newLog(y, f(1, 2))
+	// oldLog("comment", z)
+}
+`,
+			edits: 2,
+		},
+		{
+			name:        "Unchanged matches produce no edits",
+			pattern:     "oldLog(:[args])",
+			replacement: "oldLog(:[args])",
+			expected:    string(sourceCode),
+		},
+		{
+			name:        "Unbound placeholder",
+			pattern:     "oldLog(:[args])",
+			replacement: "newLog(:[other])",
+			wantErr:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc, err := NewTreeContext("example.go", sourceCode, TreeContextOptions{})
+			if err != nil {
+				t.Fatalf("NewTreeContext() error = %v", err)
+			}
+
+			edits, err := tc.Replace(tt.pattern, tt.replacement)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Replace(%q, %q) expected an error but got none", tt.pattern, tt.replacement)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Replace() error = %v", err)
+			}
+			if len(edits) != tt.edits {
+				t.Errorf("Replace() returned %d edits, want %d", len(edits), tt.edits)
+			}
+
+			got, err := ApplyEdits(sourceCode, edits)
+			if err != nil {
+				t.Fatalf("ApplyEdits() error = %v", err)
+			}
+			if string(got) != tt.expected {
+				t.Errorf("ApplyEdits() =\n%s\nwant\n%s", got, tt.expected)
+			}
+		})
+	}
+}
+
+// TestApplyEdits_Overlap tests that ApplyEdits rejects overlapping edits.
+func TestApplyEdits_Overlap(t *testing.T) {
+	edits := []Edit{{StartByte: 0, EndByte: 4, New: "x"}, {StartByte: 2, EndByte: 6, New: "y"}}
+	if _, err := ApplyEdits([]byte("abcdefgh"), edits); err == nil {
+		t.Errorf("ApplyEdits() expected an error for overlapping edits")
+	}
+}
+
+// TestUnifiedDiff tests the UnifiedDiff function.
+func TestUnifiedDiff(t *testing.T) {
+	old := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\n"
+	updated := "a\nB\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl"
+
+	expected := `--- a/x.txt
++++ b/x.txt
+@@ -1,5 +1,5 @@
+ a
+-b
++B
+ c
+ d
+ e
+@@ -9,3 +9,4 @@
+ i
+ j
+ k
++l
+\ No newline at end of file
+`
+	if got := UnifiedDiff("x.txt", []byte(old), []byte(updated)); got != expected {
+		t.Errorf("UnifiedDiff() =\n%s\nwant\n%s", got, expected)
+	}
+	if got := UnifiedDiff("x.txt", []byte(old), []byte(old)); got != "" {
+		t.Errorf("UnifiedDiff() of equal files = %q, want empty", got)
+	}
+	if got := UnifiedDiff("x.txt", nil, []byte("a\n")); got != "--- a/x.txt\n+++ b/x.txt\n@@ -0,0 +1,1 @@\n+a\n" {
+		t.Errorf("UnifiedDiff() from empty =\n%s", got)
+	}
+}
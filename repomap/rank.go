@@ -0,0 +1,160 @@
+package repomap
+
+import (
+	"math"
+	"sort"
+	"strings"
+
+	grepast "github.com/cyber-nic/grep-ast"
+)
+
+const (
+	damping       = 0.85 // Probability of following an edge rather than restarting.
+	maxIterations = 100  // Upper bound on PageRank iterations.
+	tolerance     = 1e-9 // Total rank change below which PageRank has converged.
+)
+
+// graph is the reference graph between files. An edge from src to dst means src references a
+// name that dst defines.
+type graph struct {
+	n         int
+	edges     [][]edge  // Outgoing edges by source file index.
+	outWeight []float64 // Total weight of each file's outgoing edges.
+}
+
+// edge links a referencing file to a file defining the referenced name.
+type edge struct {
+	dst    int
+	name   string
+	weight float64
+}
+
+// definitionKey identifies the definitions of a name within a file.
+type definitionKey struct {
+	file int
+	name string
+}
+
+// buildGraph links the files at paths through the names they reference and define.
+func buildGraph(paths []string, files map[string]*file) *graph {
+	defines := make(map[string]map[int]struct{}) // Files defining each name.
+	references := make(map[string]map[int]int)   // Number of references to each name by file.
+	for i, rel := range paths {
+		for _, s := range files[rel].symbols {
+			switch s.Role {
+			case grepast.SymbolDefinition:
+				if defines[s.Name] == nil {
+					defines[s.Name] = make(map[int]struct{})
+				}
+				defines[s.Name][i] = struct{}{}
+			case grepast.SymbolReference:
+				if references[s.Name] == nil {
+					references[s.Name] = make(map[int]int)
+				}
+				references[s.Name][i]++
+			}
+		}
+	}
+
+	g := &graph{n: len(paths), edges: make([][]edge, len(paths)), outWeight: make([]float64, len(paths))}
+	names := make([]string, 0, len(defines))
+	for name := range defines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		definers := defines[name]
+		refs := references[name]
+		if len(refs) == 0 {
+			// Keep unreferenced definitions in the ranking with a weak link from their own file.
+			for _, dst := range sortedKeys(definers) {
+				g.add(dst, dst, name, 0.1)
+			}
+			continue
+		}
+
+		mul := nameWeight(name)
+		if len(definers) > 5 {
+			// Names defined all over the repository say little about any one definition.
+			mul *= 0.1
+		}
+		for _, src := range sortedKeys(refs) {
+			for _, dst := range sortedKeys(definers) {
+				g.add(src, dst, name, mul*math.Sqrt(float64(refs[src])))
+			}
+		}
+	}
+	return g
+}
+
+// sortedKeys returns the keys of m in increasing order, so that the graph is built deterministically.
+func sortedKeys[V any](m map[int]V) []int {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
+
+// add adds an edge from src to dst for name.
+func (g *graph) add(src, dst int, name string, weight float64) {
+	g.edges[src] = append(g.edges[src], edge{dst: dst, name: name, weight: weight})
+	g.outWeight[src] += weight
+}
+
+// nameWeight returns the weight multiplier for references to a name. Long, descriptive names
+// are strong signals; private-looking names are weak ones.
+func nameWeight(name string) float64 {
+	switch {
+	case strings.HasPrefix(name, "_"):
+		return 0.1
+	case len(name) >= 8 && (strings.ContainsRune(name, '_') || strings.ToLower(name) != name):
+		return 10
+	default:
+		return 1
+	}
+}
+
+// pageRank returns the rank of each node of g. Ranks sum to 1. Random restarts, including those
+// from nodes without outgoing edges, follow personalization, or are uniform when it is nil.
+func pageRank(g *graph, personalization []float64) []float64 {
+	if g.n == 0 {
+		return nil
+	}
+	if personalization == nil {
+		personalization = make([]float64, g.n)
+		for i := range personalization {
+			personalization[i] = 1 / float64(g.n)
+		}
+	}
+
+	rank := append([]float64(nil), personalization...)
+	next := make([]float64, g.n)
+	for iter := 0; iter < maxIterations; iter++ {
+		dangling := 0.0
+		for i := range next {
+			next[i] = 0
+			if g.outWeight[i] == 0 {
+				dangling += rank[i]
+			}
+		}
+		for src, edges := range g.edges {
+			for _, e := range edges {
+				next[e.dst] += damping * rank[src] * e.weight / g.outWeight[src]
+			}
+		}
+
+		delta := 0.0
+		for i := range next {
+			next[i] += (damping*dangling + 1 - damping) * personalization[i]
+			delta += math.Abs(next[i] - rank[i])
+		}
+		rank, next = next, rank
+		if delta < tolerance {
+			break
+		}
+	}
+	return rank
+}
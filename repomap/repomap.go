@@ -0,0 +1,295 @@
+// Package repomap builds a compact map of a repository: the definitions that matter most,
+// rendered with their enclosing scopes and trimmed to fit a token budget.
+//
+// Symbols are extracted with grepast's tags queries. Files are linked by the names they
+// reference and define, the resulting graph is ranked PageRank-style, and the rank of each
+// file flows to the definitions it references.
+package repomap
+
+import (
+	"errors"
+	"os"
+	"sort"
+	"strings"
+
+	grepast "github.com/cyber-nic/grep-ast"
+)
+
+// DefaultTokenBudget is the token budget used by Render when none is given.
+const DefaultTokenBudget = 1024
+
+// Options specifies how a repository is scanned and ranked.
+type Options struct {
+	Walker grepast.WalkerOptions // How files under the root are enumerated.
+
+	// Focus lists files, relative to the root, that the ranking is biased towards, e.g. the
+	// files being edited. Definitions they reference rank higher. Empty means no bias.
+	Focus []string
+
+	// ChurnWeight biases the ranking towards actively changing code: each file's share of the
+	// ranking's restarts is scaled by 1 + ChurnWeight × its number of git commits relative to
+	// the most committed file. Zero means no bias; otherwise root must lie within a git work tree.
+	ChurnWeight float64
+
+	// ChurnSince only counts the commits newer than this date for ChurnWeight, in any form git
+	// log --since accepts, e.g. "3months". Empty counts all commits.
+	ChurnSince string
+}
+
+// RankedFile is a file of the repository together with its rank.
+type RankedFile struct {
+	Path string  // Path relative to the repository root.
+	Rank float64 // Share of the overall rank; the ranks of all files sum to 1.
+}
+
+// RankedSymbol is a definition together with the rank it received from the files referencing it.
+type RankedSymbol struct {
+	Path   string         // Path, relative to the repository root, of the file defining the symbol.
+	Symbol grepast.Symbol // The definition.
+	Rank   float64        // Rank flowing to the definition from the files referencing its name.
+}
+
+// RepoMap is the ranked set of definitions of a repository.
+type RepoMap struct {
+	root    string
+	files   map[string]*file // Scanned files by path relative to root.
+	ranked  []RankedFile
+	symbols []RankedSymbol
+}
+
+// file is a scanned source file and the symbols found in it.
+type file struct {
+	path    string // Path on disk.
+	source  []byte
+	symbols []grepast.Symbol
+}
+
+// Build scans every supported file under root, extracts its symbols, and ranks the files and their
+// definitions. Files in languages without a parser or tags query are skipped.
+func Build(root string, options Options) (*RepoMap, error) {
+	m := &RepoMap{root: root, files: make(map[string]*file)}
+
+	err := grepast.NewWalker(options.Walker).Walk(root, func(path, rel string) error {
+		source, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		tc, err := grepast.NewTreeContext(path, source, grepast.TreeContextOptions{})
+		if err != nil {
+			if isUnsupported(err) {
+				return nil
+			}
+			return err
+		}
+		symbols, err := tc.Symbols()
+		if err != nil {
+			if isUnsupported(err) {
+				return nil
+			}
+			return err
+		}
+		if len(symbols) > 0 {
+			m.files[rel] = &file{path: path, source: source, symbols: symbols}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var churn *grepast.GitChanges
+	if options.ChurnWeight != 0 {
+		if churn, err = (grepast.GitFilter{Since: options.ChurnSince}).Changes(root); err != nil {
+			return nil, err
+		}
+	}
+	m.rank(options.Focus, churn, options.ChurnWeight)
+	return m, nil
+}
+
+// isUnsupported reports whether err means a file cannot be mapped because its language is not supported.
+func isUnsupported(err error) bool {
+	return errors.Is(err, grepast.ErrorUnrecognizedFiletype) ||
+		errors.Is(err, grepast.ErrorUnsupportedLanguage) ||
+		errors.Is(err, grepast.ErrorNoTagsQuery)
+}
+
+// Files returns the mapped files, highest ranked first.
+func (m *RepoMap) Files() []RankedFile {
+	return append([]RankedFile(nil), m.ranked...)
+}
+
+// Symbols returns the definitions of the repository, highest ranked first.
+func (m *RepoMap) Symbols() []RankedSymbol {
+	return append([]RankedSymbol(nil), m.symbols...)
+}
+
+// rank ranks the files and definitions of the map, biased towards the focus files and, by
+// churnWeight, the files changed most often by the commits of churn, if not nil.
+func (m *RepoMap) rank(focus []string, churn *grepast.GitChanges, churnWeight float64) {
+	paths := make([]string, 0, len(m.files))
+	for rel := range m.files {
+		paths = append(paths, rel)
+	}
+	sort.Strings(paths)
+
+	g := buildGraph(paths, m.files)
+	fileRanks := pageRank(g, personalization(paths, focus, churn, churnWeight))
+
+	m.ranked = make([]RankedFile, len(paths))
+	for i, rel := range paths {
+		m.ranked[i] = RankedFile{Path: rel, Rank: fileRanks[i]}
+	}
+	sort.SliceStable(m.ranked, func(i, j int) bool { return m.ranked[i].Rank > m.ranked[j].Rank })
+
+	// Each file passes its rank on to the definitions it references, in proportion to the edge weights.
+	defRanks := make(map[definitionKey]float64)
+	for src, edges := range g.edges {
+		total := g.outWeight[src]
+		for _, e := range edges {
+			defRanks[definitionKey{file: e.dst, name: e.name}] += fileRanks[src] * e.weight / total
+		}
+	}
+
+	m.symbols = nil
+	for i, rel := range paths {
+		for _, s := range m.files[rel].symbols {
+			if s.Role != grepast.SymbolDefinition {
+				continue
+			}
+			m.symbols = append(m.symbols, RankedSymbol{
+				Path:   rel,
+				Symbol: s,
+				Rank:   defRanks[definitionKey{file: i, name: s.Name}],
+			})
+		}
+	}
+	ranks := make(map[string]float64, len(paths))
+	for i, rel := range paths {
+		ranks[rel] = fileRanks[i]
+	}
+	sort.SliceStable(m.symbols, func(i, j int) bool {
+		a, b := m.symbols[i], m.symbols[j]
+		if a.Rank != b.Rank {
+			return a.Rank > b.Rank
+		}
+		return ranks[a.Path] > ranks[b.Path]
+	})
+}
+
+// personalization returns the restart distribution of the ranking: uniform over the focus files
+// found among paths, or over all paths when there are none, with each file's share scaled by
+// 1 + churnWeight × its commits in churn relative to the most committed file. It returns nil,
+// meaning uniform, when neither biases the ranking.
+func personalization(paths []string, focus []string, churn *grepast.GitChanges, churnWeight float64) []float64 {
+	wanted := make(map[string]struct{}, len(focus))
+	for _, f := range focus {
+		wanted[f] = struct{}{}
+	}
+
+	p := make([]float64, len(paths))
+	found := 0
+	for i, rel := range paths {
+		if _, ok := wanted[rel]; ok {
+			p[i] = 1
+			found++
+		}
+	}
+	if found == 0 {
+		if churn == nil {
+			return nil
+		}
+		for i := range p {
+			p[i] = 1
+		}
+	}
+
+	if churn != nil {
+		most := 0
+		for _, rel := range paths {
+			most = max(most, churn.Commits(rel))
+		}
+		if most > 0 {
+			for i, rel := range paths {
+				p[i] *= 1 + churnWeight*float64(churn.Commits(rel))/float64(most)
+			}
+		}
+	}
+
+	total := 0.0
+	for _, v := range p {
+		total += v
+	}
+	for i := range p {
+		p[i] /= total
+	}
+	return p
+}
+
+// Render returns the map of the highest ranked definitions, each shown within its enclosing
+// scopes, using as many definitions as fit in tokenBudget tokens as estimated by EstimateTokens.
+// A tokenBudget of zero or less means DefaultTokenBudget.
+func (m *RepoMap) Render(tokenBudget int) string {
+	if tokenBudget <= 0 {
+		tokenBudget = DefaultTokenBudget
+	}
+
+	// Binary search for the largest number of top definitions whose rendering fits the budget.
+	best := ""
+	lo, hi := 1, len(m.symbols)
+	for lo <= hi {
+		n := (lo + hi) / 2
+		out := m.render(m.symbols[:n])
+		if EstimateTokens(out) <= tokenBudget {
+			best = out
+			lo = n + 1
+		} else {
+			hi = n - 1
+		}
+	}
+	return best
+}
+
+// render renders the given definitions grouped by file, with files in path order.
+func (m *RepoMap) render(symbols []RankedSymbol) string {
+	lois := make(map[string]map[int]struct{})
+	for _, s := range symbols {
+		if lois[s.Path] == nil {
+			lois[s.Path] = make(map[int]struct{})
+		}
+		lois[s.Path][s.Symbol.Line] = struct{}{}
+	}
+
+	paths := make([]string, 0, len(lois))
+	for rel := range lois {
+		paths = append(paths, rel)
+	}
+	sort.Strings(paths)
+
+	var sb strings.Builder
+	for _, rel := range paths {
+		f := m.files[rel]
+		tc, err := grepast.NewTreeContext(f.path, f.source, grepast.TreeContextOptions{
+			HeaderMax:         10,
+			ShowParentContext: true,
+		})
+		if err != nil {
+			// The file was parsed while building the map.
+			continue
+		}
+		tc.AddLinesOfInterest(lois[rel])
+		tc.AddContext()
+
+		sb.WriteString("\n")
+		sb.WriteString(rel)
+		sb.WriteString(":\n")
+		sb.WriteString(tc.Format())
+	}
+	return sb.String()
+}
+
+// EstimateTokens returns a rough estimate of the number of language model tokens in s,
+// as estimated by grepast.EstimateTokens.
+func EstimateTokens(s string) int {
+	return grepast.EstimateTokens(s)
+}
@@ -0,0 +1,193 @@
+package repomap
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeRepo creates the given files, keyed by relative path, under a temporary directory and returns it.
+func writeRepo(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for rel, content := range files {
+		path := filepath.Join(root, rel)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+// exampleRepo is a small repository in which store.go defines what the other files use.
+var exampleRepo = map[string]string{
+	"store.go": `package app
+
+type Store struct{}
+
+func OpenStore() *Store {
+	return &Store{}
+}
+
+func unusedHelper() {}
+`,
+	"server.go": `package app
+
+func Serve() {
+	s := OpenStore()
+	_ = s
+}
+`,
+	"worker.go": `package app
+
+func Work() {
+	OpenStore()
+	OpenStore()
+}
+`,
+	"README.md": "# app\n",
+}
+
+// TestBuild tests that Build ranks the most referenced file and definition first.
+func TestBuild(t *testing.T) {
+	m, err := Build(writeRepo(t, exampleRepo), Options{})
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+
+	files := m.Files()
+	if len(files) != 3 {
+		t.Fatalf("Files() = %v, want 3 files", files)
+	}
+	if files[0].Path != "store.go" {
+		t.Errorf("Files()[0] = %v, want store.go", files[0])
+	}
+	total := 0.0
+	for _, f := range files {
+		total += f.Rank
+	}
+	if total < 0.999 || total > 1.001 {
+		t.Errorf("file ranks sum to %f, want 1", total)
+	}
+
+	symbols := m.Symbols()
+	rank := make(map[string]int)
+	for i, s := range symbols {
+		rank[s.Symbol.Name] = i
+	}
+	if len(symbols) != 5 || symbols[0].Path != "store.go" {
+		t.Fatalf("Symbols() = %v, want 5 definitions led by store.go", symbols)
+	}
+	if rank["OpenStore"] > rank["unusedHelper"] || rank["unusedHelper"] > rank["Serve"] {
+		t.Errorf("Symbols() = %v, want OpenStore before unusedHelper before Serve", symbols)
+	}
+}
+
+// TestBuild_Focus tests that focus files bias the ranking towards the definitions they reference.
+func TestBuild_Focus(t *testing.T) {
+	repo := map[string]string{
+		"a.go": "package app\n\nfunc Alpha() {}\n",
+		"b.go": "package app\n\nfunc Beta() {}\n",
+		"c.go": "package app\n\nfunc UseAlpha() { Alpha() }\n",
+		"d.go": "package app\n\nfunc UseBeta() { Beta() }\n",
+	}
+	root := writeRepo(t, repo)
+
+	for _, tt := range []struct{ focus, want string }{{"c.go", "Alpha"}, {"d.go", "Beta"}} {
+		m, err := Build(root, Options{Focus: []string{tt.focus}})
+		if err != nil {
+			t.Fatalf("Build() error = %v", err)
+		}
+		if got := m.Symbols()[0].Symbol.Name; got != tt.want {
+			t.Errorf("focus %s: Symbols()[0] = %s, want %s", tt.focus, got, tt.want)
+		}
+	}
+}
+
+// gitCommit writes the given files, keyed by relative path, under dir and commits all changes.
+func gitCommit(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for rel, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, rel), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, args := range [][]string{{"add", "-A"}, {"commit", "-q", "-m", "change"}} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"GIT_CONFIG_GLOBAL="+os.DevNull, "GIT_CONFIG_NOSYSTEM=1",
+			"GIT_AUTHOR_NAME=bob", "GIT_AUTHOR_EMAIL=bob@example.com",
+			"GIT_COMMITTER_NAME=bob", "GIT_COMMITTER_EMAIL=bob@example.com",
+		)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+}
+
+// TestBuild_ChurnWeight tests that churn biases the ranking towards the definitions referenced
+// by the most committed files.
+func TestBuild_ChurnWeight(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	repo := map[string]string{
+		"a.go": "package app\n\nfunc Alpha() {}\n",
+		"b.go": "package app\n\nfunc Beta() {}\n",
+		"c.go": "package app\n\nfunc UseAlpha() { Alpha() }\n",
+		"d.go": "package app\n\nfunc UseBeta() { Beta() }\n",
+	}
+
+	for _, tt := range []struct{ churned, want string }{{"c.go", "Alpha"}, {"d.go", "Beta"}} {
+		root := writeRepo(t, nil)
+		if out, err := exec.Command("git", "init", "-q", root).CombinedOutput(); err != nil {
+			t.Fatalf("git init: %v\n%s", err, out)
+		}
+		gitCommit(t, root, repo)
+		for i := range 3 {
+			gitCommit(t, root, map[string]string{tt.churned: repo[tt.churned] + fmt.Sprintf("\n// change %d\n", i)})
+		}
+
+		m, err := Build(root, Options{ChurnWeight: 5})
+		if err != nil {
+			t.Fatalf("Build() error = %v", err)
+		}
+		if got := m.Symbols()[0].Symbol.Name; got != tt.want {
+			t.Errorf("churn in %s: Symbols()[0] = %s, want %s", tt.churned, got, tt.want)
+		}
+	}
+
+	if _, err := Build(writeRepo(t, repo), Options{ChurnWeight: 5}); err == nil {
+		t.Error("Build() outside a git work tree: error = nil, want an error")
+	}
+}
+
+// TestRepoMap_Render tests that Render fits the budget and shows the top definitions first.
+func TestRepoMap_Render(t *testing.T) {
+	m, err := Build(writeRepo(t, exampleRepo), Options{})
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+
+	full := m.Render(10000)
+	for _, want := range []string{"store.go:\n", "func OpenStore() *Store {", "server.go:\n", "func Serve() {"} {
+		if !strings.Contains(full, want) {
+			t.Errorf("Render(10000) missing %q:\n%s", want, full)
+		}
+	}
+
+	small := m.Render(12)
+	if EstimateTokens(small) > 12 {
+		t.Errorf("Render(12) uses %d tokens:\n%s", EstimateTokens(small), small)
+	}
+	if !strings.Contains(small, "store.go:") || strings.Contains(small, "server.go") {
+		t.Errorf("Render(12) should show only the top definitions:\n%s", small)
+	}
+}
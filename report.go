@@ -0,0 +1,137 @@
+package grepast
+
+import (
+	"sort"
+	"strings"
+)
+
+// ReportOrder is the order in which a Report renders its files.
+type ReportOrder string
+
+const (
+	ReportByInsertion ReportOrder = ""        // In the order the files were added.
+	ReportByPath      ReportOrder = "path"    // By path.
+	ReportByMatches   ReportOrder = "matches" // By number of lines of interest, most first, then by path.
+)
+
+// ReportOptions specifies how a Report renders its files.
+type ReportOptions struct {
+	MaxOutputBytes  int         // Trim the context of the largest files until the report is at most this many bytes; 0 means no limit.
+	MaxOutputTokens int         // Trim the context of the largest files until the report is at most this many estimated tokens; 0 means no limit.
+	Order           ReportOrder // Order of the files in the report.
+}
+
+// Report aggregates the TreeContexts of several files, e.g. the results of a tree search, and
+// renders them as one document with a header per file. Its output budget is shared by all
+// files: when it is exceeded, the context of the largest files is trimmed first, keeping their
+// most important lines as Format does.
+type Report struct {
+	options ReportOptions
+	files   []reportFile
+}
+
+// reportFile is a file of a Report.
+type reportFile struct {
+	path string
+	tc   *TreeContext
+}
+
+// NewReport returns an empty Report.
+func NewReport(options ReportOptions) *Report {
+	return &Report{options: options}
+}
+
+// Add adds a file to the report, shown under path. AddContext must have been called on tc, which
+// must not be changed until the report has been formatted.
+func (r *Report) Add(path string, tc *TreeContext) {
+	r.files = append(r.files, reportFile{path: path, tc: tc})
+}
+
+// Len returns the number of files added to the report.
+func (r *Report) Len() int {
+	return len(r.files)
+}
+
+// Format renders each file showing context under a "path:" header, in the order of the options,
+// within the output budget. Files showing nothing are left out.
+func (r *Report) Format() string {
+	files := r.sorted()
+	out := r.render(files, 0)
+	if r.fits(out) {
+		return out
+	}
+
+	// Binary search for the largest per-file output size under which the report fits.
+	best := ""
+	lo, hi := 1, len(out)
+	for lo <= hi {
+		n := (lo + hi) / 2
+		if trimmed := r.render(files, n); r.fits(trimmed) {
+			best = trimmed
+			lo = n + 1
+		} else {
+			hi = n - 1
+		}
+	}
+	return best
+}
+
+// sorted returns the files of the report in the order of its options.
+func (r *Report) sorted() []reportFile {
+	files := append([]reportFile(nil), r.files...)
+	switch r.options.Order {
+	case ReportByPath:
+		sort.SliceStable(files, func(i, j int) bool { return files[i].path < files[j].path })
+	case ReportByMatches:
+		sort.SliceStable(files, func(i, j int) bool {
+			a, b := len(files[i].tc.linesOfInterest), len(files[j].tc.linesOfInterest)
+			if a != b {
+				return a > b
+			}
+			return files[i].path < files[j].path
+		})
+	}
+	return files
+}
+
+// render renders files, limiting the context of each to maxBytes bytes; 0 means no limit other
+// than the file's own.
+func (r *Report) render(files []reportFile, maxBytes int) string {
+	var sb strings.Builder
+	for _, f := range files {
+		out := f.tc.formatWithin(maxBytes)
+		if out == "" {
+			continue
+		}
+		sb.WriteString("\n")
+		sb.WriteString(f.path)
+		sb.WriteString(":\n")
+		sb.WriteString(out)
+	}
+	return sb.String()
+}
+
+// fits reports whether out is within the output budget of the report.
+func (r *Report) fits(out string) bool {
+	if r.options.MaxOutputBytes > 0 && len(out) > r.options.MaxOutputBytes {
+		return false
+	}
+	if r.options.MaxOutputTokens > 0 && EstimateTokens(out) > r.options.MaxOutputTokens {
+		return false
+	}
+	return true
+}
+
+// formatWithin renders tc as Format does, but within maxBytes bytes as well as its own output
+// budget; 0 means no additional limit.
+func (tc *TreeContext) formatWithin(maxBytes int) string {
+	if maxBytes <= 0 {
+		return tc.Format()
+	}
+	saved := tc.maxOutputBytes
+	defer func() { tc.maxOutputBytes = saved }()
+	if saved == 0 || maxBytes < saved {
+		tc.maxOutputBytes = maxBytes
+	}
+	return tc.Format()
+}
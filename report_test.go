@@ -0,0 +1,84 @@
+package grepast
+
+import (
+	"strings"
+	"testing"
+)
+
+// reportContext returns a TreeContext for source with pattern's matches as lines of interest.
+func reportContext(t *testing.T, filename, source, pattern string) *TreeContext {
+	t.Helper()
+	tc, err := NewTreeContext(filename, []byte(source), TreeContextOptions{ShowParentContext: true})
+	if err != nil {
+		t.Fatalf("NewTreeContext() error = %v", err)
+	}
+	found, err := tc.GrepWithOptions(pattern, GrepOptions{})
+	if err != nil {
+		t.Fatalf("GrepWithOptions() error = %v", err)
+	}
+	tc.AddLinesOfInterest(found)
+	tc.AddContext()
+	return tc
+}
+
+// TestReport tests ordering the files of a Report and sharing its output budget.
+func TestReport(t *testing.T) {
+	long := "package p\n\nfunc f() {\n\tx()\n\tx()\n\tx()\n}\n"
+	short := "package p\n\nfunc g() {\n\tx()\n}\n"
+	none := "package p\n"
+
+	tests := []struct {
+		name     string
+		options  ReportOptions
+		expected string
+	}{
+		{
+			name:     "Insertion order",
+			expected: "\nb.go:\n⋮...\n│func g() {\n│\tx()\n│}\n\na.go:\n⋮...\n│func f() {\n│\tx()\n│\tx()\n│\tx()\n│}\n",
+		},
+		{
+			name:     "By path",
+			options:  ReportOptions{Order: ReportByPath},
+			expected: "\na.go:\n⋮...\n│func f() {\n│\tx()\n│\tx()\n│\tx()\n│}\n\nb.go:\n⋮...\n│func g() {\n│\tx()\n│}\n",
+		},
+		{
+			name:     "By matches",
+			options:  ReportOptions{Order: ReportByMatches},
+			expected: "\na.go:\n⋮...\n│func f() {\n│\tx()\n│\tx()\n│\tx()\n│}\n\nb.go:\n⋮...\n│func g() {\n│\tx()\n│}\n",
+		},
+		{
+			// The larger file is trimmed, while the smaller one is shown in full.
+			name:     "Shared budget",
+			options:  ReportOptions{MaxOutputBytes: 80},
+			expected: "\nb.go:\n⋮...\n│func g() {\n│\tx()\n│}\n\na.go:\n⋮...\n│\tx()\n│\tx()\n⋮...\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewReport(tt.options)
+			r.Add("b.go", reportContext(t, "b.go", short, `x\(`))
+			r.Add("a.go", reportContext(t, "a.go", long, `x\(`))
+			r.Add("c.go", reportContext(t, "c.go", none, `x\(`))
+			if r.Len() != 3 {
+				t.Errorf("Len() = %d, want 3", r.Len())
+			}
+			got := r.Format()
+			if got != tt.expected {
+				t.Errorf("Format() = %q, want %q", got, tt.expected)
+			}
+			if tt.options.MaxOutputBytes > 0 && len(got) > tt.options.MaxOutputBytes {
+				t.Errorf("Format() is %d bytes, want at most %d", len(got), tt.options.MaxOutputBytes)
+			}
+		})
+	}
+
+	// Rendering within a budget leaves the files' own budgets untouched.
+	tc := reportContext(t, "a.go", long, `x\(`)
+	r := NewReport(ReportOptions{MaxOutputTokens: 5})
+	r.Add("a.go", tc)
+	r.Format()
+	if tc.maxOutputBytes != 0 || !strings.Contains(tc.Format(), "func f() {") {
+		t.Errorf("Format() changed the budget of an added TreeContext")
+	}
+}
@@ -0,0 +1,207 @@
+package grepast
+
+import (
+	"encoding/json"
+	"strings"
+	"unicode/utf16"
+)
+
+// SARIF output follows version 2.1.0 of the Static Analysis Results Interchange Format, as
+// accepted by GitHub code scanning. Only the properties grep-ast fills in are modeled.
+const (
+	SARIFVersion = "2.1.0"
+	SARIFSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+
+	// SARIFRuleID is the rule reported for every match.
+	SARIFRuleID = "grep-ast/match"
+)
+
+// SARIFLog is the top-level object of a SARIF file.
+type SARIFLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []SARIFRun `json:"runs"`
+}
+
+// SARIFRun is the output of one run of a tool.
+type SARIFRun struct {
+	Tool    SARIFTool     `json:"tool"`
+	Results []SARIFResult `json:"results"`
+}
+
+// SARIFTool describes the tool that produced a run.
+type SARIFTool struct {
+	Driver SARIFDriver `json:"driver"`
+}
+
+// SARIFDriver is the main component of a tool, with the rules it reports.
+type SARIFDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri,omitempty"`
+	Rules          []SARIFRule `json:"rules,omitempty"`
+}
+
+// SARIFRule is a rule that results refer to by ID.
+type SARIFRule struct {
+	ID               string       `json:"id"`
+	ShortDescription SARIFMessage `json:"shortDescription"`
+}
+
+// SARIFResult is a single finding: here, one line of interest.
+type SARIFResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   SARIFMessage    `json:"message"`
+	Locations []SARIFLocation `json:"locations"`
+}
+
+// SARIFMessage is a plain text message.
+type SARIFMessage struct {
+	Text string `json:"text"`
+}
+
+// SARIFLocation is where a result was found.
+type SARIFLocation struct {
+	PhysicalLocation SARIFPhysicalLocation `json:"physicalLocation"`
+}
+
+// SARIFPhysicalLocation is a region of a file, together with the context shown around it.
+type SARIFPhysicalLocation struct {
+	ArtifactLocation SARIFArtifactLocation `json:"artifactLocation"`
+	Region           SARIFRegion           `json:"region"`
+	ContextRegion    *SARIFRegion          `json:"contextRegion,omitempty"`
+}
+
+// SARIFArtifactLocation identifies a file by URI, optionally relative to a base such as "%SRCROOT%".
+type SARIFArtifactLocation struct {
+	URI       string `json:"uri"`
+	URIBaseID string `json:"uriBaseId,omitempty"`
+}
+
+// SARIFRegion is a run of lines of a file, or part of a line. Lines and columns are one-based,
+// as SARIF requires, and columns count UTF-16 code units, SARIF's default column kind.
+type SARIFRegion struct {
+	StartLine   int           `json:"startLine"`
+	StartColumn int           `json:"startColumn,omitempty"`
+	EndLine     int           `json:"endLine,omitempty"`
+	EndColumn   int           `json:"endColumn,omitempty"`
+	Snippet     *SARIFSnippet `json:"snippet,omitempty"`
+}
+
+// SARIFSnippet is the source text of a region.
+type SARIFSnippet struct {
+	Text string `json:"text"`
+}
+
+// NewSARIFLog returns a log with a single, empty grep-ast run.
+func NewSARIFLog() *SARIFLog {
+	return &SARIFLog{
+		Schema:  SARIFSchema,
+		Version: SARIFVersion,
+		Runs: []SARIFRun{{
+			Tool: SARIFTool{Driver: SARIFDriver{
+				Name:           "grep-ast",
+				InformationURI: "https://github.com/cyber-nic/grep-ast",
+				Rules: []SARIFRule{{
+					ID:               SARIFRuleID,
+					ShortDescription: SARIFMessage{Text: "Search match"},
+				}},
+			}},
+			Results: []SARIFResult{},
+		}},
+	}
+}
+
+// Add appends results to the log's run.
+func (l *SARIFLog) Add(results ...SARIFResult) {
+	l.Runs[0].Results = append(l.Runs[0].Results, results...)
+}
+
+// SARIFResults returns a result for each line of interest, located in the file at uri. The
+// region is the line itself, narrowed to the line's first match if Grep found one, and the
+// context region is the run of shown lines around it.
+func (tc *TreeContext) SARIFResults(uri string) []SARIFResult {
+	shown := tc.Formatted().Shown
+
+	results := []SARIFResult{}
+	for _, loi := range mapKeysSorted(tc.linesOfInterest) {
+		if loi < 0 || loi >= tc.numLines {
+			continue
+		}
+		message := "Match"
+		if scope := tc.scopeName(loi, loi); scope != "" {
+			message = "Match in " + scope
+		}
+
+		location := SARIFPhysicalLocation{
+			ArtifactLocation: SARIFArtifactLocation{URI: uri},
+			Region: SARIFRegion{
+				StartLine: loi + 1,
+				Snippet:   &SARIFSnippet{Text: tc.lines[loi]},
+			},
+		}
+		if spans := tc.matchSpans[loi]; len(spans) > 0 {
+			first := spans[0]
+			for _, span := range spans[1:] {
+				if span.start < first.start {
+					first = span
+				}
+			}
+			line := tc.lines[loi]
+			location.Region.StartColumn = utf16Column(line, first.start)
+			location.Region.EndColumn = utf16Column(line, first.end)
+		}
+		for _, r := range shown {
+			if r.Start <= loi && loi <= r.End {
+				location.ContextRegion = &SARIFRegion{
+					StartLine: r.Start + 1,
+					EndLine:   r.End + 1,
+					Snippet:   &SARIFSnippet{Text: tc.linesText(r.Start, r.End)},
+				}
+				break
+			}
+		}
+
+		results = append(results, SARIFResult{
+			RuleID:    SARIFRuleID,
+			Level:     "note",
+			Message:   SARIFMessage{Text: message},
+			Locations: []SARIFLocation{{PhysicalLocation: location}},
+		})
+	}
+	return results
+}
+
+// utf16Column returns the one-based column, in UTF-16 code units, of the byte at offset in line.
+// Invalid bytes count as one unit each, as U+FFFD would.
+func utf16Column(line string, offset int) int {
+	column := 1
+	for _, r := range line[:min(offset, len(line))] {
+		if n := utf16.RuneLen(r); n > 0 {
+			column += n
+		} else {
+			column++
+		}
+	}
+	return column
+}
+
+// linesText returns lines [start, end] of the source, each ending in a newline.
+func (tc *TreeContext) linesText(start, end int) string {
+	return strings.Join(tc.lines[start:end+1], "\n") + "\n"
+}
+
+// SARIFFormatter renders the lines of interest as a JSON array of SARIF results, as returned by
+// TreeContext.SARIFResults for the file's name. Callers collect them into a SARIFLog, usually
+// after making the URIs relative to the search root.
+type SARIFFormatter struct{}
+
+// Format implements Formatter.
+func (SARIFFormatter) Format(tc *TreeContext) string {
+	out, err := json.Marshal(tc.SARIFResults(tc.filename))
+	if err != nil {
+		// SARIF results hold only strings and numbers, which always encode.
+		panic(err)
+	}
+	return string(out)
+}
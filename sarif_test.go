@@ -0,0 +1,62 @@
+package grepast
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// TestTreeContext_SARIFResults tests the SARIFResults method of TreeContext.
+func TestTreeContext_SARIFResults(t *testing.T) {
+	source := []byte("package p\n\nfunc f() {\n\ta()\n\tb()\n\tc()\n\td()\n}\n")
+	tc, err := NewTreeContext("example.go", source, TreeContextOptions{ShowParentContext: true})
+	if err != nil {
+		t.Fatalf("NewTreeContext() error = %v", err)
+	}
+	tc.AddLinesOfInterest(map[int]struct{}{6: {}})
+	tc.AddContext()
+
+	log := NewSARIFLog()
+	log.Add(tc.SARIFResults("p/example.go")...)
+	got, err := json.Marshal(log.Runs[0].Results)
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	expected := `[{"ruleId":"grep-ast/match","level":"note","message":{"text":"Match in f"},` +
+		`"locations":[{"physicalLocation":{"artifactLocation":{"uri":"p/example.go"},` +
+		`"region":{"startLine":7,"snippet":{"text":"\td()"}},` +
+		`"contextRegion":{"startLine":3,"endLine":8,"snippet":{"text":"func f() {\n\ta()\n\tb()\n\tc()\n\td()\n}\n"}}}}]}]`
+	if string(got) != expected {
+		t.Errorf("SARIFResults() =\n%s\nwant\n%s", got, expected)
+	}
+
+	// The formatter renders the same results, located by the file's name.
+	var formatted []SARIFResult
+	if err := json.Unmarshal([]byte(SARIFFormatter{}.Format(tc)), &formatted); err != nil {
+		t.Fatalf("SARIFFormatter.Format() is not JSON: %v", err)
+	}
+	if len(formatted) != 1 || formatted[0].Locations[0].PhysicalLocation.ArtifactLocation.URI != "example.go" {
+		t.Errorf("SARIFFormatter.Format() = %+v, want one result in example.go", formatted)
+	}
+}
+
+// TestTreeContext_SARIFResultsColumns tests that the columns of a match count UTF-16 code units,
+// not bytes, after a non-ASCII prefix.
+func TestTreeContext_SARIFResultsColumns(t *testing.T) {
+	// "é" is two bytes and one unit, "😀" four bytes and two units.
+	source := []byte("package p\n\nvar s = \"é😀\" + target\n")
+	tc, err := NewTreeContext("example.go", source, TreeContextOptions{})
+	if err != nil {
+		t.Fatalf("NewTreeContext() error = %v", err)
+	}
+	tc.AddLinesOfInterest(tc.Grep("target", false))
+	tc.AddContext()
+
+	results := tc.SARIFResults("example.go")
+	if len(results) != 1 {
+		t.Fatalf("SARIFResults() = %d results, want 1", len(results))
+	}
+	region := results[0].Locations[0].PhysicalLocation.Region
+	if region.StartColumn != 17 || region.EndColumn != 23 {
+		t.Errorf("SARIFResults() columns = %d-%d, want 17-23", region.StartColumn, region.EndColumn)
+	}
+}
@@ -0,0 +1,189 @@
+package grepast
+
+import (
+	"strings"
+
+	sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+// ScopeRange is a scope of the source: the lines covered by the largest parse-tree node starting
+// on a line, such as a function, class, or block.
+type ScopeRange struct {
+	Start int    `json:"start"`          // First zero-based line of the scope.
+	End   int    `json:"end"`            // Last zero-based line of the scope.
+	Kind  string `json:"kind"`           // Tree-sitter node kind of the scope, e.g. "function_declaration".
+	Name  string `json:"name,omitempty"` // Name of the scope's node, e.g. a function name, if it has one.
+}
+
+// ShownLines returns the sorted zero-based numbers of the lines Format shows, honoring output
+// budgets as Format does. It is empty until AddContext has been called.
+func (tc *TreeContext) ShownLines() []int {
+	return mapKeysSorted(tc.renderedLines())
+}
+
+// LinesOfInterest returns the sorted zero-based numbers of the lines of interest.
+func (tc *TreeContext) LinesOfInterest() []int {
+	return mapKeysSorted(tc.linesOfInterest)
+}
+
+// ScopesAt returns the scopes enclosing line, outermost first, ending with any scope starting on
+// the line itself. The file itself is not a scope.
+// It returns nil for lines outside the source.
+func (tc *TreeContext) ScopesAt(line int) []ScopeRange {
+	if line < 0 || line >= len(tc.scopes) {
+		return nil
+	}
+
+	var scopes []ScopeRange
+	for _, start := range mapKeysSorted(tc.scopes[line]) {
+		end := tc.scopeEnd(start)
+		node := tc.scopeDefinition(start)
+		if end < line || node == nil {
+			continue
+		}
+		scope := ScopeRange{Start: start, End: end, Kind: node.Kind()}
+		if name := node.ChildByFieldName("name"); name != nil {
+			scope.Name = name.Utf8Text(tc.source)
+		}
+		scopes = append(scopes, scope)
+	}
+	return scopes
+}
+
+// scopeDefinition returns the node describing the scope starting at line start: the innermost of
+// the nodes spanning the same source as the largest node starting there, so that a function is
+// preferred over a block holding nothing else. It returns nil if no node other than the root starts there.
+func (tc *TreeContext) scopeDefinition(start int) *sitter.Node {
+	node := tc.scopeNode(start)
+	if node == nil {
+		return nil
+	}
+	// Nodes are recorded in walk order, so descendants follow their ancestors.
+	for _, n := range tc.nodes[start] {
+		if n.StartByte() == node.StartByte() && n.EndByte() == node.EndByte() {
+			node = n
+		}
+	}
+	return node
+}
+
+// HeaderFor returns the first and last lines of the header of the scope starting at line, which
+// are the lines shown for it when it is a parent of a line of interest, e.g. a function's
+// signature. Lines starting no scope are their own header, and lines outside the source have
+// the header (-1, -1).
+func (tc *TreeContext) HeaderFor(line int) (start, end int) {
+	if line < 0 || line >= len(tc.header) || len(tc.header[line]) < 2 {
+		return -1, -1
+	}
+	start, end = tc.header[line][0], tc.header[line][1]-1
+	if start != line {
+		// Only multi-line scopes record a header of their own.
+		return line, line
+	}
+	if tc.headerAnnotations {
+		start = tc.annotatedStart(start)
+	}
+	return start, max(start, end)
+}
+
+// EnclosingScope returns the innermost scope enclosing line that spans several lines, such as
+// the function or block a match lies in, or false if line lies in none. A multi-line scope
+// starting on line itself counts as enclosing it.
+func (tc *TreeContext) EnclosingScope(line int) (ScopeRange, bool) {
+	chain := tc.ParentChain(line)
+	if len(chain) == 0 {
+		return ScopeRange{}, false
+	}
+	return chain[0], true
+}
+
+// ParentChain returns the scopes enclosing line that span several lines, innermost first, so
+// that walking it leads from a match up to the outermost definition around it. Joining the
+// names in reverse gives a qualified name such as "Outer.inner".
+func (tc *TreeContext) ParentChain(line int) []ScopeRange {
+	scopes := tc.ScopesAt(line)
+	var chain []ScopeRange
+	for i := len(scopes) - 1; i >= 0; i-- {
+		if scopes[i].End > scopes[i].Start {
+			chain = append(chain, scopes[i])
+		}
+	}
+	return chain
+}
+
+// definitionsAt returns the definitions enclosing line, outermost first: the parse-tree nodes
+// with both a name and a body, such as functions, methods, and classes. A definition starting
+// on the line itself counts as enclosing it.
+func (tc *TreeContext) definitionsAt(line int) []*sitter.Node {
+	if line < 0 || line >= len(tc.lines) {
+		return nil
+	}
+	text := tc.lines[line]
+	pos := uint(tc.lineStarts[line] + len(text) - len(strings.TrimLeft(text, " \t")))
+	var defs []*sitter.Node
+	for node := tc.tree.RootNode().DescendantForByteRange(pos, pos); node != nil; node = node.Parent() {
+		if node.ChildByFieldName("body") != nil && node.ChildByFieldName("name") != nil {
+			defs = append([]*sitter.Node{node}, defs...)
+		}
+	}
+	return defs
+}
+
+// Breadcrumb returns a line locating line within the file, naming the file and the definitions
+// enclosing the line, outermost first, e.g. "main.go ▸ class Server ▸ def start(self)". Each
+// definition is named by the start of its first line, up to any opening brace or colon.
+func (tc *TreeContext) Breadcrumb(line int) string {
+	crumbs := []string{tc.filename}
+	for _, def := range tc.definitionsAt(line) {
+		crumbs = append(crumbs, definitionLabel(def, tc.source))
+	}
+	return strings.Join(crumbs, " ▸ ")
+}
+
+// definitionLabel returns the first line of the source of a definition, without the opening
+// brace or colon of its body and surrounding whitespace.
+func definitionLabel(node *sitter.Node, source []byte) string {
+	text := node.Utf8Text(source)
+	if i := strings.IndexByte(text, '\n'); i >= 0 {
+		text = text[:i]
+	}
+	text = strings.TrimSpace(text)
+	text = strings.TrimSuffix(text, "{")
+	text = strings.TrimSuffix(text, ":")
+	return strings.TrimSpace(text)
+}
+
+// kindLabelSuffixes are stripped from node kinds to label scopes, e.g. "class_definition" is "class".
+var kindLabelSuffixes = []string{"_declaration", "_definition", "_item", "_statement", "_expression", "_specifier"}
+
+// kindLabelAliases shortens the labels of function-like kinds, which languages name differently.
+// Kinds aliased to "" are not labeled, since their kind says little about their scope.
+var kindLabelAliases = map[string]string{
+	"function":       "func",
+	"func_literal":   "func",
+	"arrow_function": "func",
+	"lambda":         "func",
+	"lexical":        "",
+}
+
+// scopeKindLabel returns the label, such as "[func]" or "[class]", of the scope starting on line
+// i, or "" if no multi-line declaration or other scope with a body, such as a loop, starts there.
+func (tc *TreeContext) scopeKindLabel(i int) string {
+	node := tc.scopeDefinition(i)
+	if node == nil || node.EndPosition().Row == node.StartPosition().Row {
+		return ""
+	}
+	kind := node.Kind()
+	if !tc.isDeclarationKind(kind) && node.ChildByFieldName("body") == nil && node.ChildByFieldName("consequence") == nil {
+		return ""
+	}
+	for _, suffix := range kindLabelSuffixes {
+		kind = strings.TrimSuffix(kind, suffix)
+	}
+	if alias, ok := kindLabelAliases[kind]; ok {
+		if kind = alias; kind == "" {
+			return ""
+		}
+	}
+	return "[" + kind + "] "
+}
@@ -0,0 +1,185 @@
+package grepast
+
+import (
+	"fmt"
+	"testing"
+)
+
+// TestTreeContext_ScopesAt tests the ScopesAt method of TreeContext.
+func TestTreeContext_ScopesAt(t *testing.T) {
+	source := []byte("package p\n\nfunc (t T) f() {\n\tif true {\n\t\ta()\n\t}\n}\n")
+	tc, err := NewTreeContext("example.go", source, TreeContextOptions{})
+	if err != nil {
+		t.Fatalf("NewTreeContext() error = %v", err)
+	}
+
+	tests := []struct {
+		line     int
+		expected string
+	}{
+		{-1, "[]"},
+		{1, "[]"},
+		{2, "[{2 6 method_declaration f}]"},
+		{4, "[{2 6 method_declaration f} {3 5 if_statement } {4 4 call_expression }]"},
+		{5, "[{2 6 method_declaration f} {3 5 if_statement }]"},
+		{100, "[]"},
+	}
+	for _, tt := range tests {
+		if got := fmt.Sprint(tc.ScopesAt(tt.line)); got != tt.expected {
+			t.Errorf("ScopesAt(%d) = %s, want %s", tt.line, got, tt.expected)
+		}
+	}
+}
+
+// TestTreeContext_HeaderFor tests the HeaderFor method of TreeContext.
+func TestTreeContext_HeaderFor(t *testing.T) {
+	source := []byte("package p\n\n// f does things.\nfunc f(\n\ta int,\n) {\n\tg()\n}\n")
+	tests := []struct {
+		options  TreeContextOptions
+		line     int
+		expected string
+	}{
+		{TreeContextOptions{HeaderMax: 10}, 3, "3 4"},
+		{TreeContextOptions{HeaderMax: 10}, 6, "6 6"},
+		{TreeContextOptions{HeaderMax: 10}, -1, "-1 -1"},
+		{TreeContextOptions{HeaderMax: 1}, 3, "3 3"},
+		{TreeContextOptions{HeaderMax: 10, HeaderAnnotations: true}, 3, "2 4"},
+	}
+	for _, tt := range tests {
+		tc, err := NewTreeContext("example.go", source, tt.options)
+		if err != nil {
+			t.Fatalf("NewTreeContext() error = %v", err)
+		}
+		if start, end := tc.HeaderFor(tt.line); fmt.Sprint(start, end) != tt.expected {
+			t.Errorf("HeaderFor(%d) with HeaderMax %d, HeaderAnnotations %v = %d %d, want %s",
+				tt.line, tt.options.HeaderMax, tt.options.HeaderAnnotations, start, end, tt.expected)
+		}
+	}
+}
+
+// TestTreeContext_ShownLines tests the ShownLines and LinesOfInterest methods of TreeContext.
+func TestTreeContext_ShownLines(t *testing.T) {
+	source := []byte("package p\n\nfunc f() {\n\ta()\n\tb()\n}\n")
+	tc, err := NewTreeContext("example.go", source, TreeContextOptions{ShowParentContext: true})
+	if err != nil {
+		t.Fatalf("NewTreeContext() error = %v", err)
+	}
+	if got := tc.ShownLines(); len(got) != 0 {
+		t.Errorf("ShownLines() before AddContext = %v, want []", got)
+	}
+
+	tc.AddLinesOfInterest(map[int]struct{}{3: {}})
+	tc.AddContext()
+	if got := fmt.Sprint(tc.ShownLines()); got != "[2 3 4 5]" {
+		t.Errorf("ShownLines() = %s, want [2 3 4 5]", got)
+	}
+	if got := fmt.Sprint(tc.LinesOfInterest()); got != "[3]" {
+		t.Errorf("LinesOfInterest() = %s, want [3]", got)
+	}
+}
+
+// TestTreeContext_ParentChain tests the EnclosingScope and ParentChain methods of TreeContext.
+func TestTreeContext_ParentChain(t *testing.T) {
+	source := []byte("class Outer:\n    def inner(self):\n        if x:\n            y()\n\nz = 1\n")
+	tc, err := NewTreeContext("example.py", source, TreeContextOptions{})
+	if err != nil {
+		t.Fatalf("NewTreeContext() error = %v", err)
+	}
+
+	tests := []struct {
+		line      int
+		chain     string
+		enclosing string
+	}{
+		{3, "[{2 3 if_statement } {1 3 function_definition inner} {0 3 class_definition Outer}]", "{2 3 if_statement } true"},
+		{1, "[{1 3 function_definition inner} {0 3 class_definition Outer}]", "{1 3 function_definition inner} true"},
+		{5, "[]", "{0 0  } false"},
+	}
+	for _, tt := range tests {
+		if got := fmt.Sprint(tc.ParentChain(tt.line)); got != tt.chain {
+			t.Errorf("ParentChain(%d) = %s, want %s", tt.line, got, tt.chain)
+		}
+		scope, ok := tc.EnclosingScope(tt.line)
+		if got := fmt.Sprint(scope, ok); got != tt.enclosing {
+			t.Errorf("EnclosingScope(%d) = %s, want %s", tt.line, got, tt.enclosing)
+		}
+	}
+}
+
+// TestTreeContext_Breadcrumb tests naming the definitions enclosing a line.
+func TestTreeContext_Breadcrumb(t *testing.T) {
+	source := []byte("class Server:\n    def start(self):\n        run()\n\n    def stop(self):\n        halt()\n")
+	tc, err := NewTreeContext("server.py", source, TreeContextOptions{ShowBreadcrumbs: true})
+	if err != nil {
+		t.Fatalf("NewTreeContext() error = %v", err)
+	}
+
+	tests := []struct {
+		line     int
+		expected string
+	}{
+		{0, "server.py ▸ class Server"},
+		{1, "server.py ▸ class Server ▸ def start(self)"},
+		{2, "server.py ▸ class Server ▸ def start(self)"},
+		{5, "server.py ▸ class Server ▸ def stop(self)"},
+	}
+	for _, tt := range tests {
+		if got := tc.Breadcrumb(tt.line); got != tt.expected {
+			t.Errorf("Breadcrumb(%d) = %q, want %q", tt.line, got, tt.expected)
+		}
+	}
+
+	tc.AddLinesOfInterest(map[int]struct{}{2: {}, 5: {}})
+	tc.AddContext()
+	expected := "⋮...\nserver.py ▸ class Server ▸ def start(self)\n│        run()\n⋮...\nserver.py ▸ class Server ▸ def stop(self)\n│        halt()\n"
+	if got := tc.Format(); got != expected {
+		t.Errorf("Format() = %q, want %q", got, expected)
+	}
+}
+
+// TestTreeContext_ShowScopeKinds tests labeling the first lines of shown scopes with their kinds.
+func TestTreeContext_ShowScopeKinds(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		source   string
+		line     int
+		expected string
+	}{
+		{
+			name:     "Go",
+			filename: "example.go",
+			source:   "package p\n\ntype S struct {\n\ta int\n}\n\nfunc f() {\n\tif x {\n\t\ty()\n\t}\n}\n",
+			line:     8,
+			expected: "⋮...\n│[func] func f() {\n│\t[if] if x {\n│\t\ty()\n⋮...\n",
+		},
+		{
+			name:     "Python",
+			filename: "example.py",
+			source:   "import os\n\nclass A:\n    def m(self):\n        for x in y:\n            z()\n",
+			line:     5,
+			expected: "⋮...\n│[class] class A:\n│    [func] def m(self):\n│        [for] for x in y:\n│            z()\n",
+		},
+		{
+			name:     "JavaScript",
+			filename: "example.js",
+			source:   "let a = 1;\nclass A {\n  m() {\n    const f = () => {\n      z()\n    }\n  }\n}\n",
+			line:     4,
+			expected: "⋮...\n│[class] class A {\n│  [method] m() {\n│    const f = () => {\n│      z()\n⋮...\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc, err := NewTreeContext(tt.filename, []byte(tt.source), TreeContextOptions{ShowParentContext: true, SignatureOnly: true, ShowScopeKinds: true})
+			if err != nil {
+				t.Fatalf("NewTreeContext() error = %v", err)
+			}
+			tc.AddLinesOfInterest(map[int]struct{}{tt.line: {}})
+			tc.AddContext()
+			if got := tc.Format(); got != tt.expected {
+				t.Errorf("Format() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
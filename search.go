@@ -0,0 +1,296 @@
+package grepast
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+// SearchOptions specifies how SearchFile searches a file and renders the result.
+type SearchOptions struct {
+	Patterns  []string           // Patterns to search for; lines matching any of them are lines of interest.
+	Query     string             // Tree-sitter query whose captures' start lines are also lines of interest.
+	Grep      GrepOptions        // How patterns are matched.
+	Context   TreeContextOptions // How context around matches is gathered and rendered.
+	Formatter Formatter          // Renders the result; defaults to TextFormatter.
+
+	// MaxMatchesPerFile keeps only the first lines of interest of each file, up to this many,
+	// counting the rest in FileResult.Suppressed. Zero means no limit.
+	MaxMatchesPerFile int
+
+	// CountNodeKinds fills FileResult.NodeKinds with the file's TreeContext.NodeKindCounts.
+	CountNodeKinds bool
+}
+
+// FileResult is the outcome of searching a single file.
+type FileResult struct {
+	Path            string         `json:"path"`                 // Path of the file as given to SearchFile.
+	Language        string         `json:"language"`             // Name of the language the file was parsed as.
+	LinesOfInterest []int          `json:"lines_of_interest"`    // Sorted zero-based numbers of the matching lines.
+	Matches         []Match        `json:"matches,omitempty"`    // Pattern matches with their columns and byte offsets, by position.
+	Suppressed      int            `json:"suppressed,omitempty"` // Lines of interest left out by MaxMatchesPerFile or a tree search's MaxMatches.
+	Elided          []ElidedRange  `json:"elided,omitempty"`     // Runs of lines left out of Output.
+	Output          string         `json:"output"`               // Context rendered by the formatter; empty when nothing matched.
+	Metadata        FileMetadata   `json:"metadata"`             // Size and parse cost of the file.
+	NodeKinds       map[string]int `json:"node_kinds,omitempty"` // Named parse-tree nodes by kind, if CountNodeKinds is set.
+}
+
+// String renders r as indented JSON, with fields in declaration order, for debugging.
+func (r FileResult) String() string {
+	return debugString(r)
+}
+
+// Formatter renders the context gathered in a TreeContext.
+type Formatter interface {
+	Format(tc *TreeContext) string
+}
+
+// TextFormatter renders context as annotated source lines, as returned by TreeContext.Format.
+type TextFormatter struct{}
+
+// Format implements Formatter.
+func (TextFormatter) Format(tc *TreeContext) string {
+	return tc.Format()
+}
+
+// JSONFormatter renders context as the JSON returned by TreeContext.FormatJSON.
+type JSONFormatter struct{}
+
+// Format implements Formatter.
+func (JSONFormatter) Format(tc *TreeContext) string {
+	out, err := tc.FormatJSON()
+	if err != nil {
+		// FormattedContext holds only strings and numbers, which always encode.
+		panic(err)
+	}
+	return string(out)
+}
+
+// SearchFile parses source, searches it for the given patterns and query, and renders the matching
+// lines with their context. It wraps the NewTreeContext, GrepPatterns, AddLinesOfInterest,
+// AddContext, and Format steps into one call.
+func SearchFile(path string, source []byte, options SearchOptions) (*FileResult, error) {
+	return SearchFileCtx(context.Background(), path, source, options)
+}
+
+// SearchFileCtx is like SearchFile, but gives up once ctx is done, returning ctx.Err().
+func SearchFileCtx(ctx context.Context, path string, source []byte, options SearchOptions) (*FileResult, error) {
+	m, err := matchFile(ctx, path, source, options)
+	if err != nil {
+		return nil, err
+	}
+	if options.MaxMatchesPerFile > 0 {
+		m.limit(options.MaxMatchesPerFile)
+	}
+	return m.format(ctx, options)
+}
+
+// fileMatch is a parsed and searched file whose context has not been gathered and rendered yet.
+type fileMatch struct {
+	path       string
+	language   string
+	tc         *TreeContext // Nil if the file was scanned without parsing it.
+	matches    []Match      // Pattern matches of a scanned file, by position.
+	found      map[int]struct{}
+	suppressed int            // Lines of interest dropped by limit.
+	nodeKinds  map[string]int // Node kind counts, if CountNodeKinds is set.
+}
+
+// matchFile parses source and finds its lines of interest. This is the parser-bound half of SearchFile.
+func matchFile(ctx context.Context, path string, source []byte, options SearchOptions) (*fileMatch, error) {
+	tc, err := NewTreeContextCtx(ctx, path, source, options.Context)
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[int]struct{})
+	if len(options.Patterns) > 0 {
+		if found, err = tc.GrepPatternsCtx(ctx, options.Patterns, options.Grep); err != nil {
+			return nil, err
+		}
+	}
+	if options.Query != "" {
+		captures, err := tc.RunQuery(options.Query)
+		if err != nil {
+			return nil, err
+		}
+		for line := range CaptureLines(captures) {
+			found[line] = struct{}{}
+		}
+	}
+	m := &fileMatch{path: path, language: tc.Language(), tc: tc, found: found}
+	if options.CountNodeKinds {
+		m.nodeKinds = tc.NodeKindCounts()
+	}
+	return m, nil
+}
+
+// scansWithoutParsing reports whether the lines of interest can be found without parsing the
+// source: the patterns are plain regular expressions or literals matched line by line.
+func (options SearchOptions) scansWithoutParsing() bool {
+	g := options.Grep
+	return len(options.Patterns) > 0 && options.Query == "" && !options.CountNodeKinds &&
+		!g.Multiline && !g.Invert && !g.Structural && !g.NodeText && !g.Fuzzy &&
+		len(g.NodeKinds) == 0 && !g.IdentifiersOnly && !g.CommentsOnly && !g.MatchAllInScope &&
+		!g.restrictsLines()
+}
+
+// scanFile is like matchFile, but matches each line of source against the patterns without
+// parsing it, for options that scan without parsing. The match has no TreeContext, so its
+// context cannot be gathered.
+func scanFile(ctx context.Context, path string, source []byte, options SearchOptions) (*fileMatch, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if options.Context.DetectEncoding {
+		source, _ = DecodeSource(source)
+	}
+	if IsBinary(source) {
+		return nil, ErrorBinaryFile
+	}
+
+	// Recognize the language as NewTreeContext would, so that the same files are searched.
+	var lang *sitter.Language
+	language := strings.ToLower(options.Context.Language)
+	var err error
+	if language != "" {
+		lang, err = getLanguage(language)
+	} else {
+		lang, language, err = GetLanguageFromSource(path, source)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if lang == nil {
+		return nil, fmt.Errorf("%w (%s)", ErrorUnsupportedLanguage, path)
+	}
+
+	res := make([]*regexp.Regexp, len(options.Patterns))
+	for i, pat := range options.Patterns {
+		if res[i], err = compilePattern(pat, options.Grep); err != nil {
+			return nil, err
+		}
+	}
+	m := &fileMatch{path: path, language: language, found: make(map[int]struct{})}
+	start := 0
+	for i, line := range strings.Split(string(source), "\n") {
+		text := strings.TrimSuffix(line, "\r")
+		var matches []Match
+		for p, re := range res {
+			for _, loc := range re.FindAllStringIndex(text, -1) {
+				matches = append(matches, Match{
+					Line:      i,
+					Column:    loc[0],
+					EndColumn: loc[1],
+					StartByte: start + loc[0],
+					EndByte:   start + loc[1],
+					Pattern:   p,
+					Text:      text[loc[0]:loc[1]],
+
+					RuneColumn:    utf8.RuneCountInString(text[:loc[0]]),
+					EndRuneColumn: utf8.RuneCountInString(text[:loc[1]]),
+				})
+			}
+		}
+		if len(matches) > 0 {
+			m.found[i] = struct{}{}
+			sort.SliceStable(matches, func(a, b int) bool { return matches[a].StartByte < matches[b].StartByte })
+			m.matches = append(m.matches, matches...)
+		}
+		start += len(line) + 1
+	}
+	return m, nil
+}
+
+// keepBlamed drops the lines of interest that git blame does not attribute to one of the changes'
+// commits, or that their diff does not add or change; see GitChanges.Lines.
+func (m *fileMatch) keepBlamed(changes *GitChanges, rel string) error {
+	blamed, err := changes.Lines(rel)
+	if err != nil {
+		return err
+	}
+	for line := range m.found {
+		if _, ok := blamed[line]; !ok {
+			m.drop(line)
+		}
+	}
+	return nil
+}
+
+// limit keeps only the first n lines of interest, dropping the matches on the others, and
+// returns the number kept.
+func (m *fileMatch) limit(n int) int {
+	if len(m.found) <= n {
+		return len(m.found)
+	}
+	for _, line := range mapKeysSorted(m.found)[n:] {
+		m.drop(line)
+		m.suppressed++
+	}
+	return n
+}
+
+// drop removes a line of interest along with its matches and their highlighting, so that it is
+// neither reported nor highlighted when shown as context.
+func (m *fileMatch) drop(line int) {
+	delete(m.found, line)
+	if m.tc != nil {
+		delete(m.tc.matchSpans, line)
+		delete(m.tc.outputLines, line)
+	}
+}
+
+// summary returns the result of the match without gathering or rendering its context, so that
+// its Output is empty.
+func (m *fileMatch) summary() *FileResult {
+	res := &FileResult{
+		Path:            m.path,
+		Language:        m.language,
+		LinesOfInterest: mapKeysSorted(m.found),
+		Suppressed:      m.suppressed,
+		NodeKinds:       m.nodeKinds,
+	}
+	for _, match := range m.matches {
+		// The matches of a scanned file are kept by line; drop only removes the line.
+		if _, ok := m.found[match.Line]; ok {
+			res.Matches = append(res.Matches, match)
+		}
+	}
+	if m.tc != nil {
+		res.Matches = m.tc.Matches()
+		res.Metadata = m.tc.Metadata()
+	}
+	return res
+}
+
+// format gathers the context around the lines of interest and renders it. This is the pure Go
+// half of SearchFile.
+func (m *fileMatch) format(ctx context.Context, options SearchOptions) (*FileResult, error) {
+	tc := m.tc
+	tc.AddLinesOfInterest(m.found)
+	if err := tc.AddContextCtx(ctx); err != nil {
+		return nil, err
+	}
+
+	formatter := options.Formatter
+	if formatter == nil {
+		formatter = TextFormatter{}
+	}
+
+	return &FileResult{
+		Path:            m.path,
+		Language:        tc.Language(),
+		LinesOfInterest: mapKeysSorted(m.found),
+		Matches:         tc.Matches(),
+		Suppressed:      m.suppressed,
+		Elided:          tc.ElidedRanges(),
+		Output:          formatter.Format(tc),
+		Metadata:        tc.Metadata(),
+		NodeKinds:       m.nodeKinds,
+	}, nil
+}
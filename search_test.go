@@ -0,0 +1,129 @@
+package grepast
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// upperFormatter is a Formatter used to check that SearchFile honors custom formatters.
+type upperFormatter struct{}
+
+func (upperFormatter) Format(tc *TreeContext) string {
+	return strings.ToUpper(tc.Format())
+}
+
+// TestSearchFile tests the SearchFile function.
+func TestSearchFile(t *testing.T) {
+	res, err := SearchFile("example.go", getExampleSourceCode(), SearchOptions{
+		Patterns: []string{"smallScope"},
+	})
+	if err != nil {
+		t.Fatalf("SearchFile() error = %v", err)
+	}
+
+	if res.Path != "example.go" || res.Language != "go" {
+		t.Errorf("SearchFile() = %q (%s), want example.go (go)", res.Path, res.Language)
+	}
+	if fmt.Sprint(res.LinesOfInterest) != fmt.Sprint([]int{4, 22, 26}) {
+		t.Errorf("SearchFile() LinesOfInterest = %v, want %v", res.LinesOfInterest, []int{4, 22, 26})
+	}
+	if !strings.Contains(res.Output, "func smallScope() {") {
+		t.Errorf("SearchFile() Output = %q, want it to contain the matching line", res.Output)
+	}
+
+	res, err = SearchFile("example.go", getExampleSourceCode(), SearchOptions{
+		Patterns:  []string{"smallScope"},
+		Formatter: upperFormatter{},
+	})
+	if err != nil {
+		t.Fatalf("SearchFile() error = %v", err)
+	}
+	if !strings.Contains(res.Output, "FUNC SMALLSCOPE() {") {
+		t.Errorf("SearchFile() Output = %q, want it rendered by the custom formatter", res.Output)
+	}
+
+	if _, err := SearchFile("example.xyz", getExampleSourceCode(), SearchOptions{}); err != ErrorUnrecognizedFiletype {
+		t.Errorf("SearchFile() error = %v, want %v", err, ErrorUnrecognizedFiletype)
+	}
+}
+
+// TestSearchFile_Query tests SearchFile with a tree-sitter query instead of patterns.
+func TestSearchFile_Query(t *testing.T) {
+	res, err := SearchFile("example.go", getExampleSourceCode(), SearchOptions{
+		Query: `(func_literal) @fn`,
+	})
+	if err != nil {
+		t.Fatalf("SearchFile() error = %v", err)
+	}
+	if fmt.Sprint(res.LinesOfInterest) != fmt.Sprint([]int{25}) {
+		t.Errorf("SearchFile() LinesOfInterest = %v, want %v", res.LinesOfInterest, []int{25})
+	}
+}
+
+// TestSearchFile_MaxMatchesPerFile tests keeping only the first matches of a file.
+func TestSearchFile_MaxMatchesPerFile(t *testing.T) {
+	res, err := SearchFile("example.go", getExampleSourceCode(), SearchOptions{
+		Patterns:          []string{"smallScope"},
+		MaxMatchesPerFile: 2,
+	})
+	if err != nil {
+		t.Fatalf("SearchFile() error = %v", err)
+	}
+	if fmt.Sprint(res.LinesOfInterest) != fmt.Sprint([]int{4, 22}) || res.Suppressed != 1 {
+		t.Errorf("SearchFile() LinesOfInterest = %v, Suppressed = %d, want %v, 1", res.LinesOfInterest, res.Suppressed, []int{4, 22})
+	}
+	if len(res.Matches) != 2 {
+		t.Errorf("SearchFile() Matches = %v, want 2 matches", res.Matches)
+	}
+}
+
+// TestSearchFile_CountNodeKinds tests adding node kind counts to results only when asked.
+func TestSearchFile_CountNodeKinds(t *testing.T) {
+	res, err := SearchFile("example.go", getExampleSourceCode(), SearchOptions{Patterns: []string{"smallScope"}})
+	if err != nil {
+		t.Fatalf("SearchFile() error = %v", err)
+	}
+	if res.NodeKinds != nil {
+		t.Errorf("SearchFile() NodeKinds = %v, want nil", res.NodeKinds)
+	}
+
+	res, err = SearchFile("example.go", getExampleSourceCode(), SearchOptions{
+		Patterns:       []string{"smallScope"},
+		CountNodeKinds: true,
+	})
+	if err != nil {
+		t.Fatalf("SearchFile() error = %v", err)
+	}
+	if got := res.NodeKinds["function_declaration"]; got != 3 {
+		t.Errorf("SearchFile() NodeKinds[function_declaration] = %d, want 3", got)
+	}
+}
+
+// TestFileResult_String tests that FileResult renders as JSON with its fields in declaration order.
+func TestFileResult_String(t *testing.T) {
+	r := FileResult{
+		Path:            "example.go",
+		Language:        "go",
+		LinesOfInterest: []int{4},
+		Output:          "│func f() {\n",
+	}
+	expected := `{
+  "path": "example.go",
+  "language": "go",
+  "lines_of_interest": [
+    4
+  ],
+  "output": "│func f() {\n",
+  "metadata": {
+    "language": "",
+    "source_bytes": 0,
+    "parse_ns": 0,
+    "node_count": 0,
+    "max_depth": 0
+  }
+}`
+	if got := r.String(); got != expected {
+		t.Errorf("String() =\n%s\nwant\n%s", got, expected)
+	}
+}
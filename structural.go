@@ -0,0 +1,298 @@
+package grepast
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+// StructuralMatch is a match of a structural pattern, such as `if err != nil { :[body] }`.
+type StructuralMatch struct {
+	StartLine int               // Zero-based line on which the match starts.
+	EndLine   int               // Zero-based line on which the match ends.
+	StartByte int               // Byte offset in the source at which the match starts.
+	EndByte   int               // Byte offset in the source at which the match ends.
+	Text      string            // Matched source text.
+	Holes     map[string]string // Source text bound to each named placeholder.
+}
+
+// String renders m as indented JSON, with holes sorted by name, for debugging.
+func (m StructuralMatch) String() string {
+	return debugString(m)
+}
+
+// StructuralSearch finds the non-overlapping matches of a structural pattern in the source.
+//
+// A pattern is source code in which placeholders of the form :[name] stand for any sequence of
+// syntax tokens with balanced (), [], and {} brackets, e.g. `foo(:[args])` or
+// `if err != nil { :[body] }`. A placeholder used more than once must match the same text each
+// time, except :[_], which matches anything. Whitespace between tokens is insignificant, and
+// matches always begin and end on token boundaries of the parse tree.
+func (tc *TreeContext) StructuralSearch(pattern string) ([]StructuralMatch, error) {
+	return tc.structuralSearch(pattern, false)
+}
+
+// structuralSearch implements StructuralSearch, optionally comparing tokens case-insensitively.
+func (tc *TreeContext) structuralSearch(pattern string, ignoreCase bool) ([]StructuralMatch, error) {
+	elems, err := parseStructuralPattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(elems) == 0 {
+		return nil, fmt.Errorf("empty structural pattern")
+	}
+
+	m := &structuralMatcher{
+		elems:      elems,
+		tokens:     tc.sourceTokens(),
+		ignoreCase: ignoreCase,
+	}
+
+	var matches []StructuralMatch
+	for i := 0; i < len(m.tokens); i++ {
+		if !m.tokens[i].leafStart {
+			continue
+		}
+		m.holes = make(map[string][2]int)
+		end, ok := m.match(0, i)
+		if !ok || end == i {
+			continue
+		}
+
+		start, stop := m.tokens[i].start, m.tokens[end-1].end
+		holes := make(map[string]string, len(m.holes))
+		for name, r := range m.holes {
+			holes[name] = m.text(tc.source, r[0], r[1])
+		}
+		matches = append(matches, StructuralMatch{
+			StartLine: m.tokens[i].line,
+			EndLine:   m.tokens[end-1].line,
+			StartByte: start,
+			EndByte:   stop,
+			Text:      string(tc.source[start:stop]),
+			Holes:     holes,
+		})
+		i = end - 1
+	}
+	return matches, nil
+}
+
+// structuralElem is a literal token or a placeholder of a structural pattern.
+type structuralElem struct {
+	text string // Literal token text; empty for a placeholder.
+	hole string // Placeholder name; empty for a literal token.
+}
+
+// parseStructuralPattern splits a structural pattern into literal tokens and placeholders.
+func parseStructuralPattern(pattern string) ([]structuralElem, error) {
+	var elems []structuralElem
+	for len(pattern) > 0 {
+		i := strings.Index(pattern, ":[")
+		if i < 0 {
+			i = len(pattern)
+		}
+		for _, tok := range splitTokens(pattern[:i]) {
+			elems = append(elems, structuralElem{text: tok})
+		}
+		if i == len(pattern) {
+			break
+		}
+
+		pattern = pattern[i+2:]
+		j := strings.IndexByte(pattern, ']')
+		if j < 0 {
+			return nil, fmt.Errorf("unterminated placeholder in structural pattern")
+		}
+		name := pattern[:j]
+		if name == "" || strings.IndexFunc(name, func(r rune) bool { return !isWordRune(r) }) >= 0 {
+			return nil, fmt.Errorf("invalid placeholder name %q in structural pattern", name)
+		}
+		if n := len(elems); n > 0 && elems[n-1].hole != "" {
+			return nil, fmt.Errorf("adjacent placeholders :[%s] and :[%s] in structural pattern", elems[n-1].hole, name)
+		}
+		elems = append(elems, structuralElem{hole: name})
+		pattern = pattern[j+1:]
+	}
+	return elems, nil
+}
+
+// splitTokens splits text into runs of letters, digits, and underscores, and single other
+// characters, dropping whitespace.
+func splitTokens(text string) []string {
+	var tokens []string
+	for len(text) > 0 {
+		r, size := utf8.DecodeRuneInString(text)
+		switch {
+		case unicode.IsSpace(r):
+			text = text[size:]
+		case isWordRune(r):
+			end := strings.IndexFunc(text, func(r rune) bool { return !isWordRune(r) })
+			if end < 0 {
+				end = len(text)
+			}
+			tokens = append(tokens, text[:end])
+			text = text[end:]
+		default:
+			tokens = append(tokens, text[:size])
+			text = text[size:]
+		}
+	}
+	return tokens
+}
+
+// isWordRune reports whether r is part of a word token.
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// sourceToken is a token of the source, split from the text of a leaf of the parse tree.
+type sourceToken struct {
+	text      string
+	start     int  // Byte offset of the token in the source.
+	end       int  // Byte offset just past the token in the source.
+	line      int  // Zero-based line of the token.
+	leafStart bool // Whether the token is the first of its leaf.
+	bracket   int  // +1 for a leaf that opens a bracket, -1 for one that closes it, otherwise 0.
+}
+
+// sourceTokens returns the tokens of the source in order, split from the leaves of the parse tree.
+func (tc *TreeContext) sourceTokens() []sourceToken {
+	var tokens []sourceToken
+	var visit func(node *sitter.Node)
+	visit = func(node *sitter.Node) {
+		if node.ChildCount() > 0 {
+			for i := uint(0); i < node.ChildCount(); i++ {
+				if child := node.Child(i); child != nil {
+					visit(child)
+				}
+			}
+			return
+		}
+
+		text := node.Utf8Text(tc.source)
+		bracket := 0
+		switch text {
+		case "(", "[", "{":
+			bracket = 1
+		case ")", "]", "}":
+			bracket = -1
+		}
+
+		offset := int(node.StartByte())
+		rest := text
+		for i, tok := range splitTokens(text) {
+			pos := strings.Index(rest, tok)
+			start := offset + len(text) - len(rest) + pos
+			rest = rest[pos+len(tok):]
+			tokens = append(tokens, sourceToken{
+				text:      tok,
+				start:     start,
+				end:       start + len(tok),
+				line:      tc.lineAt(start),
+				leafStart: i == 0,
+				bracket:   bracket,
+			})
+		}
+	}
+	if tc.tree != nil {
+		visit(tc.tree.RootNode())
+	}
+	return tokens
+}
+
+// structuralMatcher matches the elements of a structural pattern against source tokens.
+type structuralMatcher struct {
+	elems      []structuralElem
+	tokens     []sourceToken
+	ignoreCase bool
+	holes      map[string][2]int // Token range bound to each named placeholder.
+}
+
+// match matches elems[e:] against the tokens starting at t and returns the index just past the
+// last matched token.
+func (m *structuralMatcher) match(e, t int) (int, bool) {
+	if e == len(m.elems) {
+		return t, true
+	}
+
+	elem := m.elems[e]
+	if elem.hole == "" {
+		if t >= len(m.tokens) || !m.equal(m.tokens[t].text, elem.text) {
+			return 0, false
+		}
+		// A literal must not continue a leaf that a placeholder or nothing started.
+		if e == 0 || m.elems[e-1].hole != "" {
+			if !m.tokens[t].leafStart {
+				return 0, false
+			}
+		}
+		next := t + 1
+		// A literal followed by a placeholder or the end must finish its leaf.
+		if e+1 == len(m.elems) || m.elems[e+1].hole != "" {
+			if next < len(m.tokens) && !m.tokens[next].leafStart {
+				return 0, false
+			}
+		}
+		return m.match(e+1, next)
+	}
+
+	// A placeholder takes the shortest run of whole, bracket-balanced leaves that lets the rest match.
+	bound, isBound := m.holes[elem.hole]
+	depth := 0
+	for end := t; end <= len(m.tokens); end++ {
+		if end > t {
+			depth += m.tokens[end-1].bracket
+			if depth < 0 {
+				break
+			}
+		}
+		if depth != 0 || (end < len(m.tokens) && !m.tokens[end].leafStart) {
+			continue
+		}
+		if isBound && elem.hole != "_" && !m.sameTokens(bound, [2]int{t, end}) {
+			continue
+		}
+		if !isBound && elem.hole != "_" {
+			m.holes[elem.hole] = [2]int{t, end}
+		}
+		if next, ok := m.match(e+1, end); ok {
+			return next, true
+		}
+		if !isBound {
+			delete(m.holes, elem.hole)
+		}
+	}
+	return 0, false
+}
+
+// equal compares a source token with a pattern token.
+func (m *structuralMatcher) equal(a, b string) bool {
+	if m.ignoreCase {
+		return strings.EqualFold(a, b)
+	}
+	return a == b
+}
+
+// sameTokens reports whether two token ranges hold the same tokens.
+func (m *structuralMatcher) sameTokens(a, b [2]int) bool {
+	if a[1]-a[0] != b[1]-b[0] {
+		return false
+	}
+	for i := 0; i < a[1]-a[0]; i++ {
+		if !m.equal(m.tokens[a[0]+i].text, m.tokens[b[0]+i].text) {
+			return false
+		}
+	}
+	return true
+}
+
+// text returns the source text spanned by the token range [from, to).
+func (m *structuralMatcher) text(source []byte, from, to int) string {
+	if from == to {
+		return ""
+	}
+	return string(source[m.tokens[from].start:m.tokens[to-1].end])
+}
@@ -0,0 +1,130 @@
+package grepast
+
+import (
+	"fmt"
+	"testing"
+)
+
+// TestTreeContext_StructuralSearch tests the StructuralSearch method of TreeContext.
+func TestTreeContext_StructuralSearch(t *testing.T) {
+	sourceCode := []byte(`package main
+
+func main() {
+	v, err := load("a", f(1, 2))
+	if err != nil {
+		return
+	}
+	if err != nil { log(err); return }
+	check(v, v)
+	check(v, w)
+	// check(x, x)
+}
+`)
+
+	tests := []struct {
+		name     string
+		pattern  string
+		expected []string
+		wantErr  bool
+	}{
+		{
+			name:     "Placeholder spans balanced arguments",
+			pattern:  "load(:[args])",
+			expected: []string{`3-3 load("a", f(1, 2)) args="a", f(1, 2)`},
+		},
+		{
+			name:    "Placeholder spans lines and whitespace is insignificant",
+			pattern: "if err!=nil { :[body] }",
+			expected: []string{
+				"4-6 if err != nil {\n\t\treturn\n\t} body=return",
+				"7-7 if err != nil { log(err); return } body=log(err); return",
+			},
+		},
+		{
+			name:     "Repeated placeholder must match the same text",
+			pattern:  "check(:[a], :[a])",
+			expected: []string{"8-8 check(v, v) a=v"},
+		},
+		{
+			name:     "Anonymous placeholder",
+			pattern:  "check(:[_], w)",
+			expected: []string{"9-9 check(v, w)"},
+		},
+		{
+			name:     "Literal tokens match whole leaves",
+			pattern:  "rr",
+			expected: nil,
+		},
+		{
+			name:    "Unterminated placeholder",
+			pattern: "load(:[args)",
+			wantErr: true,
+		},
+		{
+			name:    "Adjacent placeholders",
+			pattern: "load(:[a]:[b])",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc, err := NewTreeContext("example.go", sourceCode, TreeContextOptions{})
+			if err != nil {
+				t.Fatalf("NewTreeContext() error = %v", err)
+			}
+
+			matches, err := tc.StructuralSearch(tt.pattern)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("StructuralSearch(%q) expected an error but got none", tt.pattern)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("StructuralSearch(%q) error = %v", tt.pattern, err)
+			}
+
+			var got []string
+			for _, m := range matches {
+				s := fmt.Sprintf("%d-%d %s", m.StartLine, m.EndLine, m.Text)
+				for _, name := range []string{"args", "body", "a"} {
+					if text, ok := m.Holes[name]; ok {
+						s += fmt.Sprintf(" %s=%s", name, text)
+					}
+				}
+				got = append(got, s)
+			}
+			if fmt.Sprintf("%q", got) != fmt.Sprintf("%q", tt.expected) {
+				t.Errorf("StructuralSearch(%q) = %q, want %q", tt.pattern, got, tt.expected)
+			}
+		})
+	}
+}
+
+// TestTreeContext_GrepStructural tests structural patterns in GrepWithOptions.
+func TestTreeContext_GrepStructural(t *testing.T) {
+	sourceCode := []byte(`package main
+
+func main() {
+	if err != nil {
+		return
+	}
+	if ok {
+		return
+	}
+}
+`)
+	tc, err := NewTreeContext("example.go", sourceCode, TreeContextOptions{})
+	if err != nil {
+		t.Fatalf("NewTreeContext() error = %v", err)
+	}
+
+	found, err := tc.GrepWithOptions("if err != nil { :[body] }", GrepOptions{Structural: true})
+	if err != nil {
+		t.Fatalf("GrepWithOptions() error = %v", err)
+	}
+	if got, want := fmt.Sprint(mapKeysSorted(found)), "[3 4 5]"; got != want {
+		t.Errorf("GrepWithOptions() = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,79 @@
+package grepast
+
+import (
+	"sort"
+	"strings"
+)
+
+// Definition is a named declaration found in the source, such as a function, method, type, or variable.
+type Definition struct {
+	Name      string // Identifier of the definition.
+	Kind      string // Tree-sitter node kind of the definition, e.g. "function_declaration".
+	StartLine int    // Zero-based line on which the definition starts.
+	EndLine   int    // Zero-based line on which the definition ends.
+}
+
+// definitionKindSuffixes are the node kind suffixes that grammars use for declarations.
+var definitionKindSuffixes = []string{"_declaration", "_definition", "_declarator", "_item", "_signature", "_spec"}
+
+// definitionKindExclusions are substrings of declaration-like node kinds that do not define a symbol of interest.
+var definitionKindExclusions = []string{"parameter", "import"}
+
+// Definitions returns the named declarations in the source, ordered by position. A node is treated
+// as a definition when its kind looks like a declaration and it has a "name" field.
+func (tc *TreeContext) Definitions() []Definition {
+	var defs []Definition
+	for _, nodes := range tc.nodes {
+		for _, node := range nodes {
+			if !isDefinitionKind(node.Kind()) {
+				continue
+			}
+			name := node.ChildByFieldName("name")
+			if name == nil {
+				continue
+			}
+			defs = append(defs, Definition{
+				Name:      name.Utf8Text(tc.source),
+				Kind:      node.Kind(),
+				StartLine: int(node.StartPosition().Row),
+				EndLine:   int(node.EndPosition().Row),
+			})
+		}
+	}
+	return defs
+}
+
+// isDefinitionKind reports whether a node kind denotes a declaration.
+func isDefinitionKind(kind string) bool {
+	for _, excluded := range definitionKindExclusions {
+		if strings.Contains(kind, excluded) {
+			return false
+		}
+	}
+	for _, suffix := range definitionKindSuffixes {
+		if strings.HasSuffix(kind, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// SymbolMatch is a definition whose name fuzzy-matches a query, with its FuzzyScore.
+type SymbolMatch struct {
+	Definition
+	Score int
+}
+
+// FuzzyDefinitions returns the definitions whose names fuzzy-match query, best match first.
+func (tc *TreeContext) FuzzyDefinitions(query string) []SymbolMatch {
+	var matches []SymbolMatch
+	for _, def := range tc.Definitions() {
+		if score, ok := FuzzyScore(query, def.Name); ok {
+			matches = append(matches, SymbolMatch{Definition: def, Score: score})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Score > matches[j].Score
+	})
+	return matches
+}
@@ -0,0 +1,71 @@
+package grepast
+
+import (
+	"testing"
+)
+
+// TestTreeContext_Definitions tests the Definitions method of TreeContext.
+func TestTreeContext_Definitions(t *testing.T) {
+	sourceCode := []byte(`package main
+
+import "fmt"
+
+type Server struct {
+	addr string
+}
+
+func (s *Server) Start(port int) {
+	fmt.Println(s.addr)
+}
+
+func main() {}
+`)
+
+	tc, err := NewTreeContext("example.go", sourceCode, TreeContextOptions{})
+	if err != nil {
+		t.Fatalf("NewTreeContext() error = %v", err)
+	}
+
+	expected := []Definition{
+		{Name: "Server", Kind: "type_spec", StartLine: 4, EndLine: 6},
+		{Name: "addr", Kind: "field_declaration", StartLine: 5, EndLine: 5},
+		{Name: "Start", Kind: "method_declaration", StartLine: 8, EndLine: 10},
+		{Name: "main", Kind: "function_declaration", StartLine: 12, EndLine: 12},
+	}
+
+	got := tc.Definitions()
+	if len(got) != len(expected) {
+		t.Fatalf("Definitions() = %v, want %v", got, expected)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("Definitions()[%d] = %v, want %v", i, got[i], expected[i])
+		}
+	}
+}
+
+// TestTreeContext_FuzzyDefinitions tests the FuzzyDefinitions method of TreeContext.
+func TestTreeContext_FuzzyDefinitions(t *testing.T) {
+	sourceCode := []byte(`def http_handler():
+    pass
+
+def handle():
+    pass
+
+class Server:
+    pass
+`)
+
+	tc, err := NewTreeContext("example.py", sourceCode, TreeContextOptions{})
+	if err != nil {
+		t.Fatalf("NewTreeContext() error = %v", err)
+	}
+
+	got := tc.FuzzyDefinitions("hndl")
+	if len(got) != 2 {
+		t.Fatalf("FuzzyDefinitions() = %v, want 2 matches", got)
+	}
+	if got[0].Name != "handle" || got[1].Name != "http_handler" {
+		t.Errorf("FuzzyDefinitions() order = [%s %s], want [handle http_handler]", got[0].Name, got[1].Name)
+	}
+}
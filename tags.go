@@ -0,0 +1,153 @@
+package grepast
+
+import (
+	"embed"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+// tagsQueries holds the per-language tags queries, named "<language>-tags.scm".
+//
+//go:embed queries/*-tags.scm
+var tagsQueries embed.FS
+
+// tagsQueryBases lists languages whose tags query extends another language's query.
+var tagsQueryBases = map[string]string{
+	"typescript": "javascript",
+}
+
+var (
+	tagsQueryCacheMu sync.Mutex
+	tagsQueryCache   = make(map[string]*sitter.Query) // Compiled tags queries by language name.
+)
+
+// SymbolRole tells whether a Symbol defines or references a name.
+type SymbolRole string
+
+const (
+	SymbolDefinition SymbolRole = "definition"
+	SymbolReference  SymbolRole = "reference"
+)
+
+// Symbol is a definition or reference found by a language's tags query.
+type Symbol struct {
+	Name      string     // Identifier being defined or referenced.
+	Kind      string     // Kind of symbol from the tags query, e.g. "function", "method", "class", "call", or "type".
+	Role      SymbolRole // Whether this is a definition or a reference.
+	Line      int        // Zero-based line of the identifier.
+	StartLine int        // Zero-based line on which the tagged node starts.
+	EndLine   int        // Zero-based line on which the tagged node ends.
+}
+
+// Symbols returns the definitions and references in the source, ordered by position, using the
+// language's tags query. It returns ErrorNoTagsQuery if there is no tags query for the language.
+func (tc *TreeContext) Symbols() ([]Symbol, error) {
+	if tc.tree == nil {
+		return nil, fmt.Errorf("no syntax tree for %s", tc.filename)
+	}
+	q, err := tagsQuery(tc.language, tc.tree.Language())
+	if err != nil {
+		return nil, err
+	}
+
+	cursor := sitter.NewQueryCursor()
+	defer cursor.Close()
+
+	names := q.CaptureNames()
+	var symbols []Symbol
+	var nameBytes []uint                 // Start byte of each symbol's identifier.
+	definedAt := make(map[uint]struct{}) // Start bytes of identifiers being defined.
+
+	matches := cursor.Matches(q, tc.tree.RootNode(), tc.source)
+	for m := matches.Next(); m != nil; m = matches.Next() {
+		var nameNode, tagNode *sitter.Node
+		var role, kind string
+		for _, c := range m.Captures {
+			capture := names[c.Index]
+			switch {
+			case capture == "name":
+				nameNode = &c.Node
+			case strings.HasPrefix(capture, "definition.") || strings.HasPrefix(capture, "reference."):
+				tagNode = &c.Node
+				role, kind, _ = strings.Cut(capture, ".")
+			}
+		}
+		if nameNode == nil || tagNode == nil {
+			continue
+		}
+
+		symbols = append(symbols, Symbol{
+			Name:      nameNode.Utf8Text(tc.source),
+			Kind:      kind,
+			Role:      SymbolRole(role),
+			Line:      int(nameNode.StartPosition().Row),
+			StartLine: int(tagNode.StartPosition().Row),
+			EndLine:   int(tagNode.EndPosition().Row),
+		})
+		nameBytes = append(nameBytes, nameNode.StartByte())
+		if SymbolRole(role) == SymbolDefinition {
+			definedAt[nameNode.StartByte()] = struct{}{}
+		}
+	}
+
+	// Some queries also tag the identifier of a definition as a reference; keep only the definition.
+	kept := symbols[:0]
+	for i, s := range symbols {
+		if _, defined := definedAt[nameBytes[i]]; defined && s.Role == SymbolReference {
+			continue
+		}
+		kept = append(kept, s)
+	}
+	symbols = kept
+
+	sort.SliceStable(symbols, func(i, j int) bool {
+		if symbols[i].Line != symbols[j].Line {
+			return symbols[i].Line < symbols[j].Line
+		}
+		return symbols[i].StartLine < symbols[j].StartLine
+	})
+	return symbols, nil
+}
+
+// tagsQuery returns the compiled tags query for a language, compiling and caching it on first use.
+func tagsQuery(lang string, language *sitter.Language) (*sitter.Query, error) {
+	tagsQueryCacheMu.Lock()
+	defer tagsQueryCacheMu.Unlock()
+
+	if q, ok := tagsQueryCache[lang]; ok {
+		return q, nil
+	}
+
+	source, err := tagsQuerySource(lang)
+	if err != nil {
+		return nil, err
+	}
+	q, qerr := sitter.NewQuery(language, source)
+	if qerr != nil {
+		return nil, qerr
+	}
+	tagsQueryCache[lang] = q
+	return q, nil
+}
+
+// tagsQuerySource returns the tags query text for a language, including the query of the language it extends.
+func tagsQuerySource(lang string) (string, error) {
+	data, err := tagsQueries.ReadFile("queries/" + lang + "-tags.scm")
+	if err != nil {
+		return "", ErrorNoTagsQuery
+	}
+	source := string(data)
+
+	if base, ok := tagsQueryBases[lang]; ok {
+		baseSource, err := tagsQuerySource(base)
+		if err != nil {
+			return "", err
+		}
+		source = baseSource + "\n" + source
+	}
+	return source, nil
+}
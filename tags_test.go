@@ -0,0 +1,97 @@
+package grepast
+
+import (
+	"fmt"
+	"testing"
+)
+
+// TestTreeContext_Symbols tests the Symbols method of TreeContext.
+func TestTreeContext_Symbols(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		source   string
+		expected []string
+	}{
+		{
+			name:     "Go",
+			filename: "example.go",
+			source: `package main
+
+type Server struct{}
+
+func (s *Server) Start() {
+	listen()
+}
+`,
+			expected: []string{
+				"definition type Server 2-2",
+				"reference type Server 4-4",
+				"definition method Start 4-6",
+				"reference call listen 5-5",
+			},
+		},
+		{
+			name:     "Python",
+			filename: "example.py",
+			source: `class Greeter:
+    def greet(self):
+        print("hi")
+`,
+			expected: []string{
+				"definition class Greeter 0-2",
+				"definition function greet 1-2",
+				"reference call print 2-2",
+			},
+		},
+		{
+			name:     "TypeScript extends JavaScript",
+			filename: "example.ts",
+			source: `interface Shape {}
+
+function area(s: Shape) {
+  return compute(s);
+}
+`,
+			expected: []string{
+				"definition interface Shape 0-0",
+				"definition function area 2-4",
+				"reference type Shape 2-2",
+				"reference call compute 3-3",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc, err := NewTreeContext(tt.filename, []byte(tt.source), TreeContextOptions{})
+			if err != nil {
+				t.Fatalf("NewTreeContext() error = %v", err)
+			}
+
+			symbols, err := tc.Symbols()
+			if err != nil {
+				t.Fatalf("Symbols() error = %v", err)
+			}
+
+			var got []string
+			for _, s := range symbols {
+				got = append(got, fmt.Sprintf("%s %s %s %d-%d", s.Role, s.Kind, s.Name, s.StartLine, s.EndLine))
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.expected) {
+				t.Errorf("Symbols() =\n%v\nwant\n%v", got, tt.expected)
+			}
+		})
+	}
+}
+
+// TestTreeContext_SymbolsNoTagsQuery tests Symbols for a language without a tags query.
+func TestTreeContext_SymbolsNoTagsQuery(t *testing.T) {
+	tc, err := NewTreeContext("style.css", []byte("a { color: red; }\n"), TreeContextOptions{})
+	if err != nil {
+		t.Fatalf("NewTreeContext() error = %v", err)
+	}
+	if _, err := tc.Symbols(); err != ErrorNoTagsQuery {
+		t.Errorf("Symbols() error = %v, want %v", err, ErrorNoTagsQuery)
+	}
+}
@@ -0,0 +1,264 @@
+package grepast
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// DefaultIgnoreFileName is the name of the ignore file read from the root of a walk.
+const DefaultIgnoreFileName = ".astignore"
+
+// WalkerOptions specifies options for walking a directory tree.
+type WalkerOptions struct {
+	IgnoreFileName string   // Name of the gitignore-style file read from the walk root; defaults to DefaultIgnoreFileName.
+	Include        []string // Glob patterns, e.g. "*.go" or "cmd/*.go"; if any are given, only files whose name or relative path matches one are walked.
+	Exclude        []string // Gitignore-style patterns, e.g. "vendor/**" or "*.min.js", of files to skip in addition to those of the ignore file.
+	MaxDepth       int      // If positive, only files at most this many directories deep are walked, counting the root's own files as depth 1; deeper directories are not read.
+	FollowSymlinks bool     // Walk the directories that symbolic links point to, unless already walked, so that each directory is walked once.
+	NoGitIgnore    bool     // Do not skip the files ignored by git, through .gitignore files and .git/info/exclude.
+	Languages      []string // Language names, e.g. "go" or "python"; if any are given, only files whose name, such as its extension, maps to one of them are walked.
+	Files          []string // If not nil, the files to visit in this order, relative to the root unless absolute, instead of walking the root; the other options do not apply to them.
+	Revision       string   // If set, walk the files under the root in this git commit, e.g. "v1.2.0" or "HEAD~3", instead of those on disk; the root must be a directory of a git work tree.
+
+	// OnError is called with the errors met below the root, such as a directory or .gitignore
+	// file that cannot be read, and the path they were met at. Returning nil skips the path and
+	// continues the walk; returning an error stops it. If nil, the first error stops the walk.
+	OnError func(path string, err error) error
+}
+
+// Walker enumerates the files under a root directory, skipping those excluded by its ignore file
+// or by git.
+type Walker struct {
+	options WalkerOptions
+}
+
+// WalkFunc is called by Walker.Walk for each file, with its path and its path relative to the walk root.
+// Returning an error stops the walk.
+type WalkFunc func(path, rel string) error
+
+// NewWalker creates a Walker with the given options.
+func NewWalker(options WalkerOptions) *Walker {
+	if options.IgnoreFileName == "" {
+		options.IgnoreFileName = DefaultIgnoreFileName
+	}
+	return &Walker{options: options}
+}
+
+// Walk calls fn for every file under root that is not excluded by root's ignore file or the
+// Exclude patterns, which are matched against paths relative to root, or ignored by git. As in
+// git, the .gitignore file of each directory applies below it, taking precedence over those of
+// the directories enclosing it and over .git/info/exclude, and the files of ignored directories
+// are ignored too. The ignore file and Exclude patterns take precedence over them all. Symbolic
+// links are passed to fn unless FollowSymlinks is set and they lead to a directory. Missing
+// ignore files are not an error. If root is a file, fn is called for it alone, with its base
+// name as the relative path. If Files is set, fn is called for each of them instead.
+func (w *Walker) Walk(root string, fn WalkFunc) error {
+	if w.options.Files != nil {
+		return visitFiles(root, w.options.Files, fn)
+	}
+
+	info, err := os.Stat(root)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		if w.options.Revision != "" {
+			return fmt.Errorf("revision %s: %s is not a directory", w.options.Revision, root)
+		}
+		return fn(root, filepath.Base(root))
+	}
+
+	for _, pattern := range w.options.Include {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("include pattern %q: %w", pattern, err)
+		}
+	}
+	for _, name := range w.options.Languages {
+		if !knownLanguage(name) {
+			return fmt.Errorf("language %q: %w", name, ErrorUnsupportedLanguage)
+		}
+	}
+
+	var layers []*ignoreLayer
+	if !w.options.NoGitIgnore {
+		if layers, err = gitIgnoreLayers(root); err != nil {
+			return err
+		}
+	}
+	// The ignore file and Exclude patterns are kept as the last layer, taking precedence.
+	lines, err := readIgnoreLines(filepath.Join(root, w.options.IgnoreFileName))
+	if err != nil {
+		return err
+	}
+	layers = append(layers, newIgnoreLayer(append(lines, w.options.Exclude...), "", ""))
+
+	if w.options.Revision != "" {
+		// Files committed are not ignored by git, so only the last layer applies.
+		return w.walkRevision(root, layers[len(layers)-1:], fn)
+	}
+
+	return w.walkDir(root, ".", &[]os.FileInfo{info}, layers, fn)
+}
+
+// walkDir calls fn for every file under dir, whose path relative to the walk root is rel, in
+// lexical order, unless the layers or the .gitignore file of a directory ignore it. The visited
+// directories, including dir, are those already walked when following symbolic links, which are
+// skipped whether met through a link or not, so that links cannot loop or repeat files.
+func (w *Walker) walkDir(dir, rel string, visited *[]os.FileInfo, layers []*ignoreLayer, fn WalkFunc) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return w.walkError(dir, err)
+	}
+	if !w.options.NoGitIgnore {
+		layerDir := ""
+		if rel != "." {
+			layerDir = filepath.ToSlash(rel) + "/"
+		}
+		layer, err := readIgnoreLayer(filepath.Join(dir, GitIgnoreFileName), layerDir, "")
+		if err != nil {
+			// Without its ignore file, the directory cannot be walked as git would.
+			return w.walkError(filepath.Join(dir, GitIgnoreFileName), err)
+		}
+		if layer != nil {
+			// Keep the last layer, of the ignore file and Exclude patterns, last.
+			n := len(layers) - 1
+			layers = append(slices.Clip(layers[:n]), layer, layers[n])
+		}
+	}
+	for _, entry := range entries {
+		entryPath := filepath.Join(dir, entry.Name())
+		entryRel := filepath.Join(rel, entry.Name())
+
+		isDir := entry.IsDir()
+		var info os.FileInfo
+		if entry.Type()&os.ModeSymlink != 0 && w.options.FollowSymlinks {
+			// Links that cannot be resolved are left to fn, as links to files are.
+			if info, err = os.Stat(entryPath); err == nil && info.IsDir() {
+				isDir = true
+			}
+		} else if isDir && w.options.FollowSymlinks {
+			if info, err = entry.Info(); err != nil {
+				if err := w.walkError(entryPath, err); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if ignored(layers, entryRel, isDir) || (!w.options.NoGitIgnore && entry.Name() == ".git") {
+			continue
+		}
+		if !isDir {
+			if !w.included(entryRel) || !w.hasLanguage(entryRel) {
+				continue
+			}
+			if err := fn(entryPath, entryRel); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// Do not descend below the maximum depth
+		if w.options.MaxDepth > 0 && depth(entryRel) >= w.options.MaxDepth {
+			continue
+		}
+		if info != nil {
+			if slices.ContainsFunc(*visited, func(dir os.FileInfo) bool { return os.SameFile(dir, info) }) {
+				continue // The directory was already walked, through a link or not.
+			}
+			*visited = append(*visited, info)
+		}
+		if err := w.walkDir(entryPath, entryRel, visited, layers, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkError passes err, met at path, to OnError, and returns the error stopping the walk, if any.
+func (w *Walker) walkError(path string, err error) error {
+	if w.options.OnError == nil {
+		return err
+	}
+	return w.options.OnError(path, err)
+}
+
+// walkRevision calls fn for every file under root in the commit Revision, in lexical order,
+// unless the layers ignore it or one of its directories. Symbolic links and submodules are
+// skipped, as they have no contents to search.
+func (w *Walker) walkRevision(root string, layers []*ignoreLayer, fn WalkFunc) error {
+	out, err := runGit(root, "ls-tree", "-r", "-z", w.options.Revision, "--", ".")
+	if err != nil {
+		return err
+	}
+	for _, entry := range strings.Split(string(out), "\x00") {
+		// Each entry is "<mode> <type> <object>\t<path>", with the path relative to root.
+		info, rel, ok := strings.Cut(entry, "\t")
+		if !ok || !strings.HasPrefix(info, "100") {
+			continue
+		}
+		rel = filepath.FromSlash(rel)
+		if w.options.MaxDepth > 0 && depth(rel) > w.options.MaxDepth {
+			continue
+		}
+		if ignoredPath(layers, rel) || !w.included(rel) || !w.hasLanguage(rel) {
+			continue
+		}
+		if err := fn(filepath.Join(root, rel), rel); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// visitFiles calls fn for each of files, relative to root unless absolute, in order. Their paths
+// relative to root are the files as given.
+func visitFiles(root string, files []string, fn WalkFunc) error {
+	for _, rel := range files {
+		path := rel
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(root, rel)
+		}
+		if err := fn(path, rel); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// depth returns the number of path elements of rel, so that files in the walk root have depth 1.
+func depth(rel string) int {
+	return strings.Count(filepath.ToSlash(rel), "/") + 1
+}
+
+// hasLanguage reports whether the name of the file at rel maps to one of the languages, or
+// whether there are none.
+func (w *Walker) hasLanguage(rel string) bool {
+	if len(w.options.Languages) == 0 {
+		return true
+	}
+	lang := fileNameLanguage(rel)
+	return lang != "" && slices.ContainsFunc(w.options.Languages, func(name string) bool { return strings.EqualFold(name, lang) })
+}
+
+// included reports whether the file at rel, relative to the walk root, matches an include
+// pattern, or whether there are none.
+func (w *Walker) included(rel string) bool {
+	if len(w.options.Include) == 0 {
+		return true
+	}
+	rel = filepath.ToSlash(rel)
+	for _, pattern := range w.options.Include {
+		// Patterns were checked by Walk, so they cannot be malformed.
+		if ok, _ := path.Match(pattern, path.Base(rel)); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, rel); ok {
+			return true
+		}
+	}
+	return false
+}
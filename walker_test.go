@@ -0,0 +1,329 @@
+package grepast
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// writeTree creates the given files (relative path to content) under dir.
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for rel, content := range files {
+		path := filepath.Join(dir, rel)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// walkRel returns the sorted relative paths visited by w under root.
+func walkRel(t *testing.T, w *Walker, root string) []string {
+	t.Helper()
+	var got []string
+	err := w.Walk(root, func(path, rel string) error {
+		got = append(got, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk() error = %v", err)
+	}
+	sort.Strings(got)
+	return got
+}
+
+// TestWalker_Walk tests the Walk method of Walker.
+func TestWalker_Walk(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    map[string]string
+		options  WalkerOptions
+		expected []string
+	}{
+		{
+			name:     "No ignore file",
+			files:    map[string]string{"main.go": "", "pkg/lib.go": ""},
+			expected: []string{"main.go", "pkg/lib.go"},
+		},
+		{
+			name:     "Ignore file",
+			files:    map[string]string{".astignore": "pkg/\n", "main.go": "", "pkg/lib.go": ""},
+			expected: []string{".astignore", "main.go"},
+		},
+		{
+			name:     "Custom ignore file name",
+			files:    map[string]string{".myignore": "*.go\n", "main.go": "", "README.md": ""},
+			options:  WalkerOptions{IgnoreFileName: ".myignore"},
+			expected: []string{".myignore", "README.md"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			writeTree(t, root, tt.files)
+
+			got := walkRel(t, NewWalker(tt.options), root)
+			if len(got) != len(tt.expected) {
+				t.Fatalf("Walk() = %v, want %v", got, tt.expected)
+			}
+			for i := range got {
+				if got[i] != tt.expected[i] {
+					t.Errorf("Walk() = %v, want %v", got, tt.expected)
+				}
+			}
+		})
+	}
+}
+
+// TestWalker_WalkFile tests walking a root that is a file rather than a directory.
+func TestWalker_WalkFile(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{"pkg/lib.go": ""})
+
+	got := walkRel(t, NewWalker(WalkerOptions{}), filepath.Join(root, "pkg", "lib.go"))
+	if len(got) != 1 || got[0] != "lib.go" {
+		t.Errorf("Walk() = %v, want [lib.go]", got)
+	}
+
+	err := NewWalker(WalkerOptions{}).Walk(filepath.Join(root, "missing"), func(path, rel string) error { return nil })
+	if !os.IsNotExist(err) {
+		t.Errorf("Walk() error = %v, want a missing file error", err)
+	}
+}
+
+// TestWalker_Include tests walking only the files matching include patterns.
+func TestWalker_Include(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{"main.go": "", "main_test.go": "", "cmd/run.go": "", "cmd/run.py": "", "README.md": ""})
+
+	tests := []struct {
+		include  []string
+		expected string
+	}{
+		{nil, "[README.md cmd/run.go cmd/run.py main.go main_test.go]"},
+		{[]string{"*.go"}, "[cmd/run.go main.go main_test.go]"},
+		{[]string{"*_test.go", "*.md"}, "[README.md main_test.go]"},
+		{[]string{"cmd/*"}, "[cmd/run.go cmd/run.py]"},
+	}
+	for _, tt := range tests {
+		got := walkRel(t, NewWalker(WalkerOptions{Include: tt.include}), root)
+		if fmt.Sprint(got) != tt.expected {
+			t.Errorf("Walk() with Include %q = %v, want %s", tt.include, got, tt.expected)
+		}
+	}
+
+	err := NewWalker(WalkerOptions{Include: []string{"["}}).Walk(root, func(path, rel string) error { return nil })
+	if err == nil {
+		t.Errorf("Walk() with a malformed pattern returned no error")
+	}
+}
+
+// TestWalker_Exclude tests skipping files matching exclude patterns along with the ignore file.
+func TestWalker_Exclude(t *testing.T) {
+	files := map[string]string{"main.go": "", "app.min.js": "", "web/app.min.js": "", "vendor/lib/lib.go": "", "sub/main.go": ""}
+
+	tests := []struct {
+		name     string
+		ignore   string
+		exclude  []string
+		expected string
+	}{
+		{"Globs", "", []string{"vendor/**", "*.min.js"}, "[main.go sub/main.go]"},
+		{"Anchored", "", []string{"/main.go"}, "[app.min.js sub/main.go vendor/lib/lib.go web/app.min.js]"},
+		{"Merged with the ignore file", "vendor/\n", []string{"*.js"}, "[.astignore main.go sub/main.go]"},
+		{"Negated", "", []string{"*.js", "!web/*.js"}, "[main.go sub/main.go vendor/lib/lib.go web/app.min.js]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			writeTree(t, root, files)
+			if tt.ignore != "" {
+				writeTree(t, root, map[string]string{".astignore": tt.ignore})
+			}
+			got := walkRel(t, NewWalker(WalkerOptions{Exclude: tt.exclude}), root)
+			if fmt.Sprint(got) != tt.expected {
+				t.Errorf("Walk() = %v, want %s", got, tt.expected)
+			}
+		})
+	}
+}
+
+// TestWalker_MaxDepth tests walking only the files near the walk root.
+func TestWalker_MaxDepth(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{"main.go": "", "cmd/run.go": "", "internal/db/db.go": "", "internal/db/sql/q.go": ""})
+
+	tests := []struct {
+		maxDepth int
+		expected string
+	}{
+		{0, "[cmd/run.go internal/db/db.go internal/db/sql/q.go main.go]"},
+		{1, "[main.go]"},
+		{2, "[cmd/run.go main.go]"},
+		{3, "[cmd/run.go internal/db/db.go main.go]"},
+	}
+	for _, tt := range tests {
+		got := walkRel(t, NewWalker(WalkerOptions{MaxDepth: tt.maxDepth}), root)
+		if fmt.Sprint(got) != tt.expected {
+			t.Errorf("Walk() with MaxDepth %d = %v, want %s", tt.maxDepth, got, tt.expected)
+		}
+	}
+}
+
+// TestWalker_FollowSymlinks tests walking linked directories, without looping through links to
+// enclosing directories or walking a directory twice.
+func TestWalker_FollowSymlinks(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{"src/a.go": ""})
+	for link, target := range map[string]string{"vendor": "src", "src/loop": ".."} {
+		if err := os.Symlink(target, filepath.Join(root, link)); err != nil {
+			t.Skipf("cannot create symbolic links: %v", err)
+		}
+	}
+
+	if got := fmt.Sprint(walkRel(t, NewWalker(WalkerOptions{}), root)); got != "[src/a.go src/loop vendor]" {
+		t.Errorf("Walk() = %s, want [src/a.go src/loop vendor]", got)
+	}
+	if got := fmt.Sprint(walkRel(t, NewWalker(WalkerOptions{FollowSymlinks: true}), root)); got != "[src/a.go]" {
+		t.Errorf("Walk() following links = %s, want [src/a.go]", got)
+	}
+}
+
+// TestWalker_FollowSymlinksSiblings tests walking a directory once when sibling links lead to it.
+func TestWalker_FollowSymlinksSiblings(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{"real/a.go": ""})
+	for _, link := range []string{"l1", "l2"} {
+		if err := os.Symlink("real", filepath.Join(root, link)); err != nil {
+			t.Skipf("cannot create symbolic links: %v", err)
+		}
+	}
+
+	if got := fmt.Sprint(walkRel(t, NewWalker(WalkerOptions{FollowSymlinks: true}), root)); got != "[l1/a.go]" {
+		t.Errorf("Walk() following links = %s, want [l1/a.go]", got)
+	}
+}
+
+// TestWalker_GitIgnore tests skipping the files ignored by the .gitignore files of every directory
+// and by .git/info/exclude, with git's precedence and anchoring.
+func TestWalker_GitIgnore(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		".git/HEAD":         "",
+		".git/info/exclude": "*.tmp\n",
+		".gitignore":        "build/\n*.log\n/out\n",
+		"main.go":           "",
+		"a.tmp":             "",
+		"x.log":             "",
+		"build/x.go":        "",
+		"out/o.go":          "",
+		"sub/.gitignore":    "!keep.log\n/gen.go\n",
+		"sub/out/o.go":      "",
+		"sub/keep.log":      "",
+		"sub/other.log":     "",
+		"sub/gen.go":        "",
+		"sub/deep/gen.go":   "",
+	})
+
+	tests := []struct {
+		name     string
+		root     string
+		options  WalkerOptions
+		expected string
+	}{
+		{"Root", root, WalkerOptions{}, "[.gitignore main.go sub/.gitignore sub/deep/gen.go sub/keep.log sub/out/o.go]"},
+		{"Subdirectory", filepath.Join(root, "sub"), WalkerOptions{}, "[.gitignore deep/gen.go keep.log out/o.go]"},
+		{"Overridden by Exclude", root, WalkerOptions{Exclude: []string{"sub/", "!*.log"}}, "[.gitignore main.go x.log]"},
+		{"Disabled", filepath.Join(root, "sub"), WalkerOptions{NoGitIgnore: true}, "[.gitignore deep/gen.go gen.go keep.log other.log out/o.go]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := walkRel(t, NewWalker(tt.options), tt.root)
+			if fmt.Sprint(got) != tt.expected {
+				t.Errorf("Walk() = %v, want %s", got, tt.expected)
+			}
+		})
+	}
+}
+
+// TestWalker_Languages tests walking only the files of some languages.
+func TestWalker_Languages(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{"main.go": "", "web/app.ts": "", "web/app.tsx": "", "tools/gen.py": "", "README.md": "", "Dockerfile": ""})
+
+	tests := []struct {
+		languages []string
+		expected  string
+	}{
+		{nil, "[Dockerfile README.md main.go tools/gen.py web/app.ts web/app.tsx]"},
+		{[]string{"go"}, "[main.go]"},
+		{[]string{"Python", "typescript"}, "[tools/gen.py web/app.ts web/app.tsx]"},
+		{[]string{"dockerfile"}, "[Dockerfile]"},
+	}
+	for _, tt := range tests {
+		got := walkRel(t, NewWalker(WalkerOptions{Languages: tt.languages}), root)
+		if fmt.Sprint(got) != tt.expected {
+			t.Errorf("Walk() with Languages %q = %v, want %s", tt.languages, got, tt.expected)
+		}
+	}
+
+	err := NewWalker(WalkerOptions{Languages: []string{"cobol"}}).Walk(root, func(path, rel string) error { return nil })
+	if !errors.Is(err, ErrorUnsupportedLanguage) {
+		t.Errorf("Walk() with an unknown language error = %v, want %v", err, ErrorUnsupportedLanguage)
+	}
+}
+
+// TestWalker_Files tests visiting the given files, in their order, instead of walking the root.
+func TestWalker_Files(t *testing.T) {
+	root := t.TempDir()
+	other := t.TempDir()
+	writeTree(t, root, map[string]string{"a.go": "", "b/b.go": "", "c.go": ""})
+	writeTree(t, other, map[string]string{"d.go": ""})
+
+	files := []string{filepath.Join("b", "b.go"), filepath.Join(other, "d.go"), "a.go"}
+	var got []string
+	err := NewWalker(WalkerOptions{Exclude: []string{"*.go"}, Files: files}).Walk(root, func(path, rel string) error {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("Walk() visited %s as %s: %v", rel, path, err)
+		}
+		got = append(got, rel)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk() error = %v", err)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(files) {
+		t.Errorf("Walk() = %v, want %v", got, files)
+	}
+}
+
+// TestWalker_OnError tests skipping the directories that cannot be walked, here one whose
+// .gitignore is a directory, and stopping at them without OnError.
+func TestWalker_OnError(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{"a.go": "", "bad/b.go": "", "bad/.gitignore/x": "", "c/c.go": ""})
+
+	var failed []string
+	got := walkRel(t, NewWalker(WalkerOptions{OnError: func(path string, err error) error {
+		rel, _ := filepath.Rel(root, path)
+		failed = append(failed, filepath.ToSlash(rel))
+		return nil
+	}}), root)
+	if fmt.Sprint(got) != "[a.go c/c.go]" {
+		t.Errorf("Walk() = %v, want [a.go c/c.go]", got)
+	}
+	if fmt.Sprint(failed) != "[bad/.gitignore]" {
+		t.Errorf("Walk() reported errors at %v, want [bad/.gitignore]", failed)
+	}
+
+	if err := NewWalker(WalkerOptions{}).Walk(root, func(path, rel string) error { return nil }); err == nil {
+		t.Error("Walk() without OnError error = nil, want the .gitignore error")
+	}
+}